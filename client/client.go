@@ -0,0 +1,321 @@
+// Package client calls the recipes API from Go programs. A Client has a
+// method for every endpoint of the recipes with their translations,
+// revisions, reviews and images, of cookbooks, favorites, tags and meal
+// plans, returning the types of the models package. Only the tenant
+// administration is left out. Requests answered with 429 or a server error
+// are retried with exponential backoff, POST requests carry an
+// Idempotency-Key so that retrying them is safe.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors matching the status of an Error with errors.Is.
+var (
+	ErrBadRequest          = errors.New("bad request")
+	ErrUnauthorized        = errors.New("unauthorized")
+	ErrForbidden           = errors.New("forbidden")
+	ErrNotFound            = errors.New("not found")
+	ErrConflict            = errors.New("conflict")
+	ErrPreconditionFailed  = errors.New("precondition failed")
+	ErrUnprocessableEntity = errors.New("unprocessable entity")
+	ErrTooManyRequests     = errors.New("too many requests")
+	ErrServer              = errors.New("server error")
+)
+
+var statusErrors = map[int]error{
+	http.StatusBadRequest:          ErrBadRequest,
+	http.StatusUnauthorized:        ErrUnauthorized,
+	http.StatusForbidden:           ErrForbidden,
+	http.StatusNotFound:            ErrNotFound,
+	http.StatusConflict:            ErrConflict,
+	http.StatusPreconditionFailed:  ErrPreconditionFailed,
+	http.StatusUnprocessableEntity: ErrUnprocessableEntity,
+	http.StatusTooManyRequests:     ErrTooManyRequests,
+}
+
+// Error is an error response of the API.
+type Error struct {
+	// StatusCode is the HTTP status of the response.
+	StatusCode int `json:"-"`
+
+	// Message is the error given by the API.
+	Message string `json:"error"`
+
+	// Duplicates are the recipes a new recipe likely duplicates, for 409.
+	Duplicates []Duplicate `json:"duplicates,omitempty"`
+
+	// Warnings are the problems of an import that failed, for 422.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("recipes API: %d %s: %s", err.StatusCode, http.StatusText(err.StatusCode), err.Message)
+}
+
+// Is matches the error of the status, like ErrNotFound for 404.
+func (err *Error) Is(target error) bool {
+	if err.StatusCode >= http.StatusInternalServerError {
+		return target == ErrServer
+	}
+	return statusErrors[err.StatusCode] == target
+}
+
+// Duplicate refers to a recipe that is likely a duplicate.
+type Duplicate struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// Client calls the recipes API. It is safe for concurrent use.
+type Client struct {
+	// BaseURL is the URL of the API, like "http://localhost:8080".
+	BaseURL string
+
+	// Tenant is sent in the X-Tenant header unless empty.
+	Tenant string
+
+	// HTTPClient sends the requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+
+	// MaxRetries is the number of retries of requests answered with 429 or
+	// a server error.
+	MaxRetries int
+
+	// Backoff is the delay before the first retry, doubled for every
+	// further one. A Retry-After header takes precedence.
+	Backoff time.Duration
+
+	mutex sync.Mutex
+	token string
+	etags map[string]string
+}
+
+// New creates a client for the API at baseURL, retrying requests three times.
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		MaxRetries: 3,
+		Backoff:    500 * time.Millisecond,
+		etags:      make(map[string]string),
+	}
+}
+
+// SetToken sets the bearer token sent with every request.
+func (client *Client) SetToken(token string) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.token = token
+}
+
+// Token returns the bearer token sent with every request, empty if none.
+func (client *Client) Token() string {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.token
+}
+
+// SignIn gets a token for the user and sends it with all further requests.
+func (client *Client) SignIn(ctx context.Context, username string, password string) error {
+	var output struct {
+		Token string `json:"token"`
+	}
+	credentials := map[string]string{"username": username, "password": password}
+	if _, err := client.do(ctx, http.MethodPost, "/signin", credentials, &output); err != nil {
+		return err
+	}
+	client.SetToken(output.Token)
+	return nil
+}
+
+// document is a request body that isn't JSON.
+type document struct {
+	data        []byte
+	contentType string
+}
+
+// do sends a request with the body as JSON and decodes a JSON response into
+// result, unless nil. Error responses are returned as *Error.
+func (client *Client) do(ctx context.Context, method string, path string, body interface{}, result interface{}) (*http.Response, error) {
+	return client.doWithHeader(ctx, method, path, nil, body, result)
+}
+
+func (client *Client) doWithHeader(ctx context.Context, method string, path string, header http.Header, body interface{}, result interface{}) (*http.Response, error) {
+	var data []byte
+	contentType := ""
+	switch body := body.(type) {
+	case nil:
+	case document:
+		data, contentType = body.data, body.contentType
+	default:
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+		contentType = "application/json"
+	}
+	idempotencyKey := ""
+	if method == http.MethodPost {
+		idempotencyKey = newIdempotencyKey()
+	}
+	target := path
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		target = client.BaseURL + path
+	}
+
+	for attempt := 0; ; attempt++ {
+		request, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		for key, values := range header {
+			request.Header[key] = values
+		}
+		if request.Header.Get("Accept") == "" {
+			request.Header.Set("Accept", "application/json")
+		}
+		if contentType != "" {
+			request.Header.Set("Content-Type", contentType)
+		}
+		if idempotencyKey != "" {
+			request.Header.Set("Idempotency-Key", idempotencyKey)
+		}
+		if client.Tenant != "" {
+			request.Header.Set("X-Tenant", client.Tenant)
+		}
+		client.mutex.Lock()
+		token := client.token
+		client.mutex.Unlock()
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		response, err := client.httpClient().Do(request)
+		if err != nil {
+			return nil, err
+		}
+		retry := response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= http.StatusInternalServerError
+		if !retry || attempt >= client.MaxRetries {
+			return response, decodeResponse(response, result)
+		}
+		delay := client.Backoff << attempt
+		if seconds, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil {
+			delay = time.Duration(seconds) * time.Second
+		}
+		io.Copy(ioutil.Discard, response.Body)
+		response.Body.Close()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (client *Client) httpClient() *http.Client {
+	if client.HTTPClient != nil {
+		return client.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// decodeResponse decodes the body into result, or into an *Error for error
+// responses, and closes it. A *[]byte result gets the body as it is.
+func decodeResponse(response *http.Response, result interface{}) error {
+	defer response.Body.Close()
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode >= http.StatusBadRequest {
+		apiErr := &Error{StatusCode: response.StatusCode}
+		if json.Unmarshal(data, apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(data))
+		}
+		return apiErr
+	}
+	if raw, ok := result.(*[]byte); ok {
+		*raw = data
+		return nil
+	}
+	if result == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, result)
+}
+
+// newIdempotencyKey returns a random key for a POST request and its retries.
+func newIdempotencyKey() string {
+	key := make([]byte, 16)
+	rand.Read(key)
+	return hex.EncodeToString(key)
+}
+
+// withQuery appends the non-empty query parameters to the path.
+func withQuery(path string, query url.Values) string {
+	for key, values := range query {
+		if len(values) == 0 || values[0] == "" {
+			delete(query, key)
+		}
+	}
+	if len(query) == 0 {
+		return path
+	}
+	return path + "?" + query.Encode()
+}
+
+// nextLink returns the URL of the next page from a Link header, empty on the
+// last page.
+func nextLink(header string) string {
+	for _, link := range strings.Split(header, ",") {
+		parts := strings.Split(link, ";")
+		target := strings.Trim(strings.TrimSpace(parts[0]), "<>")
+		for _, param := range parts[1:] {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return target
+			}
+		}
+	}
+	return ""
+}
+
+// etag returns the ETag of the recipe as the client read it last.
+func (client *Client) etag(id string) string {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.etags[id]
+}
+
+// setETag remembers the ETag of a response for the IDs of a recipe, or
+// forgets it if there is none.
+func (client *Client) setETag(response *http.Response, ids ...string) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	etag := ""
+	if response != nil {
+		etag = response.Header.Get("ETag")
+	}
+	for _, id := range ids {
+		if etag == "" {
+			delete(client.etags, id)
+		} else {
+			client.etags[id] = etag
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/aheadxnet/go-sandbox/handlers"
+	"github.com/aheadxnet/go-sandbox/models"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient serves the handler on httptest and returns a client for it
+// that doesn't wait long between retries.
+func newTestClient(t *testing.T, handler http.Handler) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	client := New(server.URL)
+	client.Backoff = time.Millisecond
+	return client
+}
+
+func TestRetriesWithTheSameIdempotencyKey(t *testing.T) {
+	var mutex sync.Mutex
+	keys := make([]string, 0)
+	client := newTestClient(t, http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		keys = append(keys, request.Header.Get("Idempotency-Key"))
+		switch len(keys) {
+		case 1:
+			writer.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			writer.Header().Set("Retry-After", "0")
+			writer.WriteHeader(http.StatusTooManyRequests)
+		default:
+			writer.WriteHeader(http.StatusCreated)
+			json.NewEncoder(writer).Encode(models.Recipe{Name: "Käsekuchen"})
+		}
+	}))
+	recipe, err := client.CreateRecipe(context.Background(), models.Recipe{Name: "Käsekuchen"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recipe.Name != "Käsekuchen" {
+		t.Errorf("created %q", recipe.Name)
+	}
+	if len(keys) != 3 || keys[0] == "" || keys[0] != keys[1] || keys[1] != keys[2] {
+		t.Errorf("sent Idempotency-Keys %q, want the same three times", keys)
+	}
+}
+
+func TestGivesUpAfterMaxRetries(t *testing.T) {
+	var requests int32
+	client := newTestClient(t, http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		writer.WriteHeader(http.StatusBadGateway)
+	}))
+	client.MaxRetries = 2
+	_, err := client.GetRecipe(context.Background(), "6203f6e3e1ff1e2e3a2f5a1c")
+	if !errors.Is(err, ErrServer) {
+		t.Errorf("got %v, want ErrServer", err)
+	}
+	if requests := atomic.LoadInt32(&requests); requests != 3 {
+		t.Errorf("sent %d requests, want 3", requests)
+	}
+}
+
+func TestErrorsAreTyped(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/recipes/:id", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Recipe not found"})
+	})
+	router.POST("/recipes", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"error":      "The recipe likely exists already, add ?force=true to store it anyway",
+			"duplicates": []gin.H{{"id": "6203f6e3e1ff1e2e3a2f5a1c", "name": "Käsekuchen", "url": "/recipes/6203f6e3e1ff1e2e3a2f5a1c"}},
+		})
+	})
+	client := newTestClient(t, router)
+	ctx := context.Background()
+
+	_, err := client.GetRecipe(ctx, "6203f6e3e1ff1e2e3a2f5a1c")
+	var apiErr *Error
+	if !errors.Is(err, ErrNotFound) || !errors.As(err, &apiErr) || apiErr.Message != "Recipe not found" {
+		t.Errorf("got %v, want ErrNotFound with the message", err)
+	}
+	_, err = client.CreateRecipe(ctx, models.Recipe{Name: "Käsekuchen"}, false)
+	if !errors.Is(err, ErrConflict) || !errors.As(err, &apiErr) || len(apiErr.Duplicates) != 1 {
+		t.Errorf("got %v, want ErrConflict with the duplicate", err)
+	}
+	if errors.Is(err, ErrNotFound) {
+		t.Error("409 matches ErrNotFound")
+	}
+}
+
+func TestIteratorFollowsLinks(t *testing.T) {
+	recipes := make([]models.Recipe, 7)
+	for i := range recipes {
+		recipes[i] = models.Recipe{ID: primitive.NewObjectID(), Name: "Recipe " + strconv.Itoa(i)}
+	}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/recipes", func(ctx *gin.Context) {
+		limit, _ := strconv.Atoi(ctx.Query("limit"))
+		offset, _ := strconv.Atoi(ctx.Query("offset"))
+		end := offset + limit
+		if end < len(recipes) {
+			ctx.Header("Link", `</recipes?limit=`+strconv.Itoa(limit)+`&offset=`+strconv.Itoa(end)+`>; rel="next"`)
+		} else {
+			end = len(recipes)
+		}
+		ctx.JSON(http.StatusOK, recipes[offset:end])
+	})
+	client := newTestClient(t, router)
+	all, err := client.ListRecipes(ListOptions{PageSize: 3}).All(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != len(recipes) {
+		t.Fatalf("got %d recipes, want %d", len(all), len(recipes))
+	}
+	for i, recipe := range all {
+		if recipe.ID != recipes[i].ID {
+			t.Errorf("recipe %d is %s, want %s", i, recipe.Name, recipes[i].Name)
+		}
+	}
+}
+
+func TestUpdateSendsETagAndToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/signin", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, gin.H{"token": "secret-token"})
+	})
+	router.GET("/recipes/:id", func(ctx *gin.Context) {
+		ctx.Header("ETag", `"v1"`)
+		ctx.JSON(http.StatusOK, models.Recipe{Name: "Käsekuchen"})
+	})
+	router.PUT("/recipes/:id", func(ctx *gin.Context) {
+		if ctx.GetHeader("Authorization") != "Bearer secret-token" {
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Missing token"})
+			return
+		}
+		if ctx.GetHeader("If-Match") != `"v1"` {
+			ctx.JSON(http.StatusPreconditionFailed, gin.H{"error": "The recipe has been changed since it was read, get it again"})
+			return
+		}
+		ctx.Header("ETag", `"v2"`)
+		ctx.JSON(http.StatusOK, models.Recipe{Name: "Stefans Käsekuchen"})
+	})
+	client := newTestClient(t, router)
+	ctx := context.Background()
+	id := "6203f6e3e1ff1e2e3a2f5a1c"
+
+	if _, err := client.UpdateRecipe(ctx, id, models.Recipe{}); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("update without token: %v", err)
+	}
+	if err := client.SignIn(ctx, "admin", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.UpdateRecipe(ctx, id, models.Recipe{}); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("update without reading: %v", err)
+	}
+	if _, err := client.GetRecipe(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.UpdateRecipe(ctx, id, models.Recipe{}); err != nil {
+		t.Errorf("update after reading: %v", err)
+	}
+	if _, err := client.UpdateRecipe(ctx, id, models.Recipe{}); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("update with the ETag of the update: %v", err)
+	}
+}
+
+func TestUploadsAndDownloadsImages(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/recipes/:id/images", func(ctx *gin.Context) {
+		header, err := ctx.FormFile("image")
+		if err != nil || header.Filename != "pizza.png" {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Missing image"})
+			return
+		}
+		ctx.JSON(http.StatusCreated, models.Photo{URL: "/images/1", Size: header.Size})
+	})
+	router.GET("/images/:id", func(ctx *gin.Context) {
+		ctx.Data(http.StatusOK, "image/png", []byte(ctx.GetHeader("Accept")))
+	})
+	client := newTestClient(t, router)
+	ctx := context.Background()
+
+	photo, err := client.UploadImage(ctx, "6203f6e3e1ff1e2e3a2f5a1c", "pizza.png", strings.NewReader("not really a PNG"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if photo.Size != int64(len("not really a PNG")) {
+		t.Errorf("uploaded %d bytes", photo.Size)
+	}
+	data, contentType, err := client.GetImage(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "image/*" || contentType != "image/png" {
+		t.Errorf("got %q as %s", data, contentType)
+	}
+}
+
+// TestAgainstServer runs the client against the API handlers on httptest,
+// backed by the MongoDB given by TEST_MONGO_URI and a Redis on localhost:6379.
+func TestAgainstServer(t *testing.T) {
+	uri := os.Getenv("TEST_MONGO_URI")
+	if uri == "" {
+		t.Skip("TEST_MONGO_URI not set")
+	}
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mongoClient.Disconnect(ctx)
+	redisClient := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	defer redisClient.Close()
+	if err := redisClient.Ping(ctx).Err(); err != nil {
+		t.Skip("Redis not available:", err)
+	}
+	database := mongoClient.Database("recipes_client_test")
+	database.Drop(ctx)
+	defer database.Drop(ctx)
+	tenant := handlers.NewTenant(ctx, "", database, redisClient, handlers.TenantConfig{
+		TrashRetention: time.Hour,
+		SimilarLimit:   10,
+		MaxImageSize:   1 << 20,
+		JWTSecret:      "test-secret",
+		IdempotencyTTL: time.Minute,
+	})
+	tenant.EnsureIndexes(ctx)
+	tenant.Cache.Invalidate(ctx)
+	router := handlers.NewRouter(tenant, nil)
+	client := newTestClient(t, router)
+
+	names := []string{"Käsekuchen", "Apfelstrudel", "Pizza Margherita", "Linsensuppe", "Tiramisu"}
+	for _, name := range names {
+		if _, err := client.CreateRecipe(ctx, models.Recipe{Name: name, Tags: []string{"test"}, Ingredients: []string{"1 " + name}}, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = client.CreateRecipe(ctx, models.Recipe{Name: "Käsekuchen", Ingredients: []string{"1 Käsekuchen"}}, false)
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("creating a duplicate: %v", err)
+	}
+
+	all, err := client.ListRecipes(ListOptions{PageSize: 2}).All(ctx)
+	if err != nil || len(all) != len(names) {
+		t.Fatalf("listed %d recipes with error %v, want %d", len(all), err, len(names))
+	}
+	found, err := client.SearchRecipes(SearchOptions{Tag: "test", PageSize: 3}).All(ctx)
+	if err != nil || len(found) != len(names) {
+		t.Errorf("found %d recipes with error %v, want %d", len(found), err, len(names))
+	}
+
+	id := all[0].ID.Hex()
+	recipe, err := client.GetRecipe(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := New(client.BaseURL)
+	if _, err := other.GetRecipe(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	recipe.Tags = append(recipe.Tags, "updated")
+	if _, err := client.UpdateRecipe(ctx, id, recipe); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.UpdateRecipe(ctx, id, recipe); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("update of an outdated recipe: %v", err)
+	}
+
+	if err := client.DeleteRecipe(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetRecipe(ctx, id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("getting a deleted recipe: %v", err)
+	}
+	trash, err := client.ListTrash(ListOptions{}).All(ctx)
+	if err != nil || len(trash) != 1 {
+		t.Errorf("trash holds %d recipes with error %v, want 1", len(trash), err)
+	}
+	if _, err := client.RestoreRecipe(ctx, id); err != nil {
+		t.Error(err)
+	}
+
+	// The ETag of a created recipe is the one it has when read back.
+	created, err := client.CreateRecipe(ctx, models.Recipe{Name: "Flammkuchen", Ingredients: []string{"1 Flammkuchen"}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created.Tags = []string{"updated"}
+	if _, err := client.UpdateRecipe(ctx, created.ID.Hex(), created); err != nil {
+		t.Errorf("update after creating: %v", err)
+	}
+	if _, err := client.UpdateRecipe(ctx, created.ID.Hex(), created); err != nil {
+		t.Errorf("update after updating: %v", err)
+	}
+}
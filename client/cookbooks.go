@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"github.com/aheadxnet/go-sandbox/models"
+	"net/http"
+	"net/url"
+)
+
+// CookbookEntry adds a recipe to a cookbook, at the zero based position or
+// at the end if Position is nil.
+type CookbookEntry struct {
+	RecipeID string `json:"recipeId"`
+	Position *int   `json:"position,omitempty"`
+}
+
+// NewCookbook creates a cookbook of the signed in user.
+func (client *Client) NewCookbook(ctx context.Context, cookbook models.Cookbook) (models.Cookbook, error) {
+	var created models.Cookbook
+	_, err := client.do(ctx, http.MethodPost, "/cookbooks", cookbook, &created)
+	return created, err
+}
+
+// ListCookbooks returns the cookbooks of the signed in user and the ones
+// shared with them.
+func (client *Client) ListCookbooks(ctx context.Context) ([]models.Cookbook, error) {
+	return client.listCookbooks(ctx, "/cookbooks")
+}
+
+// ListPublicCookbooks returns the public cookbooks of all users.
+func (client *Client) ListPublicCookbooks(ctx context.Context) ([]models.Cookbook, error) {
+	return client.listCookbooks(ctx, "/cookbooks/public")
+}
+
+// GetCookbook fetches a cookbook with its recipes in order.
+func (client *Client) GetCookbook(ctx context.Context, id string) (models.Cookbook, error) {
+	var cookbook models.Cookbook
+	_, err := client.do(ctx, http.MethodGet, "/cookbooks/"+url.PathEscape(id), nil, &cookbook)
+	return cookbook, err
+}
+
+// UpdateCookbook replaces a cookbook of the signed in user.
+func (client *Client) UpdateCookbook(ctx context.Context, id string, cookbook models.Cookbook) (models.Cookbook, error) {
+	var updated models.Cookbook
+	_, err := client.do(ctx, http.MethodPut, "/cookbooks/"+url.PathEscape(id), cookbook, &updated)
+	return updated, err
+}
+
+// DeleteCookbook deletes a cookbook of the signed in user, its recipes are
+// kept.
+func (client *Client) DeleteCookbook(ctx context.Context, id string) error {
+	_, err := client.do(ctx, http.MethodDelete, "/cookbooks/"+url.PathEscape(id), nil, nil)
+	return err
+}
+
+// AddCookbookRecipe adds a recipe to a cookbook of the signed in user.
+func (client *Client) AddCookbookRecipe(ctx context.Context, id string, entry CookbookEntry) (models.Cookbook, error) {
+	var cookbook models.Cookbook
+	_, err := client.do(ctx, http.MethodPost, "/cookbooks/"+url.PathEscape(id)+"/recipes", entry, &cookbook)
+	return cookbook, err
+}
+
+// RemoveCookbookRecipe removes a recipe from a cookbook of the signed in
+// user.
+func (client *Client) RemoveCookbookRecipe(ctx context.Context, id string, recipeID string) (models.Cookbook, error) {
+	var cookbook models.Cookbook
+	_, err := client.do(ctx, http.MethodDelete, "/cookbooks/"+url.PathEscape(id)+"/recipes/"+url.PathEscape(recipeID), nil, &cookbook)
+	return cookbook, err
+}
+
+// ListFavorites returns the recipes bookmarked by the signed in user, the
+// latest first.
+func (client *Client) ListFavorites(ctx context.Context) ([]models.Recipe, error) {
+	recipes := make([]models.Recipe, 0)
+	_, err := client.do(ctx, http.MethodGet, "/favorites", nil, &recipes)
+	return recipes, err
+}
+
+// AddFavorite bookmarks a recipe for the signed in user.
+func (client *Client) AddFavorite(ctx context.Context, recipeID string) error {
+	_, err := client.do(ctx, http.MethodPut, "/favorites/"+url.PathEscape(recipeID), nil, nil)
+	return err
+}
+
+// DeleteFavorite removes the bookmark of a recipe for the signed in user.
+func (client *Client) DeleteFavorite(ctx context.Context, recipeID string) error {
+	_, err := client.do(ctx, http.MethodDelete, "/favorites/"+url.PathEscape(recipeID), nil, nil)
+	return err
+}
+
+func (client *Client) listCookbooks(ctx context.Context, path string) ([]models.Cookbook, error) {
+	cookbooks := make([]models.Cookbook, 0)
+	_, err := client.do(ctx, http.MethodGet, path, nil, &cookbooks)
+	return cookbooks, err
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"github.com/aheadxnet/go-sandbox/models"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+)
+
+// UploadImage adds a JPEG or PNG photo to a recipe, the file name is kept
+// with the photo.
+func (client *Client) UploadImage(ctx context.Context, recipeID string, filename string, image io.Reader) (models.Photo, error) {
+	var photo models.Photo
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("image", filename)
+	if err != nil {
+		return photo, err
+	}
+	if _, err := io.Copy(part, image); err != nil {
+		return photo, err
+	}
+	if err := writer.Close(); err != nil {
+		return photo, err
+	}
+	upload := document{data: body.Bytes(), contentType: writer.FormDataContentType()}
+	_, err = client.do(ctx, http.MethodPost, "/recipes/"+url.PathEscape(recipeID)+"/images", upload, &photo)
+	client.setETag(nil, recipeID)
+	return photo, err
+}
+
+// ListImages returns the photos of a recipe.
+func (client *Client) ListImages(ctx context.Context, recipeID string) ([]models.Photo, error) {
+	photos := make([]models.Photo, 0)
+	_, err := client.do(ctx, http.MethodGet, "/recipes/"+url.PathEscape(recipeID)+"/images", nil, &photos)
+	return photos, err
+}
+
+// DeleteImage removes a photo from a recipe.
+func (client *Client) DeleteImage(ctx context.Context, recipeID string, imageID string) error {
+	_, err := client.do(ctx, http.MethodDelete, "/recipes/"+url.PathEscape(recipeID)+"/images/"+url.PathEscape(imageID), nil, nil)
+	client.setETag(nil, recipeID)
+	return err
+}
+
+// GetImage fetches a photo or thumbnail by its ID, returning its data and
+// content type.
+func (client *Client) GetImage(ctx context.Context, id string) ([]byte, string, error) {
+	var data []byte
+	header := http.Header{"Accept": {"image/*"}}
+	response, err := client.doWithHeader(ctx, http.MethodGet, "/images/"+url.PathEscape(id), header, nil, &data)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, response.Header.Get("Content-Type"), nil
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"github.com/aheadxnet/go-sandbox/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"net/url"
+)
+
+// ShoppingList sums up the ingredients of a meal plan, leaving out the
+// recipes that have been deleted meanwhile.
+type ShoppingList struct {
+	Items          []models.ShoppingListItem `json:"items"`
+	MissingRecipes []primitive.ObjectID      `json:"missingRecipes"`
+}
+
+// NewMealPlan creates a meal plan of the signed in user.
+func (client *Client) NewMealPlan(ctx context.Context, plan models.MealPlan) (models.MealPlan, error) {
+	var created models.MealPlan
+	_, err := client.do(ctx, http.MethodPost, "/mealplans", plan, &created)
+	return created, err
+}
+
+// ListMealPlans returns the meal plans of the signed in user.
+func (client *Client) ListMealPlans(ctx context.Context) ([]models.MealPlan, error) {
+	plans := make([]models.MealPlan, 0)
+	_, err := client.do(ctx, http.MethodGet, "/mealplans", nil, &plans)
+	return plans, err
+}
+
+// GetMealPlan fetches a meal plan of the signed in user.
+func (client *Client) GetMealPlan(ctx context.Context, id string) (models.MealPlan, error) {
+	var plan models.MealPlan
+	_, err := client.do(ctx, http.MethodGet, "/mealplans/"+url.PathEscape(id), nil, &plan)
+	return plan, err
+}
+
+// UpdateMealPlan replaces a meal plan of the signed in user.
+func (client *Client) UpdateMealPlan(ctx context.Context, id string, plan models.MealPlan) (models.MealPlan, error) {
+	var updated models.MealPlan
+	_, err := client.do(ctx, http.MethodPut, "/mealplans/"+url.PathEscape(id), plan, &updated)
+	return updated, err
+}
+
+// DeleteMealPlan deletes a meal plan of the signed in user.
+func (client *Client) DeleteMealPlan(ctx context.Context, id string) error {
+	_, err := client.do(ctx, http.MethodDelete, "/mealplans/"+url.PathEscape(id), nil, nil)
+	return err
+}
+
+// ShoppingList sums up the ingredients of all recipes of a meal plan, scaled
+// to the planned servings.
+func (client *Client) ShoppingList(ctx context.Context, id string) (ShoppingList, error) {
+	var list ShoppingList
+	_, err := client.do(ctx, http.MethodGet, "/mealplans/"+url.PathEscape(id)+"/shopping-list", nil, &list)
+	return list, err
+}
+
+// ExportMealPlan returns a meal plan as iCalendar file.
+func (client *Client) ExportMealPlan(ctx context.Context, id string) ([]byte, error) {
+	var data []byte
+	header := http.Header{"Accept": {"text/calendar"}}
+	_, err := client.doWithHeader(ctx, http.MethodGet, "/mealplans/"+url.PathEscape(id)+"/ical", header, nil, &data)
+	return data, err
+}
+
+// Feed is the calendar feed of a meal plan. The path, relative to the
+// server, can be subscribed to in calendar apps without signing in.
+type Feed struct {
+	Token string `json:"token"`
+	Path  string `json:"path"`
+}
+
+// NewMealPlanFeed creates the calendar feed of a meal plan of the signed in
+// user, revoking its former feed.
+func (client *Client) NewMealPlanFeed(ctx context.Context, id string) (Feed, error) {
+	var feed Feed
+	_, err := client.do(ctx, http.MethodPost, "/mealplans/"+url.PathEscape(id)+"/feed", nil, &feed)
+	return feed, err
+}
+
+// DeleteMealPlanFeed revokes the calendar feed of a meal plan of the signed
+// in user.
+func (client *Client) DeleteMealPlanFeed(ctx context.Context, id string) error {
+	_, err := client.do(ctx, http.MethodDelete, "/mealplans/"+url.PathEscape(id)+"/feed", nil, nil)
+	return err
+}
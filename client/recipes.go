@@ -0,0 +1,316 @@
+package client
+
+import (
+	"context"
+	"github.com/aheadxnet/go-sandbox/models"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// defaultPageSize is the number of recipes fetched per page by iterators.
+const defaultPageSize = 50
+
+// ListOptions configure listing recipes.
+type ListOptions struct {
+	// Sort is "rating" to order by the weighted rating, best first.
+	Sort string
+
+	// PageSize is the number of recipes fetched per request, up to 100.
+	PageSize int
+}
+
+// SearchOptions configure searching recipes by tag, words or both.
+type SearchOptions struct {
+	Tag      string
+	Query    string
+	Language string
+	Sort     string
+	PageSize int
+}
+
+// ImportResult is a recipe read from an HTML page or JSON-LD document.
+type ImportResult struct {
+	Recipe     models.Recipe `json:"recipe"`
+	Warnings   []string      `json:"warnings"`
+	Duplicates []Duplicate   `json:"duplicates"`
+}
+
+// RevisionDiff holds the changes between two revisions of a recipe.
+type RevisionDiff struct {
+	From    int                  `json:"from"`
+	To      int                  `json:"to"`
+	Changes []models.FieldChange `json:"changes"`
+}
+
+// RecipeIterator walks through a list of recipes page by page:
+//
+//	recipes := api.ListRecipes(client.ListOptions{})
+//	for recipes.Next(ctx) {
+//		fmt.Println(recipes.Recipe().Name)
+//	}
+//	if err := recipes.Err(); err != nil {
+//		...
+//	}
+type RecipeIterator struct {
+	client *Client
+	next   string
+	page   []models.Recipe
+	index  int
+	err    error
+}
+
+func (client *Client) iterate(path string, query url.Values, pageSize int) *RecipeIterator {
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	query.Set("limit", strconv.Itoa(pageSize))
+	return &RecipeIterator{client: client, next: withQuery(path, query), index: -1}
+}
+
+// Next advances to the next recipe, fetching the next page if needed. It
+// returns false at the end of the list or on an error.
+func (iterator *RecipeIterator) Next(ctx context.Context) bool {
+	if iterator.err != nil {
+		return false
+	}
+	iterator.index++
+	for iterator.index >= len(iterator.page) {
+		if iterator.next == "" {
+			return false
+		}
+		var page []models.Recipe
+		response, err := iterator.client.do(ctx, http.MethodGet, iterator.next, nil, &page)
+		if err != nil {
+			iterator.err = err
+			return false
+		}
+		iterator.page, iterator.index = page, 0
+		iterator.next = nextLink(response.Header.Get("Link"))
+	}
+	return true
+}
+
+// Recipe returns the current recipe.
+func (iterator *RecipeIterator) Recipe() models.Recipe {
+	return iterator.page[iterator.index]
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (iterator *RecipeIterator) Err() error {
+	return iterator.err
+}
+
+// All fetches all remaining recipes.
+func (iterator *RecipeIterator) All(ctx context.Context) ([]models.Recipe, error) {
+	recipes := make([]models.Recipe, 0)
+	for iterator.Next(ctx) {
+		recipes = append(recipes, iterator.Recipe())
+	}
+	return recipes, iterator.Err()
+}
+
+// ListRecipes lists all recipes.
+func (client *Client) ListRecipes(options ListOptions) *RecipeIterator {
+	return client.iterate("/recipes", url.Values{"sort": {options.Sort}}, options.PageSize)
+}
+
+// SearchRecipes finds recipes by tag, by words in any language or both.
+func (client *Client) SearchRecipes(options SearchOptions) *RecipeIterator {
+	return client.iterate("/recipes/search", url.Values{
+		"tag":  {options.Tag},
+		"q":    {options.Query},
+		"lang": {options.Language},
+		"sort": {options.Sort},
+	}, options.PageSize)
+}
+
+// ListTrash lists the recipes in the trash, the latest deleted first.
+func (client *Client) ListTrash(options ListOptions) *RecipeIterator {
+	return client.iterate("/trash", url.Values{}, options.PageSize)
+}
+
+// GetRecipes fetches up to 100 recipes by their IDs in the given order,
+// leaving out unknown ones.
+func (client *Client) GetRecipes(ctx context.Context, ids ...string) ([]models.Recipe, error) {
+	recipes := make([]models.Recipe, 0)
+	if len(ids) == 0 {
+		return recipes, nil
+	}
+	_, err := client.do(ctx, http.MethodGet, withQuery("/recipes", url.Values{"ids": {strings.Join(ids, ",")}}), nil, &recipes)
+	return recipes, err
+}
+
+// GetRecipe fetches a recipe by its ID or legacy ID. Its ETag is kept for
+// the next UpdateRecipe.
+func (client *Client) GetRecipe(ctx context.Context, id string) (models.Recipe, error) {
+	var recipe models.Recipe
+	response, err := client.do(ctx, http.MethodGet, "/recipes/"+url.PathEscape(id), nil, &recipe)
+	if err != nil {
+		return recipe, err
+	}
+	client.setETag(response, id, recipe.ID.Hex())
+	return recipe, nil
+}
+
+// GetRecipeBySlug fetches a recipe by its current or a former slug.
+func (client *Client) GetRecipeBySlug(ctx context.Context, slug string) (models.Recipe, error) {
+	var recipe models.Recipe
+	response, err := client.do(ctx, http.MethodGet, "/recipes/by-slug/"+url.PathEscape(slug), nil, &recipe)
+	if err != nil {
+		return recipe, err
+	}
+	client.setETag(response, recipe.ID.Hex())
+	return recipe, nil
+}
+
+// CreateRecipe stores a new recipe. Unless forced, a recipe that likely
+// exists already is rejected with an *Error matching ErrConflict and listing
+// the duplicates.
+func (client *Client) CreateRecipe(ctx context.Context, recipe models.Recipe, force bool) (models.Recipe, error) {
+	var created models.Recipe
+	response, err := client.do(ctx, http.MethodPost, withQuery("/recipes", forceQuery(force)), recipe, &created)
+	if err != nil {
+		return created, err
+	}
+	client.setETag(response, created.ID.Hex())
+	return created, nil
+}
+
+// ImportRecipe reads a recipe from an HTML page or a JSON-LD document, given
+// with its content type. Without commit the recipe is only previewed.
+func (client *Client) ImportRecipe(ctx context.Context, data []byte, contentType string, commit bool, force bool) (ImportResult, error) {
+	var result ImportResult
+	query := forceQuery(force)
+	if commit {
+		query.Set("commit", "true")
+	}
+	_, err := client.do(ctx, http.MethodPost, withQuery("/recipes/import", query), document{data: data, contentType: contentType}, &result)
+	return result, err
+}
+
+// UpdateRecipe replaces the content of a recipe. If the client read the
+// recipe before, the update fails with an *Error matching
+// ErrPreconditionFailed when the recipe has been changed since.
+func (client *Client) UpdateRecipe(ctx context.Context, id string, recipe models.Recipe) (models.Recipe, error) {
+	var updated models.Recipe
+	header := http.Header{}
+	if etag := client.etag(id); etag != "" {
+		header.Set("If-Match", etag)
+	}
+	response, err := client.doWithHeader(ctx, http.MethodPut, "/recipes/"+url.PathEscape(id), header, recipe, &updated)
+	if err != nil {
+		return updated, err
+	}
+	client.setETag(response, id, updated.ID.Hex())
+	return updated, nil
+}
+
+// DeleteRecipe moves a recipe to the trash.
+func (client *Client) DeleteRecipe(ctx context.Context, id string) error {
+	_, err := client.do(ctx, http.MethodDelete, "/recipes/"+url.PathEscape(id), nil, nil)
+	client.setETag(nil, id)
+	return err
+}
+
+// RestoreRecipe brings a recipe back from the trash.
+func (client *Client) RestoreRecipe(ctx context.Context, id string) (models.Recipe, error) {
+	return client.changeRecipe(ctx, http.MethodPost, id, "/restore", nil)
+}
+
+// PurgeRecipe deletes a recipe for good, which requires the admin role.
+func (client *Client) PurgeRecipe(ctx context.Context, id string) error {
+	_, err := client.do(ctx, http.MethodDelete, "/admin/recipes/"+url.PathEscape(id), nil, nil)
+	client.setETag(nil, id)
+	return err
+}
+
+// SimilarRecipes returns up to limit recipes similar to a recipe, the most
+// similar first.
+func (client *Client) SimilarRecipes(ctx context.Context, id string, limit int) ([]models.Recipe, error) {
+	recipes := make([]models.Recipe, 0)
+	query := url.Values{}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	_, err := client.do(ctx, http.MethodGet, withQuery("/recipes/"+url.PathEscape(id)+"/similar", query), nil, &recipes)
+	return recipes, err
+}
+
+// PutTranslation adds or replaces the translation of a recipe to a language.
+func (client *Client) PutTranslation(ctx context.Context, id string, language string, translation models.RecipeTranslation) (models.Recipe, error) {
+	return client.changeRecipe(ctx, http.MethodPut, id, "/translations/"+url.PathEscape(language), translation)
+}
+
+// DeleteTranslation removes the translation of a recipe to a language.
+func (client *Client) DeleteTranslation(ctx context.Context, id string, language string) (models.Recipe, error) {
+	return client.changeRecipe(ctx, http.MethodDelete, id, "/translations/"+url.PathEscape(language), nil)
+}
+
+// ListRevisions returns the revisions of a recipe, the latest first.
+func (client *Client) ListRevisions(ctx context.Context, id string) ([]models.Revision, error) {
+	revisions := make([]models.Revision, 0)
+	_, err := client.do(ctx, http.MethodGet, "/recipes/"+url.PathEscape(id)+"/revisions", nil, &revisions)
+	return revisions, err
+}
+
+// GetRevision returns a revision of a recipe by its number.
+func (client *Client) GetRevision(ctx context.Context, id string, number int) (models.Revision, error) {
+	var revision models.Revision
+	_, err := client.do(ctx, http.MethodGet, "/recipes/"+url.PathEscape(id)+"/revisions/"+strconv.Itoa(number), nil, &revision)
+	return revision, err
+}
+
+// DiffRevisions compares two revisions of a recipe field by field, the
+// latest revision if to is 0.
+func (client *Client) DiffRevisions(ctx context.Context, id string, from int, to int) (RevisionDiff, error) {
+	var diff RevisionDiff
+	query := url.Values{"from": {strconv.Itoa(from)}}
+	if to > 0 {
+		query.Set("to", strconv.Itoa(to))
+	}
+	_, err := client.do(ctx, http.MethodGet, withQuery("/recipes/"+url.PathEscape(id)+"/revisions/diff", query), nil, &diff)
+	return diff, err
+}
+
+// RevertRevision restores the content a recipe had in a revision.
+func (client *Client) RevertRevision(ctx context.Context, id string, number int) (models.Recipe, error) {
+	return client.changeRecipe(ctx, http.MethodPost, id, "/revisions/"+strconv.Itoa(number)+"/revert", nil)
+}
+
+// Batch creates, updates and deletes many recipes with one request. Unless
+// forced, recipes that likely exist already aren't created.
+func (client *Client) Batch(ctx context.Context, request models.BatchRequest, force bool) ([]models.BatchResult, error) {
+	results := make([]models.BatchResult, 0)
+	_, err := client.do(ctx, http.MethodPost, withQuery("/recipes:batch", forceQuery(force)), request, &results)
+	for _, result := range results {
+		client.setETag(nil, result.ID)
+	}
+	return results, err
+}
+
+// ListDuplicates returns clusters of recipes that are likely duplicates of
+// each other, which requires the admin role.
+func (client *Client) ListDuplicates(ctx context.Context) ([][]Duplicate, error) {
+	clusters := make([][]Duplicate, 0)
+	_, err := client.do(ctx, http.MethodGet, "/admin/duplicates", nil, &clusters)
+	return clusters, err
+}
+
+// changeRecipe sends a request changing a recipe without returning its ETag,
+// so the one kept is outdated.
+func (client *Client) changeRecipe(ctx context.Context, method string, id string, path string, body interface{}) (models.Recipe, error) {
+	var recipe models.Recipe
+	_, err := client.do(ctx, method, "/recipes/"+url.PathEscape(id)+path, body, &recipe)
+	client.setETag(nil, id, recipe.ID.Hex())
+	return recipe, err
+}
+
+func forceQuery(force bool) url.Values {
+	query := url.Values{}
+	if force {
+		query.Set("force", "true")
+	}
+	return query
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"context"
+	"github.com/aheadxnet/go-sandbox/models"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// ListReviews returns the visible reviews of a recipe, the latest first.
+func (client *Client) ListReviews(ctx context.Context, recipeID string) ([]models.Review, error) {
+	reviews := make([]models.Review, 0)
+	_, err := client.do(ctx, http.MethodGet, "/recipes/"+url.PathEscape(recipeID)+"/reviews", nil, &reviews)
+	return reviews, err
+}
+
+// NewReview rates and reviews a recipe, once per user. The recipe's ETag is
+// outdated afterwards, as its rating changes.
+func (client *Client) NewReview(ctx context.Context, recipeID string, review models.Review) (models.Review, error) {
+	var created models.Review
+	_, err := client.do(ctx, http.MethodPost, "/recipes/"+url.PathEscape(recipeID)+"/reviews", review, &created)
+	client.setETag(nil, recipeID, created.RecipeID.Hex())
+	return created, err
+}
+
+// ListOwnReviews returns the reviews of the signed in user, the latest first.
+func (client *Client) ListOwnReviews(ctx context.Context) ([]models.Review, error) {
+	reviews := make([]models.Review, 0)
+	_, err := client.do(ctx, http.MethodGet, "/reviews", nil, &reviews)
+	return reviews, err
+}
+
+// UpdateReview changes the rating and text of a review of the signed in user.
+func (client *Client) UpdateReview(ctx context.Context, id string, review models.Review) (models.Review, error) {
+	var updated models.Review
+	_, err := client.do(ctx, http.MethodPut, "/reviews/"+url.PathEscape(id), review, &updated)
+	client.setETag(nil, updated.RecipeID.Hex())
+	return updated, err
+}
+
+// DeleteReview deletes a review of the signed in user.
+func (client *Client) DeleteReview(ctx context.Context, id string) error {
+	_, err := client.do(ctx, http.MethodDelete, "/reviews/"+url.PathEscape(id), nil, nil)
+	return err
+}
+
+// ListAllReviews returns the reviews of all users for moderation, only the
+// hidden or visible ones if hidden isn't nil. It requires the admin role.
+func (client *Client) ListAllReviews(ctx context.Context, hidden *bool) ([]models.Review, error) {
+	query := url.Values{}
+	if hidden != nil {
+		query.Set("hidden", strconv.FormatBool(*hidden))
+	}
+	reviews := make([]models.Review, 0)
+	_, err := client.do(ctx, http.MethodGet, withQuery("/admin/reviews", query), nil, &reviews)
+	return reviews, err
+}
+
+// HideReview hides a review, so it is neither shown nor counted. It requires
+// the admin role.
+func (client *Client) HideReview(ctx context.Context, id string) (models.Review, error) {
+	return client.moderateReview(ctx, id, "/hide")
+}
+
+// ShowReview shows a hidden review again. It requires the admin role.
+func (client *Client) ShowReview(ctx context.Context, id string) (models.Review, error) {
+	return client.moderateReview(ctx, id, "/show")
+}
+
+// DeleteAnyReview deletes the review of any user. It requires the admin role.
+func (client *Client) DeleteAnyReview(ctx context.Context, id string) error {
+	_, err := client.do(ctx, http.MethodDelete, "/admin/reviews/"+url.PathEscape(id), nil, nil)
+	return err
+}
+
+func (client *Client) moderateReview(ctx context.Context, id string, action string) (models.Review, error) {
+	var review models.Review
+	_, err := client.do(ctx, http.MethodPost, "/admin/reviews/"+url.PathEscape(id)+action, nil, &review)
+	client.setETag(nil, review.RecipeID.Hex())
+	return review, err
+}
@@ -0,0 +1,68 @@
+package client
+
+import (
+	"context"
+	"github.com/aheadxnet/go-sandbox/models"
+	"net/http"
+	"net/url"
+)
+
+// TagChange is a tag changed in the taxonomy with the number of recipes
+// retagged.
+type TagChange struct {
+	Tag      models.Tag `json:"tag"`
+	Retagged int        `json:"retagged"`
+}
+
+// ListTags returns all tags with the number of recipes using them, the most
+// used first, labeled in the language if it isn't empty.
+func (client *Client) ListTags(ctx context.Context, language string) ([]models.TagUsage, error) {
+	tags := make([]models.TagUsage, 0)
+	_, err := client.do(ctx, http.MethodGet, withQuery("/tags", url.Values{"lang": {language}}), nil, &tags)
+	return tags, err
+}
+
+// NewTag adds a tag to the taxonomy, recipes using one of its aliases or
+// translations are retagged. It requires the admin role.
+func (client *Client) NewTag(ctx context.Context, tag models.Tag) (TagChange, error) {
+	return client.changeTag(ctx, http.MethodPost, "/admin/tags", tag)
+}
+
+// UpdateTag replaces the aliases, translations and parent of a tag, recipes
+// using one of its aliases or translations are retagged. It requires the
+// admin role.
+func (client *Client) UpdateTag(ctx context.Context, name string, tag models.Tag) (TagChange, error) {
+	return client.changeTag(ctx, http.MethodPut, "/admin/tags/"+url.PathEscape(name), tag)
+}
+
+// DeleteTag removes a tag from the taxonomy, recipes keep it. It requires the
+// admin role.
+func (client *Client) DeleteTag(ctx context.Context, name string) error {
+	_, err := client.do(ctx, http.MethodDelete, "/admin/tags/"+url.PathEscape(name), nil, nil)
+	return err
+}
+
+// RenameTag renames a tag across all recipes, the old name becomes an alias.
+// It requires the admin role.
+func (client *Client) RenameTag(ctx context.Context, name string, newName string) (TagChange, error) {
+	return client.changeTag(ctx, http.MethodPost, "/admin/tags/"+url.PathEscape(name)+"/rename", map[string]string{"name": newName})
+}
+
+// MergeTags merges the tags into the tag named into across all recipes, the
+// merged tags become aliases. It requires the admin role.
+func (client *Client) MergeTags(ctx context.Context, into string, tags ...string) (TagChange, error) {
+	return client.changeTag(ctx, http.MethodPost, "/admin/tags/"+url.PathEscape(into)+"/merge", map[string][]string{"tags": tags})
+}
+
+// changeTag sends a request changing the taxonomy. As recipes may be
+// retagged, all ETags kept are outdated afterwards.
+func (client *Client) changeTag(ctx context.Context, method string, path string, body interface{}) (TagChange, error) {
+	var change TagChange
+	_, err := client.do(ctx, method, path, body, &change)
+	if change.Retagged > 0 {
+		client.mutex.Lock()
+		client.etags = make(map[string]string)
+		client.mutex.Unlock()
+	}
+	return change, err
+}
@@ -0,0 +1,119 @@
+// Command fetch-swagger-ui downloads the scripts and styles of Swagger UI
+// from the npm package swagger-ui-dist into a directory, so they can be
+// embedded instead of loaded from a CDN:
+//
+//	go run ./cmd/fetch-swagger-ui -version 4.15.5 -dir openapi/swagger-ui
+//
+// The package is checked against the integrity the npm registry publishes
+// for it, and the files written are checked in, so an update shows up in
+// the diff.
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// files are the files of the package needed, by their name in the package.
+var files = []string{"swagger-ui.css", "swagger-ui-bundle.js", "LICENSE"}
+
+func main() {
+	version := flag.String("version", "", "version of swagger-ui-dist, like 4.15.5")
+	dir := flag.String("dir", ".", "directory to write the files to")
+	flag.Parse()
+	if *version == "" {
+		log.Fatal("-version is required")
+	}
+	if err := fetch(*version, *dir); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// fetch downloads a version of the package and writes its files to dir.
+func fetch(version string, dir string) error {
+	var meta struct {
+		Dist struct {
+			Tarball   string `json:"tarball"`
+			Integrity string `json:"integrity"`
+		} `json:"dist"`
+	}
+	body, err := get("https://registry.npmjs.org/swagger-ui-dist/" + version)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, &meta); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(meta.Dist.Integrity, "sha512-") {
+		return fmt.Errorf("unsupported integrity %q", meta.Dist.Integrity)
+	}
+	tarball, err := get(meta.Dist.Tarball)
+	if err != nil {
+		return err
+	}
+	sum := sha512.Sum512(tarball)
+	if "sha512-"+base64.StdEncoding.EncodeToString(sum[:]) != meta.Dist.Integrity {
+		return fmt.Errorf("%s doesn't match the integrity %s", meta.Dist.Tarball, meta.Dist.Integrity)
+	}
+
+	archive, err := gzip.NewReader(bytes.NewReader(tarball))
+	if err != nil {
+		return err
+	}
+	wanted := make(map[string]bool, len(files))
+	for _, name := range files {
+		wanted["package/"+name] = true
+	}
+	reader := tar.NewReader(archive)
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if !wanted[header.Name] {
+			continue
+		}
+		content, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimPrefix(header.Name, "package/")
+		if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			return err
+		}
+		delete(wanted, header.Name)
+		log.Printf("Wrote %s", filepath.Join(dir, name))
+	}
+	for name := range wanted {
+		return fmt.Errorf("%s is missing in swagger-ui-dist %s", name, version)
+	}
+	return nil
+}
+
+func get(url string) ([]byte, error) {
+	response, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, response.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(response.Body, 64<<20))
+}
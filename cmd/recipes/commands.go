@@ -0,0 +1,462 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/aheadxnet/go-sandbox/client"
+	"github.com/aheadxnet/go-sandbox/cooklang"
+	"github.com/aheadxnet/go-sandbox/export"
+	"github.com/aheadxnet/go-sandbox/formats"
+	"github.com/aheadxnet/go-sandbox/models"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func listCommand(app *app, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	sortBy := flags.String("sort", "", "\"rating\" to list the best rated recipes first")
+	pageSize := flags.Int("page-size", 0, "the number of recipes fetched per request")
+	parse(flags, args)
+	api, err := app.client()
+	if err != nil {
+		return err
+	}
+	recipes, err := api.ListRecipes(client.ListOptions{Sort: *sortBy, PageSize: *pageSize}).All(app.ctx)
+	if err != nil {
+		return err
+	}
+	return app.printRecipes(recipes)
+}
+
+func getCommand(app *app, args []string) error {
+	flags := flag.NewFlagSet("get", flag.ExitOnError)
+	bySlug := flags.Bool("slug", false, "get the recipe by its slug instead of its ID")
+	parse(flags, args)
+	if flags.NArg() != 1 {
+		return usageError("get")
+	}
+	recipe, err := app.getRecipe(flags.Arg(0), *bySlug)
+	if err != nil {
+		return err
+	}
+	return app.printRecipe(recipe)
+}
+
+func (app *app) getRecipe(id string, bySlug bool) (models.Recipe, error) {
+	api, err := app.client()
+	if err != nil {
+		return models.Recipe{}, err
+	}
+	if bySlug {
+		return api.GetRecipeBySlug(app.ctx, id)
+	}
+	return api.GetRecipe(app.ctx, id)
+}
+
+func createCommand(app *app, args []string) error {
+	flags := flag.NewFlagSet("create", flag.ExitOnError)
+	force := flags.Bool("force", false, "create the recipe even if it likely exists already")
+	parse(flags, args)
+	var recipe models.Recipe
+	var err error
+	switch flags.NArg() {
+	case 0:
+		recipe, err = editRecipe(models.Recipe{Tags: []string{}, Ingredients: []string{}, Instructions: []string{}})
+	case 1:
+		recipe, err = readRecipe(flags.Arg(0))
+	default:
+		return usageError("create")
+	}
+	if err != nil {
+		return err
+	}
+	api, err := app.client()
+	if err != nil {
+		return err
+	}
+	created, err := api.CreateRecipe(app.ctx, recipe, *force)
+	if err != nil {
+		return duplicatesError(err)
+	}
+	return app.printRecipe(created)
+}
+
+func updateCommand(app *app, args []string) error {
+	flags := flag.NewFlagSet("update", flag.ExitOnError)
+	parse(flags, args)
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		return usageError("update")
+	}
+	id := flags.Arg(0)
+	// Reading the recipe first keeps its ETag, so the update fails if
+	// someone else changed the recipe in the meantime.
+	current, err := app.getRecipe(id, false)
+	if err != nil {
+		return err
+	}
+	var recipe models.Recipe
+	if flags.NArg() == 2 {
+		recipe, err = readRecipe(flags.Arg(1))
+	} else {
+		recipe, err = editRecipe(current)
+	}
+	if err != nil {
+		return err
+	}
+	updated, err := app.api.UpdateRecipe(app.ctx, id, recipe)
+	if errors.Is(err, client.ErrPreconditionFailed) {
+		return errors.New("the recipe has been changed since it was read, run the update again")
+	}
+	if err != nil {
+		return err
+	}
+	return app.printRecipe(updated)
+}
+
+func deleteCommand(app *app, args []string) error {
+	flags := flag.NewFlagSet("delete", flag.ExitOnError)
+	parse(flags, args)
+	if flags.NArg() != 1 {
+		return usageError("delete")
+	}
+	api, err := app.client()
+	if err != nil {
+		return err
+	}
+	if err := api.DeleteRecipe(app.ctx, flags.Arg(0)); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Moved recipe %s to the trash\n", flags.Arg(0))
+	return nil
+}
+
+func searchCommand(app *app, args []string) error {
+	flags := flag.NewFlagSet("search", flag.ExitOnError)
+	tag := flags.String("tag", "", "only find recipes with this tag")
+	language := flags.String("lang", "", "the language of the words, e.g. de")
+	sortBy := flags.String("sort", "", "\"rating\" to list the best rated recipes first")
+	parse(flags, args)
+	if *tag == "" && flags.NArg() == 0 {
+		return usageError("search")
+	}
+	api, err := app.client()
+	if err != nil {
+		return err
+	}
+	recipes, err := api.SearchRecipes(client.SearchOptions{
+		Tag:      *tag,
+		Query:    strings.Join(flags.Args(), " "),
+		Language: *language,
+		Sort:     *sortBy,
+	}).All(app.ctx)
+	if err != nil {
+		return err
+	}
+	return app.printRecipes(recipes)
+}
+
+func importCommand(app *app, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	commit := flags.Bool("commit", false, "store the recipe instead of only previewing it")
+	force := flags.Bool("force", false, "store the recipe even if it likely exists already")
+	parse(flags, args)
+	if flags.NArg() != 1 {
+		return usageError("import")
+	}
+	source := flags.Arg(0)
+	api, err := app.client()
+	if err != nil {
+		return err
+	}
+	// The server imports HTML pages and JSON-LD documents only, Cooklang
+	// files are parsed here.
+	if strings.HasSuffix(source, cooklang.Extension) {
+		recipe, err := readRecipe(source)
+		if err != nil {
+			return err
+		}
+		if !*commit {
+			return app.printRecipe(recipe)
+		}
+		created, err := api.CreateRecipe(app.ctx, recipe, *force)
+		if err != nil {
+			return duplicatesError(err)
+		}
+		return app.printRecipe(created)
+	}
+	data, contentType, err := fetch(source)
+	if err != nil {
+		return err
+	}
+	result, err := api.ImportRecipe(app.ctx, data, contentType, *commit, *force)
+	if err != nil {
+		return duplicatesError(err)
+	}
+	for _, warning := range result.Warnings {
+		fmt.Fprintln(os.Stderr, "warning:", warning)
+	}
+	for _, duplicate := range result.Duplicates {
+		fmt.Fprintf(os.Stderr, "warning: likely exists already as %q (%s)\n", duplicate.Name, duplicate.ID)
+	}
+	return app.printRecipe(result.Recipe)
+}
+
+// fetch reads a document to import from a file or an http(s) URL.
+func fetch(source string) ([]byte, string, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		data, err := ioutil.ReadFile(source)
+		if err != nil {
+			return nil, "", err
+		}
+		contentType := "application/ld+json"
+		if ext := strings.ToLower(filepath.Ext(source)); ext == ".html" || ext == ".htm" {
+			contentType = "text/html"
+		}
+		return data, contentType, nil
+	}
+	response, err := http.Get(source)
+	if err != nil {
+		return nil, "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("%s: %s", source, response.Status)
+	}
+	data, err := ioutil.ReadAll(response.Body)
+	return data, response.Header.Get("Content-Type"), err
+}
+
+func exportCommand(app *app, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	formatName := flags.String("format", "markdown", "export format, one of jsonld, markdown, html or cooklang")
+	out := flags.String("out", "", "directory to write one file per recipe to, stdout if empty")
+	tag := flags.String("tag", "", "only export recipes with this tag")
+	parse(flags, args)
+
+	format, ok := export.ByName(*formatName)
+	if !ok {
+		return fmt.Errorf("unknown export format %q", *formatName)
+	}
+	api, err := app.client()
+	if err != nil {
+		return err
+	}
+	recipes := api.ListRecipes(client.ListOptions{})
+	if *tag != "" {
+		recipes = api.SearchRecipes(client.SearchOptions{Tag: *tag})
+	}
+	if *out == "" {
+		if format.List == nil {
+			return fmt.Errorf("format %s holds one recipe per file, use -out", format.Name)
+		}
+		all, err := recipes.All(app.ctx)
+		if err != nil {
+			return err
+		}
+		return format.List(os.Stdout, all)
+	}
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		return err
+	}
+	exported := 0
+	for recipes.Next(app.ctx) {
+		recipe := recipes.Recipe()
+		file, err := os.Create(filepath.Join(*out, recipe.ID.Hex()+format.Extension))
+		if err != nil {
+			return err
+		}
+		err = format.Recipe(file, recipe)
+		file.Close()
+		if err != nil {
+			return err
+		}
+		exported++
+	}
+	if err := recipes.Err(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d recipes to %s\n", exported, strings.TrimSuffix(*out, "/"))
+	return nil
+}
+
+func loginCommand(app *app, args []string) error {
+	flags := flag.NewFlagSet("login", flag.ExitOnError)
+	username := flags.String("username", "", "the user to sign in, the one of the profile if empty")
+	password := flags.String("password", "", "the password, the one of the profile if empty")
+	parse(flags, args)
+	profile, err := app.config.Profile(app.profileName)
+	if err != nil {
+		return err
+	}
+	if *username == "" {
+		*username = profile.Username
+	}
+	if *password == "" {
+		*password = profile.Password
+	}
+	if *username == "" || *password == "" {
+		return errors.New("missing username or password, pass them or set them in the profile")
+	}
+	api := client.New(profile.Server)
+	api.Tenant = profile.Tenant
+	if err := api.SignIn(app.ctx, *username, *password); err != nil {
+		return err
+	}
+	profile.Username = *username
+	profile.Token = api.Token()
+	app.config.Profiles[app.profileName] = profile
+	if err := app.config.Save(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Signed in to %s as %s\n", profile.Server, *username)
+	return nil
+}
+
+func logoutCommand(app *app, args []string) error {
+	flags := flag.NewFlagSet("logout", flag.ExitOnError)
+	parse(flags, args)
+	profile, err := app.config.Profile(app.profileName)
+	if err != nil {
+		return err
+	}
+	profile.Token = ""
+	app.config.Profiles[app.profileName] = profile
+	return app.config.Save()
+}
+
+func profileCommand(app *app, args []string) error {
+	if len(args) == 0 {
+		return usageError("profile")
+	}
+	switch args[0] {
+	case "list":
+		names := make([]string, 0, len(app.config.Profiles))
+		for name := range app.config.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			marker := " "
+			if name == app.config.Current {
+				marker = "*"
+			}
+			profile := app.config.Profiles[name]
+			fmt.Printf("%s %s\t%s\t%s\n", marker, name, profile.Server, profile.Username)
+		}
+		return nil
+	case "use":
+		if len(args) != 2 {
+			return usageError("profile")
+		}
+		if _, err := app.config.Profile(args[1]); err != nil {
+			return err
+		}
+		app.config.Current = args[1]
+		return app.config.Save()
+	case "set":
+		flags := flag.NewFlagSet("profile", flag.ExitOnError)
+		server := flags.String("server", "", "the URL of the API")
+		tenant := flags.String("tenant", "", "the tenant, if the server hosts several")
+		username := flags.String("username", "", "the user to sign in")
+		password := flags.String("password", "", "the password of the user")
+		parse(flags, args[1:])
+		profile := app.config.Profiles[app.profileName]
+		flags.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "server":
+				profile.Server = *server
+				profile.Token = ""
+			case "tenant":
+				profile.Tenant = *tenant
+			case "username":
+				profile.Username = *username
+				profile.Token = ""
+			case "password":
+				profile.Password = *password
+			}
+		})
+		app.config.Profiles[app.profileName] = profile
+		if app.config.Current == "" {
+			app.config.Current = app.profileName
+		}
+		return app.config.Save()
+	}
+	return usageError("profile")
+}
+
+// readRecipe reads a recipe from a Cooklang file or from a YAML or JSON file.
+func readRecipe(path string) (models.Recipe, error) {
+	var recipe models.Recipe
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return recipe, err
+	}
+	if strings.HasSuffix(path, cooklang.Extension) {
+		parsed, err := cooklang.Parse(data)
+		if err != nil {
+			return recipe, fmt.Errorf("%s: %w", path, err)
+		}
+		return parsed.ToModel(strings.TrimSuffix(filepath.Base(path), cooklang.Extension)), nil
+	}
+	if err := formats.Unmarshal(formats.MIMEYAML, data, &recipe); err != nil {
+		return recipe, fmt.Errorf("%s: %w", path, err)
+	}
+	return recipe, nil
+}
+
+// editRecipe opens the recipe as YAML in $EDITOR and reads it back.
+func editRecipe(recipe models.Recipe) (models.Recipe, error) {
+	data, err := formats.Marshal(formats.MIMEYAML, recipe)
+	if err != nil {
+		return recipe, err
+	}
+	file, err := ioutil.TempFile("", "recipe-*.yaml")
+	if err != nil {
+		return recipe, err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(data)
+	file.Close()
+	if err != nil {
+		return recipe, err
+	}
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	// $EDITOR may hold arguments like "code --wait".
+	fields := strings.Fields(editor)
+	cmd := exec.Command(fields[0], append(fields[1:], file.Name())...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return recipe, fmt.Errorf("%s: %w", editor, err)
+	}
+	edited, err := readRecipe(file.Name())
+	if err != nil {
+		return recipe, err
+	}
+	if edited.Name == "" {
+		return recipe, errors.New("the recipe has no name, nothing saved")
+	}
+	return edited, nil
+}
+
+// duplicatesError adds the recipes that likely exist already to a conflict.
+func duplicatesError(err error) error {
+	var apiError *client.Error
+	if !errors.As(err, &apiError) || len(apiError.Duplicates) == 0 {
+		return err
+	}
+	lines := []string{apiError.Message}
+	for _, duplicate := range apiError.Duplicates {
+		lines = append(lines, fmt.Sprintf("  %s  %s", duplicate.ID, duplicate.Name))
+	}
+	lines = append(lines, "use -force to store it anyway")
+	return errors.New(strings.Join(lines, "\n"))
+}
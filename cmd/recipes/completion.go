@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const bashCompletion = `# bash completion for recipes, load it with: source <(recipes completion bash)
+_recipes() {
+	local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}
+	local i command=""
+	for ((i = 1; i < COMP_CWORD; i++)); do
+		case ${COMP_WORDS[i]} in
+		-o | -profile) ((i++)) ;;
+		-*) ;;
+		*) command=${COMP_WORDS[i]}; break ;;
+		esac
+	done
+	case $prev in
+	-o) COMPREPLY=($(compgen -W "table json yaml" -- "$cur")); return ;;
+	-format) COMPREPLY=($(compgen -W "jsonld markdown html cooklang" -- "$cur")); return ;;
+	esac
+	case $command in
+	"") COMPREPLY=($(compgen -W "%s -profile -o" -- "$cur")) ;;
+	profile) COMPREPLY=($(compgen -W "list use set" -- "$cur")) ;;
+	completion) COMPREPLY=($(compgen -W "bash zsh fish" -- "$cur")) ;;
+	create | update | import) COMPREPLY=($(compgen -f -- "$cur")) ;;
+	esac
+}
+complete -o filenames -F _recipes recipes
+`
+
+const zshCompletion = `# zsh completion for recipes, load it with: source <(recipes completion zsh)
+autoload -U +X bashcompinit && bashcompinit
+`
+
+const fishCompletion = `# fish completion for recipes, load it with: recipes completion fish | source
+complete -c recipes -f
+complete -c recipes -n __fish_use_subcommand -o profile -r -d 'the profile to use'
+complete -c recipes -n __fish_use_subcommand -o o -x -a 'table json yaml' -d 'output format'
+%scomplete -c recipes -n '__fish_seen_subcommand_from profile' -x -a 'list use set'
+complete -c recipes -n '__fish_seen_subcommand_from completion' -x -a 'bash zsh fish'
+complete -c recipes -n '__fish_seen_subcommand_from create update import' -F
+`
+
+func completionCommand(app *app, args []string) error {
+	if len(args) != 1 {
+		return usageError("completion")
+	}
+	names := make([]string, len(commands))
+	for i, command := range commands {
+		names[i] = command.name
+	}
+	bash := fmt.Sprintf(bashCompletion, strings.Join(names, " "))
+	switch args[0] {
+	case "bash":
+		fmt.Print(bash)
+	case "zsh":
+		fmt.Print(zshCompletion + bash)
+	case "fish":
+		subcommands := ""
+		for _, command := range commands {
+			subcommands += fmt.Sprintf("complete -c recipes -n __fish_use_subcommand -a %s -d '%s'\n", command.name, strings.ReplaceAll(command.summary, "'", "\\'"))
+		}
+		fmt.Printf(fishCompletion, subcommands)
+	default:
+		return usageError("completion")
+	}
+	return nil
+}
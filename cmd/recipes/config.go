@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// defaultServer is the server of the profile created on first use.
+const defaultServer = "http://localhost:8080"
+
+// Profile is a server with the credentials used for it.
+type Profile struct {
+	Server   string `yaml:"server"`
+	Tenant   string `yaml:"tenant,omitempty"`
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	Token    string `yaml:"token,omitempty"`
+}
+
+// Config holds the profiles and the name of the current one. It is kept in
+// recipes/config.yaml in the user's config directory, or the file given by
+// RECIPES_CONFIG.
+type Config struct {
+	Current  string             `yaml:"current"`
+	Profiles map[string]Profile `yaml:"profiles"`
+
+	path string
+}
+
+func configPath() (string, error) {
+	if path := os.Getenv("RECIPES_CONFIG"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "recipes", "config.yaml"), nil
+}
+
+// loadConfig reads the config, or returns one with a default profile for a
+// local server if there is none yet.
+func loadConfig() (*Config, error) {
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{path: path}
+	data, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		config.Current = "default"
+		config.Profiles = map[string]Profile{"default": {Server: defaultServer}}
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if config.Profiles == nil {
+		config.Profiles = make(map[string]Profile)
+	}
+	return config, nil
+}
+
+// Save writes the config, readable by the user only as it holds credentials.
+func (config *Config) Save() error {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(config.path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(config.path, data, 0600)
+}
+
+// Profile returns the profile with the given name.
+func (config *Config) Profile(name string) (Profile, error) {
+	profile, ok := config.Profiles[name]
+	if !ok {
+		return profile, fmt.Errorf("unknown profile %q, create it with \"recipes profile set\"", name)
+	}
+	if profile.Server == "" {
+		profile.Server = defaultServer
+	}
+	return profile, nil
+}
@@ -0,0 +1,153 @@
+// Command recipes manages the recipes of the API from the command line:
+//
+//	recipes profile set -server https://recipes.example.com -username stefan -password secret
+//	recipes list
+//	recipes get stefans-kaesekuchen -slug -o yaml
+//	recipes create kaesekuchen.yaml
+//	recipes update 6203f6e3e1ff1e2e3a2f5a1c
+//
+// Run "recipes help" for all commands.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/aheadxnet/go-sandbox/client"
+	"os"
+	"strings"
+)
+
+// command is a subcommand like "list".
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(app *app, args []string) error
+}
+
+// commands are all subcommands, filled in init as help refers to them.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"list", "list [-sort rating] [-page-size n]", "list all recipes", listCommand},
+		{"get", "get [-slug] <id>", "show a recipe by its ID or slug", getCommand},
+		{"create", "create [-force] [file]", "create a recipe from a YAML, JSON or Cooklang file or in $EDITOR", createCommand},
+		{"update", "update <id> [file]", "replace a recipe by a file or edit it in $EDITOR", updateCommand},
+		{"delete", "delete <id>", "move a recipe to the trash", deleteCommand},
+		{"search", "search [-tag tag] [-lang de] [-sort rating] [words...]", "search recipes by tag and words", searchCommand},
+		{"import", "import [-commit] [-force] <file or URL>", "import an HTML page, JSON-LD or Cooklang document", importCommand},
+		{"export", "export [-format markdown] [-tag tag] [-out directory]", "export recipes as jsonld, markdown, html or cooklang", exportCommand},
+		{"login", "login [-username name] [-password secret]", "sign in and keep the token in the profile", loginCommand},
+		{"logout", "logout", "remove the token from the profile", logoutCommand},
+		{"profile", "profile list | use <name> | set [-server url] [-tenant name] [-username name] [-password secret]", "manage the servers and credentials", profileCommand},
+		{"completion", "completion bash|zsh|fish", "print the shell completion script", completionCommand},
+		{"help", "help", "show this help", func(*app, []string) error { usage(); return nil }},
+	}
+}
+
+// app is the state shared by the commands.
+type app struct {
+	ctx         context.Context
+	config      *Config
+	profileName string
+	output      string
+	api         *client.Client
+}
+
+func main() {
+	flags := flag.NewFlagSet("recipes", flag.ExitOnError)
+	profileName := flags.String("profile", os.Getenv("RECIPES_PROFILE"), "the profile to use instead of the current one")
+	output := flags.String("o", "table", "output format: table, json or yaml")
+	flags.Usage = usage
+	flags.Parse(os.Args[1:])
+	if flags.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	if *output != "table" && *output != "json" && *output != "yaml" {
+		fail(fmt.Errorf("unknown output format %q, use table, json or yaml", *output))
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		fail(err)
+	}
+	app := &app{ctx: context.Background(), config: config, profileName: *profileName, output: *output}
+	if app.profileName == "" {
+		app.profileName = config.Current
+	}
+	name := flags.Arg(0)
+	for _, command := range commands {
+		if command.name == name {
+			if err := command.run(app, flags.Args()[1:]); err != nil {
+				fail(err)
+			}
+			return
+		}
+	}
+	fail(fmt.Errorf("unknown command %q, run \"recipes help\"", name))
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: recipes [-profile name] [-o table|json|yaml] <command> [arguments]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, command := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", command.name, command.summary)
+		fmt.Fprintf(os.Stderr, "  %-12s   recipes %s\n", "", command.usage)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "recipes:", err)
+	os.Exit(1)
+}
+
+// client returns the API client for the profile, signing in with the
+// credentials of the profile unless it holds a token.
+func (app *app) client() (*client.Client, error) {
+	if app.api != nil {
+		return app.api, nil
+	}
+	profile, err := app.config.Profile(app.profileName)
+	if err != nil {
+		return nil, err
+	}
+	api := client.New(profile.Server)
+	api.Tenant = profile.Tenant
+	switch {
+	case profile.Token != "":
+		api.SetToken(profile.Token)
+	case profile.Username != "" && profile.Password != "":
+		if err := api.SignIn(app.ctx, profile.Username, profile.Password); err != nil {
+			return nil, err
+		}
+	}
+	app.api = api
+	return api, nil
+}
+
+// parse parses the flags of a command, printing its usage on errors.
+func parse(flags *flag.FlagSet, args []string) {
+	flags.Usage = func() {
+		for _, command := range commands {
+			if command.name == flags.Name() {
+				fmt.Fprintln(os.Stderr, "Usage: recipes "+command.usage)
+			}
+		}
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+}
+
+// usageError is returned for commands called with the wrong arguments.
+func usageError(name string) error {
+	for _, command := range commands {
+		if command.name == name {
+			return fmt.Errorf("usage: recipes %s", command.usage)
+		}
+	}
+	return fmt.Errorf("usage: recipes %s", strings.TrimSpace(name))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aheadxnet/go-sandbox/export"
+	"github.com/aheadxnet/go-sandbox/formats"
+	"github.com/aheadxnet/go-sandbox/models"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// print writes the value as JSON or YAML, or as table by calling table.
+func (app *app) print(value interface{}, table func(w io.Writer)) error {
+	switch app.output {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	case "yaml":
+		data, err := formats.Marshal(formats.MIMEYAML, value)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	table(writer)
+	return writer.Flush()
+}
+
+func (app *app) printRecipes(recipes []models.Recipe) error {
+	return app.print(recipes, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tNAME\tSLUG\tTAGS\tRATING")
+		for _, recipe := range recipes {
+			rating := ""
+			if recipe.Rating.Count > 0 {
+				rating = fmt.Sprintf("%.1f (%d)", recipe.Rating.Average, recipe.Rating.Count)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", recipe.ID.Hex(), recipe.Name, recipe.Slug, strings.Join(recipe.Tags, ", "), rating)
+		}
+	})
+}
+
+func (app *app) printRecipe(recipe models.Recipe) error {
+	return app.print(recipe, func(w io.Writer) {
+		fmt.Fprintf(w, "ID\t%s\n", recipe.ID.Hex())
+		fmt.Fprintf(w, "Name\t%s\n", recipe.Name)
+		fmt.Fprintf(w, "Slug\t%s\n", recipe.Slug)
+		fmt.Fprintf(w, "Tags\t%s\n", strings.Join(recipe.Tags, ", "))
+		if recipe.Servings > 0 {
+			fmt.Fprintf(w, "Servings\t%d\n", recipe.Servings)
+		}
+		if recipe.TotalTime > 0 {
+			fmt.Fprintf(w, "Time\t%s\n", export.FormatMinutes(recipe.TotalTime))
+		}
+		fmt.Fprintf(w, "Published\t%s\n", recipe.PublishedAt.Format("2006-01-02"))
+		fmt.Fprintln(w, "\nIngredients")
+		for _, ingredient := range recipe.Ingredients {
+			fmt.Fprintf(w, "  - %s\n", ingredient)
+		}
+		fmt.Fprintln(w, "\nInstructions")
+		for i, instruction := range recipe.Instructions {
+			fmt.Fprintf(w, "  %d. %s\n", i+1, instruction)
+		}
+	})
+}
@@ -0,0 +1,517 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/aheadxnet/go-sandbox/cooklang"
+	"github.com/aheadxnet/go-sandbox/export"
+	"github.com/aheadxnet/go-sandbox/handlers"
+	"github.com/aheadxnet/go-sandbox/migrations"
+	"github.com/aheadxnet/go-sandbox/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// runCommand runs the command line given instead of starting the server.
+func runCommand(args []string) {
+	var err error
+	switch args[0] {
+	case "export":
+		err = exportCommand(args[1:])
+	case "import-cooklang":
+		err = importCooklangCommand(args[1:])
+	case "cleanup-images":
+		err = cleanupImages(images, collection)
+	case "cleanup-cookbooks":
+		err = cleanupCookbooks(cookbooks, collection)
+	case "cleanup-reviews":
+		err = cleanupReviews(commandTenant.Reviews, collection)
+	case "cleanup-mealplans":
+		err = cleanupMealPlans(commandTenant.MealPlans, collection)
+	case "cleanup-revisions":
+		err = cleanupRevisions(revisions, collection)
+	case "reindex-recipes":
+		err = reindexRecipesCommand()
+	case "rebuild-similar":
+		err = rebuildSimilarCommand()
+	case "migrate-legacy-ids":
+		err = migrateLegacyIDsCommand(args[1:])
+	case "migrate":
+		err = migrateCommand(args[1:])
+	case "reconcile-indexes":
+		err = reconcileIndexesCommand(args[1:])
+	case "openapi":
+		err = openAPICommand()
+	case "add-user":
+		err = addUserCommand(args[1:])
+	default:
+		err = fmt.Errorf("unknown command %q", args[0])
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// openAPICommand writes the OpenAPI document of the API to stdout, as it is
+// served at /openapi.json.
+func openAPICommand() error {
+	doc, err := handlers.OpenAPI(handlers.NewRouter(commandTenant, tenantsHandler).Routes())
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(doc)
+}
+
+// addUserCommand stores a user of the tenant given by TENANT with the bcrypt
+// hash of the password.
+func addUserCommand(args []string) error {
+	flags := flag.NewFlagSet("add-user", flag.ExitOnError)
+	username := flags.String("username", "", "the name used to sign in")
+	password := flags.String("password", "", "the password used to sign in")
+	admin := flags.Bool("admin", false, "give the user the admin role")
+	flags.Parse(args)
+	if *username == "" || *password == "" {
+		return errors.New("usage: add-user -username name -password secret [-admin]")
+	}
+	hash, err := handlers.HashPassword(*password)
+	if err != nil {
+		return err
+	}
+	user := models.User{Username: *username, Password: hash}
+	if *admin {
+		user.Role = models.RoleAdmin
+	}
+	if _, err := commandTenant.Database.Collection("users").InsertOne(ctx, user); err != nil {
+		return err
+	}
+	log.Printf("Added user %q", *username)
+	return nil
+}
+
+// exportCommand writes all recipes in one of the export formats, either into
+// a single document on stdout or one file per recipe into a directory.
+func exportCommand(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	formatName := flags.String("format", "markdown", "export format, one of jsonld, markdown, html or cooklang")
+	out := flags.String("out", "", "directory to write one file per recipe to, stdout if empty")
+	tag := flags.String("tag", "", "only export recipes with this tag")
+	flags.Parse(args)
+
+	format, ok := export.ByName(*formatName)
+	if !ok {
+		return fmt.Errorf("unknown export format %q", *formatName)
+	}
+	filter := bson.M{"deletedAt": nil}
+	if *tag != "" {
+		filter["tags"] = *tag
+	}
+	cur, err := collection.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	recipes := make([]models.Recipe, 0)
+	if err := cur.All(ctx, &recipes); err != nil {
+		return err
+	}
+	if *out == "" {
+		if format.List == nil {
+			return fmt.Errorf("format %s holds one recipe per file, use -out", format.Name)
+		}
+		return format.List(os.Stdout, recipes)
+	}
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		return err
+	}
+	for _, recipe := range recipes {
+		file, err := os.Create(filepath.Join(*out, recipe.ID.Hex()+format.Extension))
+		if err != nil {
+			return err
+		}
+		err = format.Recipe(file, recipe)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	log.Printf("Exported %d recipes to %s", len(recipes), strings.TrimSuffix(*out, "/"))
+	return nil
+}
+
+// importCooklangCommand stores all .cook files of a directory as new recipes.
+func importCooklangCommand(args []string) error {
+	flags := flag.NewFlagSet("import-cooklang", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only parse the files without storing them")
+	force := flags.Bool("force", false, "import recipes that likely exist already, too")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("usage: import-cooklang [-dry-run] [-force] <directory>")
+	}
+	files, err := filepath.Glob(filepath.Join(flags.Arg(0), "*"+cooklang.Extension))
+	if err != nil {
+		return err
+	}
+	imported := 0
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		parsed, err := cooklang.Parse(data)
+		if err != nil {
+			log.Printf("Skipping %s: %v", file, err)
+			continue
+		}
+		recipe := parsed.ToModel(strings.TrimSuffix(filepath.Base(file), cooklang.Extension))
+		recipe.ID = primitive.NewObjectID()
+		recipe.PublishedAt = time.Now()
+		if recipe.Tags, err = tags.Normalize(ctx, recipe.Tags); err != nil {
+			return err
+		}
+		recipe.Fingerprint = models.NewFingerprint(recipe)
+		recipe.Texts = recipe.SearchTexts()
+		duplicates, err := handlers.FindDuplicates(ctx, collection, recipe)
+		if err != nil {
+			return err
+		}
+		if len(duplicates) > 0 && !*force {
+			log.Printf("Skipping %s: %q likely exists already as recipe %s", file, recipe.Name, duplicates[0].ID.Hex())
+			continue
+		}
+		if recipe.Slug, err = handlers.UniqueSlug(ctx, collection, recipe.ID, recipe.Name); err != nil {
+			return err
+		}
+		if *dryRun {
+			log.Printf("Parsed %s: %q with %d ingredients and %d steps", file, recipe.Name, len(recipe.Ingredients), len(recipe.Instructions))
+			continue
+		}
+		if _, err := collection.InsertOne(ctx, recipe); err != nil {
+			return err
+		}
+		if err := revisions.Record(ctx, models.RevisionCreate, "import-cooklang", recipe); err != nil {
+			log.Printf("Error while recording revision of %s: %v", file, err)
+		}
+		if err := similar.Update(ctx, recipe); err != nil {
+			log.Printf("Error while indexing the similarity of %s: %v", file, err)
+		}
+		imported++
+	}
+	if imported > 0 {
+		cache.Invalidate(ctx)
+	}
+	log.Printf("Imported %d of %d Cooklang files", imported, len(files))
+	return nil
+}
+
+// reindexRecipesCommand recomputes the fingerprints and search texts of all
+// recipes, like the ones stored before these existed, and gives recipes
+// without a slug one, the oldest recipes first.
+func reindexRecipesCommand() error {
+	cur, err := collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	updated := 0
+	for cur.Next(ctx) {
+		var recipe models.Recipe
+		if err := cur.Decode(&recipe); err != nil {
+			return err
+		}
+		update := bson.M{
+			"fingerprint": models.NewFingerprint(recipe),
+			"texts":       recipe.SearchTexts(),
+		}
+		if recipe.Slug == "" {
+			if update["slug"], err = handlers.UniqueSlug(ctx, collection, recipe.ID, recipe.Name); err != nil {
+				return err
+			}
+		}
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": recipe.ID}, bson.M{"$set": update}); err != nil {
+			return err
+		}
+		updated++
+	}
+	log.Printf("Reindexed %d recipes", updated)
+	return cur.Err()
+}
+
+// rebuildSimilarCommand recomputes the similar recipes of all recipes from
+// scratch.
+func rebuildSimilarCommand() error {
+	cur, err := collection.Find(ctx, bson.M{"deletedAt": nil})
+	if err != nil {
+		return err
+	}
+	recipes := make([]models.Recipe, 0)
+	if err := cur.All(ctx, &recipes); err != nil {
+		return err
+	}
+	start := time.Now()
+	if err := similar.Rebuild(ctx, recipes); err != nil {
+		return err
+	}
+	log.Printf("Indexed the similarity of %d recipes in %v", len(recipes), time.Since(start))
+	return nil
+}
+
+// cleanupImages removes the images of recipes that do not exist anymore.
+func cleanupImages(images *handlers.ImageStore, collection *mongo.Collection) error {
+	deleted, err := images.CleanupOrphans(ctx, collection)
+	if deleted > 0 {
+		log.Printf("Deleted %d orphaned image files", deleted)
+	}
+	return err
+}
+
+// cleanupCookbooks removes recipes that do not exist anymore from all
+// cookbooks and favorites.
+func cleanupCookbooks(cookbooks *handlers.CookbookStore, collection *mongo.Collection) error {
+	removed, err := cookbooks.CleanupOrphans(ctx, collection)
+	if removed > 0 {
+		log.Printf("Removed %d purged recipes from cookbooks and favorites", removed)
+	}
+	return err
+}
+
+// cleanupReviews deletes the reviews of recipes that do not exist anymore.
+func cleanupReviews(reviews *mongo.Collection, collection *mongo.Collection) error {
+	deleted, err := handlers.CleanupOrphanReviews(ctx, reviews, collection)
+	if deleted > 0 {
+		log.Printf("Deleted %d reviews of purged recipes", deleted)
+	}
+	return err
+}
+
+// cleanupMealPlans removes recipes that do not exist anymore from all meal
+// plans.
+func cleanupMealPlans(plans *mongo.Collection, collection *mongo.Collection) error {
+	changed, err := handlers.CleanupOrphanMealPlanEntries(ctx, plans, collection)
+	if changed > 0 {
+		log.Printf("Removed purged recipes from %d meal plans", changed)
+	}
+	return err
+}
+
+// cleanupRevisions records the purge of recipes purged from the trash by
+// MongoDB in their revision history.
+func cleanupRevisions(revisions *handlers.RevisionStore, collection *mongo.Collection) error {
+	recorded, err := revisions.RecordPurges(ctx, collection)
+	if recorded > 0 {
+		log.Printf("Recorded the purge of %d recipes", recorded)
+	}
+	return err
+}
+
+// cleanupPeriodically cleans up the images, reviews, cookbooks, favorites and
+// meal plans and records the purge revision of recipes purged from the trash by MongoDB
+// of all tenants, as the TTL index can't take care of them.
+func cleanupPeriodically(interval time.Duration) {
+	for range time.Tick(interval) {
+		all, err := tenants.All()
+		if err != nil {
+			log.Println("Error while loading the tenants:", err)
+			continue
+		}
+		for _, tenant := range all {
+			if err := cleanupImages(tenant.Images, tenant.Recipes); err != nil {
+				log.Println("Error while cleaning up images:", err)
+			}
+			if err := cleanupCookbooks(tenant.Cookbooks, tenant.Recipes); err != nil {
+				log.Println("Error while cleaning up cookbooks:", err)
+			}
+			if err := cleanupReviews(tenant.Reviews, tenant.Recipes); err != nil {
+				log.Println("Error while cleaning up reviews:", err)
+			}
+			if err := cleanupMealPlans(tenant.MealPlans, tenant.Recipes); err != nil {
+				log.Println("Error while cleaning up meal plans:", err)
+			}
+			if err := cleanupRevisions(tenant.Revisions, tenant.Recipes); err != nil {
+				log.Println("Error while recording purges:", err)
+			}
+		}
+	}
+}
+
+// migrateCommand applies the pending migrations, reverts the latest ones or
+// lists all of them with their state.
+func migrateCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up|down [-steps n]|status")
+	}
+	migrator := migrations.New(collection.Database(), migrations.All)
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		log.Printf("Applied %d migrations", applied)
+		return err
+	case "down":
+		flags := flag.NewFlagSet("migrate down", flag.ExitOnError)
+		steps := flags.Int("steps", 1, "number of migrations to revert")
+		flags.Parse(args[1:])
+		reverted, err := migrator.Down(ctx, *steps)
+		log.Printf("Reverted %d migrations", reverted)
+		return err
+	case "status":
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, migration := range status {
+			state := "pending"
+			if migration.AppliedAt != nil {
+				state = "applied " + migration.AppliedAt.Format(time.RFC3339)
+			}
+			if migration.Unknown {
+				state += ", unknown"
+			}
+			fmt.Printf("%4d  %-36s  %s\n", migration.Version, state, migration.Description)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown migrate command %q, use up, down or status", args[0])
+}
+
+// reconcileIndexesCommand makes the indexes match the ones declared in code
+// and prints what has been added, recreated and dropped.
+func reconcileIndexesCommand(args []string) error {
+	flags := flag.NewFlagSet("reconcile-indexes", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only print the differences without changing any index")
+	flags.Parse(args)
+
+	reports, err := commandTenant.ReconcileIndexes(ctx, *dryRun)
+	prefix := ""
+	if *dryRun {
+		prefix = "would be "
+	}
+	changes := 0
+	for _, report := range reports {
+		for _, name := range report.Added {
+			fmt.Printf("%s: %s %sadded\n", report.Collection, name, prefix)
+		}
+		for _, drift := range report.Changed {
+			fmt.Printf("%s: %s %srecreated\n  existing: %s\n  declared: %s\n", report.Collection, drift.Name, prefix, drift.Existing, drift.Declared)
+		}
+		for _, name := range report.Dropped {
+			fmt.Printf("%s: %s %sdropped\n", report.Collection, name, prefix)
+		}
+		if !report.Empty() {
+			changes++
+		}
+	}
+	if err == nil && changes == 0 {
+		fmt.Println("All indexes match their declarations")
+	}
+	return err
+}
+
+// migrationPollInterval is how often starting instances check whether another
+// instance is done migrating.
+const migrationPollInterval = 5 * time.Second
+
+// migrateAllTenants applies the pending migrations to the databases of all
+// tenants, waiting for other instances migrating them at the same time.
+func migrateAllTenants() error {
+	all, err := tenants.All()
+	if err != nil {
+		return err
+	}
+	for _, tenant := range all {
+		applied, err := migrations.New(tenant.Database, migrations.All).UpWhenUnlocked(ctx, migrationPollInterval)
+		if err != nil {
+			return fmt.Errorf("tenant %q: %w", tenant.Name, err)
+		}
+		if applied > 0 {
+			log.Printf("Applied %d migrations to tenant %q", applied, tenant.Name)
+		}
+	}
+	return nil
+}
+
+// migrateLegacyIDsCommand maps the xid IDs of the recipes exported from the
+// first, in-memory version of the API to the stored recipes, so that old
+// links keep working.
+func migrateLegacyIDsCommand(args []string) error {
+	flags := flag.NewFlagSet("migrate-legacy-ids", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only match the recipes without storing their legacy IDs")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("usage: migrate-legacy-ids [-dry-run] <recipes.json>")
+	}
+	data, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	var legacy []struct {
+		ID string `json:"id"`
+		models.Recipe
+	}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+	mapped := 0
+	for _, old := range legacy {
+		if !models.IsLegacyID(old.ID) {
+			log.Printf("Skipping %q: not a legacy ID", old.ID)
+			continue
+		}
+		recipe, err := matchLegacyRecipe(old.Recipe)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			log.Printf("Skipping %s: no recipe matches %q", old.ID, old.Name)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if *dryRun {
+			log.Printf("Matched %s to recipe %s %q", old.ID, recipe.ID.Hex(), recipe.Name)
+			mapped++
+			continue
+		}
+		_, err = collection.UpdateOne(ctx, bson.M{"_id": recipe.ID}, bson.M{"$addToSet": bson.M{"legacyIds": old.ID}})
+		if mongo.IsDuplicateKeyError(err) {
+			log.Printf("Skipping %s: mapped to another recipe already", old.ID)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		mapped++
+	}
+	log.Printf("Mapped %d of %d legacy IDs", mapped, len(legacy))
+	return nil
+}
+
+// matchLegacyRecipe finds the stored recipe for a recipe of the first
+// version: the one with the same fingerprint, else the only one of the same
+// name.
+func matchLegacyRecipe(legacy models.Recipe) (*models.Recipe, error) {
+	var recipe models.Recipe
+	err := collection.FindOne(ctx, bson.M{"fingerprint.hash": models.NewFingerprint(legacy).Hash}).Decode(&recipe)
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return &recipe, err
+	}
+	cur, err := collection.Find(ctx, bson.M{"name": legacy.Name}, options.Find().SetLimit(2))
+	if err != nil {
+		return nil, err
+	}
+	recipes := make([]models.Recipe, 0)
+	if err := cur.All(ctx, &recipes); err != nil {
+		return nil, err
+	}
+	if len(recipes) != 1 {
+		return nil, mongo.ErrNoDocuments
+	}
+	return &recipes[0], nil
+}
@@ -0,0 +1,118 @@
+package cooklang
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/aheadxnet/go-sandbox/models"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Marshal writes a recipe as Cooklang document. Each ingredient is marked
+// at its first mention in the instructions; ingredients the instructions
+// never mention are listed in an extra first step.
+func Marshal(recipe models.Recipe) []byte {
+	var buf bytes.Buffer
+	writeMetadata(&buf, "title", recipe.Name)
+	if len(recipe.Tags) > 0 {
+		writeMetadata(&buf, "tags", strings.Join(recipe.Tags, ", "))
+	}
+	if recipe.Servings > 0 {
+		writeMetadata(&buf, "servings", strconv.Itoa(recipe.Servings))
+	}
+	writeMetadata(&buf, "yield", recipe.Yield)
+	if recipe.PrepTime > 0 {
+		writeMetadata(&buf, "prep time", fmt.Sprintf("%d minutes", recipe.PrepTime))
+	}
+	if recipe.CookTime > 0 {
+		writeMetadata(&buf, "cook time", fmt.Sprintf("%d minutes", recipe.CookTime))
+	}
+	if recipe.TotalTime > 0 {
+		writeMetadata(&buf, "time", fmt.Sprintf("%d minutes", recipe.TotalTime))
+	}
+	writeMetadata(&buf, "image", recipe.Image)
+	writeMetadata(&buf, "source", recipe.Source)
+	buf.WriteString("\n")
+
+	steps := make([]string, len(recipe.Instructions))
+	for i, step := range recipe.Instructions {
+		steps[i] = strings.Join(strings.Fields(step), " ")
+	}
+	unmentioned := make([]string, 0)
+	for _, line := range recipe.Ingredients {
+		ingredient := models.ParseIngredient(line)
+		if ingredient.Name == "" {
+			continue
+		}
+		marker := ingredientMarker(ingredient)
+		if !markFirstMention(steps, ingredient.Name, marker) {
+			unmentioned = append(unmentioned, marker)
+		}
+	}
+	if len(unmentioned) > 0 {
+		steps = append([]string{"Ingredients: " + strings.Join(unmentioned, ", ")}, steps...)
+	}
+	for _, step := range steps {
+		buf.WriteString(step)
+		buf.WriteString("\n\n")
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n")
+}
+
+func writeMetadata(buf *bytes.Buffer, key string, value string) {
+	value = strings.Join(strings.Fields(value), " ")
+	if value != "" {
+		fmt.Fprintf(buf, ">> %s: %s\n", key, value)
+	}
+}
+
+func ingredientMarker(ingredient models.Ingredient) string {
+	name := strings.NewReplacer("{", "(", "}", ")", "@", "", "#", "", "~", "").Replace(ingredient.Name)
+	amount := formatQuantity(ingredient.Quantity)
+	if ingredient.Unit != "" {
+		amount += "%" + ingredient.Unit
+	}
+	return "@" + name + "{" + amount + "}"
+}
+
+// markFirstMention replaces the first case insensitive mention of name
+// outside of other markers with the marker.
+func markFirstMention(steps []string, name string, marker string) bool {
+	lowerName := strings.ToLower(name)
+	for i, step := range steps {
+		lowerStep := strings.ToLower(step)
+		offset := 0
+		for {
+			index := strings.Index(lowerStep[offset:], lowerName)
+			if index < 0 {
+				break
+			}
+			index += offset
+			if !insideMarker(step, index) && len(lowerStep) == len(step) && wordBoundary(step, index, index+len(name)) {
+				steps[i] = step[:index] + marker + step[index+len(name):]
+				return true
+			}
+			offset = index + len(lowerName)
+		}
+	}
+	return false
+}
+
+// wordBoundary reports whether step[start:end] is a whole word.
+func wordBoundary(step string, start int, end int) bool {
+	before, _ := utf8.DecodeLastRuneInString(step[:start])
+	after, _ := utf8.DecodeRuneInString(step[end:])
+	return !unicode.IsLetter(before) && !unicode.IsLetter(after)
+}
+
+// insideMarker reports whether position is part of a marker already inserted.
+func insideMarker(step string, position int) bool {
+	start := strings.LastIndexAny(step[:position], "@#~")
+	if start < 0 {
+		return false
+	}
+	end := strings.Index(step[start:], "}")
+	return end >= 0 && start+end >= position
+}
@@ -0,0 +1,84 @@
+package cooklang
+
+import (
+	"github.com/aheadxnet/go-sandbox/models"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMarshalRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		recipe models.Recipe
+		// instructions differ from the recipe's if ingredients aren't
+		// mentioned in them
+		instructions []string
+	}{
+		{
+			name: "all fields",
+			recipe: models.Recipe{
+				Name:         "Pizza Margherita",
+				Tags:         []string{"italian", "baking"},
+				Ingredients:  []string{"500 g flour", "1 tsp salt", "2 tbsp olive oil"},
+				Instructions: []string{"Mix the flour with the salt.", "Drizzle with olive oil and pre--heat the oven."},
+				Servings:     2,
+				Yield:        "2 pizzas",
+				PrepTime:     20,
+				CookTime:     10,
+				TotalTime:    90,
+				Image:        "https://example.com/pizza.jpg",
+				Source:       "https://x--y.com/pizza",
+			},
+		},
+		{
+			name: "unmentioned ingredients",
+			recipe: models.Recipe{
+				Name:         "Tea",
+				Tags:         []string{},
+				Ingredients:  []string{"1 pinch sugar", "water"},
+				Instructions: []string{"Boil the water."},
+			},
+			instructions: []string{"Ingredients: sugar", "Boil the water."},
+		},
+		{
+			name: "whitespace",
+			recipe: models.Recipe{
+				Name:         "Toast",
+				Tags:         []string{},
+				Ingredients:  []string{"2 slices bread"},
+				Instructions: []string{"  Toast\n the   bread. "},
+			},
+			instructions: []string{"Ingredients: slices bread", "Toast the bread."},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			document := Marshal(test.recipe)
+			parsed, err := Parse(document)
+			if err != nil {
+				t.Fatalf("%v in\n%s", err, document)
+			}
+			want := test.recipe
+			if test.instructions != nil {
+				want.Instructions = test.instructions
+			}
+			if got := parsed.ToModel(""); !reflect.DeepEqual(got, want) {
+				t.Errorf("got\n%+v\nwant\n%+v\nfrom\n%s", got, want, document)
+			}
+		})
+	}
+}
+
+func TestMarshalMarksFirstMention(t *testing.T) {
+	document := string(Marshal(models.Recipe{
+		Name:         "Salad",
+		Ingredients:  []string{"1 tomato", "2 tbsp oil"},
+		Instructions: []string{"Cut the tomato and the tomatoes.", "Add oil, then more oil."},
+	}))
+	for _, want := range []string{"Cut the @tomato{1} and the tomatoes.", "Add @oil{2%tbsp}, then more oil."} {
+		if !strings.Contains(document, want) {
+			t.Errorf("%q is missing in\n%s", want, document)
+		}
+	}
+}
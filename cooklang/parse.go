@@ -0,0 +1,180 @@
+// Package cooklang reads and writes recipes in the Cooklang format (https://cooklang.org).
+package cooklang
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/aheadxnet/go-sandbox/models"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MIMEType is the media type of Cooklang documents.
+const MIMEType = "text/x-cooklang"
+
+// Extension is the file extension of Cooklang documents.
+const Extension = ".cook"
+
+// Timer is a ~timer{} of a step.
+type Timer struct {
+	Name     string
+	Quantity float64
+	Unit     string
+}
+
+// Recipe is a parsed Cooklang document.
+type Recipe struct {
+	// Metadata holds the ">> key: value" lines with lower case keys.
+	Metadata    map[string]string
+	Steps       []string
+	Ingredients []models.Ingredient
+	Cookware    []string
+	Timers      []Timer
+}
+
+var (
+	blockCommentPattern = regexp.MustCompile(`(?s)\[-.*?-\]`)
+	// lineCommentPattern matches "--" comments, which start a line or follow
+	// whitespace, so URLs like https://x--y.com and words like pre--heat stay.
+	lineCommentPattern = regexp.MustCompile(`(?:^|\s)--.*$`)
+	// markerPattern matches @ingredient, #cookware and ~timer, either as single
+	// word or as several words closed by braces holding an optional amount.
+	markerPattern = regexp.MustCompile(`([@#~])(?:([^@#~{}\n]*?)\{([^}]*)\}|([\p{L}\p{N}_\-]+))`)
+)
+
+// Parse reads a Cooklang document. Every non-empty line is a step.
+func Parse(data []byte) (*Recipe, error) {
+	recipe := &Recipe{
+		Metadata:    make(map[string]string),
+		Steps:       make([]string, 0),
+		Ingredients: make([]models.Ingredient, 0),
+		Cookware:    make([]string, 0),
+		Timers:      make([]Timer, 0),
+	}
+	text := blockCommentPattern.ReplaceAll(data, nil)
+	scanner := bufio.NewScanner(bytes.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(lineCommentPattern.ReplaceAllString(scanner.Text(), ""))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, ">>") {
+			parts := strings.SplitN(strings.TrimPrefix(line, ">>"), ":", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid metadata line %q", line)
+			}
+			recipe.Metadata[strings.ToLower(strings.TrimSpace(parts[0]))] = strings.TrimSpace(parts[1])
+			continue
+		}
+		recipe.Steps = append(recipe.Steps, recipe.parseStep(line))
+	}
+	return recipe, scanner.Err()
+}
+
+// parseStep collects the markers of a step and returns its plain text.
+func (recipe *Recipe) parseStep(line string) string {
+	return markerPattern.ReplaceAllStringFunc(line, func(marker string) string {
+		parts := markerPattern.FindStringSubmatch(marker)
+		name := strings.TrimSpace(parts[2] + parts[4])
+		quantity, unit := parseAmount(parts[3])
+		switch parts[1] {
+		case "@":
+			recipe.Ingredients = append(recipe.Ingredients, models.Ingredient{Quantity: quantity, Unit: unit, Name: name})
+			return name
+		case "#":
+			recipe.Cookware = append(recipe.Cookware, name)
+			return name
+		default:
+			recipe.Timers = append(recipe.Timers, Timer{Name: name, Quantity: quantity, Unit: unit})
+			return strings.TrimSpace(formatQuantity(quantity) + " " + unit)
+		}
+	})
+}
+
+// parseAmount reads "qty%unit", "qty" or an empty amount.
+func parseAmount(amount string) (float64, string) {
+	parts := strings.SplitN(amount, "%", 2)
+	quantity := 0.0
+	if text := strings.TrimSpace(parts[0]); text != "" {
+		if fraction := strings.SplitN(text, "/", 2); len(fraction) == 2 {
+			numerator, _ := strconv.ParseFloat(strings.TrimSpace(fraction[0]), 64)
+			denominator, _ := strconv.ParseFloat(strings.TrimSpace(fraction[1]), 64)
+			if denominator != 0 {
+				quantity = numerator / denominator
+			}
+		} else {
+			quantity, _ = strconv.ParseFloat(strings.Replace(text, ",", ".", 1), 64)
+		}
+	}
+	if len(parts) == 2 {
+		return quantity, strings.TrimSpace(parts[1])
+	}
+	return quantity, ""
+}
+
+// ToModel maps the document onto a recipe. The name is taken from the title
+// metadata, falling back to the given name, like the file name.
+func (recipe *Recipe) ToModel(fallbackName string) models.Recipe {
+	model := models.Recipe{
+		Name:         firstOf(recipe.Metadata, "title", "name"),
+		Tags:         make([]string, 0),
+		Ingredients:  make([]string, 0, len(recipe.Ingredients)),
+		Instructions: recipe.Steps,
+		Yield:        recipe.Metadata["yield"],
+		Image:        recipe.Metadata["image"],
+		Source:       firstOf(recipe.Metadata, "source", "source.url", "url"),
+	}
+	if model.Name == "" {
+		model.Name = fallbackName
+	}
+	for _, tag := range strings.Split(recipe.Metadata["tags"], ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			model.Tags = append(model.Tags, tag)
+		}
+	}
+	for _, ingredient := range recipe.Ingredients {
+		model.Ingredients = append(model.Ingredients, ingredient.String())
+	}
+	if servings := firstOf(recipe.Metadata, "servings", "serves"); servings != "" {
+		model.Servings, _ = strconv.Atoi(strings.Fields(servings)[0])
+	}
+	model.PrepTime = parseMinutes(firstOf(recipe.Metadata, "prep time", "prep_time", "time.prep"))
+	model.CookTime = parseMinutes(firstOf(recipe.Metadata, "cook time", "cook_time", "time.cook"))
+	model.TotalTime = parseMinutes(firstOf(recipe.Metadata, "time", "total time", "duration"))
+	return model
+}
+
+var durationPartPattern = regexp.MustCompile(`(?i)(\d+(?:[.,]\d+)?)\s*(h|hours?|std|stunden?|m|mins?|minutes?|minuten?)?`)
+
+// parseMinutes reads times like "20 minutes", "1 hour 30 min" or "1h30m".
+func parseMinutes(text string) int {
+	minutes := 0.0
+	for _, part := range durationPartPattern.FindAllStringSubmatch(text, -1) {
+		value, _ := strconv.ParseFloat(strings.Replace(part[1], ",", ".", 1), 64)
+		switch strings.ToLower(part[2]) {
+		case "h", "hour", "hours", "std", "stunde", "stunden":
+			minutes += value * 60
+		default:
+			minutes += value
+		}
+	}
+	return int(minutes)
+}
+
+func firstOf(metadata map[string]string, keys ...string) string {
+	for _, key := range keys {
+		if value := metadata[key]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func formatQuantity(quantity float64) string {
+	if quantity == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(quantity, 'f', -1, 64)
+}
@@ -0,0 +1,139 @@
+package cooklang
+
+import (
+	"github.com/aheadxnet/go-sandbox/models"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name        string
+		document    string
+		steps       []string
+		ingredients []models.Ingredient
+		cookware    []string
+		timers      []Timer
+		metadata    map[string]string
+	}{
+		{
+			name:     "ingredients and quantities",
+			document: "Add @salt and @olive oil{2%tbsp} to the @flour{1/2%kg}.\n@eggs{3} go in last.",
+			steps:    []string{"Add salt and olive oil to the flour.", "eggs go in last."},
+			ingredients: []models.Ingredient{
+				{Name: "salt"},
+				{Quantity: 2, Unit: "tbsp", Name: "olive oil"},
+				{Quantity: 0.5, Unit: "kg", Name: "flour"},
+				{Quantity: 3, Name: "eggs"},
+			},
+		},
+		{
+			name:     "decimal comma",
+			document: "Add @milk{1,5%l}.",
+			steps:    []string{"Add milk."},
+			ingredients: []models.Ingredient{
+				{Quantity: 1.5, Unit: "l", Name: "milk"},
+			},
+		},
+		{
+			name:     "line comments",
+			document: "-- a whole line\nPre--heat the oven -- to 200 °C\nSee https://x--y.com for more\t-- tabs too",
+			steps:    []string{"Pre--heat the oven", "See https://x--y.com for more"},
+		},
+		{
+			name:     "block comments",
+			document: "[- spans\nlines -]Mix [- inline -]well",
+			steps:    []string{"Mix well"},
+		},
+		{
+			name:     "metadata",
+			document: ">> Title: Pizza\n>> servings: 2\n>> source: https://x--y.com/pizza\nBake.",
+			steps:    []string{"Bake."},
+			metadata: map[string]string{"title": "Pizza", "servings": "2", "source": "https://x--y.com/pizza"},
+		},
+		{
+			name:     "cookware and timers",
+			document: "Boil in a #pot for ~{10%minutes}, then fry in the #frying pan{} for ~browning{2%min}.",
+			steps:    []string{"Boil in a pot for 10 minutes, then fry in the frying pan for 2 min."},
+			cookware: []string{"pot", "frying pan"},
+			timers:   []Timer{{Quantity: 10, Unit: "minutes"}, {Name: "browning", Quantity: 2, Unit: "min"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recipe, err := Parse([]byte(test.document))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(recipe.Steps, test.steps) {
+				t.Errorf("got steps %q, want %q", recipe.Steps, test.steps)
+			}
+			if test.ingredients == nil {
+				test.ingredients = []models.Ingredient{}
+			}
+			if !reflect.DeepEqual(recipe.Ingredients, test.ingredients) {
+				t.Errorf("got ingredients %+v, want %+v", recipe.Ingredients, test.ingredients)
+			}
+			if test.cookware == nil {
+				test.cookware = []string{}
+			}
+			if !reflect.DeepEqual(recipe.Cookware, test.cookware) {
+				t.Errorf("got cookware %q, want %q", recipe.Cookware, test.cookware)
+			}
+			if test.timers == nil {
+				test.timers = []Timer{}
+			}
+			if !reflect.DeepEqual(recipe.Timers, test.timers) {
+				t.Errorf("got timers %+v, want %+v", recipe.Timers, test.timers)
+			}
+			if test.metadata == nil {
+				test.metadata = map[string]string{}
+			}
+			if !reflect.DeepEqual(recipe.Metadata, test.metadata) {
+				t.Errorf("got metadata %q, want %q", recipe.Metadata, test.metadata)
+			}
+		})
+	}
+}
+
+func TestParseInvalidMetadata(t *testing.T) {
+	if _, err := Parse([]byte(">> no colon\nBake.")); err == nil {
+		t.Error("parsed metadata without colon")
+	}
+}
+
+func TestToModel(t *testing.T) {
+	document := `>> title: Pizza
+>> tags: italian, baking
+>> servings: 4 people
+>> prep time: 1 hour 30 min
+>> cook time: 15 minutes
+>> url: https://example.com/pizza
+
+Mix @flour{500%g} with @water{300%ml} in a #bowl.
+Bake for ~{15%minutes}.`
+	recipe, err := Parse([]byte(document))
+	if err != nil {
+		t.Fatal(err)
+	}
+	model := recipe.ToModel("fallback")
+	want := models.Recipe{
+		Name:        "Pizza",
+		Tags:        []string{"italian", "baking"},
+		Ingredients: []string{"500 g flour", "300 ml water"},
+		// Recipes have no cookware and timers, only their text is kept.
+		Instructions: []string{"Mix flour with water in a bowl.", "Bake for 15 minutes."},
+		Servings:     4,
+		PrepTime:     90,
+		CookTime:     15,
+		Source:       "https://example.com/pizza",
+	}
+	if !reflect.DeepEqual(model, want) {
+		t.Errorf("got %+v, want %+v", model, want)
+	}
+
+	untitled, _ := Parse([]byte("Bake."))
+	if name := untitled.ToModel("fallback").Name; name != "fallback" {
+		t.Errorf("got name %q, want the fallback", name)
+	}
+}
@@ -0,0 +1,175 @@
+// Package export renders recipes in the formats offered by the API and the
+// export command: schema.org JSON-LD, Markdown, printable HTML and Cooklang.
+package export
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"github.com/aheadxnet/go-sandbox/cooklang"
+	"github.com/aheadxnet/go-sandbox/models"
+	"github.com/aheadxnet/go-sandbox/schemaorg"
+	"html/template"
+	"io"
+	"strings"
+)
+
+// Media types of the export formats.
+const (
+	MIMEJSONLD   = "application/ld+json"
+	MIMEMarkdown = "text/markdown"
+	MIMEHTML     = "text/html"
+)
+
+// HTMLTemplate is the name of the template rendering a list of recipes.
+const HTMLTemplate = "recipes.html"
+
+//go:embed templates/*.html
+var templateFiles embed.FS
+
+// Templates holds the HTML templates, gin uses them to render text/html responses.
+var Templates = template.Must(template.New("").Funcs(template.FuncMap{
+	"minutes": FormatMinutes,
+}).ParseFS(templateFiles, "templates/*.html"))
+
+// HTMLPage is the data the HTML template is rendered with.
+type HTMLPage struct {
+	Title   string
+	Recipes []models.Recipe
+}
+
+// Format is an export format with its media type and file extension.
+type Format struct {
+	Name      string
+	MediaType string
+	Extension string
+	// Recipe renders a single recipe.
+	Recipe func(w io.Writer, recipe models.Recipe) error
+	// List renders several recipes into one document, nil for formats
+	// holding a single recipe per document.
+	List func(w io.Writer, recipes []models.Recipe) error
+}
+
+// Formats are all available export formats.
+var Formats = []Format{
+	{Name: "jsonld", MediaType: MIMEJSONLD, Extension: ".jsonld", Recipe: JSONLD, List: JSONLDList},
+	{Name: "markdown", MediaType: MIMEMarkdown, Extension: ".md", Recipe: Markdown, List: MarkdownList},
+	{Name: "html", MediaType: MIMEHTML, Extension: ".html", Recipe: HTML, List: HTMLList},
+	{Name: "cooklang", MediaType: cooklang.MIMEType, Extension: cooklang.Extension, Recipe: Cooklang},
+}
+
+// ByName returns the format with the given name.
+func ByName(name string) (Format, bool) {
+	for _, format := range Formats {
+		if format.Name == name {
+			return format, true
+		}
+	}
+	return Format{}, false
+}
+
+// JSONLD writes a recipe as schema.org JSON-LD document.
+func JSONLD(w io.Writer, recipe models.Recipe) error {
+	data, err := schemaorg.Marshal(recipe)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// JSONLDList writes recipes as schema.org JSON-LD document with a @graph.
+func JSONLDList(w io.Writer, recipes []models.Recipe) error {
+	data, err := schemaorg.MarshalList(recipes)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Markdown writes a recipe as Markdown document.
+func Markdown(w io.Writer, recipe models.Recipe) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# %s\n\n", recipe.Name)
+	facts := make([]string, 0)
+	if recipe.Yield != "" {
+		facts = append(facts, recipe.Yield)
+	} else if recipe.Servings > 0 {
+		facts = append(facts, fmt.Sprintf("%d servings", recipe.Servings))
+	}
+	if recipe.PrepTime > 0 {
+		facts = append(facts, "Preparation "+FormatMinutes(recipe.PrepTime))
+	}
+	if recipe.CookTime > 0 {
+		facts = append(facts, "Cooking "+FormatMinutes(recipe.CookTime))
+	}
+	if recipe.TotalTime > 0 {
+		facts = append(facts, "Total "+FormatMinutes(recipe.TotalTime))
+	}
+	if len(facts) > 0 {
+		fmt.Fprintf(&buf, "_%s_\n\n", strings.Join(facts, " · "))
+	}
+	if len(recipe.Tags) > 0 {
+		fmt.Fprintf(&buf, "Tags: %s\n\n", strings.Join(recipe.Tags, ", "))
+	}
+	if recipe.Image != "" {
+		fmt.Fprintf(&buf, "![%s](%s)\n\n", recipe.Name, recipe.Image)
+	}
+	buf.WriteString("## Ingredients\n\n")
+	for _, ingredient := range recipe.Ingredients {
+		fmt.Fprintf(&buf, "- %s\n", strings.TrimSpace(ingredient))
+	}
+	buf.WriteString("\n## Instructions\n\n")
+	for i, instruction := range recipe.Instructions {
+		fmt.Fprintf(&buf, "%d. %s\n", i+1, strings.TrimSpace(instruction))
+	}
+	if recipe.Source != "" {
+		fmt.Fprintf(&buf, "\nSource: <%s>\n", recipe.Source)
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// MarkdownList writes recipes as one Markdown document separated by rules.
+func MarkdownList(w io.Writer, recipes []models.Recipe) error {
+	for i, recipe := range recipes {
+		if i > 0 {
+			if _, err := io.WriteString(w, "\n---\n\n"); err != nil {
+				return err
+			}
+		}
+		if err := Markdown(w, recipe); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Cooklang writes a recipe as Cooklang document.
+func Cooklang(w io.Writer, recipe models.Recipe) error {
+	_, err := w.Write(cooklang.Marshal(recipe))
+	return err
+}
+
+// HTML writes a recipe as printable HTML page.
+func HTML(w io.Writer, recipe models.Recipe) error {
+	return Templates.ExecuteTemplate(w, HTMLTemplate, HTMLPage{Title: recipe.Name, Recipes: []models.Recipe{recipe}})
+}
+
+// HTMLList writes recipes as one printable HTML page, one recipe per printed page.
+func HTMLList(w io.Writer, recipes []models.Recipe) error {
+	return Templates.ExecuteTemplate(w, HTMLTemplate, HTMLPage{Title: "Recipes", Recipes: recipes})
+}
+
+// FormatMinutes formats a time in minutes like "1 h 30 min".
+func FormatMinutes(minutes int) string {
+	switch {
+	case minutes < 60:
+		return fmt.Sprintf("%d min", minutes)
+	case minutes%60 == 0:
+		return fmt.Sprintf("%d h", minutes/60)
+	default:
+		return fmt.Sprintf("%d h %d min", minutes/60, minutes%60)
+	}
+}
@@ -0,0 +1,224 @@
+// Package formats encodes values as JSON, YAML, XML, MessagePack and CBOR.
+// All formats go through the JSON encoding of a value, so they share the
+// field names of the json tags and the encoding of types like IDs and times.
+package formats
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/ugorji/go/codec"
+	"gopkg.in/yaml.v2"
+	"reflect"
+)
+
+// The media types of the formats.
+const (
+	MIMEJSON    = "application/json"
+	MIMEYAML    = "application/yaml"
+	MIMEXML     = "application/xml"
+	MIMEMsgPack = "application/msgpack"
+	MIMECBOR    = "application/cbor"
+)
+
+// MediaTypes are the media types of all formats, JSON first.
+var MediaTypes = []string{MIMEJSON, MIMEYAML, MIMEXML, MIMEMsgPack, MIMECBOR}
+
+// aliases are media types still in use for some of the formats.
+var aliases = map[string]string{
+	"application/x-yaml":    MIMEYAML,
+	"text/yaml":             MIMEYAML,
+	"text/xml":              MIMEXML,
+	"application/x-msgpack": MIMEMsgPack,
+}
+
+var (
+	msgpackHandle = &codec.MsgpackHandle{WriteExt: true}
+	cborHandle    = &codec.CborHandle{}
+)
+
+func init() {
+	mapType := reflect.TypeOf(map[string]interface{}(nil))
+	msgpackHandle.MapType = mapType
+	msgpackHandle.RawToString = true
+	msgpackHandle.Canonical = true
+	cborHandle.MapType = mapType
+	cborHandle.Canonical = true
+}
+
+// Supported returns the format of a media type, or of one of its aliases,
+// and whether there is one.
+func Supported(mediaType string) (string, bool) {
+	if alias, ok := aliases[mediaType]; ok {
+		return alias, true
+	}
+	for _, supported := range MediaTypes {
+		if supported == mediaType {
+			return supported, true
+		}
+	}
+	return "", false
+}
+
+// Marshal encodes the value in the format of the media type.
+func Marshal(mediaType string, value interface{}) ([]byte, error) {
+	format, ok := Supported(mediaType)
+	if !ok {
+		return nil, fmt.Errorf("unsupported media type %q", mediaType)
+	}
+	if format == MIMEJSON {
+		return json.Marshal(value)
+	}
+	ordered, err := toOrdered(value)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case MIMEYAML:
+		return yaml.Marshal(ordered)
+	case MIMEXML:
+		return marshalXML(rootName(reflect.TypeOf(value)), ordered)
+	case MIMEMsgPack:
+		return encode(msgpackHandle, ordered)
+	default:
+		return encode(cborHandle, ordered)
+	}
+}
+
+// Unmarshal decodes data in the format of the media type into the value, as
+// if it was the JSON encoding of the data.
+func Unmarshal(mediaType string, data []byte, value interface{}) error {
+	format, ok := Supported(mediaType)
+	if !ok {
+		return fmt.Errorf("unsupported media type %q", mediaType)
+	}
+	if format == MIMEJSON {
+		return json.Unmarshal(data, value)
+	}
+	var document interface{}
+	var err error
+	switch format {
+	case MIMEYAML:
+		err = yaml.Unmarshal(data, &document)
+	case MIMEXML:
+		document, err = unmarshalXML(data)
+	case MIMEMsgPack:
+		err = codec.NewDecoderBytes(data, msgpackHandle).Decode(&document)
+	default:
+		err = codec.NewDecoderBytes(data, cborHandle).Decode(&document)
+	}
+	if err != nil {
+		return err
+	}
+	data, err = json.Marshal(jsonValue(document))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+func encode(handle codec.Handle, ordered interface{}) ([]byte, error) {
+	var data []byte
+	err := codec.NewEncoderBytes(&data, handle).Encode(unordered(ordered))
+	return data, err
+}
+
+// toOrdered returns the JSON encoding of the value as generic value, with
+// objects as yaml.MapSlice to keep the order of their fields.
+func toOrdered(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decodeOrdered(decoder)
+}
+
+// decodeOrdered decodes the next JSON value, objects into yaml.MapSlice to
+// keep the order of their fields.
+func decodeOrdered(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token := token.(type) {
+	case json.Delim:
+		if token == '{' {
+			object := yaml.MapSlice{}
+			for decoder.More() {
+				key, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := decodeOrdered(decoder)
+				if err != nil {
+					return nil, err
+				}
+				object = append(object, yaml.MapItem{Key: key, Value: value})
+			}
+			_, err := decoder.Token()
+			return object, err
+		}
+		array := make([]interface{}, 0)
+		for decoder.More() {
+			value, err := decodeOrdered(decoder)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		_, err := decoder.Token()
+		return array, err
+	case json.Number:
+		if n, err := token.Int64(); err == nil {
+			return n, nil
+		}
+		return token.Float64()
+	}
+	return token, nil
+}
+
+// unordered turns the yaml.MapSlice objects of an ordered value into maps,
+// which the binary formats encode as maps.
+func unordered(value interface{}) interface{} {
+	switch value := value.(type) {
+	case yaml.MapSlice:
+		object := make(map[string]interface{}, len(value))
+		for _, item := range value {
+			object[fmt.Sprint(item.Key)] = unordered(item.Value)
+		}
+		return object
+	case []interface{}:
+		array := make([]interface{}, len(value))
+		for i, element := range value {
+			array[i] = unordered(element)
+		}
+		return array
+	}
+	return value
+}
+
+// jsonValue turns the maps decoded by yaml.v2 and codec into maps JSON can
+// encode.
+func jsonValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		object := make(map[string]interface{}, len(value))
+		for key, element := range value {
+			object[fmt.Sprint(key)] = jsonValue(element)
+		}
+		return object
+	case map[string]interface{}:
+		for key, element := range value {
+			value[key] = jsonValue(element)
+		}
+	case []interface{}:
+		for i, element := range value {
+			value[i] = jsonValue(element)
+		}
+	case []byte:
+		return string(value)
+	}
+	return value
+}
@@ -0,0 +1,119 @@
+package formats
+
+import (
+	"github.com/aheadxnet/go-sandbox/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testRecipe() models.Recipe {
+	deletedAt := time.Date(2022, 3, 4, 5, 6, 7, 890000000, time.UTC)
+	return models.Recipe{
+		ID:           primitive.NewObjectID(),
+		Name:         "Fish & Chips <classic>",
+		Slug:         "fish-chips-classic",
+		Tags:         []string{"yes", "null", "123", "y", "2021-01-01"},
+		Ingredients:  []string{},
+		Instructions: []string{"  Cut the potatoes\n into fries  ", "Fry"},
+		Servings:     4,
+		Yield:        "4 portions",
+		PrepTime:     20,
+		TotalTime:    45,
+		Rating:       models.Rating{Average: 4.5, Count: 2},
+		Photos: []models.Photo{{
+			ID:          primitive.NewObjectID(),
+			ThumbnailID: primitive.NewObjectID(),
+			URL:         "/images/1",
+			ContentType: "image/jpeg",
+			Width:       800,
+			Height:      600,
+			Size:        1 << 40,
+			UploadedAt:  time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC),
+		}},
+		Language: "en",
+		Translations: map[string]models.RecipeTranslation{
+			"de":    {Name: "Fisch und Pommes", Ingredients: []string{"Kabeljau"}},
+			"pt-BR": {Name: "Peixe com batatas"},
+			"xml":   {Name: "a key that is no element name"},
+		},
+		Source:      "https://example.com/fish?a=1&b=2",
+		PublishedAt: time.Date(2021, 12, 24, 18, 0, 0, 0, time.UTC),
+		DeletedAt:   &deletedAt,
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, mediaType := range MediaTypes {
+		t.Run(mediaType, func(t *testing.T) {
+			recipe := testRecipe()
+			data, err := Marshal(mediaType, recipe)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decoded models.Recipe
+			if err := Unmarshal(mediaType, data, &decoded); err != nil {
+				t.Fatalf("%v in\n%s", err, data)
+			}
+			if !reflect.DeepEqual(decoded, recipe) {
+				t.Errorf("got\n%+v\nwant\n%+v\nfrom\n%s", decoded, recipe, data)
+			}
+
+			recipes := []models.Recipe{recipe, {ID: primitive.NewObjectID(), Name: "Pizza"}}
+			data, err = Marshal(mediaType, recipes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decodedList []models.Recipe
+			if err := Unmarshal(mediaType, data, &decodedList); err != nil {
+				t.Fatalf("%v in\n%s", err, data)
+			}
+			if !reflect.DeepEqual(decodedList, recipes) {
+				t.Errorf("got\n%+v\nwant\n%+v\nfrom\n%s", decodedList, recipes, data)
+			}
+		})
+	}
+}
+
+func TestXML(t *testing.T) {
+	data, err := Marshal(MIMEXML, []models.Recipe{testRecipe()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"<recipes type=\"array\">", "<servings type=\"number\">4</servings>", "<pt-BR>", "<item key=\"xml\">"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("%s is missing in\n%s", want, data)
+		}
+	}
+
+	// Documents written by hand may leave out the types of strings and
+	// objects and be indented.
+	document := `<?xml version="1.0"?>
+<recipe>
+  <name>Pizza</name>
+  <tags type="array">
+    <item>italian</item>
+  </tags>
+  <rating>
+    <average type="number">5</average>
+  </rating>
+</recipe>`
+	var recipe models.Recipe
+	if err := Unmarshal("text/xml", []byte(document), &recipe); err != nil {
+		t.Fatal(err)
+	}
+	if recipe.Name != "Pizza" || !reflect.DeepEqual(recipe.Tags, []string{"italian"}) || recipe.Rating.Average != 5 {
+		t.Errorf("got %+v", recipe)
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	if _, err := Marshal("text/csv", testRecipe()); err == nil {
+		t.Error("encoded as text/csv")
+	}
+	if format, ok := Supported("application/x-yaml"); !ok || format != MIMEYAML {
+		t.Errorf("got %q for application/x-yaml", format)
+	}
+}
@@ -0,0 +1,214 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The XML of a value has an element per field, named like the field. Values
+// other than strings and objects name their type in a type attribute:
+//
+//	<recipe>
+//	  <name>Pizza</name>
+//	  <tags type="array"><item>italian</item></tags>
+//	  <servings type="number">2</servings>
+//	  <deletedAt type="null"/>
+//	</recipe>
+//
+// Keys that aren't XML names, like those of some maps, become item elements
+// with the key in a key attribute.
+
+// xmlName matches the keys that can be used as element names.
+var xmlName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9._-]*$`)
+
+// rootName names the root element by the Go type of the value, like recipe
+// for a models.Recipe and recipes for a slice of them.
+func rootName(t reflect.Type) string {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == nil:
+		return "value"
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		if t.Elem().Name() == "" {
+			return "items"
+		}
+		return rootName(t.Elem()) + "s"
+	case t.Name() == "":
+		return "value"
+	}
+	runes := []rune(t.Name())
+	runes[0] = unicode.ToLower(runes[0])
+	return string(runes)
+}
+
+func marshalXML(root string, ordered interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	encoder := xml.NewEncoder(&buf)
+	encoder.Indent("", "  ")
+	if err := writeElement(encoder, root, ordered); err != nil {
+		return nil, err
+	}
+	if err := encoder.Flush(); err != nil {
+		return nil, err
+	}
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+func writeElement(encoder *xml.Encoder, key string, value interface{}) error {
+	start := xml.StartElement{Name: xml.Name{Local: key}}
+	if !xmlName.MatchString(key) || strings.HasPrefix(strings.ToLower(key), "xml") {
+		start.Name.Local = "item"
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "key"}, Value: key})
+	}
+	typed := func(name string) {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "type"}, Value: name})
+	}
+	text := ""
+	var children func() error
+	switch value := value.(type) {
+	case nil:
+		typed("null")
+	case string:
+		text = value
+	case bool:
+		typed("boolean")
+		text = strconv.FormatBool(value)
+	case int64:
+		typed("number")
+		text = strconv.FormatInt(value, 10)
+	case float64:
+		typed("number")
+		text = strconv.FormatFloat(value, 'g', -1, 64)
+	case []interface{}:
+		typed("array")
+		children = func() error {
+			for _, element := range value {
+				if err := writeElement(encoder, "item", element); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case yaml.MapSlice:
+		if len(value) == 0 {
+			typed("object")
+		}
+		children = func() error {
+			for _, item := range value {
+				if err := writeElement(encoder, fmt.Sprint(item.Key), item.Value); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	default:
+		return fmt.Errorf("cannot encode %T as XML", value)
+	}
+
+	if err := encoder.EncodeToken(start); err != nil {
+		return err
+	}
+	if children != nil {
+		if err := children(); err != nil {
+			return err
+		}
+	} else if text != "" {
+		if err := encoder.EncodeToken(xml.CharData(text)); err != nil {
+			return err
+		}
+	}
+	return encoder.EncodeToken(start.End())
+}
+
+func unmarshalXML(data []byte) (interface{}, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			_, value, err := readElement(decoder, start)
+			return value, err
+		}
+	}
+}
+
+// readElement reads the value of an element up to its end, returning its
+// key as well.
+func readElement(decoder *xml.Decoder, start xml.StartElement) (string, interface{}, error) {
+	key, kind := start.Name.Local, ""
+	for _, attr := range start.Attr {
+		switch attr.Name.Local {
+		case "key":
+			key = attr.Value
+		case "type":
+			kind = attr.Value
+		}
+	}
+	keys := make([]string, 0)
+	values := make([]interface{}, 0)
+	var text strings.Builder
+	for done := false; !done; {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", nil, err
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			childKey, value, err := readElement(decoder, token)
+			if err != nil {
+				return "", nil, err
+			}
+			keys = append(keys, childKey)
+			values = append(values, value)
+		case xml.CharData:
+			text.Write(token)
+		case xml.EndElement:
+			done = true
+		}
+	}
+
+	switch kind {
+	case "null":
+		return key, nil, nil
+	case "boolean":
+		value, err := strconv.ParseBool(strings.TrimSpace(text.String()))
+		if err != nil {
+			return "", nil, fmt.Errorf("element %s: %v", key, err)
+		}
+		return key, value, nil
+	case "number":
+		number := strings.TrimSpace(text.String())
+		if _, err := strconv.ParseFloat(number, 64); err != nil {
+			return "", nil, fmt.Errorf("element %s: %v", key, err)
+		}
+		return key, json.Number(number), nil
+	case "array":
+		return key, values, nil
+	case "object":
+	case "", "string":
+		if kind == "string" || len(keys) == 0 {
+			return key, text.String(), nil
+		}
+	default:
+		return "", nil, fmt.Errorf("element %s: unknown type %q", key, kind)
+	}
+	object := make(map[string]interface{}, len(keys))
+	for i, childKey := range keys {
+		object[childKey] = values[i]
+	}
+	return key, object, nil
+}
@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/aheadxnet/go-sandbox/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// tokenLifetime is how long a token issued by SignInHandler stays valid.
+const tokenLifetime = 10 * time.Minute
+
+// MinSecretLength is the minimum length of the secret signing the tokens, as
+// anyone guessing the secret can sign tokens of admins.
+const MinSecretLength = 32
+
+// errNoSecret keeps an AuthHandler without secret from signing and accepting
+// tokens anyone could sign.
+var errNoSecret = errors.New("no secret to sign tokens with")
+
+// Claims are the JWT claims of the tokens issued by this application.
+type Claims struct {
+	Username  string `json:"username"`
+	Role      string `json:"role,omitempty"`
+	Tenant    string `json:"tenant,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// swagger:model JWTOutput
+// A signed token and its expiry date.
+type JWTOutput struct {
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+type AuthHandler struct {
+	collection *mongo.Collection
+	ctx        context.Context
+	secret     []byte
+	tenant     string
+}
+
+// Indexes is the unique index on the names users sign in with.
+func (handler *AuthHandler) Indexes() IndexSet {
+	return IndexSet{Collection: handler.collection, Indexes: []mongo.IndexModel{{
+		Keys:    bson.D{{Key: "username", Value: 1}},
+		Options: options.Index().SetName("username_unique").SetUnique(true),
+	}}}
+}
+
+// NewAuthHandler creates the handler signing in the users of a tenant, its
+// tokens valid for that tenant only.
+func NewAuthHandler(ctx context.Context, collection *mongo.Collection, secret string, tenant string) *AuthHandler {
+	return &AuthHandler{
+		collection: collection,
+		ctx:        ctx,
+		secret:     []byte(secret),
+		tenant:     tenant,
+	}
+}
+
+// SignInHandler signs a user in with username and password and returns a
+// token.
+func (handler *AuthHandler) SignInHandler(ctx *gin.Context) {
+	var user models.User
+	if err := ctx.ShouldBindJSON(&user); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var stored models.User
+	err := handler.collection.FindOne(handler.ctx, bson.M{"username": user.Username}).Decode(&stored)
+	if err != nil || !handler.checkPassword(stored, user.Password) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+		return
+	}
+	expires := time.Now().Add(tokenLifetime)
+	token, err := handler.Sign(Claims{
+		Username:  stored.Username,
+		Role:      stored.Role,
+		Tenant:    handler.tenant,
+		ExpiresAt: expires.Unix(),
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, JWTOutput{Token: token, Expires: expires})
+}
+
+// HashPassword returns the bcrypt hash of a password, as it is stored.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// checkPassword compares a password with the stored hash. Users stored with
+// the unsalted SHA-256 hashes of former versions get a bcrypt hash on their
+// first sign in.
+func (handler *AuthHandler) checkPassword(user models.User, password string) bool {
+	if !isLegacyHash(user.Password) {
+		return bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) == nil
+	}
+	legacy := sha256.Sum256([]byte(password))
+	if !hmac.Equal([]byte(user.Password), []byte(hex.EncodeToString(legacy[:]))) {
+		return false
+	}
+	hash, err := HashPassword(password)
+	if err == nil {
+		_, err = handler.collection.UpdateOne(handler.ctx, bson.M{"username": user.Username, "password": user.Password}, bson.M{"$set": bson.M{"password": hash}})
+	}
+	if err != nil {
+		log.Printf("Error while rehashing the password of %q: %v", user.Username, err)
+	}
+	return true
+}
+
+// isLegacyHash tells whether a stored password is a hex SHA-256 hash.
+func isLegacyHash(stored string) bool {
+	if len(stored) != hex.EncodedLen(sha256.Size) {
+		return false
+	}
+	_, err := hex.DecodeString(stored)
+	return err == nil
+}
+
+// AuthMiddleware rejects requests without a valid bearer token and makes the
+// username and role of the token available to the following handlers.
+func (handler *AuthHandler) AuthMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		header := ctx.GetHeader("Authorization")
+		if !strings.HasPrefix(header, "Bearer ") {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing bearer token"})
+			return
+		}
+		claims, err := handler.Verify(strings.TrimPrefix(header, "Bearer "))
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if claims.Tenant != handler.tenant {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Token of another tenant"})
+			return
+		}
+		ctx.Set("username", claims.Username)
+		ctx.Set("role", claims.Role)
+		ctx.Next()
+	}
+}
+
+// IdentifyMiddleware makes the username and role of a bearer token available
+// like AuthMiddleware, but lets anonymous requests without token pass.
+func (handler *AuthHandler) IdentifyMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if ctx.GetHeader("Authorization") == "" {
+			ctx.Next()
+			return
+		}
+		handler.AuthMiddleware()(ctx)
+	}
+}
+
+// AdminMiddleware rejects requests of users without the admin role.
+// It has to run after AuthMiddleware.
+func AdminMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if ctx.GetString("role") != models.RoleAdmin {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin role required"})
+			return
+		}
+		ctx.Next()
+	}
+}
+
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Sign creates an HS256 signed JWT for the given claims.
+func (handler *AuthHandler) Sign(claims Claims) (string, error) {
+	if len(handler.secret) == 0 {
+		return "", errNoSecret
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	unsigned := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + handler.signature(unsigned), nil
+}
+
+// Verify checks signature and expiry of a JWT and returns its claims.
+func (handler *AuthHandler) Verify(token string) (*Claims, error) {
+	if len(handler.secret) == 0 {
+		return nil, errNoSecret
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != jwtHeader {
+		return nil, errors.New("malformed token")
+	}
+	expected := handler.signature(parts[0] + "." + parts[1])
+	if !hmac.Equal([]byte(parts[2]), []byte(expected)) {
+		return nil, errors.New("invalid token signature")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("malformed token")
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("malformed token")
+	}
+	if time.Now().Unix() > claims.ExpiresAt {
+		return nil, errors.New("token expired")
+	}
+	return &claims, nil
+}
+
+func (handler *AuthHandler) signature(unsigned string) string {
+	mac := hmac.New(sha256.New, handler.secret)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
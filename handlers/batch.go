@@ -0,0 +1,415 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aheadxnet/go-sandbox/models"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxBatchSize limits the number of operations of a batch, maxBatchGet the
+// number of recipes fetched at once.
+const (
+	maxBatchSize = 1000
+	maxBatchGet  = 100
+)
+
+// duplicateKeyCode is the code of MongoDB's duplicate key errors.
+const duplicateKeyCode = 11000
+
+// slugAttempts is how often a new recipe is written when concurrent inserts
+// take its slug.
+const slugAttempts = 3
+
+// batchItem is an operation of a batch prepared for the bulk write.
+type batchItem struct {
+	id        primitive.ObjectID
+	recipe    models.Recipe
+	deletedAt time.Time
+	write     mongo.WriteModel
+}
+
+// batchCreates are the recipes a batch creates, the following ones may
+// neither take their slugs nor duplicate them.
+type batchCreates struct {
+	slugs   map[string]bool
+	indexes []int
+	recipes []models.Recipe
+}
+
+// duplicate returns the index of the operation creating a likely duplicate of
+// the recipe, -1 if there is none.
+func (creates *batchCreates) duplicate(recipe models.Recipe) int {
+	for i, created := range creates.recipes {
+		if recipe.Fingerprint.Duplicates(created.Fingerprint) {
+			return creates.indexes[i]
+		}
+	}
+	return -1
+}
+
+// BatchRecipesHandler creates, updates and deletes many recipes at once.
+func (handler *RecipesHandler) BatchRecipesHandler(ctx *gin.Context) {
+	// gin takes the colon for the start of a path parameter, so the route
+	// matches "/recipes" followed by anything.
+	if ctx.Param("batch") != ":batch" {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Page not found"})
+		return
+	}
+	request := models.BatchRequest{Ordered: true}
+	if err := bindData(ctx, &request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(request.Operations) == 0 || len(request.Operations) > maxBatchSize {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "A batch has between 1 and " + strconv.Itoa(maxBatchSize) + " operations"})
+		return
+	}
+	existing, err := handler.batchRecipes(request.Operations)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	results := make([]models.BatchResult, len(request.Operations))
+	items := make([]batchItem, len(request.Operations))
+	indexes := make([]int, 0, len(request.Operations))
+	creates := &batchCreates{slugs: make(map[string]bool)}
+	// All deletes of a batch share the time, MongoDB keeps milliseconds.
+	now := time.Now().Truncate(time.Millisecond)
+	failed := false
+	for i, operation := range request.Operations {
+		results[i] = models.BatchResult{Index: i, Op: operation.Op, ID: operation.ID}
+		if request.Ordered && failed {
+			results[i].Status = http.StatusFailedDependency
+			results[i].Error = "Not executed as an earlier operation failed"
+			continue
+		}
+		status, err := handler.prepareBatchItem(ctx, &items[i], operation, existing, creates, now)
+		results[i].Status = status
+		if err != nil {
+			results[i].Error = err.Error()
+			failed = true
+			continue
+		}
+		if operation.Op == models.BatchCreate {
+			creates.indexes = append(creates.indexes, i)
+			creates.recipes = append(creates.recipes, items[i].recipe)
+		}
+		results[i].ID = items[i].id.Hex()
+		indexes = append(indexes, i)
+	}
+	if len(indexes) > 0 {
+		if err := handler.writeBatch(request, items, indexes, results, creates.slugs, now); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		handler.completeBatch(ctx, request.Operations, items, results, existing)
+	}
+	renderData(ctx, http.StatusOK, results)
+}
+
+// writeBatch executes the prepared writes of the operations with the given
+// indexes in a bulk write and fills in the results of failed ones, including
+// updates and deletes whose recipe has been moved to the trash meanwhile.
+func (handler *RecipesHandler) writeBatch(request models.BatchRequest, items []batchItem, indexes []int, results []models.BatchResult, slugs map[string]bool, now time.Time) error {
+	matched, err := handler.bulkWrite(request, items, indexes, results, slugs)
+	if err != nil {
+		return err
+	}
+	return handler.flagUnmatched(request.Operations, items, results, matched, now)
+}
+
+// bulkWrite executes the prepared writes of the operations with the given
+// indexes and returns the number of recipes matched by updates and deletes.
+// A new recipe whose slug has been taken by a concurrent insert meanwhile is
+// written again with the next free slug, like insertRecipe does.
+func (handler *RecipesHandler) bulkWrite(request models.BatchRequest, items []batchItem, indexes []int, results []models.BatchResult, slugs map[string]bool) (int64, error) {
+	matched := int64(0)
+	for attempt := 1; len(indexes) > 0; attempt++ {
+		writes := make([]mongo.WriteModel, len(indexes))
+		for j, i := range indexes {
+			writes[j] = items[i].write
+		}
+		result, err := handler.collection.BulkWrite(handler.ctx, writes, options.BulkWrite().SetOrdered(request.Ordered))
+		var bulkErr mongo.BulkWriteException
+		if err != nil && !errors.As(err, &bulkErr) {
+			return matched, err
+		}
+		if result != nil {
+			// Only creates are written again, so no update or delete is
+			// counted twice.
+			matched += result.MatchedCount
+		}
+		retry := make([]int, 0)
+		first := len(writes)
+		for _, writeErr := range bulkErr.WriteErrors {
+			i := indexes[writeErr.Index]
+			if writeErr.Code == duplicateKeyCode && request.Operations[i].Op == models.BatchCreate && attempt < slugAttempts {
+				slug, err := uniqueSlug(handler.ctx, handler.collection, items[i].id, items[i].recipe.Name, slugs)
+				if err != nil {
+					return matched, err
+				}
+				slugs[slug] = true
+				items[i].recipe.Slug = slug
+				items[i].write = mongo.NewInsertOneModel().SetDocument(items[i].recipe)
+				retry = append(retry, writeErr.Index)
+				continue
+			}
+			results[i].Status = http.StatusInternalServerError
+			if writeErr.Code == duplicateKeyCode {
+				results[i].Status = http.StatusConflict
+			}
+			results[i].Error = writeErr.Message
+			if writeErr.Index < first {
+				first = writeErr.Index
+			}
+		}
+		if !request.Ordered {
+			next := make([]int, len(retry))
+			for j, index := range retry {
+				next[j] = indexes[index]
+			}
+			indexes = next
+			continue
+		}
+		// An ordered bulk write stops at the first error, so a retried
+		// recipe is written again together with the operations after it.
+		if len(retry) > 0 {
+			indexes = indexes[retry[0]:]
+			continue
+		}
+		if first < len(writes) {
+			for _, i := range indexes[first+1:] {
+				results[i].Status = http.StatusFailedDependency
+				results[i].Error = "Not executed as an earlier operation failed"
+			}
+		}
+		return matched, nil
+	}
+	return matched, nil
+}
+
+// flagUnmatched answers updates and deletes that matched no recipe with 404.
+// That happens if their recipe has been moved to the trash or purged between
+// loading and writing it, or has been deleted by an earlier operation of the
+// batch. As the bulk write only counts the matched recipes, the recipes are
+// only looked at again if fewer than expected matched.
+func (handler *RecipesHandler) flagUnmatched(operations []models.BatchOperation, items []batchItem, results []models.BatchResult, matched int64, now time.Time) error {
+	pending := make([]int, 0)
+	ids := make([]primitive.ObjectID, 0)
+	for i, operation := range operations {
+		if results[i].Error != "" || (operation.Op != models.BatchUpdate && operation.Op != models.BatchDelete) {
+			continue
+		}
+		pending = append(pending, i)
+		if !containsObjectID(ids, items[i].id) {
+			ids = append(ids, items[i].id)
+		}
+	}
+	if int64(len(pending)) <= matched {
+		return nil
+	}
+	cur, err := handler.collection.Find(handler.ctx, bson.M{"_id": bson.M{"$in": ids}},
+		options.Find().SetProjection(bson.M{"_id": 1, "deletedAt": 1}))
+	if err != nil {
+		return err
+	}
+	recipes := make([]models.Recipe, 0, len(ids))
+	if err := cur.All(handler.ctx, &recipes); err != nil {
+		return err
+	}
+	// A recipe still active matched all its operations, one moved to the
+	// trash by this batch the ones up to its first delete. Recipes trashed by
+	// others or purged matched none.
+	found := make(map[primitive.ObjectID]bool, len(recipes))
+	deletedAt := make(map[primitive.ObjectID]*time.Time, len(recipes))
+	for _, recipe := range recipes {
+		found[recipe.ID] = true
+		deletedAt[recipe.ID] = recipe.DeletedAt
+	}
+	deletedByBatch := make(map[primitive.ObjectID]bool)
+	for _, i := range pending {
+		id := items[i].id
+		if found[id] && deletedAt[id] == nil {
+			continue
+		}
+		if found[id] && !deletedByBatch[id] && deletedAt[id].Equal(now) {
+			if operations[i].Op == models.BatchDelete {
+				deletedByBatch[id] = true
+			}
+			continue
+		}
+		results[i].Status = http.StatusNotFound
+		results[i].Error = "recipe not found"
+	}
+	return nil
+}
+
+// batchRecipes loads the active recipes to update or delete.
+func (handler *RecipesHandler) batchRecipes(operations []models.BatchOperation) (map[primitive.ObjectID]models.Recipe, error) {
+	ids := make([]primitive.ObjectID, 0)
+	for _, operation := range operations {
+		if operation.Op == models.BatchCreate {
+			continue
+		}
+		id, err := ResolveRecipeID(handler.ctx, handler.collection, operation.ID)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return findRecipes(handler.ctx, handler.collection, ids)
+}
+
+// prepareBatchItem validates an operation and prepares its write, returning
+// the status of the operation.
+func (handler *RecipesHandler) prepareBatchItem(ctx *gin.Context, item *batchItem, operation models.BatchOperation, existing map[primitive.ObjectID]models.Recipe, creates *batchCreates, now time.Time) (int, error) {
+	if operation.Op == models.BatchCreate {
+		if operation.Recipe == nil {
+			return http.StatusBadRequest, errors.New("recipe is missing")
+		}
+		if err := binding.Validator.ValidateStruct(operation.Recipe); err != nil {
+			return http.StatusBadRequest, err
+		}
+		item.recipe = *operation.Recipe
+		if ctx.Query("force") != "true" {
+			duplicates, err := FindDuplicates(handler.ctx, handler.collection, item.recipe)
+			if err != nil {
+				return http.StatusInternalServerError, err
+			}
+			if len(duplicates) > 0 {
+				return http.StatusConflict, fmt.Errorf("the recipe likely exists already as recipe %s", duplicates[0].ID.Hex())
+			}
+		}
+		handler.prepareRecipe(&item.recipe)
+		if ctx.Query("force") != "true" {
+			if index := creates.duplicate(item.recipe); index >= 0 {
+				return http.StatusConflict, fmt.Errorf("the recipe likely duplicates the one created by operation %d", index)
+			}
+		}
+		slug, err := uniqueSlug(handler.ctx, handler.collection, item.recipe.ID, item.recipe.Name, creates.slugs)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		creates.slugs[slug] = true
+		item.recipe.Slug = slug
+		item.id = item.recipe.ID
+		item.write = mongo.NewInsertOneModel().SetDocument(item.recipe)
+		return http.StatusCreated, nil
+	}
+	if operation.Op != models.BatchUpdate && operation.Op != models.BatchDelete {
+		return http.StatusBadRequest, errors.New("unknown operation " + strconv.Quote(operation.Op) + ", use create, update or delete")
+	}
+	id, err := ResolveRecipeID(handler.ctx, handler.collection, operation.ID)
+	if err != nil {
+		return http.StatusNotFound, errors.New("recipe not found")
+	}
+	if _, found := existing[id]; !found {
+		return http.StatusNotFound, errors.New("recipe not found")
+	}
+	item.id = id
+	if operation.Op == models.BatchDelete {
+		item.deletedAt = now
+		item.write = mongo.NewUpdateOneModel().SetFilter(active(bson.M{"_id": id})).
+			SetUpdate(bson.M{"$set": bson.M{"deletedAt": now}})
+		return http.StatusOK, nil
+	}
+	if operation.Recipe == nil {
+		return http.StatusBadRequest, errors.New("recipe is missing")
+	}
+	if err := binding.Validator.ValidateStruct(operation.Recipe); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if operation.Recipe.TranslatedTo != "" {
+		return http.StatusBadRequest, errors.New("this is the " + operation.Recipe.TranslatedTo + " translation, edit it with PUT /recipes/" + operation.ID + "/translations/" + operation.Recipe.TranslatedTo)
+	}
+	item.recipe = *operation.Recipe
+	handler.normalizeTags(&item.recipe)
+	item.write = mongo.NewUpdateOneModel().SetFilter(active(bson.M{"_id": id})).
+		SetUpdate(bson.D{{Key: "$set", Value: recipeContent(item.recipe)}})
+	return http.StatusOK, nil
+}
+
+// completeBatch records the revisions of the successful operations, updates
+// texts, slugs and similarity index and invalidates the cache once.
+func (handler *RecipesHandler) completeBatch(ctx *gin.Context, operations []models.BatchOperation, items []batchItem, results []models.BatchResult, existing map[primitive.ObjectID]models.Recipe) {
+	updated := make([]primitive.ObjectID, 0)
+	for i, operation := range operations {
+		if results[i].Error != "" {
+			continue
+		}
+		switch operation.Op {
+		case models.BatchCreate:
+			handler.recordRevision(ctx, models.RevisionCreate, items[i].recipe)
+			handler.indexSimilar(items[i].recipe)
+		case models.BatchUpdate:
+			updated = append(updated, items[i].id)
+		case models.BatchDelete:
+			recipe := existing[items[i].id]
+			recipe.DeletedAt = &items[i].deletedAt
+			handler.recordRevision(ctx, models.RevisionDelete, recipe)
+			handler.unindexSimilar(recipe.ID)
+		}
+	}
+	recipes, err := findRecipes(handler.ctx, handler.collection, updated)
+	if err != nil {
+		log.Printf("Error while loading the recipes updated by a batch: %v", err)
+	}
+	for _, recipe := range recipes {
+		handler.updateTexts(&recipe)
+		handler.updateSlug(&recipe)
+		handler.recordRevision(ctx, models.RevisionUpdate, recipe)
+		handler.indexSimilar(recipe)
+	}
+	handler.cache.Invalidate(ctx)
+}
+
+// listRecipesByID renders the recipes with the given IDs in the given order,
+// leaving out unknown ones.
+func (handler *RecipesHandler) listRecipesByID(ctx *gin.Context, param string) {
+	values := strings.Split(param, ",")
+	if len(values) > maxBatchGet {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "At most " + strconv.Itoa(maxBatchGet) + " recipes can be fetched at once"})
+		return
+	}
+	ids := make([]primitive.ObjectID, 0, len(values))
+	for _, value := range values {
+		id, err := ResolveRecipeID(handler.ctx, handler.collection, strings.TrimSpace(value))
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !containsObjectID(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	found, err := findRecipes(handler.ctx, handler.collection, ids)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recipes := make([]models.Recipe, 0, len(found))
+	for _, id := range ids {
+		if recipe, ok := found[id]; ok {
+			recipes = append(recipes, recipe)
+		}
+	}
+	renderRecipes(ctx, http.StatusOK, recipes)
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"log"
+)
+
+// RecipeCache is the list of all recipes cached in Redis, under a key with
+// the prefix of the tenant.
+type RecipeCache struct {
+	client *redis.Client
+	key    string
+}
+
+func NewRecipeCache(client *redis.Client, prefix string) *RecipeCache {
+	return &RecipeCache{
+		client: client,
+		key:    prefix + "recipes",
+	}
+}
+
+// Get returns the cached list, redis.Nil if there is none.
+func (cache *RecipeCache) Get(ctx context.Context) (string, error) {
+	return cache.client.Get(ctx, cache.key).Result()
+}
+
+// Set caches the list.
+func (cache *RecipeCache) Set(ctx context.Context, data string) {
+	cache.client.Set(ctx, cache.key, data, 0)
+}
+
+// Invalidate removes the cached list after recipes have changed.
+func (cache *RecipeCache) Invalidate(ctx context.Context) {
+	log.Println("Remove data from Redis")
+	cache.client.Del(ctx, cache.key)
+}
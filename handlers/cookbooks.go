@@ -0,0 +1,456 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aheadxnet/go-sandbox/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"net/http"
+	"time"
+)
+
+// CookbookStore holds the cookbooks and favorites referencing recipes.
+type CookbookStore struct {
+	cookbooks *mongo.Collection
+	favorites *mongo.Collection
+}
+
+func NewCookbookStore(cookbooks *mongo.Collection, favorites *mongo.Collection) *CookbookStore {
+	return &CookbookStore{
+		cookbooks: cookbooks,
+		favorites: favorites,
+	}
+}
+
+// Indexes are the indexes to find the cookbooks of a user and allowing to
+// bookmark a recipe only once.
+func (store *CookbookStore) Indexes() []IndexSet {
+	return []IndexSet{
+		{Collection: store.cookbooks, Indexes: []mongo.IndexModel{
+			{Keys: bson.D{{Key: "owner", Value: 1}}, Options: options.Index().SetName("owner")},
+			{Keys: bson.D{{Key: "sharedWith", Value: 1}}, Options: options.Index().SetName("sharedWith")},
+			{Keys: bson.D{{Key: "recipeIds", Value: 1}}, Options: options.Index().SetName("recipeIds")},
+		}},
+		{Collection: store.favorites, Indexes: []mongo.IndexModel{{
+			Keys:    bson.D{{Key: "username", Value: 1}, {Key: "recipeId", Value: 1}},
+			Options: options.Index().SetName("username_recipeId_unique").SetUnique(true),
+		}}},
+	}
+}
+
+// RemoveRecipe drops a recipe deleted for good from all cookbooks and favorites.
+func (store *CookbookStore) RemoveRecipe(ctx context.Context, recipeID primitive.ObjectID) error {
+	return store.removeRecipes(ctx, []interface{}{recipeID})
+}
+
+// CleanupOrphans drops recipes that do not exist anymore from all cookbooks
+// and favorites, like recipes purged from the trash by the TTL index.
+func (store *CookbookStore) CleanupOrphans(ctx context.Context, recipes *mongo.Collection) (int, error) {
+	ids, err := store.cookbooks.Distinct(ctx, "recipeIds", bson.M{})
+	if err != nil {
+		return 0, err
+	}
+	favorites, err := store.favorites.Distinct(ctx, "recipeId", bson.M{})
+	if err != nil {
+		return 0, err
+	}
+	orphans, err := purgedRecipes(ctx, recipes, append(ids, favorites...))
+	if err != nil {
+		return 0, err
+	}
+	if len(orphans) == 0 {
+		return 0, nil
+	}
+	return len(orphans), store.removeRecipes(ctx, orphans)
+}
+
+func (store *CookbookStore) removeRecipes(ctx context.Context, ids []interface{}) error {
+	_, err := store.cookbooks.UpdateMany(ctx, bson.M{"recipeIds": bson.M{"$in": ids}},
+		bson.M{"$pull": bson.M{"recipeIds": bson.M{"$in": ids}}})
+	if err != nil {
+		return err
+	}
+	_, err = store.favorites.DeleteMany(ctx, bson.M{"recipeId": bson.M{"$in": ids}})
+	return err
+}
+
+type CookbooksHandler struct {
+	store   *CookbookStore
+	recipes *mongo.Collection
+	ctx     context.Context
+}
+
+func NewCookbooksHandler(ctx context.Context, store *CookbookStore, recipes *mongo.Collection) *CookbooksHandler {
+	return &CookbooksHandler{
+		store:   store,
+		recipes: recipes,
+		ctx:     ctx,
+	}
+}
+
+// NewCookbookHandler creates a new cookbook.
+func (handler *CookbooksHandler) NewCookbookHandler(ctx *gin.Context) {
+	var cookbook models.Cookbook
+	if err := ctx.ShouldBindJSON(&cookbook); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !handler.validate(ctx, &cookbook) {
+		return
+	}
+	cookbook.ID = primitive.NewObjectID()
+	cookbook.Owner = ctx.GetString("username")
+	cookbook.CreatedAt = time.Now()
+	cookbook.UpdatedAt = cookbook.CreatedAt
+	if _, err := handler.store.cookbooks.InsertOne(handler.ctx, cookbook); err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error while inserting a new cookbook"})
+		return
+	}
+	ctx.JSON(http.StatusCreated, cookbook)
+}
+
+// ListCookbooksHandler returns the cookbooks of the current user and the ones
+// shared with them.
+func (handler *CookbooksHandler) ListCookbooksHandler(ctx *gin.Context) {
+	username := ctx.GetString("username")
+	handler.list(ctx, bson.M{"$or": bson.A{
+		bson.M{"owner": username},
+		bson.M{"visibility": models.Shared, "sharedWith": username},
+	}})
+}
+
+// ListPublicCookbooksHandler returns the public cookbooks of all users.
+func (handler *CookbooksHandler) ListPublicCookbooksHandler(ctx *gin.Context) {
+	handler.list(ctx, bson.M{"visibility": models.Public})
+}
+
+// GetCookbookHandler gets a cookbook with its recipes in order.
+func (handler *CookbooksHandler) GetCookbookHandler(ctx *gin.Context) {
+	cookbook, ok := handler.find(ctx, false)
+	if !ok {
+		return
+	}
+	recipes, err := findRecipes(handler.ctx, handler.recipes, cookbook.RecipeIDs)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	cookbook.Recipes = make([]models.Recipe, 0, len(cookbook.RecipeIDs))
+	for _, id := range cookbook.RecipeIDs {
+		if recipe, found := recipes[id]; found {
+			cookbook.Recipes = append(cookbook.Recipes, recipe)
+		} else if cookbook.Owner == ctx.GetString("username") {
+			cookbook.MissingRecipes = append(cookbook.MissingRecipes, id)
+		}
+	}
+	ctx.JSON(http.StatusOK, cookbook)
+}
+
+// UpdateCookbookHandler updates an existing cookbook of the current user.
+func (handler *CookbooksHandler) UpdateCookbookHandler(ctx *gin.Context) {
+	existing, ok := handler.find(ctx, true)
+	if !ok {
+		return
+	}
+	var cookbook models.Cookbook
+	if err := ctx.ShouldBindJSON(&cookbook); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !handler.validate(ctx, &cookbook) {
+		return
+	}
+	cookbook.ID = existing.ID
+	cookbook.Owner = existing.Owner
+	cookbook.CreatedAt = existing.CreatedAt
+	cookbook.UpdatedAt = time.Now()
+	_, err := handler.store.cookbooks.UpdateOne(handler.ctx, bson.M{"_id": cookbook.ID}, bson.D{{Key: "$set", Value: bson.D{
+		{Key: "name", Value: cookbook.Name},
+		{Key: "description", Value: cookbook.Description},
+		{Key: "visibility", Value: cookbook.Visibility},
+		{Key: "sharedWith", Value: cookbook.SharedWith},
+		{Key: "recipeIds", Value: cookbook.RecipeIDs},
+		{Key: "updatedAt", Value: cookbook.UpdatedAt},
+	}}})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, cookbook)
+}
+
+// DeleteCookbookHandler deletes an existing cookbook of the current user, the
+// recipes are kept.
+func (handler *CookbooksHandler) DeleteCookbookHandler(ctx *gin.Context) {
+	cookbook, ok := handler.find(ctx, true)
+	if !ok {
+		return
+	}
+	if _, err := handler.store.cookbooks.DeleteOne(handler.ctx, bson.M{"_id": cookbook.ID}); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "Cookbook has been deleted"})
+}
+
+// CookbookEntry is a recipe to add to a cookbook.
+type CookbookEntry struct {
+	// the current or a legacy id of the recipe
+	RecipeID string `json:"recipeId" binding:"required"`
+
+	// the zero based position of the recipe, appended if omitted
+	Position *int `json:"position"`
+}
+
+// AddRecipeHandler adds a recipe to a cookbook of the current user.
+func (handler *CookbooksHandler) AddRecipeHandler(ctx *gin.Context) {
+	var input CookbookEntry
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Position != nil && *input.Position < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Position must not be negative"})
+		return
+	}
+	cookbook, ok := handler.find(ctx, true)
+	if !ok {
+		return
+	}
+	recipeId, err := ResolveRecipeID(handler.ctx, handler.recipes, input.RecipeID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Recipe does not exist"})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if containsObjectID(cookbook.RecipeIDs, recipeId) {
+		ctx.JSON(http.StatusConflict, gin.H{"error": "Recipe is already in the cookbook"})
+		return
+	}
+	recipes, err := findRecipes(handler.ctx, handler.recipes, []primitive.ObjectID{recipeId})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(recipes) == 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Recipe does not exist"})
+		return
+	}
+	push := bson.M{"$each": bson.A{recipeId}}
+	if input.Position != nil {
+		push["$position"] = *input.Position
+	}
+	// The filter makes a concurrent add of the same recipe a no-op.
+	err = handler.store.cookbooks.FindOneAndUpdate(handler.ctx,
+		bson.M{"_id": cookbook.ID, "recipeIds": bson.M{"$ne": recipeId}},
+		bson.M{
+			"$push": bson.M{"recipeIds": push},
+			"$set":  bson.M{"updatedAt": time.Now()},
+		}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(cookbook)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		ctx.JSON(http.StatusConflict, gin.H{"error": "Recipe is already in the cookbook"})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, cookbook)
+}
+
+// RemoveRecipeHandler removes a recipe from a cookbook of the current user.
+func (handler *CookbooksHandler) RemoveRecipeHandler(ctx *gin.Context) {
+	cookbook, ok := handler.find(ctx, true)
+	if !ok {
+		return
+	}
+	recipeId, ok := recipeID(ctx, handler.recipes, "recipeId")
+	if !ok {
+		return
+	}
+	err := handler.store.cookbooks.FindOneAndUpdate(handler.ctx, bson.M{"_id": cookbook.ID}, bson.M{
+		"$pull": bson.M{"recipeIds": recipeId},
+		"$set":  bson.M{"updatedAt": time.Now()},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(cookbook)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, cookbook)
+}
+
+// ListFavoritesHandler returns the recipes bookmarked by the current user,
+// latest first.
+func (handler *CookbooksHandler) ListFavoritesHandler(ctx *gin.Context) {
+	cur, err := handler.store.favorites.Find(handler.ctx, bson.M{"username": ctx.GetString("username")},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	favorites := make([]models.Favorite, 0)
+	if err := cur.All(handler.ctx, &favorites); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ids := make([]primitive.ObjectID, len(favorites))
+	for i, favorite := range favorites {
+		ids[i] = favorite.RecipeID
+	}
+	recipes, err := findRecipes(handler.ctx, handler.recipes, ids)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	list := make([]models.Recipe, 0, len(ids))
+	for _, id := range ids {
+		if recipe, found := recipes[id]; found {
+			list = append(list, recipe)
+		}
+	}
+	ctx.JSON(http.StatusOK, list)
+}
+
+// AddFavoriteHandler bookmarks a recipe for the current user.
+func (handler *CookbooksHandler) AddFavoriteHandler(ctx *gin.Context) {
+	recipeId, ok := recipeID(ctx, handler.recipes, "id")
+	if !ok {
+		return
+	}
+	recipes, err := findRecipes(handler.ctx, handler.recipes, []primitive.ObjectID{recipeId})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(recipes) == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Recipe not found"})
+		return
+	}
+	favorite := models.Favorite{
+		Username:  ctx.GetString("username"),
+		RecipeID:  recipeId,
+		CreatedAt: time.Now(),
+	}
+	// Bookmarking a recipe twice keeps the original date.
+	_, err = handler.store.favorites.UpdateOne(handler.ctx,
+		bson.M{"username": favorite.Username, "recipeId": favorite.RecipeID},
+		bson.M{"$setOnInsert": favorite}, options.Update().SetUpsert(true))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "Recipe has been bookmarked"})
+}
+
+// DeleteFavoriteHandler removes the bookmark of a recipe for the current user.
+func (handler *CookbooksHandler) DeleteFavoriteHandler(ctx *gin.Context) {
+	recipeId, ok := recipeID(ctx, handler.recipes, "id")
+	if !ok {
+		return
+	}
+	_, err := handler.store.favorites.DeleteOne(handler.ctx, bson.M{
+		"username": ctx.GetString("username"),
+		"recipeId": recipeId,
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "Bookmark has been removed"})
+}
+
+func (handler *CookbooksHandler) list(ctx *gin.Context, filter bson.M) {
+	cur, err := handler.store.cookbooks.Find(handler.ctx, filter, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	cookbooks := make([]models.Cookbook, 0)
+	if err := cur.All(handler.ctx, &cookbooks); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, cookbooks)
+}
+
+// find loads the cookbook addressed by the id parameter. Cookbooks the user
+// may not read, or not change if owned is set, are reported as not found.
+func (handler *CookbooksHandler) find(ctx *gin.Context, owned bool) (*models.Cookbook, bool) {
+	objectId, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Invalid cookbook ID"})
+		return nil, false
+	}
+	var cookbook models.Cookbook
+	err = handler.store.cookbooks.FindOne(handler.ctx, bson.M{"_id": objectId}).Decode(&cookbook)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	username := ctx.GetString("username")
+	if err != nil || (owned && cookbook.Owner != username) || !cookbook.VisibleTo(username) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Cookbook not found"})
+		return nil, false
+	}
+	return &cookbook, true
+}
+
+// validate checks visibility and recipes of a cookbook and refuses cookbooks
+// referencing recipes that do not exist (anymore).
+func (handler *CookbooksHandler) validate(ctx *gin.Context, cookbook *models.Cookbook) bool {
+	if cookbook.Visibility == "" {
+		cookbook.Visibility = models.Private
+	}
+	if !cookbook.Visibility.Valid() {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid visibility %q", cookbook.Visibility)})
+		return false
+	}
+	if cookbook.Visibility != models.Shared {
+		cookbook.SharedWith = nil
+	}
+	ids := make([]primitive.ObjectID, 0, len(cookbook.RecipeIDs))
+	for _, id := range cookbook.RecipeIDs {
+		if !containsObjectID(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	cookbook.RecipeIDs = ids
+	recipes, err := findRecipes(handler.ctx, handler.recipes, ids)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	missing := make([]primitive.ObjectID, 0)
+	for _, id := range ids {
+		if _, found := recipes[id]; !found {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":          "Cookbook references recipes that do not exist",
+			"missingRecipes": missing,
+		})
+		return false
+	}
+	return true
+}
+
+func containsObjectID(values []primitive.ObjectID, value primitive.ObjectID) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"context"
+	"github.com/aheadxnet/go-sandbox/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"net/http"
+)
+
+// fingerprintIndexes are the indexes to look up recipes by their
+// fingerprint.
+func fingerprintIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{Keys: bson.D{{Key: "fingerprint.hash", Value: 1}}, Options: options.Index().SetName("fingerprint_hash")},
+		{Keys: bson.D{{Key: "fingerprint.title", Value: 1}}, Options: options.Index().SetName("fingerprint_title")},
+	}
+}
+
+// FindDuplicates returns the active recipes that are likely duplicates of the recipe.
+func FindDuplicates(ctx context.Context, collection *mongo.Collection, recipe models.Recipe) ([]models.Recipe, error) {
+	fingerprint := models.NewFingerprint(recipe)
+	cur, err := collection.Find(ctx, active(bson.M{
+		"_id": bson.M{"$ne": recipe.ID},
+		"$or": bson.A{
+			bson.M{"fingerprint.hash": fingerprint.Hash},
+			bson.M{"fingerprint.title": fingerprint.Title},
+		},
+	}))
+	if err != nil {
+		return nil, err
+	}
+	candidates := make([]models.Recipe, 0)
+	if err := cur.All(ctx, &candidates); err != nil {
+		return nil, err
+	}
+	duplicates := make([]models.Recipe, 0)
+	for _, candidate := range candidates {
+		if fingerprint.Duplicates(candidate.Fingerprint) {
+			duplicates = append(duplicates, candidate)
+		}
+	}
+	return duplicates, nil
+}
+
+// DuplicateRef links a likely duplicate of a recipe.
+type DuplicateRef struct {
+	ID   primitive.ObjectID `json:"id"`
+	Name string             `json:"name"`
+	URL  string             `json:"url"`
+}
+
+func duplicateRefs(recipes []models.Recipe) []DuplicateRef {
+	refs := make([]DuplicateRef, len(recipes))
+	for i, recipe := range recipes {
+		refs[i] = DuplicateRef{ID: recipe.ID, Name: recipe.Name, URL: "/recipes/" + recipe.ID.Hex()}
+	}
+	return refs
+}
+
+// checkDuplicates rejects a new recipe with 409 Conflict if it likely
+// duplicates an existing one, unless the force parameter is set.
+func (handler *RecipesHandler) checkDuplicates(ctx *gin.Context, recipe models.Recipe) bool {
+	if ctx.Query("force") == "true" {
+		return true
+	}
+	duplicates, err := FindDuplicates(handler.ctx, handler.collection, recipe)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if len(duplicates) > 0 {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"error":      "The recipe likely exists already, add ?force=true to store it anyway",
+			"duplicates": duplicateRefs(duplicates),
+		})
+		return false
+	}
+	return true
+}
+
+// ListDuplicatesHandler returns clusters of recipes that are likely duplicates
+// of each other.
+func (handler *RecipesHandler) ListDuplicatesHandler(ctx *gin.Context) {
+	// Duplicates share their title, so only recipes of titles used more
+	// than once need to be compared.
+	cur, err := handler.collection.Aggregate(handler.ctx, mongo.Pipeline{
+		{{Key: "$match", Value: active(bson.M{"fingerprint.title": bson.M{"$exists": true}})}},
+		{{Key: "$group", Value: bson.M{
+			"_id":     "$fingerprint.title",
+			"recipes": bson.M{"$push": "$$ROOT"},
+			"count":   bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var groups []struct {
+		Recipes []models.Recipe `bson:"recipes"`
+	}
+	if err := cur.All(handler.ctx, &groups); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	clusters := make([][]DuplicateRef, 0)
+	for _, group := range groups {
+		for _, cluster := range clusterDuplicates(group.Recipes) {
+			clusters = append(clusters, duplicateRefs(cluster))
+		}
+	}
+	ctx.JSON(http.StatusOK, clusters)
+}
+
+// clusterDuplicates groups recipes that are duplicates of each other,
+// directly or through other recipes, leaving out recipes without duplicates.
+func clusterDuplicates(recipes []models.Recipe) [][]models.Recipe {
+	parent := make([]int, len(recipes))
+	for i := range parent {
+		parent[i] = i
+	}
+	root := func(i int) int {
+		for parent[i] != i {
+			parent[i] = parent[parent[i]]
+			i = parent[i]
+		}
+		return i
+	}
+	for i := range recipes {
+		for j := i + 1; j < len(recipes); j++ {
+			if recipes[i].Fingerprint.Duplicates(recipes[j].Fingerprint) {
+				parent[root(j)] = root(i)
+			}
+		}
+	}
+	members := make(map[int][]models.Recipe)
+	roots := make([]int, 0)
+	for i, recipe := range recipes {
+		r := root(i)
+		if _, seen := members[r]; !seen {
+			roots = append(roots, r)
+		}
+		members[r] = append(members[r], recipe)
+	}
+	clusters := make([][]models.Recipe, 0)
+	for _, r := range roots {
+		if len(members[r]) > 1 {
+			clusters = append(clusters, members[r])
+		}
+	}
+	return clusters
+}
@@ -1,86 +1,202 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/aheadxnet/go-sandbox/models"
+	"github.com/aheadxnet/go-sandbox/schemaorg"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 )
 
 type RecipesHandler struct {
-	collection  *mongo.Collection
-	ctx         context.Context
-	redisClient *redis.Client
+	collection *mongo.Collection
+	ctx        context.Context
+	cache      *RecipeCache
+	revisions  *RevisionStore
+	images     *ImageStore
+	reviews    *mongo.Collection
+	cookbooks  *CookbookStore
+	mealPlans  *mongo.Collection
+	similar    *SimilarityIndex
+	tags       *TagTaxonomy
 }
 
-func NewRecipesHandler(ctx context.Context, collection *mongo.Collection, redisClient *redis.Client) *RecipesHandler {
+func NewRecipesHandler(ctx context.Context, collection *mongo.Collection, cache *RecipeCache, revisions *RevisionStore, images *ImageStore, reviews *mongo.Collection, cookbooks *CookbookStore, mealPlans *mongo.Collection, similar *SimilarityIndex, tags *TagTaxonomy) *RecipesHandler {
 	return &RecipesHandler{
-		collection:  collection,
-		ctx:         ctx,
-		redisClient: redisClient,
-	}
-}
-
-// swagger:operation POST /recipes recipes newRecipe
-// Create a new recipe
-// ---
-// parameters:
-// - in: body
-//   description: data for the new recipe
-//   required: true
-//   type: Recipe
-// produces:
-// - application/json
-// responses:
-//     '200':
-//         description: Successful operation
-//     '400':
-//         description: Invalid input
+		collection: collection,
+		ctx:        ctx,
+		cache:      cache,
+		revisions:  revisions,
+		images:     images,
+		reviews:    reviews,
+		cookbooks:  cookbooks,
+		mealPlans:  mealPlans,
+		similar:    similar,
+		tags:       tags,
+	}
+}
+
+// NewRecipeHandler creates a new recipe.
 func (handler *RecipesHandler) NewRecipeHandler(ctx *gin.Context) {
 	var recipe models.Recipe
-	if err := ctx.ShouldBindJSON(&recipe); err != nil {
+	if err := bindRecipe(ctx, &recipe); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
 			"error": err.Error()})
 		return
 	}
-	recipe.ID = primitive.NewObjectID()
-	recipe.PublishedAt = time.Now()
-	_, err := handler.collection.InsertOne(ctx, recipe)
-	if err != nil {
+	if !handler.checkDuplicates(ctx, recipe) {
+		return
+	}
+	if err := handler.insertRecipe(ctx, &recipe); err != nil {
 		fmt.Println(err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error while inserting a new recipe"})
 		return
 	}
-	log.Println("Remove data from Redis")
-	handler.redisClient.Del(ctx, "recipes")
-	ctx.JSON(http.StatusCreated, recipe)
+	ctx.Header("ETag", recipeETag(recipe))
+	renderData(ctx, http.StatusCreated, recipe)
+}
+
+// ImportRecipeHandler imports a recipe from an HTML page or a JSON-LD document
+// using the schema.org Recipe vocabulary.
+func (handler *RecipesHandler) ImportRecipeHandler(ctx *gin.Context) {
+	document, contentType, err := readImportDocument(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var recipe *models.Recipe
+	var warnings []string
+	if strings.Contains(contentType, "html") || bytes.HasPrefix(bytes.TrimSpace(document), []byte("<")) {
+		recipe, warnings, err = schemaorg.ParseHTML(document)
+	} else {
+		recipe, warnings, err = schemaorg.Parse(document)
+	}
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "warnings": warnings})
+		return
+	}
+	if ctx.Query("commit") != "true" {
+		duplicates, err := FindDuplicates(handler.ctx, handler.collection, *recipe)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		renderData(ctx, http.StatusOK, gin.H{"recipe": recipe, "warnings": warnings, "duplicates": duplicateRefs(duplicates)})
+		return
+	}
+	if blocking := schemaorg.Blocking(warnings); len(blocking) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": "The recipe can't be stored: " + strings.Join(blocking, ", "), "warnings": warnings})
+		return
+	}
+	if !handler.checkDuplicates(ctx, *recipe) {
+		return
+	}
+	if err := handler.insertRecipe(ctx, recipe); err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error while inserting a new recipe"})
+		return
+	}
+	renderData(ctx, http.StatusCreated, gin.H{"recipe": recipe, "warnings": warnings})
 }
 
-// swagger:operation GET /recipes recipes listRecipes
-// Returns list of recipes
-// ---
-// produces:
-// - application/json
-// responses:
-//     '200':
-//         description: Successful operation
-//         schema:
-//           type: array
-//           items: Recipe
+// maxImportSize limits the size of documents accepted by ImportRecipeHandler.
+const maxImportSize = 5 << 20
+
+// readImportDocument reads the document to import either from the uploaded
+// file of a multipart form or from the raw request body.
+func readImportDocument(ctx *gin.Context) ([]byte, string, error) {
+	if strings.HasPrefix(ctx.ContentType(), "multipart/") {
+		header, err := ctx.FormFile("file")
+		if err != nil {
+			return nil, "", err
+		}
+		if header.Size > maxImportSize {
+			return nil, "", errors.New("uploaded file is too large")
+		}
+		file, err := header.Open()
+		if err != nil {
+			return nil, "", err
+		}
+		defer file.Close()
+		document, err := ioutil.ReadAll(file)
+		return document, header.Header.Get("Content-Type"), err
+	}
+	document, err := ioutil.ReadAll(io.LimitReader(ctx.Request.Body, maxImportSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(document) > maxImportSize {
+		return nil, "", errors.New("request body is too large")
+	}
+	return document, ctx.ContentType(), nil
+}
+
+// prepareRecipe sets the ID and the computed fields of a new recipe.
+func (handler *RecipesHandler) prepareRecipe(recipe *models.Recipe) {
+	recipe.ID = primitive.NewObjectID()
+	// MongoDB keeps milliseconds in UTC, so the new recipe looks like it
+	// will when read back.
+	recipe.PublishedAt = time.Now().UTC().Truncate(time.Millisecond)
+	recipe.Rating = models.Rating{}
+	recipe.Photos = nil
+	handler.normalizeTags(recipe)
+	normalizeTranslations(recipe)
+	recipe.Fingerprint = models.NewFingerprint(*recipe)
+	recipe.Texts = recipe.SearchTexts()
+	recipe.OldSlugs = nil
+}
+
+// insertRecipe stores a new recipe and invalidates the cached recipe list.
+func (handler *RecipesHandler) insertRecipe(ctx *gin.Context, recipe *models.Recipe) error {
+	handler.prepareRecipe(recipe)
+	// A concurrent insert may take the same slug, so retry with the next one.
+	for attempt := 1; ; attempt++ {
+		slug, err := UniqueSlug(ctx, handler.collection, recipe.ID, recipe.Name)
+		if err != nil {
+			return err
+		}
+		recipe.Slug = slug
+		_, err = handler.collection.InsertOne(ctx, recipe)
+		if err == nil {
+			break
+		}
+		if !mongo.IsDuplicateKeyError(err) || attempt == 3 {
+			return err
+		}
+	}
+	handler.recordRevision(ctx, models.RevisionCreate, *recipe)
+	handler.indexSimilar(*recipe)
+	handler.cache.Invalidate(ctx)
+	return nil
+}
+
+// ListRecipesHandler returns the list of recipes.
 func (handler *RecipesHandler) ListRecipesHandler(ctx *gin.Context) {
-	val, err := handler.redisClient.Get(ctx, "recipes").Result()
+	if ids := ctx.Query("ids"); ids != "" {
+		handler.listRecipesByID(ctx, ids)
+		return
+	}
+	val, err := handler.cache.Get(ctx)
 	if err == redis.Nil {
 		log.Printf("Request to MongoDB")
 		cur, err := handler.collection.Find(handler.ctx,
-			bson.M{})
+			active(bson.M{}))
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError,
 				gin.H{"error": err.Error()})
@@ -94,8 +210,11 @@ func (handler *RecipesHandler) ListRecipesHandler(ctx *gin.Context) {
 			recipes = append(recipes, recipe)
 		}
 		data, _ := json.Marshal(recipes)
-		handler.redisClient.Set(ctx, "recipes", string(data), 0)
-		ctx.JSON(http.StatusOK, recipes)
+		handler.cache.Set(ctx, string(data))
+		sortRecipes(ctx, recipes)
+		if recipes, ok := paginate(ctx, recipes); ok {
+			renderRecipes(ctx, http.StatusOK, recipes)
+		}
 	} else if err != nil {
 		ctx.JSON(http.StatusInternalServerError,
 			gin.H{"error": err.Error()})
@@ -104,150 +223,258 @@ func (handler *RecipesHandler) ListRecipesHandler(ctx *gin.Context) {
 		log.Printf("Request to Redis")
 		recipes := make([]models.Recipe, 0)
 		json.Unmarshal([]byte(val), &recipes)
-		ctx.JSON(http.StatusOK, recipes)
-	}
-}
-
-// swagger:operation GET /recipes/{id} recipes getRecipe
-// Get an existing recipe
-// ---
-// parameters:
-// - name: id
-//   in: path
-//   description: ID of the recipe
-//   required: true
-//   type: string
-// produces:
-// - application/json
-// responses:
-//     '200':
-//         description: Successful operation
-//     '404':
-//         description: Invalid recipe ID
+		sortRecipes(ctx, recipes)
+		if recipes, ok := paginate(ctx, recipes); ok {
+			renderRecipes(ctx, http.StatusOK, recipes)
+		}
+	}
+}
+
+// GetRecipeHandler gets an existing recipe.
 func (handler *RecipesHandler) GetRecipeHandler(ctx *gin.Context) {
-	id := ctx.Param("id")
-	objectId, _ := primitive.ObjectIDFromHex(id)
-	cur := handler.collection.FindOne(ctx, bson.M{
+	objectId, ok := recipeID(ctx, handler.collection, "id")
+	if !ok {
+		return
+	}
+	cur := handler.collection.FindOne(ctx, active(bson.M{
 		"_id": objectId,
-	})
+	}))
 	var recipe models.Recipe
 	err := cur.Decode(&recipe)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Recipe not found"})
+		return
+	}
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	ctx.JSON(http.StatusOK, recipe)
-}
-
-// swagger:operation PUT /recipes/{id} recipes updateRecipe
-// Update an existing recipe
-// ---
-// parameters:
-// - name: id
-//   in: path
-//   description: ID of the recipe
-//   required: true
-//   type: string
-// - in: body
-//   description: new date of the recipe
-//   required: true
-//   type: Recipe
-// produces:
-// - application/json
-// responses:
-//     '200':
-//         description: Successful operation
-//     '400':
-//         description: Invalid input
-//     '404':
-//         description: Invalid recipe ID
+	ctx.Header("ETag", recipeETag(recipe))
+	renderRecipe(ctx, http.StatusOK, recipe)
+}
+
+// recipeETag is the entity tag of a stored recipe, changing with every edit.
+// The recipe is passed through BSON first, so a recipe just written gets the
+// same tag as when it is read back.
+func recipeETag(recipe models.Recipe) string {
+	if raw, err := bson.Marshal(recipe); err == nil {
+		var stored models.Recipe
+		if bson.Unmarshal(raw, &stored) == nil {
+			recipe = stored
+		}
+	}
+	data, _ := json.Marshal(recipe)
+	hash := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(hash[:16]) + `"`
+}
+
+// UpdateRecipeHandler updates an existing recipe.
 func (handler *RecipesHandler) UpdateRecipeHandler(ctx *gin.Context) {
 	id := ctx.Param("id")
 	var recipe models.Recipe
-	if err := ctx.ShouldBindJSON(&recipe); err != nil {
+	if err := bindRecipe(ctx, &recipe); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
 			"error": err.Error()})
 		return
 	}
-	objectId, _ := primitive.ObjectIDFromHex(id)
-	_, err := handler.collection.UpdateOne(ctx, bson.M{
-		"_id": objectId,
-	}, bson.D{{"$set", bson.D{
-		{"name", recipe.Name},
-		{"instructions", recipe.Instructions},
-		{"ingredients", recipe.Ingredients},
-		{"tags", recipe.Tags},
-	}}})
+	if recipe.TranslatedTo != "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "This is the " + recipe.TranslatedTo + " translation, edit it with PUT /recipes/" + id + "/translations/" + recipe.TranslatedTo})
+		return
+	}
+	handler.normalizeTags(&recipe)
+	objectId, ok := recipeID(ctx, handler.collection, "id")
+	if !ok {
+		return
+	}
+	filter := active(bson.M{"_id": objectId})
+	ifMatch := ctx.GetHeader("If-Match")
+	if ifMatch != "" && ifMatch != "*" {
+		current, err := handler.collection.FindOne(ctx, filter).DecodeBytes()
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Recipe not found"})
+			return
+		}
+		var decoded models.Recipe
+		if err == nil {
+			err = bson.Unmarshal(current, &decoded)
+		}
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if recipeETag(decoded) != ifMatch {
+			ctx.JSON(http.StatusPreconditionFailed, gin.H{"error": "The recipe has been changed since it was read, get it again"})
+			return
+		}
+		// Only update the recipe as it was compared, so a change made in
+		// between isn't overwritten.
+		filter["$expr"] = bson.M{"$eq": bson.A{"$$ROOT", bson.M{"$literal": current}}}
+	}
+	err := handler.collection.FindOneAndUpdate(ctx, filter, bson.D{{Key: "$set", Value: recipeContent(recipe)}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&recipe)
+	if errors.Is(err, mongo.ErrNoDocuments) && ifMatch != "" && ifMatch != "*" {
+		ctx.JSON(http.StatusPreconditionFailed, gin.H{"error": "The recipe has been changed since it was read, get it again"})
+		return
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Recipe not found"})
+		return
+	}
 	if err != nil {
 		fmt.Println(err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	log.Println("Remove data from Redis")
-	handler.redisClient.Del(ctx, "recipes")
-	ctx.JSON(http.StatusOK, recipe)
-}
-
-// swagger:operation DELETE /recipes/{id} recipes deleteRecipe
-// Deletes an existing recipe
-// ---
-// parameters:
-// - name: id
-//   in: path
-//   description: ID of the recipe
-//   required: true
-//   type: string
-// produces:
-// - application/json
-// responses:
-//     '200':
-//         description: Successful operation
-//     '404':
-//         description: Invalid recipe ID
+	handler.updateTexts(&recipe)
+	handler.updateSlug(&recipe)
+	handler.recordRevision(ctx, models.RevisionUpdate, recipe)
+	handler.indexSimilar(recipe)
+	handler.cache.Invalidate(ctx)
+	ctx.Header("ETag", recipeETag(recipe))
+	renderData(ctx, http.StatusOK, recipe)
+}
+
+// DeleteRecipeHandler moves an existing recipe to the trash.
 func (handler *RecipesHandler) DeleteRecipeHandler(ctx *gin.Context) {
-	id := ctx.Param("id")
-	objectId, _ := primitive.ObjectIDFromHex(id)
-	_, err := handler.collection.DeleteOne(ctx, bson.M{
+	objectId, ok := recipeID(ctx, handler.collection, "id")
+	if !ok {
+		return
+	}
+	var recipe models.Recipe
+	err := handler.collection.FindOneAndUpdate(ctx, active(bson.M{
 		"_id": objectId,
-	})
+	}), bson.M{"$set": bson.M{"deletedAt": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&recipe)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Recipe not found"})
+		return
+	}
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	handler.recordRevision(ctx, models.RevisionDelete, recipe)
+	handler.unindexSimilar(recipe.ID)
+	handler.cache.Invalidate(ctx)
 	ctx.JSON(http.StatusOK, gin.H{"message": "Recipe has been deleted"})
 }
 
-// swagger:operation GET /recipes/search recipes findRecipe
-// Search recipes based on tags
-// ---
-// produces:
-// - application/json
-// parameters:
-//   - name: tag
-//     in: query
-//     description: recipe tag
-//     required: true
-//     type: string
-// responses:
-//     '200':
-//         description: Successful operation
-func (handler *RecipesHandler) SearchRecipesHandler(c *gin.Context) {
-	/*
-		tag := c.Query("tag")
-		listOfRecipes := make([]Recipe, 0)
-		for i := 0; i < len(oldRecipes); i++ {
-			found := false
-			for _, t := range oldRecipes[i].Tags {
-				if strings.EqualFold(t, tag) {
-					found = true
-				}
-			}
-			if found {
-				listOfRecipes = append(listOfRecipes,
-					oldRecipes[i])
-			}
-		}
-		c.JSON(http.StatusOK, listOfRecipes)
-	*/
+// SearchRecipesHandler searches recipes by tags and full text.
+func (handler *RecipesHandler) SearchRecipesHandler(ctx *gin.Context) {
+	tag, query := ctx.Query("tag"), ctx.Query("q")
+	if tag == "" && query == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Search by tag, q or both"})
+		return
+	}
+	filter := bson.M{}
+	opts := options.Find()
+	if tag != "" {
+		// Recipes store their tags normalized to the canonical names, so the
+		// index on the tags finds them by the canonical name of the term.
+		tags, err := handler.tags.Normalize(handler.ctx, []string{tag})
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if len(tags) == 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Search by tag, q or both"})
+			return
+		}
+		filter["tags"] = tags[0]
+	}
+	if query != "" {
+		// The words are stemmed like the texts in the language searched in.
+		language := "none"
+		if languages := preferredLanguages(ctx); len(languages) > 0 {
+			language = models.TextLanguage(languages[0])
+		}
+		filter["$text"] = bson.M{"$search": query, "$language": language}
+		opts.SetSort(bson.M{"score": bson.M{"$meta": "textScore"}})
+	}
+	cur, err := handler.collection.Find(handler.ctx, active(filter), opts)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recipes := make([]models.Recipe, 0)
+	if err := cur.All(handler.ctx, &recipes); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	sortRecipes(ctx, recipes)
+	if recipes, ok := paginate(ctx, recipes); ok {
+		renderRecipes(ctx, http.StatusOK, recipes)
+	}
+}
+
+// recipeContent holds the fields of a recipe edited by its authors, leaving
+// out the fields maintained by the application like photos and ratings.
+func recipeContent(recipe models.Recipe) bson.D {
+	return bson.D{
+		{Key: "name", Value: recipe.Name},
+		{Key: "instructions", Value: recipe.Instructions},
+		{Key: "ingredients", Value: recipe.Ingredients},
+		{Key: "tags", Value: recipe.Tags},
+		{Key: "servings", Value: recipe.Servings},
+		{Key: "yield", Value: recipe.Yield},
+		{Key: "prepTime", Value: recipe.PrepTime},
+		{Key: "cookTime", Value: recipe.CookTime},
+		{Key: "totalTime", Value: recipe.TotalTime},
+		{Key: "image", Value: recipe.Image},
+		{Key: "source", Value: recipe.Source},
+		{Key: "language", Value: models.NormalizeLanguage(recipe.Language)},
+		{Key: "fingerprint", Value: models.NewFingerprint(recipe)},
+	}
+}
+
+// active restricts a filter to recipes that are not in the trash.
+func active(filter bson.M) bson.M {
+	filter["deletedAt"] = nil
+	return filter
+}
+
+// findRecipes fetches the recipes with the given ids in one query, leaving
+// out recipes in the trash.
+func findRecipes(ctx context.Context, collection *mongo.Collection, ids []primitive.ObjectID) (map[primitive.ObjectID]models.Recipe, error) {
+	recipes := make(map[primitive.ObjectID]models.Recipe)
+	if len(ids) == 0 {
+		return recipes, nil
+	}
+	cur, err := collection.Find(ctx, active(bson.M{"_id": bson.M{"$in": ids}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var recipe models.Recipe
+		if err := cur.Decode(&recipe); err != nil {
+			return nil, err
+		}
+		recipes[recipe.ID] = recipe
+	}
+	return recipes, cur.Err()
+}
+
+// purgedRecipes returns those of the ids whose recipes do not exist anymore,
+// not even in the trash.
+func purgedRecipes(ctx context.Context, collection *mongo.Collection, ids []interface{}) ([]interface{}, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	existing, err := collection.Distinct(ctx, "_id", bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[interface{}]bool, len(existing))
+	for _, id := range existing {
+		found[id] = true
+	}
+	purged := make([]interface{}, 0)
+	for _, id := range ids {
+		if !found[id] {
+			purged = append(purged, id)
+		}
+	}
+	return purged, nil
 }
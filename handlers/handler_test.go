@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestImportRefusesIncompleteRecipes checks that a recipe without
+// ingredients is previewed, but not stored.
+func TestImportRefusesIncompleteRecipes(t *testing.T) {
+	router := newTestRouter(t, "")
+	document := `{"@context": "https://schema.org", "@type": "Recipe", "name": "Käsekuchen", "recipeInstructions": "Backen"}`
+	request := httptest.NewRequest(http.MethodPost, "/recipes/import?commit=true", strings.NewReader(document))
+	request.Header.Set("Content-Type", "application/ld+json")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusUnprocessableEntity || !strings.Contains(recorder.Body.String(), "recipe has no ingredients") {
+		t.Errorf("got status %d: %s", recorder.Code, recorder.Body)
+	}
+}
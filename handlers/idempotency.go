@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+)
+
+// IdempotencyHeader is the request header carrying the key of a POST request
+// that may be retried safely.
+const IdempotencyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength limits the length of the keys chosen by clients.
+const maxIdempotencyKeyLength = 255
+
+// storedResponse is the response to a request with an idempotency key,
+// without status while the request is still being processed.
+type storedResponse struct {
+	Hash        string `json:"hash"`
+	Status      int    `json:"status,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	Location    string `json:"location,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+// IdempotencyStore keeps the responses to POST requests with an
+// Idempotency-Key header in Redis, so that a retry gets the original response
+// instead of e.g. creating a recipe twice.
+type IdempotencyStore struct {
+	client  *redis.Client
+	prefix  string
+	ttl     time.Duration
+	maxBody int64
+}
+
+// NewIdempotencyStore creates a store keeping responses for ttl, its keys
+// starting with the prefix of the tenant. Requests with a key and a body
+// larger than maxBody bytes are rejected, as the body is read into memory.
+func NewIdempotencyStore(client *redis.Client, prefix string, ttl time.Duration, maxBody int64) *IdempotencyStore {
+	return &IdempotencyStore{
+		client:  client,
+		prefix:  prefix,
+		ttl:     ttl,
+		maxBody: maxBody,
+	}
+}
+
+// Middleware replays the stored response for POST requests with a known
+// Idempotency-Key and stores the response for new keys. Reusing a key for a
+// different request is answered with 422. It has to run after the
+// IdentifyMiddleware, as keys are scoped by user.
+func (store *IdempotencyStore) Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key := ctx.GetHeader(IdempotencyHeader)
+		if ctx.Request.Method != http.MethodPost || key == "" {
+			ctx.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is longer than 255 characters"})
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, store.maxBody))
+		if err != nil && int64(len(body)) >= store.maxBody {
+			ctx.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Body is larger than %d bytes", store.maxBody)})
+			return
+		}
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+		hash := sha256.New()
+		hash.Write([]byte(ctx.Request.Method + " " + ctx.Request.URL.RequestURI() + "\n"))
+		hash.Write(body)
+		request := storedResponse{Hash: hex.EncodeToString(hash.Sum(nil))}
+		redisKey := store.prefix + "idempotency:" + ctx.GetString("username") + ":" + key
+
+		stored, found, err := store.begin(ctx, redisKey, request)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if found {
+			replay(ctx, request, stored)
+			return
+		}
+
+		defer func() {
+			// Release the key of a request whose handler panicked, so the
+			// client's retry isn't answered with 409 until the key expires.
+			if err := recover(); err != nil {
+				store.client.Del(context.Background(), redisKey)
+				panic(err)
+			}
+		}()
+		writer := &recordingWriter{ResponseWriter: ctx.Writer}
+		ctx.Writer = writer
+		ctx.Next()
+		if writer.Status() >= http.StatusInternalServerError {
+			// Let the client retry requests that failed on our side.
+			store.client.Del(context.Background(), redisKey)
+			return
+		}
+		request.Status = writer.Status()
+		request.ContentType = writer.Header().Get("Content-Type")
+		request.Location = writer.Header().Get("Location")
+		request.Body = writer.body.Bytes()
+		data, _ := json.Marshal(request)
+		if err := store.client.Set(context.Background(), redisKey, data, store.ttl).Err(); err != nil {
+			log.Printf("Error while storing the response for Idempotency-Key %q: %v", key, err)
+		}
+	}
+}
+
+// begin marks a key as in progress, unless there is a stored response for it
+// already, which is returned then.
+func (store *IdempotencyStore) begin(ctx context.Context, redisKey string, request storedResponse) (storedResponse, bool, error) {
+	var stored storedResponse
+	data, _ := json.Marshal(request)
+	created, err := store.client.SetNX(ctx, redisKey, data, store.ttl).Result()
+	if err != nil || created {
+		return stored, false, err
+	}
+	existing, err := store.client.Get(ctx, redisKey).Bytes()
+	if err == redis.Nil {
+		// Expired or failed meanwhile, so try again.
+		return store.begin(ctx, redisKey, request)
+	}
+	if err != nil {
+		return stored, false, err
+	}
+	err = json.Unmarshal(existing, &stored)
+	return stored, true, err
+}
+
+func replay(ctx *gin.Context, request storedResponse, stored storedResponse) {
+	if stored.Hash != request.Hash {
+		ctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key has been used for a different request"})
+		return
+	}
+	if stored.Status == 0 {
+		ctx.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is still being processed"})
+		return
+	}
+	if stored.Location != "" {
+		ctx.Header("Location", stored.Location)
+	}
+	ctx.Header("Idempotent-Replayed", "true")
+	ctx.Data(stored.Status, stored.ContentType, stored.Body)
+	ctx.Abort()
+}
+
+// recordingWriter keeps a copy of the response body.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (writer *recordingWriter) Write(data []byte) (int, error) {
+	writer.body.Write(data)
+	return writer.ResponseWriter.Write(data)
+}
+
+func (writer *recordingWriter) WriteString(s string) (int, error) {
+	writer.body.WriteString(s)
+	return writer.ResponseWriter.WriteString(s)
+}
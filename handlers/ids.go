@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/aheadxnet/go-sandbox/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"net/http"
+)
+
+// legacyIDIndex is the unique index mapping legacy IDs to recipes.
+func legacyIDIndex() mongo.IndexModel {
+	return mongo.IndexModel{
+		Keys: bson.D{{Key: "legacyIds", Value: 1}},
+		Options: options.Index().SetName("legacyIds_unique").SetUnique(true).
+			SetPartialFilterExpression(bson.M{"legacyIds": bson.M{"$exists": true}}),
+	}
+}
+
+// ResolveRecipeID returns the ObjectID of the recipe an ID refers to, looking
+// up legacy IDs. Invalid and unknown legacy IDs yield mongo.ErrNoDocuments.
+func ResolveRecipeID(ctx context.Context, collection *mongo.Collection, id string) (primitive.ObjectID, error) {
+	recipeID, err := models.ParseRecipeID(id)
+	if err != nil {
+		return primitive.NilObjectID, mongo.ErrNoDocuments
+	}
+	if !recipeID.IsLegacy() {
+		return recipeID.ObjectID, nil
+	}
+	var recipe models.Recipe
+	err = collection.FindOne(ctx, bson.M{"legacyIds": recipeID.Legacy},
+		options.FindOne().SetProjection(bson.M{"_id": 1})).Decode(&recipe)
+	return recipe.ID, err
+}
+
+// recipeID resolves the recipe ID in a path parameter, answering with 404 for
+// IDs not referring to any recipe.
+func recipeID(ctx *gin.Context, collection *mongo.Collection, param string) (primitive.ObjectID, bool) {
+	objectId, err := ResolveRecipeID(ctx, collection, ctx.Param(param))
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Recipe not found"})
+		return primitive.NilObjectID, false
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return primitive.NilObjectID, false
+	}
+	return objectId, true
+}
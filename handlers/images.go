@@ -0,0 +1,346 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aheadxnet/go-sandbox/models"
+	"github.com/aheadxnet/go-sandbox/thumbnail"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	// thumbnailSize is the maximum width and height of thumbnails.
+	thumbnailSize = 320
+	// maxImagePixels protects against images decompressing into huge bitmaps.
+	maxImagePixels = 50000000
+)
+
+// ImageStore keeps the photos of recipes in a GridFS bucket.
+type ImageStore struct {
+	database *mongo.Database
+	name     string
+}
+
+func NewImageStore(database *mongo.Database, name string) *ImageStore {
+	return &ImageStore{
+		database: database,
+		name:     name,
+	}
+}
+
+// bucket opens the GridFS bucket. A bucket must not be shared between
+// goroutines, so every operation opens its own.
+func (store *ImageStore) bucket() (*gridfs.Bucket, error) {
+	return gridfs.NewBucket(store.database, options.GridFSBucket().SetName(store.name))
+}
+
+// Upload stores an image and its thumbnail for a recipe.
+func (store *ImageStore) Upload(recipeID primitive.ObjectID, filename string, data []byte) (*models.Photo, error) {
+	contentType := http.DetectContentType(data)
+	if contentType != "image/jpeg" && contentType != "image/png" {
+		return nil, fmt.Errorf("unsupported image type %s, use image/jpeg or image/png", contentType)
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return nil, fmt.Errorf("image of %dx%d pixels is too large", config.Width, config.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var thumb bytes.Buffer
+	if contentType == "image/png" {
+		err = png.Encode(&thumb, thumbnail.Fit(img, thumbnailSize))
+	} else {
+		err = jpeg.Encode(&thumb, thumbnail.Fit(img, thumbnailSize), &jpeg.Options{Quality: 80})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	bucket, err := store.bucket()
+	if err != nil {
+		return nil, err
+	}
+	photo := &models.Photo{
+		ContentType: contentType,
+		Width:       config.Width,
+		Height:      config.Height,
+		Size:        int64(len(data)),
+		UploadedAt:  time.Now(),
+	}
+	photo.ID, err = bucket.UploadFromStream(filename, bytes.NewReader(data), options.GridFSUpload().SetMetadata(bson.M{
+		"recipeId":    recipeID,
+		"contentType": contentType,
+		"kind":        "original",
+	}))
+	if err != nil {
+		return nil, err
+	}
+	photo.ThumbnailID, err = bucket.UploadFromStream("thumbnail-"+filename, &thumb, options.GridFSUpload().SetMetadata(bson.M{
+		"recipeId":    recipeID,
+		"contentType": contentType,
+		"kind":        "thumbnail",
+		"original":    photo.ID,
+	}))
+	if err != nil {
+		bucket.Delete(photo.ID)
+		return nil, err
+	}
+	photo.URL = "/images/" + photo.ID.Hex()
+	photo.ThumbnailURL = "/images/" + photo.ThumbnailID.Hex()
+	return photo, nil
+}
+
+// Delete removes an image together with its thumbnail.
+func (store *ImageStore) Delete(photo models.Photo) error {
+	bucket, err := store.bucket()
+	if err != nil {
+		return err
+	}
+	for _, id := range []primitive.ObjectID{photo.ID, photo.ThumbnailID} {
+		if err := bucket.Delete(id); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteRecipeImages removes all images of a recipe.
+func (store *ImageStore) DeleteRecipeImages(ctx context.Context, recipeID primitive.ObjectID) (int, error) {
+	return store.deleteWhere(ctx, bson.M{"metadata.recipeId": recipeID})
+}
+
+// CleanupOrphans removes the images of recipes that do not exist anymore,
+// like recipes purged from the trash by the TTL index.
+func (store *ImageStore) CleanupOrphans(ctx context.Context, recipes *mongo.Collection) (int, error) {
+	bucket, err := store.bucket()
+	if err != nil {
+		return 0, err
+	}
+	ids, err := bucket.GetFilesCollection().Distinct(ctx, "metadata.recipeId", bson.M{})
+	if err != nil {
+		return 0, err
+	}
+	orphans, err := purgedRecipes(ctx, recipes, ids)
+	if err != nil {
+		return 0, err
+	}
+	if len(orphans) == 0 {
+		return 0, nil
+	}
+	return store.deleteWhere(ctx, bson.M{"metadata.recipeId": bson.M{"$in": orphans}})
+}
+
+func (store *ImageStore) deleteWhere(ctx context.Context, filter bson.M) (int, error) {
+	bucket, err := store.bucket()
+	if err != nil {
+		return 0, err
+	}
+	cur, err := bucket.GetFilesCollection().Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(ctx)
+	deleted := 0
+	for cur.Next(ctx) {
+		var file struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cur.Decode(&file); err != nil {
+			return deleted, err
+		}
+		if err := bucket.Delete(file.ID); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, cur.Err()
+}
+
+// maxEnvelopeSize is the room left for the multipart envelope around an
+// uploaded image.
+const maxEnvelopeSize = 64 << 10
+
+type ImagesHandler struct {
+	recipes *mongo.Collection
+	store   *ImageStore
+	ctx     context.Context
+	cache   *RecipeCache
+	maxSize int64
+}
+
+func NewImagesHandler(ctx context.Context, recipes *mongo.Collection, store *ImageStore, cache *RecipeCache, maxSize int64) *ImagesHandler {
+	return &ImagesHandler{
+		recipes: recipes,
+		store:   store,
+		ctx:     ctx,
+		cache:   cache,
+		maxSize: maxSize,
+	}
+}
+
+// UploadImageHandler uploads a photo of a recipe.
+func (handler *ImagesHandler) UploadImageHandler(ctx *gin.Context) {
+	objectId, ok := recipeID(ctx, handler.recipes, "id")
+	if !ok {
+		return
+	}
+	count, err := handler.recipes.CountDocuments(handler.ctx, active(bson.M{"_id": objectId}))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if count == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Recipe not found"})
+		return
+	}
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, handler.maxSize+maxEnvelopeSize)
+	header, err := ctx.FormFile("image")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if header.Size > handler.maxSize {
+		ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Image is larger than %d bytes", handler.maxSize)})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	photo, err := handler.store.Upload(objectId, header.Filename, data)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	_, err = handler.recipes.UpdateOne(handler.ctx, bson.M{"_id": objectId}, bson.M{"$push": bson.M{"photos": photo}})
+	if err != nil {
+		handler.store.Delete(*photo)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	handler.cache.Invalidate(ctx)
+	ctx.JSON(http.StatusCreated, photo)
+}
+
+// ListImagesHandler returns the photos of a recipe.
+func (handler *ImagesHandler) ListImagesHandler(ctx *gin.Context) {
+	recipe, ok := handler.findRecipe(ctx)
+	if !ok {
+		return
+	}
+	photos := recipe.Photos
+	if photos == nil {
+		photos = make([]models.Photo, 0)
+	}
+	ctx.JSON(http.StatusOK, photos)
+}
+
+// DeleteImageHandler deletes a photo of a recipe.
+func (handler *ImagesHandler) DeleteImageHandler(ctx *gin.Context) {
+	recipe, ok := handler.findRecipe(ctx)
+	if !ok {
+		return
+	}
+	imageId, _ := primitive.ObjectIDFromHex(ctx.Param("imageId"))
+	for _, photo := range recipe.Photos {
+		if photo.ID != imageId {
+			continue
+		}
+		_, err := handler.recipes.UpdateOne(handler.ctx, bson.M{"_id": recipe.ID},
+			bson.M{"$pull": bson.M{"photos": bson.M{"id": imageId}}})
+		if err == nil {
+			err = handler.store.Delete(photo)
+		}
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		handler.cache.Invalidate(ctx)
+		ctx.JSON(http.StatusOK, gin.H{"message": "Photo has been deleted"})
+		return
+	}
+	ctx.JSON(http.StatusNotFound, gin.H{"error": "Photo not found"})
+}
+
+// GetImageHandler serves a photo or thumbnail, supporting conditional and
+// range requests.
+func (handler *ImagesHandler) GetImageHandler(ctx *gin.Context) {
+	objectId, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+	bucket, err := handler.store.bucket()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	stream, err := bucket.OpenDownloadStream(objectId)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer stream.Close()
+	data, err := ioutil.ReadAll(stream)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	file := stream.GetFile()
+	var metadata struct {
+		ContentType string `bson:"contentType"`
+	}
+	bson.Unmarshal(file.Metadata, &metadata)
+	// Images never change, a new upload gets a new id.
+	ctx.Header("Content-Type", metadata.ContentType)
+	ctx.Header("Cache-Control", "public, max-age=31536000, immutable")
+	ctx.Header("ETag", `"`+objectId.Hex()+`"`)
+	http.ServeContent(ctx.Writer, ctx.Request, file.Name, file.UploadDate, bytes.NewReader(data))
+}
+
+func (handler *ImagesHandler) findRecipe(ctx *gin.Context) (*models.Recipe, bool) {
+	objectId, ok := recipeID(ctx, handler.recipes, "id")
+	if !ok {
+		return nil, false
+	}
+	var recipe models.Recipe
+	err := handler.recipes.FindOne(handler.ctx, active(bson.M{"_id": objectId})).Decode(&recipe)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Recipe not found"})
+		return nil, false
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return &recipe, true
+}
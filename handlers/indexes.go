@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// IndexSet are the indexes declared for a collection. Every index needs a
+// name, as indexes are matched by name.
+type IndexSet struct {
+	Collection *mongo.Collection
+	Indexes    []mongo.IndexModel
+}
+
+// IndexDrift is an existing index that differs from its declaration.
+type IndexDrift struct {
+	Name     string
+	Existing string
+	Declared string
+}
+
+// IndexReport lists what reconciling changed, or would change in a dry run,
+// about the indexes of a collection.
+type IndexReport struct {
+	Collection string
+	Added      []string
+	Changed    []IndexDrift
+	Dropped    []string
+}
+
+// Empty reports whether the indexes matched their declarations.
+func (report IndexReport) Empty() bool {
+	return len(report.Added) == 0 && len(report.Changed) == 0 && len(report.Dropped) == 0
+}
+
+// RecipeIndexes declares the indexes of the recipes collection.
+func RecipeIndexes(trashRetention time.Duration) []mongo.IndexModel {
+	indexes := []mongo.IndexModel{
+		searchIndex(),
+		{Keys: bson.D{{Key: "tags", Value: 1}}, Options: options.Index().SetName("tags")},
+		{Keys: bson.D{{Key: "publishedAt", Value: -1}}, Options: options.Index().SetName("publishedAt")},
+		trashIndex(trashRetention),
+		legacyIDIndex(),
+	}
+	indexes = append(indexes, slugIndexes()...)
+	return append(indexes, fingerprintIndexes()...)
+}
+
+// ReconcileIndexes makes the indexes of the collection match the declared
+// ones: missing indexes are created, drifted ones recreated and undeclared
+// ones dropped, except for the one on _id. A dry run only reports what would
+// change.
+func ReconcileIndexes(ctx context.Context, set IndexSet, dryRun bool) (IndexReport, error) {
+	report := IndexReport{Collection: set.Collection.Name()}
+	cur, err := set.Collection.Indexes().List(ctx)
+	if err != nil {
+		return report, err
+	}
+	var documents []bson.D
+	if err := cur.All(ctx, &documents); err != nil {
+		return report, err
+	}
+	existing := make(map[string]indexSpec)
+	for _, document := range documents {
+		name, _ := document.Map()["name"].(string)
+		if name != "_id_" {
+			existing[name] = existingSpec(document)
+		}
+	}
+
+	drop := make([]string, 0)
+	create := make([]mongo.IndexModel, 0)
+	declared := make(map[string]bool)
+	for _, model := range set.Indexes {
+		name := *model.Options.Name
+		declared[name] = true
+		spec, err := declaredSpec(model)
+		if err != nil {
+			return report, err
+		}
+		current, found := existing[name]
+		switch {
+		case !found:
+			report.Added = append(report.Added, name)
+			create = append(create, model)
+		case !current.equal(spec):
+			report.Changed = append(report.Changed, IndexDrift{Name: name, Existing: current.String(), Declared: spec.String()})
+			drop = append(drop, name)
+			create = append(create, model)
+		}
+	}
+	for name := range existing {
+		if !declared[name] {
+			report.Dropped = append(report.Dropped, name)
+			drop = append(drop, name)
+		}
+	}
+	sort.Strings(report.Dropped)
+	if dryRun {
+		return report, nil
+	}
+
+	// Drop first, as a collection can't have two text indexes.
+	for _, name := range drop {
+		if _, err := set.Collection.Indexes().DropOne(ctx, name); err != nil {
+			return report, err
+		}
+	}
+	if len(create) > 0 {
+		if _, err := set.Collection.Indexes().CreateMany(ctx, create); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// indexSpec is the part of an index that is compared to find drift, with all
+// numbers as float64, as MongoDB may return them with another type than they
+// have been declared with.
+type indexSpec struct {
+	key     bson.D
+	options map[string]interface{}
+}
+
+// comparedOptions are the index options compared to find drift.
+var comparedOptions = []string{"unique", "sparse", "expireAfterSeconds", "partialFilterExpression",
+	"weights", "default_language", "language_override"}
+
+// existingSpec extracts the spec of an index listed by MongoDB.
+func existingSpec(document bson.D) indexSpec {
+	spec := indexSpec{options: make(map[string]interface{})}
+	values := document.Map()
+	if key, ok := values["key"].(bson.D); ok {
+		spec.key = normalizeKey(key)
+	}
+	for _, name := range comparedOptions {
+		if value, ok := values[name]; ok && value != false {
+			spec.options[name] = normalizeValue(value)
+		}
+	}
+	return spec
+}
+
+// declaredSpec returns the spec of a declared index as MongoDB would list it,
+// with the defaults of text indexes filled in.
+func declaredSpec(model mongo.IndexModel) (indexSpec, error) {
+	opts := model.Options
+	document := bson.D{{Key: "key", Value: model.Keys}}
+	if opts.Unique != nil && *opts.Unique {
+		document = append(document, bson.E{Key: "unique", Value: true})
+	}
+	if opts.Sparse != nil && *opts.Sparse {
+		document = append(document, bson.E{Key: "sparse", Value: true})
+	}
+	if opts.ExpireAfterSeconds != nil {
+		document = append(document, bson.E{Key: "expireAfterSeconds", Value: *opts.ExpireAfterSeconds})
+	}
+	if opts.PartialFilterExpression != nil {
+		document = append(document, bson.E{Key: "partialFilterExpression", Value: opts.PartialFilterExpression})
+	}
+	if opts.Weights != nil {
+		document = append(document, bson.E{Key: "weights", Value: opts.Weights})
+	}
+	// Round trip through BSON to get the types of listed indexes.
+	data, err := bson.Marshal(document)
+	if err != nil {
+		return indexSpec{}, err
+	}
+	var raw bson.D
+	if err := bson.Unmarshal(data, &raw); err != nil {
+		return indexSpec{}, err
+	}
+	spec := existingSpec(raw)
+
+	key, _ := raw.Map()["key"].(bson.D)
+	weights := make(map[string]interface{})
+	for _, field := range key {
+		if field.Value == "text" {
+			weights[field.Key] = float64(1)
+		}
+	}
+	if len(weights) == 0 {
+		return spec, nil
+	}
+	if declared, ok := spec.options["weights"].(map[string]interface{}); ok {
+		for field, weight := range declared {
+			weights[field] = weight
+		}
+	}
+	spec.options["weights"] = weights
+	spec.options["default_language"] = "english"
+	if opts.DefaultLanguage != nil {
+		spec.options["default_language"] = *opts.DefaultLanguage
+	}
+	spec.options["language_override"] = "language"
+	if opts.LanguageOverride != nil {
+		spec.options["language_override"] = *opts.LanguageOverride
+	}
+	return spec, nil
+}
+
+// normalizeKey replaces the text fields of a key by the _fts and _ftsx
+// fields MongoDB lists text indexes with, the fields are part of the weights.
+func normalizeKey(key bson.D) bson.D {
+	normalized := make(bson.D, 0, len(key))
+	text := false
+	for _, field := range key {
+		switch {
+		case field.Value == "text" || field.Key == "_fts" || field.Key == "_ftsx":
+			if !text {
+				normalized = append(normalized, bson.E{Key: "_fts", Value: "text"}, bson.E{Key: "_ftsx", Value: float64(1)})
+				text = true
+			}
+		default:
+			normalized = append(normalized, bson.E{Key: field.Key, Value: normalizeValue(field.Value)})
+		}
+	}
+	return normalized
+}
+
+// normalizeValue turns documents into maps and numbers into float64.
+func normalizeValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case bson.D:
+		normalized := make(map[string]interface{}, len(value))
+		for _, field := range value {
+			normalized[field.Key] = normalizeValue(field.Value)
+		}
+		return normalized
+	case bson.A:
+		normalized := make([]interface{}, len(value))
+		for i, element := range value {
+			normalized[i] = normalizeValue(element)
+		}
+		return normalized
+	case int32:
+		return float64(value)
+	case int64:
+		return float64(value)
+	}
+	return value
+}
+
+func (spec indexSpec) equal(other indexSpec) bool {
+	return reflect.DeepEqual(spec.key, other.key) && reflect.DeepEqual(spec.options, other.options)
+}
+
+func (spec indexSpec) String() string {
+	fields := make([]string, 0, len(spec.key))
+	for _, field := range spec.key {
+		fields = append(fields, fmt.Sprintf("%s: %v", field.Key, field.Value))
+	}
+	names := make([]string, 0, len(spec.options))
+	for name := range spec.options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var builder strings.Builder
+	builder.WriteString("key " + strings.Join(fields, ", "))
+	for _, name := range names {
+		data, err := bson.MarshalExtJSON(bson.M{name: integers(spec.options[name])}, false, false)
+		if err == nil {
+			builder.WriteString(" " + string(data))
+		}
+	}
+	return builder.String()
+}
+
+// integers turns whole float64 numbers back into integers for display.
+func integers(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for key, element := range value {
+			converted[key] = integers(element)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(value))
+		for i, element := range value {
+			converted[i] = integers(element)
+		}
+		return converted
+	case float64:
+		if value == float64(int64(value)) {
+			return int64(value)
+		}
+	}
+	return value
+}
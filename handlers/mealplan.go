@@ -0,0 +1,503 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/aheadxnet/go-sandbox/ical"
+	"github.com/aheadxnet/go-sandbox/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// mealTimes are the start hours of the meal slots in the calendar export.
+var mealTimes = map[models.MealSlot]int{
+	models.Breakfast: 8,
+	models.Lunch:     12,
+	models.Dinner:    18,
+}
+
+type MealPlansHandler struct {
+	collection *mongo.Collection
+	recipes    *mongo.Collection
+	ctx        context.Context
+	tenant     string
+}
+
+// MealPlanInput is a meal plan as written by its owner.
+type MealPlanInput struct {
+	// the name for this meal plan
+	Name string `json:"name"`
+
+	// the planned meals
+	Entries []MealPlanEntryInput `json:"entries"`
+}
+
+// MealPlanEntryInput is a planned meal, the recipe is referred to by its
+// current or a legacy ID.
+type MealPlanEntryInput struct {
+	// the day of the meal, formatted as YYYY-MM-DD
+	Date string `json:"date" binding:"required"`
+
+	// the meal slot, one of breakfast, lunch or dinner
+	Slot models.MealSlot `json:"slot" binding:"required"`
+
+	// the id of the planned recipe
+	RecipeID string `json:"recipeId" binding:"required"`
+
+	// the number of servings to cook
+	Servings int `json:"servings" binding:"required"`
+}
+
+// feedResponse holds the path of a new calendar feed, the only time its
+// token is shown.
+type feedResponse struct {
+	Token string `json:"token" binding:"required"`
+	Path  string `json:"path" binding:"required"`
+}
+
+// Indexes are the index to list the meal plans of a user, the one to find
+// the plans of a recipe and the one to find a plan by the token of its feed.
+func (handler *MealPlansHandler) Indexes() IndexSet {
+	return IndexSet{Collection: handler.collection, Indexes: []mongo.IndexModel{
+		{Keys: bson.D{{Key: "owner", Value: 1}}, Options: options.Index().SetName("owner")},
+		{Keys: bson.D{{Key: "entries.recipeId", Value: 1}}, Options: options.Index().SetName("entries_recipeId")},
+		{
+			Keys: bson.D{{Key: "feedToken", Value: 1}},
+			Options: options.Index().SetName("feedToken_unique").SetUnique(true).
+				SetPartialFilterExpression(bson.M{"feedToken": bson.M{"$exists": true}}),
+		},
+	}}
+}
+
+// RemovePlannedRecipes removes the entries of recipes purged for good from
+// all meal plans, so their shopping lists and calendars don't refer to
+// recipes that can't be restored. It returns the number of plans changed.
+func RemovePlannedRecipes(ctx context.Context, plans *mongo.Collection, ids []interface{}) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	result, err := plans.UpdateMany(ctx, bson.M{"entries.recipeId": bson.M{"$in": ids}}, bson.M{
+		"$pull": bson.M{"entries": bson.M{"recipeId": bson.M{"$in": ids}}},
+		"$set":  bson.M{"updatedAt": time.Now()},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(result.ModifiedCount), nil
+}
+
+// CleanupOrphanMealPlanEntries removes the entries of recipes that do not
+// exist anymore, like the ones purged from the trash by MongoDB, from all
+// meal plans.
+func CleanupOrphanMealPlanEntries(ctx context.Context, plans *mongo.Collection, recipes *mongo.Collection) (int, error) {
+	ids, err := plans.Distinct(ctx, "entries.recipeId", bson.M{})
+	if err != nil {
+		return 0, err
+	}
+	orphans, err := purgedRecipes(ctx, recipes, ids)
+	if err != nil {
+		return 0, err
+	}
+	return RemovePlannedRecipes(ctx, plans, orphans)
+}
+
+// NewMealPlansHandler creates the handler of the meal plans of a tenant,
+// which is named in the paths of their feeds.
+func NewMealPlansHandler(ctx context.Context, collection *mongo.Collection, recipes *mongo.Collection, tenant string) *MealPlansHandler {
+	return &MealPlansHandler{
+		collection: collection,
+		recipes:    recipes,
+		ctx:        ctx,
+		tenant:     tenant,
+	}
+}
+
+// NewMealPlanHandler creates a new meal plan.
+func (handler *MealPlansHandler) NewMealPlanHandler(ctx *gin.Context) {
+	var input MealPlanInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	plan, ok := handler.validate(ctx, input)
+	if !ok {
+		return
+	}
+	plan.ID = primitive.NewObjectID()
+	plan.Owner = ctx.GetString("username")
+	plan.CreatedAt = time.Now()
+	plan.UpdatedAt = plan.CreatedAt
+	if _, err := handler.collection.InsertOne(handler.ctx, plan); err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error while inserting a new meal plan"})
+		return
+	}
+	ctx.JSON(http.StatusCreated, plan)
+}
+
+// ListMealPlansHandler returns the meal plans of the current user.
+func (handler *MealPlansHandler) ListMealPlansHandler(ctx *gin.Context) {
+	cur, err := handler.collection.Find(handler.ctx, bson.M{"owner": ctx.GetString("username")})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer cur.Close(handler.ctx)
+	plans := make([]models.MealPlan, 0)
+	if err := cur.All(handler.ctx, &plans); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range plans {
+		if err := handler.flagMissingRecipes(&plans[i]); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	ctx.JSON(http.StatusOK, plans)
+}
+
+// GetMealPlanHandler gets an existing meal plan.
+func (handler *MealPlansHandler) GetMealPlanHandler(ctx *gin.Context) {
+	plan, ok := handler.find(ctx)
+	if !ok {
+		return
+	}
+	if err := handler.flagMissingRecipes(plan); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, plan)
+}
+
+// UpdateMealPlanHandler updates an existing meal plan.
+func (handler *MealPlansHandler) UpdateMealPlanHandler(ctx *gin.Context) {
+	existing, ok := handler.find(ctx)
+	if !ok {
+		return
+	}
+	var input MealPlanInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	plan, ok := handler.validate(ctx, input)
+	if !ok {
+		return
+	}
+	plan.ID = existing.ID
+	plan.Owner = existing.Owner
+	plan.CreatedAt = existing.CreatedAt
+	plan.UpdatedAt = time.Now()
+	_, err := handler.collection.UpdateOne(handler.ctx, bson.M{"_id": plan.ID}, bson.D{{Key: "$set", Value: bson.D{
+		{Key: "name", Value: plan.Name},
+		{Key: "entries", Value: plan.Entries},
+		{Key: "updatedAt", Value: plan.UpdatedAt},
+	}}})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, plan)
+}
+
+// DeleteMealPlanHandler deletes an existing meal plan.
+func (handler *MealPlansHandler) DeleteMealPlanHandler(ctx *gin.Context) {
+	plan, ok := handler.find(ctx)
+	if !ok {
+		return
+	}
+	if _, err := handler.collection.DeleteOne(handler.ctx, bson.M{"_id": plan.ID}); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "Meal plan has been deleted"})
+}
+
+// ShoppingListHandler sums up the ingredients of all planned recipes, scaled
+// to the planned servings.
+func (handler *MealPlansHandler) ShoppingListHandler(ctx *gin.Context) {
+	plan, ok := handler.find(ctx)
+	if !ok {
+		return
+	}
+	recipes, err := handler.loadRecipes(plan.RecipeIDs())
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	items := make(map[string]*models.ShoppingListItem)
+	keys := make([]string, 0)
+	for _, entry := range plan.Entries {
+		recipe, found := recipes[entry.RecipeID]
+		if !found {
+			plan.MissingRecipes = append(plan.MissingRecipes, entry.RecipeID)
+			continue
+		}
+		factor := 1.0
+		if recipe.Servings > 0 {
+			factor = float64(entry.Servings) / float64(recipe.Servings)
+		}
+		for _, line := range recipe.Ingredients {
+			ingredient := models.ParseIngredient(line)
+			if ingredient.Name == "" {
+				continue
+			}
+			key := strings.ToLower(ingredient.Name) + "|" + ingredient.Unit
+			item, exists := items[key]
+			if !exists {
+				item = &models.ShoppingListItem{Unit: ingredient.Unit, Name: ingredient.Name, Recipes: make([]string, 0)}
+				items[key] = item
+				keys = append(keys, key)
+			}
+			item.Quantity += ingredient.Quantity * factor
+			if !containsString(item.Recipes, recipe.Name) {
+				item.Recipes = append(item.Recipes, recipe.Name)
+			}
+		}
+	}
+	sort.Strings(keys)
+	list := make([]models.ShoppingListItem, 0, len(keys))
+	for _, key := range keys {
+		list = append(list, *items[key])
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"items":          list,
+		"missingRecipes": plan.MissingRecipes,
+	})
+}
+
+// ExportMealPlanHandler exports a meal plan as iCalendar file.
+func (handler *MealPlansHandler) ExportMealPlanHandler(ctx *gin.Context) {
+	plan, ok := handler.find(ctx)
+	if !ok {
+		return
+	}
+	handler.export(ctx, plan)
+}
+
+// NewFeedHandler creates the calendar feed of a meal plan. Calendar apps
+// can't send a bearer token, so the feed is served to anyone knowing its
+// secret token. A new feed replaces the former one, whose token stops
+// working.
+func (handler *MealPlansHandler) NewFeedHandler(ctx *gin.Context) {
+	plan, ok := handler.find(ctx)
+	if !ok {
+		return
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	token := base64.RawURLEncoding.EncodeToString(key)
+	_, err := handler.collection.UpdateOne(handler.ctx, bson.M{"_id": plan.ID}, bson.M{"$set": bson.M{"feedToken": feedTokenHash(token)}})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	path := "/feeds/mealplans/" + token
+	if handler.tenant != "" {
+		path += "?" + url.Values{"tenant": {handler.tenant}}.Encode()
+	}
+	ctx.JSON(http.StatusCreated, feedResponse{Token: token, Path: path})
+}
+
+// DeleteFeedHandler revokes the calendar feed of a meal plan.
+func (handler *MealPlansHandler) DeleteFeedHandler(ctx *gin.Context) {
+	plan, ok := handler.find(ctx)
+	if !ok {
+		return
+	}
+	if plan.FeedToken == "" {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Meal plan has no feed"})
+		return
+	}
+	_, err := handler.collection.UpdateOne(handler.ctx, bson.M{"_id": plan.ID}, bson.M{"$unset": bson.M{"feedToken": ""}})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "Feed has been revoked"})
+}
+
+// FeedHandler serves the calendar feed of a meal plan by its token, without
+// bearer token.
+func (handler *MealPlansHandler) FeedHandler(ctx *gin.Context) {
+	var plan models.MealPlan
+	err := handler.collection.FindOne(handler.ctx, bson.M{"feedToken": feedTokenHash(ctx.Param("token"))}).Decode(&plan)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Feed not found"})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	handler.export(ctx, &plan)
+}
+
+// feedTokenHash is what is stored of a feed token, so the database doesn't
+// give away the feeds.
+func feedTokenHash(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// export writes a meal plan as iCalendar file.
+func (handler *MealPlansHandler) export(ctx *gin.Context, plan *models.MealPlan) {
+	recipes, err := handler.loadRecipes(plan.RecipeIDs())
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	calendar := ical.Calendar{Name: plan.Name}
+	for i, entry := range plan.Entries {
+		day, _ := time.Parse(models.MealPlanDateLayout, entry.Date)
+		start := day.Add(time.Duration(mealTimes[entry.Slot]) * time.Hour)
+		summary := "Deleted recipe"
+		description := ""
+		if recipe, found := recipes[entry.RecipeID]; found {
+			summary = recipe.Name
+			description = fmt.Sprintf("%d servings\n\n%s\n\n%s", entry.Servings,
+				strings.Join(recipe.Ingredients, "\n"), strings.Join(recipe.Instructions, "\n"))
+		}
+		calendar.Events = append(calendar.Events, ical.Event{
+			UID:         fmt.Sprintf("%s-%d@go-sandbox", plan.ID.Hex(), i),
+			Summary:     fmt.Sprintf("%s: %s", strings.ToUpper(string(entry.Slot[:1]))+string(entry.Slot[1:]), summary),
+			Description: description,
+			Start:       start,
+			End:         start.Add(time.Hour),
+		})
+	}
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="mealplan-%s.ics"`, plan.ID.Hex()))
+	ctx.Data(http.StatusOK, "text/calendar; charset=utf-8", calendar.Encode())
+}
+
+// find loads the meal plan addressed by the id parameter. Plans of other
+// users are reported as not found.
+func (handler *MealPlansHandler) find(ctx *gin.Context) (*models.MealPlan, bool) {
+	objectId, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Invalid meal plan ID"})
+		return nil, false
+	}
+	var plan models.MealPlan
+	err = handler.collection.FindOne(handler.ctx, bson.M{
+		"_id":   objectId,
+		"owner": ctx.GetString("username"),
+	}).Decode(&plan)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Meal plan not found"})
+		return nil, false
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return &plan, true
+}
+
+// validate checks the entries of a meal plan, resolves the IDs of the planned
+// recipes and refuses plans referencing recipes that do not exist (anymore).
+func (handler *MealPlansHandler) validate(ctx *gin.Context, input MealPlanInput) (models.MealPlan, bool) {
+	plan := models.MealPlan{Name: input.Name, Entries: make([]models.MealPlanEntry, 0, len(input.Entries))}
+	resolved := make(map[string]primitive.ObjectID)
+	unknown := make([]string, 0)
+	for _, entry := range input.Entries {
+		if _, err := time.Parse(models.MealPlanDateLayout, entry.Date); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid date %q, expected YYYY-MM-DD", entry.Date)})
+			return plan, false
+		}
+		if !entry.Slot.Valid() {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid meal slot %q", entry.Slot)})
+			return plan, false
+		}
+		if entry.Servings < 1 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Servings must be at least 1"})
+			return plan, false
+		}
+		recipeId, found := resolved[entry.RecipeID]
+		if !found {
+			var err error
+			recipeId, err = ResolveRecipeID(handler.ctx, handler.recipes, entry.RecipeID)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				if !containsString(unknown, entry.RecipeID) {
+					unknown = append(unknown, entry.RecipeID)
+				}
+				continue
+			}
+			if err != nil {
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return plan, false
+			}
+			resolved[entry.RecipeID] = recipeId
+		}
+		plan.Entries = append(plan.Entries, models.MealPlanEntry{
+			Date:     entry.Date,
+			Slot:     entry.Slot,
+			RecipeID: recipeId,
+			Servings: entry.Servings,
+		})
+	}
+	if err := handler.flagMissingRecipes(&plan); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return plan, false
+	}
+	for _, id := range plan.MissingRecipes {
+		unknown = append(unknown, id.Hex())
+	}
+	if len(unknown) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":          "Meal plan references recipes that do not exist",
+			"missingRecipes": unknown,
+		})
+		return plan, false
+	}
+	return plan, true
+}
+
+// flagMissingRecipes sets the ids of planned recipes that have been deleted
+// or moved to the trash.
+func (handler *MealPlansHandler) flagMissingRecipes(plan *models.MealPlan) error {
+	recipes, err := handler.loadRecipes(plan.RecipeIDs())
+	if err != nil {
+		return err
+	}
+	plan.MissingRecipes = nil
+	for _, id := range plan.RecipeIDs() {
+		if _, found := recipes[id]; !found {
+			plan.MissingRecipes = append(plan.MissingRecipes, id)
+		}
+	}
+	return nil
+}
+
+// loadRecipes fetches the planned recipes in one query, leaving out recipes
+// in the trash.
+func (handler *MealPlansHandler) loadRecipes(ids []primitive.ObjectID) (map[primitive.ObjectID]models.Recipe, error) {
+	return findRecipes(handler.ctx, handler.recipes, ids)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
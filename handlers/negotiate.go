@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"github.com/aheadxnet/go-sandbox/cooklang"
+	"github.com/aheadxnet/go-sandbox/export"
+	"github.com/aheadxnet/go-sandbox/formats"
+	"github.com/aheadxnet/go-sandbox/models"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"net/http"
+)
+
+// recipeFormats are the media types recipe endpoints respond with, JSON being the default.
+var recipeFormats = []string{
+	gin.MIMEJSON, formats.MIMEYAML, formats.MIMEXML, formats.MIMEMsgPack, formats.MIMECBOR,
+	export.MIMEJSONLD, export.MIMEMarkdown, export.MIMEHTML,
+}
+
+// singleRecipeFormats additionally holds the formats with one recipe per document.
+var singleRecipeFormats = append(recipeFormats, cooklang.MIMEType)
+
+// recipeBodyFormats are the media types of recipes sent by clients.
+var recipeBodyFormats = []string{gin.MIMEJSON, formats.MIMEYAML, formats.MIMEXML, formats.MIMEMsgPack, formats.MIMECBOR, cooklang.MIMEType}
+
+// renderRecipe writes a single recipe in the format requested by the Accept header.
+// The recipe is localized to the language preferred by the client.
+func renderRecipe(ctx *gin.Context, status int, recipe models.Recipe) {
+	ctx.Header("Vary", "Accept, Accept-Language")
+	recipe = recipe.Localize(preferredLanguages(ctx))
+	if recipe.TranslatedTo != "" {
+		ctx.Header("Content-Language", recipe.TranslatedTo)
+	} else if recipe.Language != "" {
+		ctx.Header("Content-Language", recipe.Language)
+	}
+	switch format := ctx.NegotiateFormat(singleRecipeFormats...); format {
+	case gin.MIMEJSON:
+		ctx.JSON(status, recipe)
+	case formats.MIMEYAML, formats.MIMEXML, formats.MIMEMsgPack, formats.MIMECBOR:
+		writeFormat(ctx, status, format, recipe)
+	case export.MIMEHTML:
+		ctx.HTML(status, export.HTMLTemplate, export.HTMLPage{Title: recipe.Name, Recipes: []models.Recipe{recipe}})
+	case export.MIMEJSONLD:
+		writeExport(ctx, status, export.MIMEJSONLD, func(buf *bytes.Buffer) error { return export.JSONLD(buf, recipe) })
+	case export.MIMEMarkdown:
+		writeExport(ctx, status, export.MIMEMarkdown, func(buf *bytes.Buffer) error { return export.Markdown(buf, recipe) })
+	case cooklang.MIMEType:
+		writeExport(ctx, status, cooklang.MIMEType, func(buf *bytes.Buffer) error { return export.Cooklang(buf, recipe) })
+	default:
+		ctx.JSON(http.StatusNotAcceptable, gin.H{"error": "Not acceptable", "offered": singleRecipeFormats})
+	}
+}
+
+// renderRecipes writes a list of recipes in the format requested by the Accept header.
+// Each recipe is localized to the language preferred by the client.
+func renderRecipes(ctx *gin.Context, status int, recipes []models.Recipe) {
+	ctx.Header("Vary", "Accept, Accept-Language")
+	preferences := preferredLanguages(ctx)
+	localized := make([]models.Recipe, len(recipes))
+	for i, recipe := range recipes {
+		localized[i] = recipe.Localize(preferences)
+	}
+	recipes = localized
+	switch format := ctx.NegotiateFormat(recipeFormats...); format {
+	case gin.MIMEJSON:
+		ctx.JSON(status, recipes)
+	case formats.MIMEYAML, formats.MIMEXML, formats.MIMEMsgPack, formats.MIMECBOR:
+		writeFormat(ctx, status, format, recipes)
+	case export.MIMEHTML:
+		ctx.HTML(status, export.HTMLTemplate, export.HTMLPage{Title: "Recipes", Recipes: recipes})
+	case export.MIMEJSONLD:
+		writeExport(ctx, status, export.MIMEJSONLD, func(buf *bytes.Buffer) error { return export.JSONLDList(buf, recipes) })
+	case export.MIMEMarkdown:
+		writeExport(ctx, status, export.MIMEMarkdown, func(buf *bytes.Buffer) error { return export.MarkdownList(buf, recipes) })
+	default:
+		ctx.JSON(http.StatusNotAcceptable, gin.H{"error": "Not acceptable", "offered": recipeFormats})
+	}
+}
+
+// renderData writes the value in the format requested by the Accept header,
+// JSON if none of the formats is accepted, as the responses of changes
+// shouldn't fail after the change has been made.
+func renderData(ctx *gin.Context, status int, value interface{}) {
+	ctx.Header("Vary", "Accept")
+	switch format := ctx.NegotiateFormat(formats.MediaTypes...); format {
+	case formats.MIMEYAML, formats.MIMEXML, formats.MIMEMsgPack, formats.MIMECBOR:
+		writeFormat(ctx, status, format, value)
+	default:
+		ctx.JSON(status, value)
+	}
+}
+
+// bindRecipe reads a recipe from the request body, as Cooklang document or
+// in one of the formats, JSON if the Content-Type is none of them.
+func bindRecipe(ctx *gin.Context, recipe *models.Recipe) error {
+	if ctx.ContentType() != cooklang.MIMEType {
+		return bindData(ctx, recipe)
+	}
+	data, err := ctx.GetRawData()
+	if err != nil {
+		return err
+	}
+	parsed, err := cooklang.Parse(data)
+	if err != nil {
+		return err
+	}
+	*recipe = parsed.ToModel("")
+	if len(recipe.Name) < 3 {
+		return errors.New("a Cooklang recipe needs a title of at least 3 characters")
+	}
+	return nil
+}
+
+// bindData reads a value from the request body in one of the formats, JSON
+// if the Content-Type is none of them, and validates it like ShouldBindJSON.
+func bindData(ctx *gin.Context, value interface{}) error {
+	format, ok := formats.Supported(ctx.ContentType())
+	if !ok || format == formats.MIMEJSON {
+		return ctx.ShouldBindJSON(value)
+	}
+	data, err := ctx.GetRawData()
+	if err != nil {
+		return err
+	}
+	if err := formats.Unmarshal(format, data, value); err != nil {
+		return err
+	}
+	return binding.Validator.ValidateStruct(value)
+}
+
+// writeFormat writes the value in one of the formats, with the field names of
+// its JSON encoding.
+func writeFormat(ctx *gin.Context, status int, mediaType string, value interface{}) {
+	data, err := formats.Marshal(mediaType, value)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if mediaType == formats.MIMEYAML || mediaType == formats.MIMEXML {
+		mediaType += "; charset=utf-8"
+	}
+	ctx.Data(status, mediaType, data)
+}
+
+func writeExport(ctx *gin.Context, status int, mediaType string, write func(buf *bytes.Buffer) error) {
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.Data(status, mediaType+"; charset=utf-8", buf.Bytes())
+}
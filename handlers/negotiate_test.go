@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"bytes"
+	"github.com/aheadxnet/go-sandbox/formats"
+	"github.com/aheadxnet/go-sandbox/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRecipeFormats sends a recipe in every format and reads it back in the
+// same format.
+func TestRecipeFormats(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/echo", func(ctx *gin.Context) {
+		var recipe models.Recipe
+		if err := bindRecipe(ctx, &recipe); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		renderRecipe(ctx, http.StatusOK, recipe)
+	})
+
+	recipe := models.Recipe{
+		ID:           primitive.NewObjectID(),
+		Name:         "Pizza",
+		Tags:         []string{"italian"},
+		Ingredients:  []string{"flour", "tomatoes"},
+		Instructions: []string{"Bake"},
+		Servings:     2,
+		Rating:       models.Rating{Average: 4.5, Count: 2},
+		Translations: map[string]models.RecipeTranslation{"de": {Name: "Pizza"}},
+		PublishedAt:  time.Date(2021, 12, 24, 18, 0, 0, 0, time.UTC),
+	}
+	for _, mediaType := range formats.MediaTypes {
+		t.Run(mediaType, func(t *testing.T) {
+			body, err := formats.Marshal(mediaType, recipe)
+			if err != nil {
+				t.Fatal(err)
+			}
+			request := httptest.NewRequest(http.MethodPost, "/echo", bytes.NewReader(body))
+			request.Header.Set("Content-Type", mediaType)
+			request.Header.Set("Accept", mediaType)
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", recorder.Code, recorder.Body)
+			}
+			if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, mediaType) {
+				t.Errorf("got Content-Type %q", contentType)
+			}
+			var decoded models.Recipe
+			if err := formats.Unmarshal(mediaType, recorder.Body.Bytes(), &decoded); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(decoded, recipe) {
+				t.Errorf("got %+v, want %+v", decoded, recipe)
+			}
+		})
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader("<recipe><name>Pizza"))
+	request.Header.Set("Content-Type", "text/xml")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for a truncated document: %s", recorder.Code, recorder.Body)
+	}
+}
+
+// TestBindData reads the bodies of other endpoints than the recipe ones in
+// the formats and their aliases.
+func TestBindData(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/batch", func(ctx *gin.Context) {
+		request := models.BatchRequest{Ordered: true}
+		if err := bindData(ctx, &request); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		renderData(ctx, http.StatusOK, request)
+	})
+
+	// Leaving out ordered keeps the default.
+	body := gin.H{"operations": []gin.H{{"op": models.BatchDelete, "id": "pizza"}}}
+	want := models.BatchRequest{Ordered: true, Operations: []models.BatchOperation{{Op: models.BatchDelete, ID: "pizza"}}}
+	for _, contentType := range []string{gin.MIMEJSON, formats.MIMECBOR, "application/x-yaml", "text/xml", "application/x-msgpack"} {
+		format, _ := formats.Supported(contentType)
+		data, err := formats.Marshal(format, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request := httptest.NewRequest(http.MethodPost, "/batch", bytes.NewReader(data))
+		request.Header.Set("Content-Type", contentType)
+		request.Header.Set("Accept", formats.MIMEYAML)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusOK {
+			t.Errorf("%s: got status %d: %s", contentType, recorder.Code, recorder.Body)
+			continue
+		}
+		var decoded models.BatchRequest
+		if err := formats.Unmarshal(formats.MIMEYAML, recorder.Body.Bytes(), &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, want) {
+			t.Errorf("%s: got %+v, want %+v", contentType, decoded, want)
+		}
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader("ordered: false\n"))
+	request.Header.Set("Content-Type", formats.MIMEYAML)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for a batch without operations: %s", recorder.Code, recorder.Body)
+	}
+}
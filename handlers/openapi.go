@@ -0,0 +1,781 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aheadxnet/go-sandbox/export"
+	"github.com/aheadxnet/go-sandbox/formats"
+	"github.com/aheadxnet/go-sandbox/models"
+	"github.com/aheadxnet/go-sandbox/openapi"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io/fs"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// apiInfo describes the API in its OpenAPI document.
+var apiInfo = openapi.Info{
+	Title:       "Recipes API",
+	Description: "This is a sample recipes API. You can find out more about the API at https://github.com/aheadxnet/go-sandbox.",
+	Version:     "1.0.0",
+	Contact: &openapi.Contact{
+		Name:  "Stefan Hedtfeld",
+		URL:   "https://github.com/aheadxnet/go-sandbox",
+		Email: "stefan@aheadx.net",
+	},
+}
+
+// errorResponse is the body of all error responses, some of them with
+// details on the error.
+type errorResponse struct {
+	Error          string               `json:"error" binding:"required"`
+	Warnings       []string             `json:"warnings,omitempty"`
+	Duplicates     []DuplicateRef       `json:"duplicates,omitempty"`
+	MissingRecipes []primitive.ObjectID `json:"missingRecipes,omitempty"`
+	Offered        []string             `json:"offered,omitempty"`
+}
+
+// messageResponse confirms a request that returns no data.
+type messageResponse struct {
+	Message string `json:"message" binding:"required"`
+}
+
+// importResponse is an imported recipe, with the recipes likely being the
+// same when previewing it.
+type importResponse struct {
+	Recipe     models.Recipe  `json:"recipe" binding:"required"`
+	Warnings   []string       `json:"warnings"`
+	Duplicates []DuplicateRef `json:"duplicates,omitempty"`
+}
+
+// tagResponse is a changed tag with the number of recipes retagged.
+type tagResponse struct {
+	Tag      models.Tag `json:"tag" binding:"required"`
+	Retagged int        `json:"retagged" binding:"required"`
+}
+
+// shoppingListResponse sums up the ingredients of a meal plan.
+type shoppingListResponse struct {
+	Items          []models.ShoppingListItem `json:"items" binding:"required"`
+	MissingRecipes []primitive.ObjectID      `json:"missingRecipes"`
+}
+
+// diffResponse holds the changes between two revisions.
+type diffResponse struct {
+	From    int                  `json:"from" binding:"required"`
+	To      int                  `json:"to" binding:"required"`
+	Changes []models.FieldChange `json:"changes"`
+}
+
+// translationsResponse holds the translations of a recipe by language.
+type translationsResponse struct {
+	Language     string                              `json:"language"`
+	Translations map[string]models.RecipeTranslation `json:"translations" binding:"required"`
+}
+
+// OpenAPI describes the routes of a router as OpenAPI document. Routes
+// without operation in apiOperations make it fail, so the document never
+// misses a route.
+func OpenAPI(routes gin.RoutesInfo) (*openapi.Document, error) {
+	doc := newOpenAPIDocument()
+	operations := apiOperations(doc)
+	missing := make([]string, 0)
+	for _, route := range routes {
+		operation, ok := operations[route.Method+" "+route.Path]
+		if !ok {
+			missing = append(missing, route.Method+" "+route.Path)
+			continue
+		}
+		if err := doc.Add(route.Method, route.Path, operation); err != nil {
+			return nil, err
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("routes without operation: %s", strings.Join(missing, ", "))
+	}
+	return doc, nil
+}
+
+func newOpenAPIDocument() *openapi.Document {
+	doc := openapi.New(apiInfo)
+	doc.Define(primitive.ObjectID{}, &openapi.Schema{Type: "string", Pattern: "^[0-9a-f]{24}$"})
+	doc.Components.SecuritySchemes["bearer"] = &openapi.SecurityScheme{Type: "http", Scheme: "bearer", BearerFormat: "JWT"}
+	return doc
+}
+
+// apiOperations describes all operations by the method and path of their
+// route as registered with gin.
+func apiOperations(doc *openapi.Document) map[string]*openapi.Operation {
+	type responses = map[string]*openapi.Response
+	failure := func(description string) *openapi.Response {
+		return doc.JSON(description, errorResponse{})
+	}
+	message := doc.JSON("Successful operation", messageResponse{})
+	bearer := []map[string][]string{{"bearer": {}}}
+	recipeID := openapi.PathParam("id", "ID of the recipe")
+	limit := openapi.QueryParam("limit", openapi.Integer(), "the number of recipes per page, up to 100, all recipes if neither limit nor offset is given")
+	offset := openapi.QueryParam("offset", openapi.Integer(), "the number of recipes to skip")
+	force := openapi.QueryParam("force", openapi.Boolean(), "store the recipe even if it likely exists already")
+	rating := openapi.QueryParam("sort", openapi.String(), "\"rating\" to order by the weighted rating, best first")
+	language := openapi.QueryParam("lang", openapi.String(), "comma separated languages to translate into, best first, the Accept-Language header is used if omitted")
+	otherFormats := formats.MediaTypes[1:]
+	recipe := doc.JSON("Successful operation", models.Recipe{}, singleRecipeFormats[1:]...)
+	recipeList := doc.JSON("Successful operation", []models.Recipe{}, recipeFormats[1:]...)
+	notAcceptable := failure("None of the accepted media types is offered")
+	problem := &openapi.MediaType{Schema: doc.Schema(Problem{})}
+
+	operations := map[string]*openapi.Operation{
+		"GET /openapi.json": {
+			OperationID: "getOpenAPI", Tags: []string{"docs"},
+			Summary:   "Returns this OpenAPI document",
+			Responses: responses{"200": doc.JSON("Successful operation", map[string]interface{}{})},
+		},
+		"GET /docs": {
+			OperationID: "getDocs", Tags: []string{"docs"},
+			Summary:   "Shows this OpenAPI document in Swagger UI",
+			Responses: responses{"200": openapi.Binary("Successful operation", gin.MIMEHTML)},
+		},
+		"GET /docs/:file": {
+			OperationID: "getDocsAsset", Tags: []string{"docs"},
+			Summary:    "Returns a script or style of Swagger UI",
+			Parameters: []*openapi.Parameter{openapi.PathParam("file", "name of the file, like \"swagger-ui.css\"")},
+			Responses: responses{
+				"200": openapi.Binary("Successful operation", "text/css", "text/javascript"),
+				"404": failure("Unknown file"),
+			},
+		},
+		"POST /signin": {
+			OperationID: "signIn", Tags: []string{"auth"},
+			Summary:     "Login with username and password",
+			RequestBody: doc.Body("the username and password", models.User{}, gin.MIMEJSON),
+			Responses: responses{
+				"200": doc.JSON("Successful operation", JWTOutput{}),
+				"400": failure("Invalid input"),
+				"401": failure("Invalid credentials"),
+			},
+		},
+
+		"POST /recipes": {
+			OperationID: "newRecipe", Tags: []string{"recipes"},
+			Summary:     "Create a new recipe",
+			Parameters:  []*openapi.Parameter{force},
+			RequestBody: doc.Body("data for the new recipe", models.Recipe{}, recipeBodyFormats...),
+			Responses: responses{
+				"201": doc.JSON("Successful operation", models.Recipe{}, otherFormats...),
+				"400": failure("Invalid input"),
+				"409": failure("The recipe likely exists already"),
+			},
+		},
+		"POST /recipes/import": {
+			OperationID: "importRecipe", Tags: []string{"recipes"},
+			Summary: "Import a recipe from an HTML page or a JSON-LD document using the schema.org Recipe vocabulary",
+			Parameters: []*openapi.Parameter{
+				openapi.QueryParam("commit", openapi.Boolean(), "store the imported recipe instead of only previewing it"),
+				force,
+			},
+			RequestBody: importBody(),
+			Responses: responses{
+				"200": doc.JSON("Preview of the imported recipe", importResponse{}, otherFormats...),
+				"201": doc.JSON("Recipe has been imported", importResponse{}, otherFormats...),
+				"400": failure("Invalid input"),
+				"409": failure("The recipe likely exists already"),
+				"422": failure("No recipe found in the document, or the recipe to store has no name or no ingredients"),
+			},
+		},
+		"POST /recipes:batch": {
+			OperationID: "batchRecipes", Tags: []string{"recipes"},
+			Summary:     "Creates, updates and deletes many recipes at once",
+			Parameters:  []*openapi.Parameter{openapi.QueryParam("force", openapi.Boolean(), "create recipes even if they likely exist already")},
+			RequestBody: doc.Body("the operations and whether to execute them in order", models.BatchRequest{}, formats.MediaTypes...),
+			Responses: responses{
+				"200": doc.JSON("The results of the operations, one per operation", []models.BatchResult{}, otherFormats...),
+				"400": failure("Invalid input"),
+			},
+		},
+		"GET /recipes": {
+			OperationID: "listRecipes", Tags: []string{"recipes"},
+			Summary: "Returns list of recipes",
+			Parameters: []*openapi.Parameter{
+				rating, language,
+				openapi.QueryParam("ids", openapi.String(), "comma separated IDs of up to 100 recipes to fetch in this order, unknown ones are left out"),
+				limit, offset,
+			},
+			Responses: responses{"200": recipeList, "400": failure("Invalid IDs"), "406": notAcceptable},
+		},
+		"GET /recipes/search": {
+			OperationID: "findRecipe", Tags: []string{"recipes"},
+			Summary: "Search recipes based on tags and full text",
+			Parameters: []*openapi.Parameter{
+				openapi.QueryParam("tag", openapi.String(), "recipe tag"),
+				openapi.QueryParam("q", openapi.String(), "words to find in name, ingredients and instructions in any language"),
+				openapi.QueryParam("lang", openapi.String(), "language of the words, the Accept-Language header is used if omitted"),
+				openapi.QueryParam("sort", openapi.String(), "\"rating\" to order by the weighted rating, best first, by relevance otherwise"),
+				limit, offset,
+			},
+			Responses: responses{"200": recipeList, "400": failure("Neither tag nor q given"), "406": notAcceptable},
+		},
+		"GET /recipes/:id": {
+			OperationID: "getRecipe", Tags: []string{"recipes"},
+			Summary:    "Get an existing recipe",
+			Parameters: []*openapi.Parameter{recipeID, language},
+			Responses:  responses{"200": recipe, "404": failure("Invalid recipe ID"), "406": notAcceptable},
+		},
+		"GET /recipes/by-slug/:slug": {
+			OperationID: "getRecipeBySlug", Tags: []string{"recipes"},
+			Summary:    "Get an existing recipe by its slug, former slugs redirect to the current one",
+			Parameters: []*openapi.Parameter{openapi.PathParam("slug", "slug of the recipe"), language},
+			Responses: responses{
+				"200": recipe,
+				"301": openapi.Empty("The recipe has been renamed, see the Location header"),
+				"404": failure("Unknown slug"),
+				"406": notAcceptable,
+			},
+		},
+		"PUT /recipes/:id": {
+			OperationID: "updateRecipe", Tags: []string{"recipes"},
+			Summary: "Update an existing recipe",
+			Parameters: []*openapi.Parameter{
+				recipeID,
+				openapi.HeaderParam("If-Match", "the ETag of the recipe as it was read, to not overwrite changes made meanwhile"),
+			},
+			RequestBody: doc.Body("new data of the recipe", models.Recipe{}, recipeBodyFormats...),
+			Responses: responses{
+				"200": doc.JSON("Successful operation", models.Recipe{}, otherFormats...),
+				"400": failure("Invalid input"),
+				"404": failure("Invalid recipe ID"),
+				"412": failure("The recipe has been changed since it was read"),
+			},
+		},
+		"DELETE /recipes/:id": {
+			OperationID: "deleteRecipe", Tags: []string{"recipes"},
+			Summary:    "Moves an existing recipe to the trash",
+			Parameters: []*openapi.Parameter{recipeID},
+			Responses:  responses{"200": message, "404": failure("Invalid recipe ID")},
+		},
+		"GET /recipes/:id/similar": {
+			OperationID: "similarRecipes", Tags: []string{"recipes"},
+			Summary: "Returns the recipes most similar to a recipe by tags, ingredients and title, best first",
+			Parameters: []*openapi.Parameter{
+				recipeID, language,
+				openapi.QueryParam("limit", openapi.Integer(), "the maximum number of recipes, 10 by default or SIMILAR_RECIPES_LIMIT if less"),
+			},
+			Responses: responses{"200": recipeList, "404": failure("Invalid recipe ID"), "406": notAcceptable},
+		},
+		"GET /trash": {
+			OperationID: "listTrash", Tags: []string{"recipes"},
+			Summary:    "Returns the recipes in the trash",
+			Parameters: []*openapi.Parameter{limit, offset},
+			Responses:  responses{"200": doc.JSON("Successful operation", []models.Recipe{}, otherFormats...)},
+		},
+		"POST /recipes/:id/restore": {
+			OperationID: "restoreRecipe", Tags: []string{"recipes"},
+			Summary:    "Restores a recipe from the trash",
+			Parameters: []*openapi.Parameter{recipeID},
+			Responses:  responses{"200": doc.JSON("Successful operation", models.Recipe{}, otherFormats...), "404": failure("Recipe is not in the trash")},
+		},
+		"GET /recipes/:id/translations": {
+			OperationID: "listTranslations", Tags: []string{"recipes"},
+			Summary:    "Returns the translations of a recipe by language",
+			Parameters: []*openapi.Parameter{recipeID},
+			Responses:  responses{"200": doc.JSON("Successful operation", translationsResponse{}, otherFormats...), "404": failure("Invalid recipe ID")},
+		},
+		"PUT /recipes/:id/translations/:lang": {
+			OperationID: "putTranslation", Tags: []string{"recipes"},
+			Summary:     "Adds or replaces the translation of a recipe into a language",
+			Parameters:  []*openapi.Parameter{recipeID, openapi.PathParam("lang", "language code of the translation, like \"de\" or \"de-at\"")},
+			RequestBody: doc.Body("the translated name, ingredients and instructions", models.RecipeTranslation{}, formats.MediaTypes...),
+			Responses: responses{
+				"200": doc.JSON("Successful operation", models.Recipe{}, otherFormats...),
+				"400": failure("Invalid input"),
+				"404": failure("Invalid recipe ID"),
+			},
+		},
+		"DELETE /recipes/:id/translations/:lang": {
+			OperationID: "deleteTranslation", Tags: []string{"recipes"},
+			Summary:    "Deletes the translation of a recipe into a language",
+			Parameters: []*openapi.Parameter{recipeID, openapi.PathParam("lang", "language code of the translation")},
+			Responses:  responses{"200": doc.JSON("Successful operation", models.Recipe{}, otherFormats...), "404": failure("Invalid recipe ID or language")},
+		},
+		"GET /recipes/:id/revisions": {
+			OperationID: "listRevisions", Tags: []string{"recipes"},
+			Summary:    "Returns the revisions of a recipe, newest first",
+			Parameters: []*openapi.Parameter{recipeID},
+			Responses:  responses{"200": doc.JSON("Successful operation", []models.Revision{}, otherFormats...), "404": failure("Invalid recipe ID")},
+		},
+		"GET /recipes/:id/revisions/diff": {
+			OperationID: "diffRevisions", Tags: []string{"recipes"},
+			Summary: "Compares two revisions of a recipe field by field",
+			Parameters: []*openapi.Parameter{
+				recipeID,
+				{Name: "from", In: "query", Description: "number of the older revision", Required: true, Schema: openapi.Integer()},
+				openapi.QueryParam("to", openapi.Integer(), "number of the newer revision, the latest one if omitted"),
+			},
+			Responses: responses{
+				"200": doc.JSON("Successful operation", diffResponse{}, otherFormats...),
+				"400": failure("Invalid revision numbers"),
+				"404": failure("Invalid recipe ID or revision"),
+			},
+		},
+		"GET /recipes/:id/revisions/:rev": {
+			OperationID: "getRevision", Tags: []string{"recipes"},
+			Summary:    "Get a revision of a recipe",
+			Parameters: []*openapi.Parameter{recipeID, revisionNumber("number of the revision")},
+			Responses:  responses{"200": doc.JSON("Successful operation", models.Revision{}, otherFormats...), "404": failure("Invalid recipe ID or revision")},
+		},
+		"POST /recipes/:id/revisions/:rev/revert": {
+			OperationID: "revertRevision", Tags: []string{"recipes"},
+			Summary:    "Restores a recipe to the state of a revision, recreating it if it has been deleted",
+			Parameters: []*openapi.Parameter{recipeID, revisionNumber("number of the revision to restore")},
+			Responses:  responses{"200": doc.JSON("Successful operation", models.Recipe{}, otherFormats...), "404": failure("Invalid recipe ID or revision")},
+		},
+
+		"POST /recipes/:id/images": {
+			OperationID: "uploadImage", Tags: []string{"images"},
+			Summary:     "Uploads a photo of a recipe",
+			Parameters:  []*openapi.Parameter{recipeID},
+			RequestBody: openapi.Upload("the JPEG or PNG image", "image"),
+			Responses: responses{
+				"201": doc.JSON("Successful operation", models.Photo{}),
+				"400": failure("Invalid image"),
+				"404": failure("Invalid recipe ID"),
+				"413": failure("Image too large"),
+			},
+		},
+		"GET /recipes/:id/images": {
+			OperationID: "listImages", Tags: []string{"images"},
+			Summary:    "Returns the photos of a recipe",
+			Parameters: []*openapi.Parameter{recipeID},
+			Responses:  responses{"200": doc.JSON("Successful operation", []models.Photo{}), "404": failure("Invalid recipe ID")},
+		},
+		"DELETE /recipes/:id/images/:imageId": {
+			OperationID: "deleteImage", Tags: []string{"images"},
+			Summary:    "Deletes a photo of a recipe",
+			Parameters: []*openapi.Parameter{recipeID, openapi.PathParam("imageId", "ID of the photo")},
+			Responses:  responses{"200": message, "404": failure("Invalid recipe or photo ID")},
+		},
+		"GET /images/:id": {
+			OperationID: "getImage", Tags: []string{"images"},
+			Summary:    "Serves a photo or thumbnail, supporting conditional and range requests",
+			Parameters: []*openapi.Parameter{openapi.PathParam("id", "ID of the photo or thumbnail")},
+			Responses: responses{
+				"200": openapi.Binary("Successful operation", "image/jpeg", "image/png"),
+				"206": openapi.Binary("Partial content", "image/jpeg", "image/png"),
+				"304": openapi.Empty("Not modified"),
+				"404": failure("Invalid image ID"),
+			},
+		},
+
+		"GET /recipes/:id/reviews": {
+			OperationID: "listRecipeReviews", Tags: []string{"reviews"},
+			Summary:    "Returns the reviews of a recipe, newest first",
+			Parameters: []*openapi.Parameter{recipeID},
+			Responses:  responses{"200": doc.JSON("Successful operation", []models.Review{}), "404": failure("Invalid recipe ID")},
+		},
+		"POST /recipes/:id/reviews": {
+			OperationID: "newReview", Tags: []string{"reviews"}, Security: bearer,
+			Summary:     "Rates and reviews a recipe, once per user",
+			Parameters:  []*openapi.Parameter{recipeID},
+			RequestBody: doc.Body("rating and text of the review", models.Review{}, gin.MIMEJSON),
+			Responses: responses{
+				"201": doc.JSON("Successful operation", models.Review{}),
+				"400": failure("Invalid input"),
+				"404": failure("Invalid recipe ID"),
+				"409": failure("The user already reviewed this recipe"),
+			},
+		},
+		"GET /reviews": {
+			OperationID: "listOwnReviews", Tags: []string{"reviews"}, Security: bearer,
+			Summary:   "Returns the reviews of the current user, newest first",
+			Responses: responses{"200": doc.JSON("Successful operation", []models.Review{})},
+		},
+		"PUT /reviews/:id": {
+			OperationID: "updateReview", Tags: []string{"reviews"}, Security: bearer,
+			Summary:     "Updates a review of the current user",
+			Parameters:  []*openapi.Parameter{openapi.PathParam("id", "ID of the review")},
+			RequestBody: doc.Body("new rating and text of the review", models.Review{}, gin.MIMEJSON),
+			Responses: responses{
+				"200": doc.JSON("Successful operation", models.Review{}),
+				"400": failure("Invalid input"),
+				"404": failure("Invalid review ID"),
+			},
+		},
+		"DELETE /reviews/:id": {
+			OperationID: "deleteReview", Tags: []string{"reviews"}, Security: bearer,
+			Summary:    "Deletes a review of the current user",
+			Parameters: []*openapi.Parameter{openapi.PathParam("id", "ID of the review")},
+			Responses:  responses{"200": message, "404": failure("Invalid review ID")},
+		},
+
+		"POST /mealplans": {
+			OperationID: "newMealPlan", Tags: []string{"mealplans"}, Security: bearer,
+			Summary:     "Create a new meal plan",
+			RequestBody: doc.Body("data for the new meal plan", MealPlanInput{}, gin.MIMEJSON),
+			Responses: responses{
+				"201": doc.JSON("Successful operation", models.MealPlan{}),
+				"400": failure("Invalid input"),
+				"422": failure("Planned recipes do not exist"),
+			},
+		},
+		"GET /mealplans": {
+			OperationID: "listMealPlans", Tags: []string{"mealplans"}, Security: bearer,
+			Summary:   "Returns the meal plans of the current user",
+			Responses: responses{"200": doc.JSON("Successful operation", []models.MealPlan{})},
+		},
+		"GET /mealplans/:id": {
+			OperationID: "getMealPlan", Tags: []string{"mealplans"}, Security: bearer,
+			Summary:    "Get an existing meal plan",
+			Parameters: []*openapi.Parameter{openapi.PathParam("id", "ID of the meal plan")},
+			Responses:  responses{"200": doc.JSON("Successful operation", models.MealPlan{}), "404": failure("Invalid meal plan ID")},
+		},
+		"PUT /mealplans/:id": {
+			OperationID: "updateMealPlan", Tags: []string{"mealplans"}, Security: bearer,
+			Summary:     "Update an existing meal plan",
+			Parameters:  []*openapi.Parameter{openapi.PathParam("id", "ID of the meal plan")},
+			RequestBody: doc.Body("new data of the meal plan", MealPlanInput{}, gin.MIMEJSON),
+			Responses: responses{
+				"200": doc.JSON("Successful operation", models.MealPlan{}),
+				"400": failure("Invalid input"),
+				"404": failure("Invalid meal plan ID"),
+				"422": failure("Planned recipes do not exist"),
+			},
+		},
+		"DELETE /mealplans/:id": {
+			OperationID: "deleteMealPlan", Tags: []string{"mealplans"}, Security: bearer,
+			Summary:    "Deletes an existing meal plan",
+			Parameters: []*openapi.Parameter{openapi.PathParam("id", "ID of the meal plan")},
+			Responses:  responses{"200": message, "404": failure("Invalid meal plan ID")},
+		},
+		"GET /mealplans/:id/shopping-list": {
+			OperationID: "shoppingList", Tags: []string{"mealplans"}, Security: bearer,
+			Summary:    "Sums up the ingredients of all planned recipes, scaled to the planned servings",
+			Parameters: []*openapi.Parameter{openapi.PathParam("id", "ID of the meal plan")},
+			Responses:  responses{"200": doc.JSON("Successful operation", shoppingListResponse{}), "404": failure("Invalid meal plan ID")},
+		},
+		"GET /mealplans/:id/ical": {
+			OperationID: "exportMealPlan", Tags: []string{"mealplans"}, Security: bearer,
+			Summary:    "Exports a meal plan as iCalendar file",
+			Parameters: []*openapi.Parameter{openapi.PathParam("id", "ID of the meal plan")},
+			Responses:  responses{"200": openapi.Binary("Successful operation", "text/calendar"), "404": failure("Invalid meal plan ID")},
+		},
+		"POST /mealplans/:id/feed": {
+			OperationID: "newMealPlanFeed", Tags: []string{"mealplans"}, Security: bearer,
+			Summary:    "Creates a calendar feed of a meal plan to subscribe to without bearer token, revoking the former one",
+			Parameters: []*openapi.Parameter{openapi.PathParam("id", "ID of the meal plan")},
+			Responses:  responses{"201": doc.JSON("The token and path of the feed, only shown once", feedResponse{}), "404": failure("Invalid meal plan ID")},
+		},
+		"DELETE /mealplans/:id/feed": {
+			OperationID: "deleteMealPlanFeed", Tags: []string{"mealplans"}, Security: bearer,
+			Summary:    "Revokes the calendar feed of a meal plan",
+			Parameters: []*openapi.Parameter{openapi.PathParam("id", "ID of the meal plan")},
+			Responses:  responses{"200": message, "404": failure("Invalid meal plan ID or no feed")},
+		},
+		"GET /feeds/mealplans/:token": {
+			OperationID: "getMealPlanFeed", Tags: []string{"mealplans"},
+			Summary:    "Serves the calendar feed of a meal plan to anyone knowing its token",
+			Parameters: []*openapi.Parameter{openapi.PathParam("token", "the token of the feed")},
+			Responses:  responses{"200": openapi.Binary("Successful operation", "text/calendar"), "404": failure("Unknown or revoked feed")},
+		},
+
+		"POST /cookbooks": {
+			OperationID: "newCookbook", Tags: []string{"cookbooks"}, Security: bearer,
+			Summary:     "Create a new cookbook",
+			RequestBody: doc.Body("data for the new cookbook", models.Cookbook{}, gin.MIMEJSON),
+			Responses: responses{
+				"201": doc.JSON("Successful operation", models.Cookbook{}),
+				"400": failure("Invalid input"),
+				"422": failure("Recipes of the cookbook do not exist"),
+			},
+		},
+		"GET /cookbooks": {
+			OperationID: "listCookbooks", Tags: []string{"cookbooks"}, Security: bearer,
+			Summary:   "Returns the cookbooks of the current user and the ones shared with them",
+			Responses: responses{"200": doc.JSON("Successful operation", []models.Cookbook{})},
+		},
+		"GET /cookbooks/public": {
+			OperationID: "listPublicCookbooks", Tags: []string{"cookbooks"},
+			Summary:   "Returns the public cookbooks of all users",
+			Responses: responses{"200": doc.JSON("Successful operation", []models.Cookbook{})},
+		},
+		"GET /cookbooks/:id": {
+			OperationID: "getCookbook", Tags: []string{"cookbooks"},
+			Summary:    "Get a cookbook with its recipes in order",
+			Parameters: []*openapi.Parameter{openapi.PathParam("id", "ID of the cookbook")},
+			Responses:  responses{"200": doc.JSON("Successful operation", models.Cookbook{}), "404": failure("Invalid cookbook ID")},
+		},
+		"PUT /cookbooks/:id": {
+			OperationID: "updateCookbook", Tags: []string{"cookbooks"}, Security: bearer,
+			Summary:     "Update an existing cookbook of the current user",
+			Parameters:  []*openapi.Parameter{openapi.PathParam("id", "ID of the cookbook")},
+			RequestBody: doc.Body("new data of the cookbook", models.Cookbook{}, gin.MIMEJSON),
+			Responses: responses{
+				"200": doc.JSON("Successful operation", models.Cookbook{}),
+				"400": failure("Invalid input"),
+				"404": failure("Invalid cookbook ID"),
+				"422": failure("Recipes of the cookbook do not exist"),
+			},
+		},
+		"DELETE /cookbooks/:id": {
+			OperationID: "deleteCookbook", Tags: []string{"cookbooks"}, Security: bearer,
+			Summary:    "Deletes an existing cookbook of the current user, the recipes are kept",
+			Parameters: []*openapi.Parameter{openapi.PathParam("id", "ID of the cookbook")},
+			Responses:  responses{"200": message, "404": failure("Invalid cookbook ID")},
+		},
+		"POST /cookbooks/:id/recipes": {
+			OperationID: "addCookbookRecipe", Tags: []string{"cookbooks"}, Security: bearer,
+			Summary:     "Adds a recipe to a cookbook of the current user",
+			Parameters:  []*openapi.Parameter{openapi.PathParam("id", "ID of the cookbook")},
+			RequestBody: doc.Body("the recipe to add and its position", CookbookEntry{}, gin.MIMEJSON),
+			Responses: responses{
+				"200": doc.JSON("Successful operation", models.Cookbook{}),
+				"400": failure("Invalid input"),
+				"404": failure("Invalid cookbook ID"),
+				"409": failure("The recipe is already in the cookbook"),
+				"422": failure("The recipe does not exist"),
+			},
+		},
+		"DELETE /cookbooks/:id/recipes/:recipeId": {
+			OperationID: "removeCookbookRecipe", Tags: []string{"cookbooks"}, Security: bearer,
+			Summary:    "Removes a recipe from a cookbook of the current user",
+			Parameters: []*openapi.Parameter{openapi.PathParam("id", "ID of the cookbook"), openapi.PathParam("recipeId", "ID of the recipe")},
+			Responses:  responses{"200": doc.JSON("Successful operation", models.Cookbook{}), "404": failure("Invalid cookbook ID")},
+		},
+		"GET /favorites": {
+			OperationID: "listFavorites", Tags: []string{"favorites"}, Security: bearer,
+			Summary:   "Returns the recipes bookmarked by the current user, latest first",
+			Responses: responses{"200": doc.JSON("Successful operation", []models.Recipe{})},
+		},
+		"PUT /favorites/:id": {
+			OperationID: "addFavorite", Tags: []string{"favorites"}, Security: bearer,
+			Summary:    "Bookmarks a recipe for the current user",
+			Parameters: []*openapi.Parameter{recipeID},
+			Responses:  responses{"200": message, "404": failure("Invalid recipe ID")},
+		},
+		"DELETE /favorites/:id": {
+			OperationID: "deleteFavorite", Tags: []string{"favorites"}, Security: bearer,
+			Summary:    "Removes the bookmark of a recipe for the current user",
+			Parameters: []*openapi.Parameter{recipeID},
+			Responses:  responses{"200": message},
+		},
+
+		"GET /tags": {
+			OperationID: "listTags", Tags: []string{"tags"},
+			Summary:    "Returns all tags with the number of recipes using them, most used first",
+			Parameters: []*openapi.Parameter{openapi.QueryParam("lang", openapi.String(), "language code to label the tags in, like \"de\"")},
+			Responses:  responses{"200": doc.JSON("Successful operation", []models.TagUsage{})},
+		},
+		"POST /admin/tags": {
+			OperationID: "newTag", Tags: []string{"admin"}, Security: bearer,
+			Summary:     "Adds a tag to the taxonomy, recipes using one of its aliases or translations are retagged",
+			RequestBody: doc.Body("data for the new tag", models.Tag{}, gin.MIMEJSON),
+			Responses: responses{
+				"201": doc.JSON("Successful operation", tagResponse{}),
+				"400": failure("Invalid input"),
+				"409": failure("A name of the tag belongs to another tag already"),
+			},
+		},
+		"PUT /admin/tags/:name": {
+			OperationID: "updateTag", Tags: []string{"admin"}, Security: bearer,
+			Summary:     "Updates the aliases, translations and parent of a tag, recipes using one of its aliases or translations are retagged",
+			Parameters:  []*openapi.Parameter{openapi.PathParam("name", "canonical name of the tag")},
+			RequestBody: doc.Body("new data of the tag, its name is changed by renaming it", models.Tag{}, gin.MIMEJSON),
+			Responses: responses{
+				"200": doc.JSON("Successful operation", tagResponse{}),
+				"400": failure("Invalid input"),
+				"404": failure("Unknown tag"),
+				"409": failure("A name of the tag belongs to another tag already"),
+			},
+		},
+		"DELETE /admin/tags/:name": {
+			OperationID: "deleteTag", Tags: []string{"admin"}, Security: bearer,
+			Summary:    "Removes a tag from the taxonomy, recipes keep the tag",
+			Parameters: []*openapi.Parameter{openapi.PathParam("name", "canonical name of the tag")},
+			Responses:  responses{"200": message, "404": failure("Unknown tag")},
+		},
+		"POST /admin/tags/:name/rename": {
+			OperationID: "renameTag", Tags: []string{"admin"}, Security: bearer,
+			Summary:     "Renames a tag across all recipes, the old name becomes an alias",
+			Parameters:  []*openapi.Parameter{openapi.PathParam("name", "current name of the tag")},
+			RequestBody: doc.Body("the new name", TagRename{}, gin.MIMEJSON),
+			Responses: responses{
+				"200": doc.JSON("Successful operation", tagResponse{}),
+				"400": failure("Invalid input"),
+				"409": failure("The new name belongs to another tag, merge the tags instead"),
+			},
+		},
+		"POST /admin/tags/:name/merge": {
+			OperationID: "mergeTags", Tags: []string{"admin"}, Security: bearer,
+			Summary:     "Merges tags into a tag across all recipes, the merged tags become aliases",
+			Parameters:  []*openapi.Parameter{openapi.PathParam("name", "name of the tag to merge into, added to the taxonomy if unknown")},
+			RequestBody: doc.Body("the tags to merge", TagMerge{}, gin.MIMEJSON),
+			Responses:   responses{"200": doc.JSON("Successful operation", tagResponse{}), "400": failure("Invalid input")},
+		},
+
+		"DELETE /admin/recipes/:id": {
+			OperationID: "purgeRecipe", Tags: []string{"admin"}, Security: bearer,
+			Summary:    "Deletes a recipe with its images and reviews for good, removing it from all cookbooks and favorites, whether it is in the trash or not",
+			Parameters: []*openapi.Parameter{recipeID},
+			Responses:  responses{"200": message, "404": failure("Invalid recipe ID")},
+		},
+		"GET /admin/duplicates": {
+			OperationID: "listDuplicates", Tags: []string{"admin"}, Security: bearer,
+			Summary:   "Returns clusters of recipes that are likely duplicates of each other",
+			Responses: responses{"200": doc.JSON("Successful operation", [][]DuplicateRef{})},
+		},
+		"GET /admin/reviews": {
+			OperationID: "listReviews", Tags: []string{"admin"}, Security: bearer,
+			Summary:    "Returns all reviews for moderation, newest first",
+			Parameters: []*openapi.Parameter{openapi.QueryParam("hidden", openapi.Boolean(), "only return hidden (true) or visible (false) reviews")},
+			Responses:  responses{"200": doc.JSON("Successful operation", []models.Review{})},
+		},
+		"POST /admin/reviews/:id/hide": {
+			OperationID: "hideReview", Tags: []string{"admin"}, Security: bearer,
+			Summary:    "Hides a review, it is no longer shown nor counted",
+			Parameters: []*openapi.Parameter{openapi.PathParam("id", "ID of the review")},
+			Responses:  responses{"200": doc.JSON("Successful operation", models.Review{}), "404": failure("Invalid review ID")},
+		},
+		"POST /admin/reviews/:id/show": {
+			OperationID: "showReview", Tags: []string{"admin"}, Security: bearer,
+			Summary:    "Shows a hidden review again",
+			Parameters: []*openapi.Parameter{openapi.PathParam("id", "ID of the review")},
+			Responses:  responses{"200": doc.JSON("Successful operation", models.Review{}), "404": failure("Invalid review ID")},
+		},
+		"DELETE /admin/reviews/:id": {
+			OperationID: "deleteAnyReview", Tags: []string{"admin"}, Security: bearer,
+			Summary:    "Deletes any review",
+			Parameters: []*openapi.Parameter{openapi.PathParam("id", "ID of the review")},
+			Responses:  responses{"200": message, "404": failure("Invalid review ID")},
+		},
+		"GET /admin/tenants": {
+			OperationID: "listTenants", Tags: []string{"tenants"}, Security: bearer,
+			Summary:   "Returns the registered tenants, admins of the default tenant only",
+			Responses: responses{"200": doc.JSON("Successful operation", []models.Tenant{})},
+		},
+		"POST /admin/tenants": {
+			OperationID: "newTenant", Tags: []string{"tenants"}, Security: bearer,
+			Summary:     "Provisions a new tenant with an empty database, admins of the default tenant only",
+			RequestBody: doc.Body("the name of the tenant and optionally its first admin user", TenantRequest{}, gin.MIMEJSON),
+			Responses: responses{
+				"201": doc.JSON("Successful operation", models.Tenant{}),
+				"400": failure("Invalid input"),
+				"409": failure("The tenant exists already"),
+			},
+		},
+		"DELETE /admin/tenants/:name": {
+			OperationID: "deleteTenant", Tags: []string{"tenants"}, Security: bearer,
+			Summary:    "Deprovisions a tenant, deleting its database and cache, admins of the default tenant only",
+			Parameters: []*openapi.Parameter{openapi.PathParam("name", "name of the tenant")},
+			Responses:  responses{"200": message, "404": failure("Unknown tenant")},
+		},
+	}
+
+	// Every request may fail for reasons of its own, authorized ones for
+	// missing tokens and admin ones for missing roles.
+	for route, operation := range operations {
+		if operation.Security != nil {
+			operation.Responses["401"] = failure("Missing or invalid bearer token")
+		}
+		if strings.Contains(route, " /admin/") {
+			operation.Responses["403"] = failure("Admin role required")
+		}
+		operation.Responses["default"] = failure("Unexpected error")
+		operation.Responses["default"].Content[MIMEProblem] = problem
+
+		// With VALIDATE_REQUESTS, requests not matching this document are
+		// answered with a problem.
+		if len(operation.Parameters) == 0 && operation.RequestBody == nil {
+			continue
+		}
+		if _, ok := operation.Responses["400"]; !ok {
+			operation.Responses["400"] = failure("Invalid input")
+		}
+		operation.Responses["400"].Content[MIMEProblem] = problem
+		if operation.RequestBody != nil {
+			operation.Responses["415"] = &openapi.Response{
+				Description: "Unsupported media type of the body",
+				Content:     map[string]*openapi.MediaType{MIMEProblem: problem},
+			}
+		}
+	}
+	return operations
+}
+
+func revisionNumber(description string) *openapi.Parameter {
+	return &openapi.Parameter{Name: "rev", In: "path", Description: description, Required: true, Schema: openapi.Integer()}
+}
+
+// importBody is the document to import, uploaded as file or sent as body.
+func importBody() *openapi.RequestBody {
+	body := openapi.Upload("the HTML page or JSON-LD document to import", "file")
+	body.Content[gin.MIMEHTML] = &openapi.MediaType{Schema: openapi.String()}
+	body.Content[export.MIMEJSONLD] = &openapi.MediaType{Schema: &openapi.Schema{}}
+	body.Content[gin.MIMEJSON] = &openapi.MediaType{Schema: &openapi.Schema{}}
+	return body
+}
+
+// OpenAPIHandler serves the OpenAPI document of a router and Swagger UI to
+// browse it.
+type OpenAPIHandler struct {
+	doc      *openapi.Document
+	document []byte
+}
+
+func NewOpenAPIHandler() *OpenAPIHandler {
+	return &OpenAPIHandler{}
+}
+
+// Describe builds the document served from the routes of the router, which
+// must be called once all routes are registered.
+func (handler *OpenAPIHandler) Describe(routes gin.RoutesInfo) error {
+	doc, err := OpenAPI(routes)
+	if err != nil {
+		return err
+	}
+	handler.doc = doc
+	handler.document, err = json.MarshalIndent(doc, "", "  ")
+	return err
+}
+
+// GetOpenAPIHandler returns the OpenAPI document of this API.
+func (handler *OpenAPIHandler) GetOpenAPIHandler(ctx *gin.Context) {
+	if handler.document == nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "The OpenAPI document has not been built"})
+		return
+	}
+	ctx.Data(http.StatusOK, gin.MIMEJSON+"; charset=utf-8", handler.document)
+}
+
+// docsAssets are the files of Swagger UI served next to the page, by their
+// content type.
+var docsAssets = map[string]string{
+	"swagger-ui.css":       "text/css; charset=utf-8",
+	"swagger-ui-bundle.js": "text/javascript; charset=utf-8",
+}
+
+// GetDocsHandler shows the OpenAPI document of this API in Swagger UI.
+func (handler *OpenAPIHandler) GetDocsHandler(ctx *gin.Context) {
+	handler.serveDocs(ctx, "index.html", gin.MIMEHTML+"; charset=utf-8")
+}
+
+// GetDocsAssetHandler returns a script or style of Swagger UI.
+func (handler *OpenAPIHandler) GetDocsAssetHandler(ctx *gin.Context) {
+	contentType, ok := docsAssets[ctx.Param("file")]
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Page not found"})
+		return
+	}
+	handler.serveDocs(ctx, ctx.Param("file"), contentType)
+}
+
+func (handler *OpenAPIHandler) serveDocs(ctx *gin.Context, name string, contentType string) {
+	content, err := fs.ReadFile(openapi.SwaggerUI, "swagger-ui/"+name)
+	if err != nil {
+		log.Printf("Error while reading %s of Swagger UI: %v", name, err)
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Page not found"})
+		return
+	}
+	ctx.Data(http.StatusOK, contentType, content)
+}
@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/aheadxnet/go-sandbox/openapi"
+	"github.com/gin-gonic/gin"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newTestRouter creates the router of a tenant without connecting to MongoDB
+// or Redis.
+func newTestRouter(t *testing.T, name string) *gin.Engine {
+	t.Helper()
+	tenants, client, redisClient := newTestTenants(t, "mongodb://localhost:27017", nil)
+	tenant := NewTenant(context.Background(), name, client.Database("recipes_test"), redisClient, TenantConfig{JWTSecret: testSecret})
+	return NewRouter(tenant, NewTenantsHandler(tenants))
+}
+
+// TestOpenAPIMatchesRoutes fails whenever a route is added without
+// describing it, or an operation is described that isn't routed.
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	router := newTestRouter(t, "")
+	doc, err := OpenAPI(router.Routes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes := make(map[string]bool)
+	for _, route := range router.Routes() {
+		routes[route.Method+" "+route.Path] = true
+	}
+	for route := range apiOperations(newOpenAPIDocument()) {
+		if !routes[route] {
+			t.Errorf("operation %s has no route", route)
+		}
+	}
+	if len(doc.Routes()) != len(routes) {
+		t.Errorf("got %d operations for %d routes", len(doc.Routes()), len(routes))
+	}
+
+	// Other tenants have no routes to manage tenants, but all of theirs
+	// are described as well.
+	if _, err := OpenAPI(newTestRouter(t, "brand-b").Routes()); err != nil {
+		t.Error(err)
+	}
+
+	// The handlers read the parameters described, and no others.
+	reads := parameterReads(t)
+	for _, route := range router.Routes() {
+		name := handlerName.FindStringSubmatch(route.Handler)
+		if name == nil {
+			t.Errorf("%s %s: unknown handler %s", route.Method, route.Path, route.Handler)
+			continue
+		}
+		read := reads[name[1]+"."+name[2]]
+		path, _ := openapi.Path(route.Path)
+		described := make(map[string]bool)
+		for _, parameter := range doc.Operation(route.Method, path).Parameters {
+			if parameter.In == "path" || parameter.In == "query" {
+				described[parameter.In+" "+parameter.Name] = true
+			}
+		}
+		for parameter := range read {
+			if !described[parameter] && !undescribedReads[route.Method+" "+route.Path+" "+parameter] {
+				t.Errorf("%s %s: %s reads the %s parameter, which is not described", route.Method, route.Path, name[2], parameter)
+			}
+		}
+		for parameter := range described {
+			if !read[parameter] {
+				t.Errorf("%s %s: the %s parameter is described, but %s doesn't read it", route.Method, route.Path, parameter, name[2])
+			}
+		}
+	}
+}
+
+// handlerName matches the name gin gives the methods routed, like
+// ".../handlers.(*RecipesHandler).GetRecipeHandler-fm".
+var handlerName = regexp.MustCompile(`\.\(\*(\w+)\)\.(\w+)-fm$`)
+
+// undescribedReads are parameters read by a handler that aren't part of the
+// API.
+var undescribedReads = map[string]bool{
+	// gin takes ":batch" for a parameter, which tells /recipes:batch from
+	// other paths starting with /recipes.
+	"POST /recipes:batch path batch": true,
+}
+
+// parameterReads parses the sources of this package and returns the path
+// and query parameters each function reads, like "query limit", by the
+// names of the functions, like "RecipesHandler.ListRecipesHandler". Calls
+// to functions and to methods of the same receiver are followed, as are
+// parameter names passed to functions like recipeID.
+func parameterReads(t *testing.T) map[string]map[string]bool {
+	t.Helper()
+	files := token.NewFileSet()
+	packages, err := parser.ParseDir(files, ".", func(info fs.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type call struct {
+		callee string
+		args   []ast.Expr
+	}
+	type function struct {
+		reads    map[string]bool
+		forwards map[int]string
+		params   map[string]int
+		calls    []call
+	}
+	accessors := map[string]string{
+		"Param": "path", "Query": "query", "DefaultQuery": "query", "GetQuery": "query",
+		"QueryArray": "query", "GetQueryArray": "query",
+	}
+	functions := make(map[string]*function)
+	for _, file := range packages["handlers"].Files {
+		for _, decl := range file.Decls {
+			decl, ok := decl.(*ast.FuncDecl)
+			if !ok || decl.Body == nil {
+				continue
+			}
+			name, receiver, receiverType := decl.Name.Name, "", ""
+			if decl.Recv != nil {
+				field := decl.Recv.List[0]
+				if star, ok := field.Type.(*ast.StarExpr); ok {
+					receiverType = star.X.(*ast.Ident).Name
+				} else {
+					receiverType = field.Type.(*ast.Ident).Name
+				}
+				if len(field.Names) > 0 {
+					receiver = field.Names[0].Name
+				}
+				name = receiverType + "." + name
+			}
+			f := &function{reads: make(map[string]bool), forwards: make(map[int]string), params: make(map[string]int)}
+			for _, field := range decl.Type.Params.List {
+				for _, param := range field.Names {
+					f.params[param.Name] = len(f.params)
+				}
+			}
+			ast.Inspect(decl.Body, func(node ast.Node) bool {
+				expr, ok := node.(*ast.CallExpr)
+				if !ok {
+					return true
+				}
+				switch fun := expr.Fun.(type) {
+				case *ast.Ident:
+					f.calls = append(f.calls, call{fun.Name, expr.Args})
+				case *ast.SelectorExpr:
+					x, ok := fun.X.(*ast.Ident)
+					if !ok {
+						break
+					}
+					if x.Name == receiver {
+						f.calls = append(f.calls, call{receiverType + "." + fun.Sel.Name, expr.Args})
+					} else if in, ok := accessors[fun.Sel.Name]; ok && len(expr.Args) > 0 {
+						f.calls = append(f.calls, call{in, expr.Args[:1]})
+					}
+				}
+				return true
+			})
+			functions[name] = f
+		}
+	}
+	for _, in := range []string{"path", "query"} {
+		functions[in] = &function{reads: map[string]bool{}, forwards: map[int]string{0: in}}
+	}
+
+	// Follow the calls until nothing new is found.
+	for changed := true; changed; {
+		changed = false
+		for _, f := range functions {
+			for _, call := range f.calls {
+				callee, ok := functions[call.callee]
+				if !ok {
+					continue
+				}
+				for read := range callee.reads {
+					if !f.reads[read] {
+						f.reads[read], changed = true, true
+					}
+				}
+				for i, in := range callee.forwards {
+					if i >= len(call.args) {
+						continue
+					}
+					switch arg := call.args[i].(type) {
+					case *ast.BasicLit:
+						value, _ := strconv.Unquote(arg.Value)
+						if !f.reads[in+" "+value] {
+							f.reads[in+" "+value], changed = true, true
+						}
+					case *ast.Ident:
+						if index, ok := f.params[arg.Name]; ok && f.forwards[index] == "" {
+							f.forwards[index], changed = in, true
+						}
+					}
+				}
+			}
+		}
+	}
+	reads := make(map[string]map[string]bool)
+	for name, f := range functions {
+		reads[name] = f.reads
+	}
+	return reads
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	router := newTestRouter(t, "")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", recorder.Code, recorder.Body)
+	}
+	var doc openapi.Document
+	if err := json.Unmarshal(recorder.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI != openapi.Version {
+		t.Errorf("got version %q", doc.OpenAPI)
+	}
+	if doc.Operation(http.MethodGet, "/recipes/search") == nil {
+		t.Error("missing GET /recipes/search")
+	}
+	if doc.Operation(http.MethodPost, "/recipes:batch") == nil {
+		t.Error("missing POST /recipes:batch")
+	}
+	body := doc.Operation(http.MethodPost, "/recipes").RequestBody.Content[gin.MIMEJSON].Schema
+	if body.Ref != "#/components/schemas/Recipe" {
+		t.Errorf("got request body %+v, want a reference to Recipe", body)
+	}
+
+	ids := make(map[string]string)
+	for path, item := range doc.Paths {
+		for method, operation := range *item {
+			route := strings.ToUpper(method) + " " + path
+			if other, ok := ids[operation.OperationID]; ok {
+				t.Errorf("%s and %s share the operation ID %q", route, other, operation.OperationID)
+			}
+			ids[operation.OperationID] = route
+			if len(operation.Responses) == 0 {
+				t.Errorf("%s has no responses", route)
+			}
+		}
+	}
+
+	// Every reference resolves and every array has items, which the
+	// former swagger.json got wrong.
+	var raw interface{}
+	json.Unmarshal(recorder.Body.Bytes(), &raw)
+	walk(raw, func(object map[string]interface{}) {
+		if ref, ok := object["$ref"].(string); ok {
+			name := strings.TrimPrefix(ref, "#/components/schemas/")
+			if _, ok := doc.Components.Schemas[name]; !ok {
+				t.Errorf("unresolved reference %s", ref)
+			}
+		}
+		if object["type"] == "array" && object["items"] == nil {
+			t.Errorf("array without items: %v", object)
+		}
+	})
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "openapi.json") {
+		t.Errorf("got status %d: %s", recorder.Code, recorder.Body)
+	}
+	if strings.Contains(recorder.Body.String(), "://") {
+		t.Errorf("the page loads from another origin: %s", recorder.Body)
+	}
+	for file, contentType := range docsAssets {
+		if !strings.Contains(recorder.Body.String(), "docs/"+file) {
+			t.Errorf("the page doesn't load %s", file)
+		}
+		if _, err := fs.Stat(openapi.SwaggerUI, "swagger-ui/"+file); err != nil {
+			t.Logf("%s is not embedded, run go generate ./openapi", file)
+			continue
+		}
+		recorder = httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/docs/"+file, nil))
+		if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != contentType {
+			t.Errorf("got status %d and %s for %s", recorder.Code, recorder.Header().Get("Content-Type"), file)
+		}
+	}
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/docs/index.html", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("got status %d for /docs/index.html", recorder.Code)
+	}
+}
+
+func walk(value interface{}, visit func(map[string]interface{})) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		visit(value)
+		for _, element := range value {
+			walk(element, visit)
+		}
+	case []interface{}:
+		for _, element := range value {
+			walk(element, visit)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"github.com/aheadxnet/go-sandbox/models"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+// maxPageSize limits the number of recipes per page.
+const maxPageSize = 100
+
+// paginate returns the page of the recipes given by the limit and offset
+// parameters, counting the recipes in the X-Total-Count header and linking
+// the next page in the Link header. Without both parameters all recipes are
+// returned, as clients did before lists were paginated. It answers invalid
+// parameters with 400 itself and returns false then.
+func paginate(ctx *gin.Context, recipes []models.Recipe) ([]models.Recipe, bool) {
+	if ctx.Query("limit") == "" && ctx.Query("offset") == "" {
+		return recipes, true
+	}
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > maxPageSize {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Limit must be between 1 and " + strconv.Itoa(maxPageSize)})
+		return nil, false
+	}
+	offset, err := strconv.Atoi(ctx.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Offset must not be negative"})
+		return nil, false
+	}
+	ctx.Header("X-Total-Count", strconv.Itoa(len(recipes)))
+	if offset >= len(recipes) {
+		return []models.Recipe{}, true
+	}
+	end := offset + limit
+	if end >= len(recipes) {
+		return recipes[offset:], true
+	}
+	next := *ctx.Request.URL
+	query := next.Query()
+	query.Set("limit", strconv.Itoa(limit))
+	query.Set("offset", strconv.Itoa(end))
+	next.RawQuery = query.Encode()
+	ctx.Header("Link", "<"+next.RequestURI()+`>; rel="next"`)
+	return recipes[offset:end], true
+}
@@ -0,0 +1,353 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/aheadxnet/go-sandbox/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// ratingWeight is the number of mean ratings every recipe is assumed to have
+// when sorting by the Bayesian-weighted rating.
+const ratingWeight = 5
+
+// reviewIndexes are the indexes of the reviews, allowing a single review per
+// user and recipe.
+func reviewIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{{
+		Keys:    bson.D{{Key: "recipeId", Value: 1}, {Key: "author", Value: 1}},
+		Options: options.Index().SetName("recipeId_author_unique").SetUnique(true),
+	}}
+}
+
+// CleanupOrphanReviews deletes the reviews of recipes that do not exist
+// anymore, like recipes purged from the trash by the TTL index.
+func CleanupOrphanReviews(ctx context.Context, reviews *mongo.Collection, recipes *mongo.Collection) (int, error) {
+	ids, err := reviews.Distinct(ctx, "recipeId", bson.M{})
+	if err != nil {
+		return 0, err
+	}
+	orphans, err := purgedRecipes(ctx, recipes, ids)
+	if err != nil || len(orphans) == 0 {
+		return 0, err
+	}
+	result, err := reviews.DeleteMany(ctx, bson.M{"recipeId": bson.M{"$in": orphans}})
+	if err != nil {
+		return 0, err
+	}
+	return int(result.DeletedCount), nil
+}
+
+type ReviewsHandler struct {
+	collection *mongo.Collection
+	recipes    *mongo.Collection
+	ctx        context.Context
+	cache      *RecipeCache
+}
+
+func NewReviewsHandler(ctx context.Context, collection *mongo.Collection, recipes *mongo.Collection, cache *RecipeCache) *ReviewsHandler {
+	return &ReviewsHandler{
+		collection: collection,
+		recipes:    recipes,
+		ctx:        ctx,
+		cache:      cache,
+	}
+}
+
+// NewReviewHandler rates and reviews a recipe, once per user.
+func (handler *ReviewsHandler) NewReviewHandler(ctx *gin.Context) {
+	var review models.Review
+	if err := ctx.ShouldBindJSON(&review); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	recipeId, ok := recipeID(ctx, handler.recipes, "id")
+	if !ok {
+		return
+	}
+	count, err := handler.recipes.CountDocuments(handler.ctx, active(bson.M{"_id": recipeId}))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if count == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Recipe not found"})
+		return
+	}
+	review.ID = primitive.NewObjectID()
+	review.RecipeID = recipeId
+	review.Author = ctx.GetString("username")
+	review.Hidden = false
+	review.CreatedAt = time.Now()
+	review.UpdatedAt = review.CreatedAt
+	_, err = handler.collection.InsertOne(handler.ctx, review)
+	if mongo.IsDuplicateKeyError(err) {
+		ctx.JSON(http.StatusConflict, gin.H{"error": "You already reviewed this recipe, edit your review instead"})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := handler.adjustRating(ctx, recipeId, review.Rating, 1); err != nil {
+		handler.undo(ctx, recipeId, err, func() error {
+			_, err := handler.collection.DeleteOne(handler.ctx, bson.M{"_id": review.ID})
+			return err
+		})
+		return
+	}
+	ctx.JSON(http.StatusCreated, review)
+}
+
+// ListRecipeReviewsHandler returns the reviews of a recipe, newest first.
+func (handler *ReviewsHandler) ListRecipeReviewsHandler(ctx *gin.Context) {
+	recipeId, ok := recipeID(ctx, handler.recipes, "id")
+	if !ok {
+		return
+	}
+	handler.list(ctx, bson.M{"recipeId": recipeId, "hidden": bson.M{"$ne": true}})
+}
+
+// ListOwnReviewsHandler returns the reviews of the current user, newest first.
+func (handler *ReviewsHandler) ListOwnReviewsHandler(ctx *gin.Context) {
+	handler.list(ctx, bson.M{"author": ctx.GetString("username")})
+}
+
+// UpdateReviewHandler updates a review of the current user.
+func (handler *ReviewsHandler) UpdateReviewHandler(ctx *gin.Context) {
+	var input models.Review
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	reviewId, _ := primitive.ObjectIDFromHex(ctx.Param("id"))
+	var old models.Review
+	err := handler.collection.FindOneAndUpdate(handler.ctx, bson.M{
+		"_id":    reviewId,
+		"author": ctx.GetString("username"),
+	}, bson.M{"$set": bson.M{
+		"rating":    input.Rating,
+		"text":      input.Text,
+		"updatedAt": time.Now(),
+	}}).Decode(&old)
+	if !handler.found(ctx, err) {
+		return
+	}
+	if !old.Hidden {
+		if err := handler.adjustRating(ctx, old.RecipeID, input.Rating-old.Rating, 0); err != nil {
+			handler.undo(ctx, old.RecipeID, err, func() error {
+				_, err := handler.collection.UpdateOne(handler.ctx, bson.M{"_id": old.ID}, bson.M{"$set": bson.M{
+					"rating":    old.Rating,
+					"text":      old.Text,
+					"updatedAt": old.UpdatedAt,
+				}})
+				return err
+			})
+			return
+		}
+	}
+	review := old
+	review.Rating = input.Rating
+	review.Text = input.Text
+	review.UpdatedAt = time.Now()
+	ctx.JSON(http.StatusOK, review)
+}
+
+// DeleteReviewHandler deletes a review of the current user.
+func (handler *ReviewsHandler) DeleteReviewHandler(ctx *gin.Context) {
+	reviewId, _ := primitive.ObjectIDFromHex(ctx.Param("id"))
+	handler.delete(ctx, bson.M{"_id": reviewId, "author": ctx.GetString("username")})
+}
+
+// ListAllReviewsHandler returns all reviews for moderation, newest first.
+func (handler *ReviewsHandler) ListAllReviewsHandler(ctx *gin.Context) {
+	filter := bson.M{}
+	switch ctx.Query("hidden") {
+	case "true":
+		filter["hidden"] = true
+	case "false":
+		filter["hidden"] = bson.M{"$ne": true}
+	}
+	handler.list(ctx, filter)
+}
+
+// HideReviewHandler hides a review, it is no longer shown nor counted.
+func (handler *ReviewsHandler) HideReviewHandler(ctx *gin.Context) {
+	handler.setHidden(ctx, true)
+}
+
+// ShowReviewHandler shows a hidden review again.
+func (handler *ReviewsHandler) ShowReviewHandler(ctx *gin.Context) {
+	handler.setHidden(ctx, false)
+}
+
+// DeleteAnyReviewHandler deletes any review.
+func (handler *ReviewsHandler) DeleteAnyReviewHandler(ctx *gin.Context) {
+	reviewId, _ := primitive.ObjectIDFromHex(ctx.Param("id"))
+	handler.delete(ctx, bson.M{"_id": reviewId})
+}
+
+func (handler *ReviewsHandler) list(ctx *gin.Context, filter bson.M) {
+	cur, err := handler.collection.Find(handler.ctx, filter, options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	reviews := make([]models.Review, 0)
+	if err := cur.All(handler.ctx, &reviews); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, reviews)
+}
+
+func (handler *ReviewsHandler) delete(ctx *gin.Context, filter bson.M) {
+	var review models.Review
+	err := handler.collection.FindOneAndDelete(handler.ctx, filter).Decode(&review)
+	if !handler.found(ctx, err) {
+		return
+	}
+	if !review.Hidden {
+		if err := handler.adjustRating(ctx, review.RecipeID, -review.Rating, -1); err != nil {
+			handler.undo(ctx, review.RecipeID, err, func() error {
+				_, err := handler.collection.InsertOne(handler.ctx, review)
+				return err
+			})
+			return
+		}
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "Review has been deleted"})
+}
+
+func (handler *ReviewsHandler) setHidden(ctx *gin.Context, hidden bool) {
+	reviewId, _ := primitive.ObjectIDFromHex(ctx.Param("id"))
+	var review models.Review
+	err := handler.collection.FindOneAndUpdate(handler.ctx,
+		bson.M{"_id": reviewId, "hidden": bson.M{"$ne": hidden}},
+		bson.M{"$set": bson.M{"hidden": hidden}}).Decode(&review)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Either unknown or already in the requested state.
+		err = handler.collection.FindOne(handler.ctx, bson.M{"_id": reviewId}).Decode(&review)
+		if handler.found(ctx, err) {
+			ctx.JSON(http.StatusOK, review)
+		}
+		return
+	}
+	if !handler.found(ctx, err) {
+		return
+	}
+	sign := 1
+	if hidden {
+		sign = -1
+	}
+	if err := handler.adjustRating(ctx, review.RecipeID, sign*review.Rating, sign); err != nil {
+		handler.undo(ctx, review.RecipeID, err, func() error {
+			_, err := handler.collection.UpdateOne(handler.ctx, bson.M{"_id": review.ID}, bson.M{"$set": bson.M{"hidden": !hidden}})
+			return err
+		})
+		return
+	}
+	review.Hidden = hidden
+	ctx.JSON(http.StatusOK, review)
+}
+
+func (handler *ReviewsHandler) found(ctx *gin.Context, err error) bool {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
+		return false
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// adjustRating updates the aggregated rating of a recipe in a single atomic
+// update, so concurrent reviews can't get lost.
+func (handler *ReviewsHandler) adjustRating(ctx *gin.Context, recipeId primitive.ObjectID, sumDelta int, countDelta int) error {
+	_, err := handler.recipes.UpdateOne(handler.ctx, bson.M{"_id": recipeId}, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"rating.sum":   bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$rating.sum", 0}}, sumDelta}},
+			"rating.count": bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$rating.count", 0}}, countDelta}},
+		}}},
+		{{Key: "$set", Value: bson.M{
+			"rating.average": bson.M{"$cond": bson.A{
+				bson.M{"$gt": bson.A{"$rating.count", 0}},
+				bson.M{"$divide": bson.A{"$rating.sum", "$rating.count"}},
+				0,
+			}},
+		}}},
+	})
+	if err != nil {
+		return err
+	}
+	handler.cache.Invalidate(ctx)
+	return nil
+}
+
+// undo reverts the change of a review whose rating could not be counted for
+// its recipe, so the reviews and the aggregated rating stay consistent. If
+// even that fails, the rating is recomputed from the reviews.
+func (handler *ReviewsHandler) undo(ctx *gin.Context, recipeId primitive.ObjectID, err error, revert func() error) {
+	log.Printf("Error while updating the rating of recipe %s: %v", recipeId.Hex(), err)
+	if err := revert(); err != nil {
+		log.Printf("Error while reverting a review of recipe %s: %v", recipeId.Hex(), err)
+		if err := RecomputeRating(handler.ctx, handler.collection, handler.recipes, recipeId); err != nil {
+			log.Printf("Error while recomputing the rating of recipe %s: %v", recipeId.Hex(), err)
+		}
+	}
+	ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// RecomputeRating sets the aggregated rating of a recipe from its visible
+// reviews.
+func RecomputeRating(ctx context.Context, reviews *mongo.Collection, recipes *mongo.Collection, recipeId primitive.ObjectID) error {
+	cur, err := reviews.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"recipeId": recipeId, "hidden": bson.M{"$ne": true}}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "sum": bson.M{"$sum": "$rating"}, "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return err
+	}
+	var totals []models.Rating
+	if err := cur.All(ctx, &totals); err != nil {
+		return err
+	}
+	rating := models.Rating{}
+	if len(totals) > 0 && totals[0].Count > 0 {
+		rating = totals[0]
+		rating.Average = float64(rating.Sum) / float64(rating.Count)
+	}
+	_, err = recipes.UpdateOne(ctx, bson.M{"_id": recipeId}, bson.M{"$set": bson.M{"rating": rating}})
+	return err
+}
+
+// sortRecipes orders recipes as requested by the sort parameter: "rating"
+// sorts by the Bayesian-weighted rating, best first.
+func sortRecipes(ctx *gin.Context, recipes []models.Recipe) {
+	if ctx.Query("sort") != "rating" {
+		return
+	}
+	sum, count := 0.0, 0
+	for _, recipe := range recipes {
+		sum += recipe.Rating.Average * float64(recipe.Rating.Count)
+		count += recipe.Rating.Count
+	}
+	mean := 0.0
+	if count > 0 {
+		mean = sum / float64(count)
+	}
+	sort.SliceStable(recipes, func(i, j int) bool {
+		return models.BayesianRating(recipes[i].Rating, mean, ratingWeight) >
+			models.BayesianRating(recipes[j].Rating, mean, ratingWeight)
+	})
+}
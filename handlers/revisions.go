@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/aheadxnet/go-sandbox/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RevisionStore writes the immutable revisions of recipes.
+type RevisionStore struct {
+	collection *mongo.Collection
+	retention  int
+}
+
+// NewRevisionStore creates a store keeping the last retention revisions of
+// each recipe, all of them if retention is 0.
+func NewRevisionStore(collection *mongo.Collection, retention int) *RevisionStore {
+	return &RevisionStore{
+		collection: collection,
+		retention:  retention,
+	}
+}
+
+// recordAttempts is how often recording a revision is tried when concurrent
+// changes of the recipe take the same revision number.
+const recordAttempts = 5
+
+// Indexes is the unique index on the revision numbers of each recipe, so
+// concurrent changes can't record revisions with the same number.
+func (store *RevisionStore) Indexes() IndexSet {
+	return IndexSet{Collection: store.collection, Indexes: []mongo.IndexModel{{
+		Keys:    bson.D{{Key: "recipeId", Value: 1}, {Key: "number", Value: 1}},
+		Options: options.Index().SetName("recipeId_number_unique").SetUnique(true),
+	}}}
+}
+
+// Record stores a snapshot of the recipe as its next revision and drops the
+// revisions exceeding the retention.
+func (store *RevisionStore) Record(ctx context.Context, action string, author string, recipe models.Recipe) error {
+	revision := models.Revision{
+		ID:        primitive.NewObjectID(),
+		RecipeID:  recipe.ID,
+		Action:    action,
+		Author:    author,
+		CreatedAt: time.Now(),
+		Snapshot:  recipe,
+	}
+	var err error
+	for attempt := 0; attempt < recordAttempts; attempt++ {
+		var latest *models.Revision
+		revision.Number = 1
+		latest, err = store.latest(ctx, recipe.ID)
+		if err == nil {
+			revision.Number = latest.Number + 1
+		} else if !errors.Is(err, mongo.ErrNoDocuments) {
+			return err
+		}
+		if _, err = store.collection.InsertOne(ctx, revision); !mongo.IsDuplicateKeyError(err) {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	number := revision.Number
+	if store.retention > 0 && number > store.retention {
+		_, err = store.collection.DeleteMany(ctx, bson.M{
+			"recipeId": recipe.ID,
+			"number":   bson.M{"$lte": number - store.retention},
+		})
+	}
+	return err
+}
+
+// RecordPurges records a purge revision for recipes that do not exist
+// anymore and have none yet, like recipes purged from the trash by the TTL
+// index. Their revisions are kept, so they can still be reverted.
+func (store *RevisionStore) RecordPurges(ctx context.Context, recipes *mongo.Collection) (int, error) {
+	ids, err := store.collection.Distinct(ctx, "recipeId", bson.M{})
+	if err != nil {
+		return 0, err
+	}
+	orphans, err := purgedRecipes(ctx, recipes, ids)
+	if err != nil {
+		return 0, err
+	}
+	recorded := 0
+	for _, id := range orphans {
+		recipeID, ok := id.(primitive.ObjectID)
+		if !ok {
+			continue
+		}
+		latest, err := store.latest(ctx, recipeID)
+		if err != nil {
+			return recorded, err
+		}
+		if latest.Action == models.RevisionPurge {
+			continue
+		}
+		if err := store.Record(ctx, models.RevisionPurge, "anonymous", latest.Snapshot); err != nil {
+			return recorded, err
+		}
+		recorded++
+	}
+	return recorded, nil
+}
+
+func (store *RevisionStore) latest(ctx context.Context, recipeID primitive.ObjectID) (*models.Revision, error) {
+	var revision models.Revision
+	err := store.collection.FindOne(ctx, bson.M{"recipeId": recipeID},
+		options.FindOne().SetSort(bson.D{{Key: "number", Value: -1}})).Decode(&revision)
+	if err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// find returns a revision of a recipe, the latest one for number 0.
+func (store *RevisionStore) find(ctx context.Context, recipeID primitive.ObjectID, number int) (*models.Revision, error) {
+	if number == 0 {
+		return store.latest(ctx, recipeID)
+	}
+	var revision models.Revision
+	err := store.collection.FindOne(ctx, bson.M{"recipeId": recipeID, "number": number}).Decode(&revision)
+	if err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// recordRevision records a revision, a failure is only logged as the change itself succeeded.
+func (handler *RecipesHandler) recordRevision(ctx *gin.Context, action string, recipe models.Recipe) {
+	if err := handler.revisions.Record(handler.ctx, action, author(ctx), recipe); err != nil {
+		log.Printf("Error while recording %s revision of recipe %s: %v", action, recipe.ID.Hex(), err)
+	}
+}
+
+// author returns the name of the signed in user, "anonymous" if there is none.
+func author(ctx *gin.Context) string {
+	if username := ctx.GetString("username"); username != "" {
+		return username
+	}
+	return "anonymous"
+}
+
+// ListRevisionsHandler returns the revisions of a recipe, newest first.
+func (handler *RecipesHandler) ListRevisionsHandler(ctx *gin.Context) {
+	objectId, ok := recipeID(ctx, handler.collection, "id")
+	if !ok {
+		return
+	}
+	cur, err := handler.revisions.collection.Find(handler.ctx, bson.M{"recipeId": objectId},
+		options.Find().SetSort(bson.D{{Key: "number", Value: -1}}))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	revisions := make([]models.Revision, 0)
+	if err := cur.All(handler.ctx, &revisions); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	renderData(ctx, http.StatusOK, revisions)
+}
+
+// GetRevisionHandler gets a revision of a recipe.
+func (handler *RecipesHandler) GetRevisionHandler(ctx *gin.Context) {
+	revision, ok := handler.findRevision(ctx, ctx.Param("rev"))
+	if !ok {
+		return
+	}
+	renderData(ctx, http.StatusOK, revision)
+}
+
+// DiffRevisionsHandler compares two revisions of a recipe field by field.
+func (handler *RecipesHandler) DiffRevisionsHandler(ctx *gin.Context) {
+	from, ok := handler.findRevision(ctx, ctx.Query("from"))
+	if !ok {
+		return
+	}
+	to, ok := handler.findRevision(ctx, ctx.DefaultQuery("to", "0"))
+	if !ok {
+		return
+	}
+	renderData(ctx, http.StatusOK, gin.H{
+		"from":    from.Number,
+		"to":      to.Number,
+		"changes": models.DiffRecipes(from.Snapshot, to.Snapshot),
+	})
+}
+
+// RevertRevisionHandler restores a recipe to the state of a revision,
+// recreating it if it has been deleted.
+func (handler *RecipesHandler) RevertRevisionHandler(ctx *gin.Context) {
+	revision, ok := handler.findRevision(ctx, ctx.Param("rev"))
+	if !ok {
+		return
+	}
+	if revision.Action == models.RevisionDelete {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Can't revert to a deleted state, revert to an earlier revision"})
+		return
+	}
+	handler.normalizeTags(&revision.Snapshot)
+	var recipe models.Recipe
+	content := append(recipeContent(revision.Snapshot), bson.E{Key: "translations", Value: revision.Snapshot.Translations})
+	err := handler.collection.FindOneAndUpdate(handler.ctx, bson.M{"_id": revision.RecipeID}, bson.D{
+		{Key: "$set", Value: content},
+		{Key: "$unset", Value: bson.M{"deletedAt": ""}},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&recipe)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// The recipe has been purged meanwhile together with its photos and
+		// reviews, so bring back the content of the snapshot only.
+		recipe = revision.Snapshot
+		recipe.DeletedAt = nil
+		recipe.Photos = nil
+		recipe.Rating = models.Rating{}
+		// Its slugs may have been taken by other recipes meanwhile.
+		recipe.Slug = ""
+		recipe.OldSlugs = nil
+		_, err = handler.collection.InsertOne(handler.ctx, recipe)
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	handler.updateTexts(&recipe)
+	handler.updateSlug(&recipe)
+	handler.recordRevision(ctx, models.RevisionRevert, recipe)
+	handler.indexSimilar(recipe)
+	handler.cache.Invalidate(ctx)
+	renderData(ctx, http.StatusOK, recipe)
+}
+
+// findRevision loads the revision with the given number of the recipe
+// addressed by the id parameter, the latest one for "0".
+func (handler *RecipesHandler) findRevision(ctx *gin.Context, number string) (*models.Revision, bool) {
+	objectId, ok := recipeID(ctx, handler.collection, "id")
+	if !ok {
+		return nil, false
+	}
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision number " + strconv.Quote(number)})
+		return nil, false
+	}
+	revision, err := handler.revisions.find(handler.ctx, objectId, n)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+		return nil, false
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return revision, true
+}
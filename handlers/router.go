@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"github.com/aheadxnet/go-sandbox/export"
+	"github.com/gin-gonic/gin"
+	"log"
+)
+
+// NewRouter creates the router serving the API for a tenant. The routes to
+// manage tenants are only served for the default tenant. The OpenAPI
+// document is built from the routes, so every route needs an operation in
+// apiOperations. If the tenant validates requests, they are checked against
+// the document.
+func NewRouter(tenant *Tenant, tenantsHandler *TenantsHandler) *gin.Engine {
+	recipesHandler := tenant.RecipesHandler
+	authHandler := tenant.AuthHandler
+	mealPlansHandler := tenant.MealPlansHandler
+	imagesHandler := tenant.ImagesHandler
+	reviewsHandler := tenant.ReviewsHandler
+	cookbooksHandler := tenant.CookbooksHandler
+	tagsHandler := tenant.TagsHandler
+	openAPIHandler := NewOpenAPIHandler()
+
+	router := gin.Default()
+	router.SetHTMLTemplate(export.Templates)
+	if tenant.validateRequests {
+		// Responses are only validated in tests, as they are buffered.
+		router.Use(openAPIHandler.ValidationMiddleware(gin.Mode() == gin.TestMode))
+	}
+	router.Use(authHandler.IdentifyMiddleware(), tenant.Idempotency.Middleware())
+	router.POST("/recipes", recipesHandler.NewRecipeHandler)
+	router.POST("/recipes/import", recipesHandler.ImportRecipeHandler)
+	router.POST("/recipes:batch", recipesHandler.BatchRecipesHandler)
+	router.GET("/recipes", recipesHandler.ListRecipesHandler)
+	router.PUT("/recipes/:id", recipesHandler.UpdateRecipeHandler)
+	router.DELETE("/recipes/:id", recipesHandler.DeleteRecipeHandler)
+	router.POST("/recipes/:id/restore", recipesHandler.RestoreRecipeHandler)
+	router.GET("/trash", recipesHandler.ListTrashHandler)
+	router.GET("/recipes/:id", recipesHandler.GetRecipeHandler)
+	router.GET("/recipes/:id/similar", recipesHandler.SimilarRecipesHandler)
+	router.GET("/recipes/:id/translations", recipesHandler.ListTranslationsHandler)
+	router.PUT("/recipes/:id/translations/:lang", recipesHandler.PutTranslationHandler)
+	router.DELETE("/recipes/:id/translations/:lang", recipesHandler.DeleteTranslationHandler)
+	router.GET("/recipes/:id/revisions", recipesHandler.ListRevisionsHandler)
+	router.GET("/recipes/:id/revisions/diff", recipesHandler.DiffRevisionsHandler)
+	router.GET("/recipes/:id/revisions/:rev", recipesHandler.GetRevisionHandler)
+	router.POST("/recipes/:id/revisions/:rev/revert", recipesHandler.RevertRevisionHandler)
+	router.GET("/recipes/search", recipesHandler.SearchRecipesHandler)
+	router.GET("/recipes/by-slug/:slug", recipesHandler.GetRecipeBySlugHandler)
+	router.POST("/recipes/:id/images", imagesHandler.UploadImageHandler)
+	router.GET("/recipes/:id/images", imagesHandler.ListImagesHandler)
+	router.DELETE("/recipes/:id/images/:imageId", imagesHandler.DeleteImageHandler)
+	router.GET("/images/:id", imagesHandler.GetImageHandler)
+	router.GET("/recipes/:id/reviews", reviewsHandler.ListRecipeReviewsHandler)
+	router.GET("/cookbooks/public", cookbooksHandler.ListPublicCookbooksHandler)
+	router.GET("/cookbooks/:id", cookbooksHandler.GetCookbookHandler)
+	router.GET("/tags", tagsHandler.ListTagsHandler)
+	router.GET("/feeds/mealplans/:token", mealPlansHandler.FeedHandler)
+	router.POST("/signin", authHandler.SignInHandler)
+	router.GET("/openapi.json", openAPIHandler.GetOpenAPIHandler)
+	router.GET("/docs", openAPIHandler.GetDocsHandler)
+	router.GET("/docs/:file", openAPIHandler.GetDocsAssetHandler)
+
+	authorized := router.Group("/")
+	authorized.Use(authHandler.AuthMiddleware())
+	authorized.POST("/mealplans", mealPlansHandler.NewMealPlanHandler)
+	authorized.GET("/mealplans", mealPlansHandler.ListMealPlansHandler)
+	authorized.GET("/mealplans/:id", mealPlansHandler.GetMealPlanHandler)
+	authorized.PUT("/mealplans/:id", mealPlansHandler.UpdateMealPlanHandler)
+	authorized.DELETE("/mealplans/:id", mealPlansHandler.DeleteMealPlanHandler)
+	authorized.GET("/mealplans/:id/shopping-list", mealPlansHandler.ShoppingListHandler)
+	authorized.GET("/mealplans/:id/ical", mealPlansHandler.ExportMealPlanHandler)
+	authorized.POST("/mealplans/:id/feed", mealPlansHandler.NewFeedHandler)
+	authorized.DELETE("/mealplans/:id/feed", mealPlansHandler.DeleteFeedHandler)
+	authorized.POST("/recipes/:id/reviews", reviewsHandler.NewReviewHandler)
+	authorized.GET("/reviews", reviewsHandler.ListOwnReviewsHandler)
+	authorized.PUT("/reviews/:id", reviewsHandler.UpdateReviewHandler)
+	authorized.DELETE("/reviews/:id", reviewsHandler.DeleteReviewHandler)
+	authorized.POST("/cookbooks", cookbooksHandler.NewCookbookHandler)
+	authorized.GET("/cookbooks", cookbooksHandler.ListCookbooksHandler)
+	authorized.PUT("/cookbooks/:id", cookbooksHandler.UpdateCookbookHandler)
+	authorized.DELETE("/cookbooks/:id", cookbooksHandler.DeleteCookbookHandler)
+	authorized.POST("/cookbooks/:id/recipes", cookbooksHandler.AddRecipeHandler)
+	authorized.DELETE("/cookbooks/:id/recipes/:recipeId", cookbooksHandler.RemoveRecipeHandler)
+	authorized.GET("/favorites", cookbooksHandler.ListFavoritesHandler)
+	authorized.PUT("/favorites/:id", cookbooksHandler.AddFavoriteHandler)
+	authorized.DELETE("/favorites/:id", cookbooksHandler.DeleteFavoriteHandler)
+
+	admin := authorized.Group("/admin")
+	admin.Use(AdminMiddleware())
+	admin.DELETE("/recipes/:id", recipesHandler.PurgeRecipeHandler)
+	admin.GET("/reviews", reviewsHandler.ListAllReviewsHandler)
+	admin.POST("/reviews/:id/hide", reviewsHandler.HideReviewHandler)
+	admin.POST("/reviews/:id/show", reviewsHandler.ShowReviewHandler)
+	admin.DELETE("/reviews/:id", reviewsHandler.DeleteAnyReviewHandler)
+	admin.GET("/duplicates", recipesHandler.ListDuplicatesHandler)
+	admin.POST("/tags", tagsHandler.NewTagHandler)
+	admin.PUT("/tags/:name", tagsHandler.UpdateTagHandler)
+	admin.DELETE("/tags/:name", tagsHandler.DeleteTagHandler)
+	admin.POST("/tags/:name/rename", tagsHandler.RenameTagHandler)
+	admin.POST("/tags/:name/merge", tagsHandler.MergeTagsHandler)
+	if tenant.Name == "" && tenantsHandler != nil {
+		admin.GET("/tenants", tenantsHandler.ListTenantsHandler)
+		admin.POST("/tenants", tenantsHandler.NewTenantHandler)
+		admin.DELETE("/tenants/:name", tenantsHandler.DeleteTenantHandler)
+	}
+	if err := openAPIHandler.Describe(router.Routes()); err != nil {
+		log.Println(err)
+	}
+	return router
+}
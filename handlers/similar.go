@@ -0,0 +1,376 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/aheadxnet/go-sandbox/models"
+	"github.com/aheadxnet/go-sandbox/similarity"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// Redis keys of the similarity index: a hash with the features of every
+// recipe, a set of recipe ids per feature and a sorted set with the most
+// similar recipes per recipe.
+const (
+	similarFeaturesKey   = "similar:features"
+	similarFeaturePrefix = "similar:feature:"
+	similarRecipePrefix  = "similar:recipe:"
+)
+
+// SimilarityIndex keeps the most similar recipes of every recipe in Redis,
+// updated incrementally whenever a recipe changes.
+type SimilarityIndex struct {
+	client *redis.Client
+	prefix string
+	limit  int
+}
+
+// NewSimilarityIndex creates an index keeping the limit most similar recipes
+// of every recipe, its keys starting with the prefix of the tenant.
+func NewSimilarityIndex(client *redis.Client, prefix string, limit int) *SimilarityIndex {
+	return &SimilarityIndex{
+		client: client,
+		prefix: prefix,
+		limit:  limit,
+	}
+}
+
+func (index *SimilarityIndex) featuresKey() string {
+	return index.prefix + similarFeaturesKey
+}
+
+func (index *SimilarityIndex) featureKey(feature string) string {
+	return index.prefix + similarFeaturePrefix + feature
+}
+
+func (index *SimilarityIndex) recipeKey(id string) string {
+	return index.prefix + similarRecipePrefix + id
+}
+
+// Update (re)indexes a recipe and updates the similar recipes of all recipes
+// sharing a feature with its old or new version.
+func (index *SimilarityIndex) Update(ctx context.Context, recipe models.Recipe) error {
+	id := recipe.ID.Hex()
+	old, err := index.features(ctx, id)
+	if err != nil {
+		return err
+	}
+	features := similarity.Of(recipe)
+	candidates, err := index.candidates(ctx, id, old, features)
+	if err != nil {
+		return err
+	}
+	candidateFeatures, err := index.featuresOf(ctx, candidates)
+	if err != nil {
+		return err
+	}
+	encoded, _ := json.Marshal(features)
+	matches := make([]similarity.Match, 0)
+	_, err = index.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for feature := range old {
+			if _, kept := features[feature]; !kept {
+				pipe.SRem(ctx, index.featureKey(feature), id)
+			}
+		}
+		for feature := range features {
+			pipe.SAdd(ctx, index.featureKey(feature), id)
+		}
+		pipe.HSet(ctx, index.featuresKey(), id, encoded)
+		for _, candidate := range candidates {
+			score := similarity.Jaccard(features, candidateFeatures[candidate])
+			if score == 0 {
+				pipe.ZRem(ctx, index.recipeKey(candidate), id)
+				continue
+			}
+			matches = append(matches, similarity.Match{ID: candidate, Score: score})
+			pipe.ZAdd(ctx, index.recipeKey(candidate), &redis.Z{Score: score, Member: id})
+			pipe.ZRemRangeByRank(ctx, index.recipeKey(candidate), 0, int64(-index.limit-1))
+		}
+		index.writeMatches(ctx, pipe, id, matches)
+		return nil
+	})
+	return err
+}
+
+// Remove drops a recipe from the index and from the similar recipes of all
+// other recipes.
+func (index *SimilarityIndex) Remove(ctx context.Context, recipeID primitive.ObjectID) error {
+	id := recipeID.Hex()
+	old, err := index.features(ctx, id)
+	if err != nil || old == nil {
+		return err
+	}
+	candidates, err := index.candidates(ctx, id, old, nil)
+	if err != nil {
+		return err
+	}
+	_, err = index.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for feature := range old {
+			pipe.SRem(ctx, index.featureKey(feature), id)
+		}
+		pipe.HDel(ctx, index.featuresKey(), id)
+		pipe.Del(ctx, index.recipeKey(id))
+		for _, candidate := range candidates {
+			pipe.ZRem(ctx, index.recipeKey(candidate), id)
+		}
+		return nil
+	})
+	return err
+}
+
+// Similar returns up to n recipes most similar to a recipe, best first. The
+// flag tells whether the recipe is indexed at all.
+func (index *SimilarityIndex) Similar(ctx context.Context, recipeID primitive.ObjectID, n int) ([]similarity.Match, bool, error) {
+	id := recipeID.Hex()
+	indexed, err := index.client.HExists(ctx, index.featuresKey(), id).Result()
+	if err != nil || !indexed {
+		return nil, false, err
+	}
+	scored, err := index.client.ZRevRangeWithScores(ctx, index.recipeKey(id), 0, int64(n-1)).Result()
+	if err != nil {
+		return nil, true, err
+	}
+	matches := make([]similarity.Match, len(scored))
+	for i, z := range scored {
+		matches[i] = similarity.Match{ID: z.Member.(string), Score: z.Score}
+	}
+	return matches, true, nil
+}
+
+// Rebuild replaces the whole index by one computed from scratch for the
+// given recipes.
+func (index *SimilarityIndex) Rebuild(ctx context.Context, recipes []models.Recipe) error {
+	if err := index.clear(ctx); err != nil {
+		return err
+	}
+	ids := make([]string, len(recipes))
+	features := make([]similarity.Features, len(recipes))
+	byFeature := make(map[string][]int)
+	for i, recipe := range recipes {
+		ids[i] = recipe.ID.Hex()
+		features[i] = similarity.Of(recipe)
+		for feature := range features[i] {
+			byFeature[feature] = append(byFeature[feature], i)
+		}
+	}
+	pipe := index.client.Pipeline()
+	flush := func() error {
+		if pipe.Len() < 1000 {
+			return nil
+		}
+		_, err := pipe.Exec(ctx)
+		return err
+	}
+	for feature, members := range byFeature {
+		values := make([]interface{}, len(members))
+		for i, member := range members {
+			values[i] = ids[member]
+		}
+		pipe.SAdd(ctx, index.featureKey(feature), values...)
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+	for i := range recipes {
+		encoded, _ := json.Marshal(features[i])
+		pipe.HSet(ctx, index.featuresKey(), ids[i], encoded)
+		seen := make(map[int]bool)
+		matches := make([]similarity.Match, 0)
+		for feature := range features[i] {
+			for _, j := range byFeature[feature] {
+				if j == i || seen[j] {
+					continue
+				}
+				seen[j] = true
+				matches = append(matches, similarity.Match{ID: ids[j], Score: similarity.Jaccard(features[i], features[j])})
+			}
+		}
+		index.writeMatches(ctx, pipe, ids[i], matches)
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// writeMatches replaces the similar recipes of a recipe by the best matches.
+func (index *SimilarityIndex) writeMatches(ctx context.Context, pipe redis.Pipeliner, id string, matches []similarity.Match) {
+	pipe.Del(ctx, index.recipeKey(id))
+	matches = similarity.Top(matches, index.limit)
+	if len(matches) == 0 {
+		return
+	}
+	members := make([]*redis.Z, len(matches))
+	for i, match := range matches {
+		members[i] = &redis.Z{Score: match.Score, Member: match.ID}
+	}
+	pipe.ZAdd(ctx, index.recipeKey(id), members...)
+}
+
+// candidates returns the ids of all other recipes sharing one of the features.
+func (index *SimilarityIndex) candidates(ctx context.Context, id string, features ...similarity.Features) ([]string, error) {
+	keys := make([]string, 0)
+	for _, set := range features {
+		for feature := range set {
+			keys = append(keys, index.featureKey(feature))
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	members, err := index.client.SUnion(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	candidates := make([]string, 0, len(members))
+	for _, member := range members {
+		if member != id {
+			candidates = append(candidates, member)
+		}
+	}
+	return candidates, nil
+}
+
+// features returns the indexed features of a recipe, nil if it isn't indexed.
+func (index *SimilarityIndex) features(ctx context.Context, id string) (similarity.Features, error) {
+	encoded, err := index.client.HGet(ctx, index.featuresKey(), id).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var features similarity.Features
+	err = json.Unmarshal([]byte(encoded), &features)
+	return features, err
+}
+
+// featuresOf returns the indexed features of several recipes.
+func (index *SimilarityIndex) featuresOf(ctx context.Context, ids []string) (map[string]similarity.Features, error) {
+	result := make(map[string]similarity.Features, len(ids))
+	if len(ids) == 0 {
+		return result, nil
+	}
+	values, err := index.client.HMGet(ctx, index.featuresKey(), ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, value := range values {
+		encoded, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var features similarity.Features
+		if err := json.Unmarshal([]byte(encoded), &features); err != nil {
+			return nil, err
+		}
+		result[ids[i]] = features
+	}
+	return result, nil
+}
+
+// clear deletes all keys of the index.
+func (index *SimilarityIndex) clear(ctx context.Context) error {
+	iter := index.client.Scan(ctx, 0, index.prefix+"similar:*", 1000).Iterator()
+	keys := make([]string, 0)
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) == 1000 {
+			if err := index.client.Del(ctx, keys...).Err(); err != nil {
+				return err
+			}
+			keys = keys[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return index.client.Del(ctx, keys...).Err()
+}
+
+// indexSimilar updates the similarity index for a changed recipe, a failure
+// is only logged as the change itself succeeded.
+func (handler *RecipesHandler) indexSimilar(recipe models.Recipe) {
+	if err := handler.similar.Update(handler.ctx, recipe); err != nil {
+		log.Printf("Error while indexing the similarity of recipe %s: %v", recipe.ID.Hex(), err)
+	}
+}
+
+// unindexSimilar removes a deleted recipe from the similarity index.
+func (handler *RecipesHandler) unindexSimilar(recipeID primitive.ObjectID) {
+	if err := handler.similar.Remove(handler.ctx, recipeID); err != nil {
+		log.Printf("Error while removing recipe %s from the similarity index: %v", recipeID.Hex(), err)
+	}
+}
+
+// SimilarRecipesHandler returns the recipes most similar to a recipe by tags,
+// ingredients and title, best first. Without a limit it returns 10 recipes,
+// or less if fewer similar recipes are kept.
+func (handler *RecipesHandler) SimilarRecipesHandler(ctx *gin.Context) {
+	limit := 10
+	if handler.similar.limit < limit {
+		limit = handler.similar.limit
+	}
+	if param, ok := ctx.GetQuery("limit"); ok {
+		var err error
+		limit, err = strconv.Atoi(param)
+		if err != nil || limit < 1 || limit > handler.similar.limit {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Limit must be between 1 and " + strconv.Itoa(handler.similar.limit)})
+			return
+		}
+	}
+	objectId, ok := recipeID(ctx, handler.collection, "id")
+	if !ok {
+		return
+	}
+	matches, indexed, err := handler.similar.Similar(handler.ctx, objectId, limit)
+	if err == nil && !indexed {
+		// Not indexed yet, like after Redis lost its data: index it now.
+		var recipe models.Recipe
+		err = handler.collection.FindOne(handler.ctx, active(bson.M{"_id": objectId})).Decode(&recipe)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Recipe not found"})
+			return
+		}
+		if err == nil {
+			err = handler.similar.Update(handler.ctx, recipe)
+		}
+		if err == nil {
+			matches, _, err = handler.similar.Similar(handler.ctx, objectId, limit)
+		}
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ids := make([]primitive.ObjectID, 0, len(matches))
+	for _, match := range matches {
+		if id, err := primitive.ObjectIDFromHex(match.ID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	found, err := findRecipes(handler.ctx, handler.collection, ids)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recipes := make([]models.Recipe, 0, len(ids))
+	for _, id := range ids {
+		if recipe, ok := found[id]; ok {
+			recipes = append(recipes, recipe)
+		}
+	}
+	renderRecipes(ctx, http.StatusOK, recipes)
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aheadxnet/go-sandbox/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+)
+
+// slugIndexes are the unique indexes on current and former slugs.
+func slugIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "slug", Value: 1}},
+			Options: options.Index().SetName("slug_unique").SetUnique(true).
+				SetPartialFilterExpression(bson.M{"slug": bson.M{"$type": "string"}}),
+		},
+		{
+			Keys: bson.D{{Key: "oldSlugs", Value: 1}},
+			Options: options.Index().SetName("oldSlugs_unique").SetUnique(true).
+				SetPartialFilterExpression(bson.M{"oldSlugs": bson.M{"$exists": true}}),
+		},
+	}
+}
+
+// UniqueSlug returns a free slug for the recipe with the given id and name:
+// the slug of the name, suffixed by -2, -3 and so on if other recipes use it
+// already or used it before.
+func UniqueSlug(ctx context.Context, collection *mongo.Collection, id primitive.ObjectID, name string) (string, error) {
+	return uniqueSlug(ctx, collection, id, name, nil)
+}
+
+// uniqueSlug is UniqueSlug skipping the reserved slugs as well, the ones
+// given to the other new recipes of a batch.
+func uniqueSlug(ctx context.Context, collection *mongo.Collection, id primitive.ObjectID, name string, reserved map[string]bool) (string, error) {
+	base := models.Slugify(name)
+	pattern := primitive.Regex{Pattern: "^" + regexp.QuoteMeta(base) + "(-[0-9]+)?$"}
+	cur, err := collection.Find(ctx, bson.M{
+		"_id": bson.M{"$ne": id},
+		"$or": bson.A{bson.M{"slug": pattern}, bson.M{"oldSlugs": pattern}},
+	}, options.Find().SetProjection(bson.M{"slug": 1, "oldSlugs": 1}))
+	if err != nil {
+		return "", err
+	}
+	var recipes []models.Recipe
+	if err := cur.All(ctx, &recipes); err != nil {
+		return "", err
+	}
+	taken := make(map[string]bool)
+	for _, recipe := range recipes {
+		taken[recipe.Slug] = true
+		for _, slug := range recipe.OldSlugs {
+			taken[slug] = true
+		}
+	}
+	slug := base
+	for n := 2; taken[slug] || reserved[slug]; n++ {
+		slug = fmt.Sprintf("%s-%d", base, n)
+	}
+	return slug, nil
+}
+
+// sluggedAs reports whether the slug has been derived from the name,
+// possibly with a numeric suffix.
+func sluggedAs(slug string, name string) bool {
+	base := models.Slugify(name)
+	return slug == base || regexp.MustCompile("^"+regexp.QuoteMeta(base)+"-[0-9]+$").MatchString(slug)
+}
+
+// updateSlug gives a renamed recipe a new slug, keeping the old one to
+// redirect from. A failure is only logged as the change itself succeeded.
+func (handler *RecipesHandler) updateSlug(recipe *models.Recipe) {
+	if recipe.Slug != "" && sluggedAs(recipe.Slug, recipe.Name) {
+		return
+	}
+	slug, err := UniqueSlug(handler.ctx, handler.collection, recipe.ID, recipe.Name)
+	if err != nil {
+		log.Printf("Error while updating the slug of recipe %s: %v", recipe.ID.Hex(), err)
+		return
+	}
+	oldSlugs := make([]string, 0, len(recipe.OldSlugs)+1)
+	for _, old := range append(recipe.OldSlugs, recipe.Slug) {
+		if old != "" && old != slug && !containsString(oldSlugs, old) {
+			oldSlugs = append(oldSlugs, old)
+		}
+	}
+	_, err = handler.collection.UpdateOne(handler.ctx, bson.M{"_id": recipe.ID},
+		bson.M{"$set": bson.M{"slug": slug, "oldSlugs": oldSlugs}})
+	if err != nil {
+		log.Printf("Error while updating the slug of recipe %s: %v", recipe.ID.Hex(), err)
+		return
+	}
+	recipe.Slug = slug
+	recipe.OldSlugs = oldSlugs
+}
+
+// GetRecipeBySlugHandler gets an existing recipe by its slug, former slugs
+// redirect to the current one.
+func (handler *RecipesHandler) GetRecipeBySlugHandler(ctx *gin.Context) {
+	slug := ctx.Param("slug")
+	var recipe models.Recipe
+	err := handler.collection.FindOne(handler.ctx, active(bson.M{"slug": slug})).Decode(&recipe)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = handler.collection.FindOne(handler.ctx, active(bson.M{"oldSlugs": slug})).Decode(&recipe)
+		if err == nil {
+			location := "/recipes/by-slug/" + url.PathEscape(recipe.Slug)
+			if ctx.Request.URL.RawQuery != "" {
+				location += "?" + ctx.Request.URL.RawQuery
+			}
+			ctx.Redirect(http.StatusMovedPermanently, location)
+			return
+		}
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Recipe not found"})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.Header("ETag", recipeETag(recipe))
+	renderRecipe(ctx, http.StatusOK, recipe)
+}
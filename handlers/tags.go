@@ -0,0 +1,557 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/aheadxnet/go-sandbox/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TagTaxonomy maps the spellings, synonyms and translations of tags to
+// their canonical names.
+type TagTaxonomy struct {
+	collection *mongo.Collection
+}
+
+func NewTagTaxonomy(collection *mongo.Collection) *TagTaxonomy {
+	return &TagTaxonomy{
+		collection: collection,
+	}
+}
+
+// Indexes are the unique indexes on the canonical names and on all terms, so
+// every term belongs to a single tag.
+func (taxonomy *TagTaxonomy) Indexes() IndexSet {
+	return IndexSet{Collection: taxonomy.collection, Indexes: []mongo.IndexModel{
+		{Keys: bson.D{{Key: "name", Value: 1}}, Options: options.Index().SetName("name_unique").SetUnique(true)},
+		{Keys: bson.D{{Key: "terms", Value: 1}}, Options: options.Index().SetName("terms_unique").SetUnique(true)},
+	}}
+}
+
+// Normalize normalizes tags and replaces known aliases and translations by
+// the canonical tag, dropping duplicates.
+func (taxonomy *TagTaxonomy) Normalize(ctx context.Context, tags []string) ([]string, error) {
+	terms := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if term := models.NormalizeTag(tag); term != "" {
+			terms = append(terms, term)
+		}
+	}
+	canonical, err := taxonomy.canonicalNames(ctx, terms)
+	if err != nil {
+		return nil, err
+	}
+	normalized := make([]string, 0, len(terms))
+	for _, term := range terms {
+		if name, found := canonical[term]; found {
+			term = name
+		}
+		if !containsString(normalized, term) {
+			normalized = append(normalized, term)
+		}
+	}
+	return normalized, nil
+}
+
+func (taxonomy *TagTaxonomy) canonicalNames(ctx context.Context, terms []string) (map[string]string, error) {
+	canonical := make(map[string]string)
+	if len(terms) == 0 {
+		return canonical, nil
+	}
+	cur, err := taxonomy.collection.Find(ctx, bson.M{"terms": bson.M{"$in": terms}})
+	if err != nil {
+		return nil, err
+	}
+	tags := make([]models.Tag, 0)
+	if err := cur.All(ctx, &tags); err != nil {
+		return nil, err
+	}
+	for _, tag := range tags {
+		for _, term := range tag.Terms {
+			canonical[term] = tag.Name
+		}
+	}
+	return canonical, nil
+}
+
+func (taxonomy *TagTaxonomy) find(ctx context.Context, filter bson.M) (*models.Tag, error) {
+	var tag models.Tag
+	if err := taxonomy.collection.FindOne(ctx, filter).Decode(&tag); err != nil {
+		return nil, err
+	}
+	return &tag, nil
+}
+
+// normalizeTags normalizes the tags of a recipe before writing it. If the
+// taxonomy can't be read the tags are only normalized in spelling.
+func (handler *RecipesHandler) normalizeTags(recipe *models.Recipe) {
+	tags, err := handler.tags.Normalize(handler.ctx, recipe.Tags)
+	if err != nil {
+		log.Println("Error while normalizing tags:", err)
+		tags = make([]string, 0, len(recipe.Tags))
+		for _, tag := range recipe.Tags {
+			if tag = models.NormalizeTag(tag); tag != "" && !containsString(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	recipe.Tags = tags
+}
+
+type TagsHandler struct {
+	taxonomy  *TagTaxonomy
+	recipes   *mongo.Collection
+	ctx       context.Context
+	cache     *RecipeCache
+	revisions *RevisionStore
+	similar   *SimilarityIndex
+}
+
+func NewTagsHandler(ctx context.Context, taxonomy *TagTaxonomy, recipes *mongo.Collection, cache *RecipeCache, revisions *RevisionStore, similar *SimilarityIndex) *TagsHandler {
+	return &TagsHandler{
+		taxonomy:  taxonomy,
+		recipes:   recipes,
+		ctx:       ctx,
+		cache:     cache,
+		revisions: revisions,
+		similar:   similar,
+	}
+}
+
+// ListTagsHandler returns all tags with the number of recipes using them, most
+// used first.
+func (handler *TagsHandler) ListTagsHandler(ctx *gin.Context) {
+	cur, err := handler.recipes.Aggregate(handler.ctx, mongo.Pipeline{
+		{{Key: "$match", Value: active(bson.M{})}},
+		{{Key: "$unwind", Value: "$tags"}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"$toLower": "$tags"},
+			"count": bson.M{"$sum": 1},
+		}}},
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var counts []struct {
+		Tag   string `bson:"_id"`
+		Count int    `bson:"count"`
+	}
+	if err := cur.All(handler.ctx, &counts); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	cur, err = handler.taxonomy.collection.Find(handler.ctx, bson.M{})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	tags := make([]models.Tag, 0)
+	if err := cur.All(handler.ctx, &tags); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	usages := make(map[string]*models.TagUsage)
+	canonical := make(map[string]string)
+	for _, tag := range tags {
+		usages[tag.Name] = &models.TagUsage{Name: tag.Name, Label: tag.Translations[ctx.Query("lang")], Parent: tag.Parent}
+		for _, term := range tag.Terms {
+			canonical[term] = tag.Name
+		}
+	}
+	for _, count := range counts {
+		// Recipes written before a tag has been added to the taxonomy may
+		// still use one of its aliases.
+		name := models.NormalizeTag(count.Tag)
+		if c, found := canonical[name]; found {
+			name = c
+		}
+		usage, found := usages[name]
+		if !found {
+			usage = &models.TagUsage{Name: name}
+			usages[name] = usage
+		}
+		usage.Count += count.Count
+	}
+	list := make([]models.TagUsage, 0, len(usages))
+	for _, usage := range usages {
+		list = append(list, *usage)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Name < list[j].Name
+	})
+	ctx.JSON(http.StatusOK, list)
+}
+
+// NewTagHandler adds a tag to the taxonomy, recipes using one of its aliases
+// or translations are retagged.
+func (handler *TagsHandler) NewTagHandler(ctx *gin.Context) {
+	var tag models.Tag
+	if err := ctx.ShouldBindJSON(&tag); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tag.Normalize()
+	if !handler.validateParent(ctx, &tag) {
+		return
+	}
+	tag.ID = primitive.NewObjectID()
+	tag.CreatedAt = time.Now()
+	tag.UpdatedAt = tag.CreatedAt
+	_, err := handler.taxonomy.collection.InsertOne(handler.ctx, tag)
+	if mongo.IsDuplicateKeyError(err) {
+		ctx.JSON(http.StatusConflict, gin.H{"error": "A name of the tag belongs to another tag already, merge the tags instead"})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	retagged, ok := handler.retag(ctx, tag.Terms, tag.Name)
+	if !ok {
+		return
+	}
+	ctx.JSON(http.StatusCreated, gin.H{"tag": tag, "retagged": retagged})
+}
+
+// UpdateTagHandler updates the aliases, translations and parent of a tag,
+// recipes using one of its aliases or translations are retagged.
+func (handler *TagsHandler) UpdateTagHandler(ctx *gin.Context) {
+	existing, ok := handler.find(ctx)
+	if !ok {
+		return
+	}
+	var tag models.Tag
+	if err := ctx.ShouldBindJSON(&tag); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tag.Name = existing.Name
+	tag.Normalize()
+	if !handler.validateParent(ctx, &tag) {
+		return
+	}
+	tag.ID = existing.ID
+	tag.CreatedAt = existing.CreatedAt
+	tag.UpdatedAt = time.Now()
+	_, err := handler.taxonomy.collection.ReplaceOne(handler.ctx, bson.M{"_id": tag.ID}, tag)
+	if mongo.IsDuplicateKeyError(err) {
+		ctx.JSON(http.StatusConflict, gin.H{"error": "A name of the tag belongs to another tag already, merge the tags instead"})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	retagged, ok := handler.retag(ctx, tag.Terms, tag.Name)
+	if !ok {
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"tag": tag, "retagged": retagged})
+}
+
+// DeleteTagHandler removes a tag from the taxonomy, recipes keep the tag.
+func (handler *TagsHandler) DeleteTagHandler(ctx *gin.Context) {
+	tag, ok := handler.find(ctx)
+	if !ok {
+		return
+	}
+	if _, err := handler.taxonomy.collection.DeleteOne(handler.ctx, bson.M{"_id": tag.ID}); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := handler.taxonomy.collection.UpdateMany(handler.ctx, bson.M{"parent": tag.Name},
+		bson.M{"$unset": bson.M{"parent": ""}}); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "Tag has been deleted"})
+}
+
+// TagRename is the new name of a tag.
+type TagRename struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// RenameTagHandler renames a tag across all recipes, the old name becomes an
+// alias.
+func (handler *TagsHandler) RenameTagHandler(ctx *gin.Context) {
+	var input TagRename
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	from := models.NormalizeTag(ctx.Param("name"))
+	to := models.NormalizeTag(input.Name)
+	if to == "" || to == from {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "The new name must differ from the current one"})
+		return
+	}
+	other, err := handler.taxonomy.find(handler.ctx, bson.M{"terms": to})
+	if err == nil && other.Name != from {
+		ctx.JSON(http.StatusConflict, gin.H{"error": "The new name belongs to tag " + other.Name + ", merge the tags instead"})
+		return
+	}
+	handler.merge(ctx, to, []string{from})
+}
+
+// TagMerge lists the tags to merge into another one.
+type TagMerge struct {
+	Tags []string `json:"tags" binding:"required"`
+}
+
+// MergeTagsHandler merges tags into a tag across all recipes, the merged tags
+// become aliases.
+func (handler *TagsHandler) MergeTagsHandler(ctx *gin.Context) {
+	var input TagMerge
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	into := models.NormalizeTag(ctx.Param("name"))
+	if into == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tag name"})
+		return
+	}
+	handler.merge(ctx, into, input.Tags)
+}
+
+// merge folds the tags into the tag named into: their taxonomy entries are
+// combined, their names become aliases and all recipes are retagged.
+func (handler *TagsHandler) merge(ctx *gin.Context, into string, tags []string) {
+	target, err := handler.taxonomy.find(handler.ctx, bson.M{"name": into})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		target = &models.Tag{ID: primitive.NewObjectID(), Name: into, CreatedAt: time.Now()}
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	merged := make([]primitive.ObjectID, 0)
+	names := make([]string, 0)
+	taken := make([]string, 0)
+	for _, name := range tags {
+		name = models.NormalizeTag(name)
+		if name == "" || name == into || containsString(names, name) {
+			continue
+		}
+		names = append(names, name)
+		target.Aliases = append(target.Aliases, name)
+		tag, err := handler.taxonomy.find(handler.ctx, bson.M{"terms": name})
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if tag.ID == target.ID {
+			continue
+		}
+		merged = append(merged, tag.ID)
+		taken = append(taken, tag.Terms...)
+		names = append(names, tag.Name)
+		target.Aliases = append(target.Aliases, tag.Name)
+		target.Aliases = append(target.Aliases, tag.Aliases...)
+		for lang, translation := range tag.Translations {
+			if target.Translations == nil {
+				target.Translations = make(map[string]string)
+			}
+			if _, exists := target.Translations[lang]; !exists {
+				target.Translations[lang] = translation
+			} else {
+				target.Aliases = append(target.Aliases, translation)
+			}
+		}
+		if target.Parent == "" && tag.Parent != into {
+			target.Parent = tag.Parent
+		}
+	}
+	if len(names) == 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "No tags to merge"})
+		return
+	}
+	target.Normalize()
+	if containsString(names, target.Parent) {
+		target.Parent = ""
+	}
+	target.UpdatedAt = time.Now()
+	// The target is stored before the merged tags are deleted, so a failure
+	// in between loses no names. Until the merged tags are gone it leaves out
+	// their terms, which the unique index allows a single tag to have.
+	terms := target.Terms
+	target.Terms = make([]string, 0, len(terms))
+	for _, term := range terms {
+		if !containsString(taken, term) {
+			target.Terms = append(target.Terms, term)
+		}
+	}
+	_, err = handler.taxonomy.collection.ReplaceOne(handler.ctx, bson.M{"_id": target.ID}, target, options.Replace().SetUpsert(true))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(merged) > 0 {
+		if _, err := handler.taxonomy.collection.DeleteMany(handler.ctx, bson.M{"_id": bson.M{"$in": merged}}); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	target.Terms = terms
+	_, err = handler.taxonomy.collection.UpdateOne(handler.ctx, bson.M{"_id": target.ID}, bson.M{"$set": bson.M{"terms": terms}})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	_, err = handler.taxonomy.collection.UpdateMany(handler.ctx, bson.M{"parent": bson.M{"$in": names}},
+		bson.M{"$set": bson.M{"parent": into}})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	retagged, ok := handler.retag(ctx, target.Terms, target.Name)
+	if !ok {
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"tag": target, "retagged": retagged})
+}
+
+// tagPattern matches the tags normalized to the term, ignoring case and
+// leading, trailing and repeated white space like models.NormalizeTag.
+func tagPattern(term string) primitive.Regex {
+	words := strings.Fields(term)
+	for i, word := range words {
+		words[i] = regexp.QuoteMeta(word)
+	}
+	return primitive.Regex{Pattern: `^\s*` + strings.Join(words, `\s+`) + `\s*$`, Options: "i"}
+}
+
+// normalizedTag is an aggregation expression normalizing the tag like
+// models.NormalizeTag: lower cased with its words joined by single spaces.
+func normalizedTag(tag string) bson.M {
+	return bson.M{"$reduce": bson.M{
+		"input":        bson.M{"$regexFindAll": bson.M{"input": bson.M{"$toLower": tag}, "regex": `\S+`}},
+		"initialValue": "",
+		"in": bson.M{"$cond": bson.A{
+			bson.M{"$eq": bson.A{"$$value", ""}},
+			"$$this.match",
+			bson.M{"$concat": bson.A{"$$value", " ", "$$this.match"}},
+		}},
+	}}
+}
+
+// retag replaces the terms by the tag in all recipes, including the ones in
+// the trash, in a single update. Revisions, caches and the similarity index
+// are updated for the changed recipes.
+func (handler *TagsHandler) retag(ctx *gin.Context, terms []string, tag string) (int, bool) {
+	patterns := make(bson.A, 0, len(terms))
+	for _, term := range terms {
+		patterns = append(patterns, tagPattern(term))
+	}
+	// Recipes having the tag in its canonical spelling already are left alone.
+	filter := bson.M{"tags": bson.M{"$elemMatch": bson.M{"$in": patterns, "$ne": tag}}}
+	ids, err := handler.recipes.Distinct(handler.ctx, "_id", filter)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return 0, false
+	}
+	if len(ids) == 0 {
+		return 0, true
+	}
+	_, err = handler.recipes.UpdateMany(handler.ctx, bson.M{"_id": bson.M{"$in": ids}}, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"tags": bson.M{"$reduce": bson.M{
+			"input": bson.M{"$map": bson.M{
+				"input": "$tags",
+				"as":    "tag",
+				"in": bson.M{"$cond": bson.A{
+					bson.M{"$in": bson.A{normalizedTag("$$tag"), terms}},
+					tag,
+					"$$tag",
+				}},
+			}},
+			"initialValue": bson.A{},
+			"in": bson.M{"$cond": bson.A{
+				bson.M{"$in": bson.A{"$$this", "$$value"}},
+				"$$value",
+				bson.M{"$concatArrays": bson.A{"$$value", bson.A{"$$this"}}},
+			}},
+		}}}}},
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return 0, false
+	}
+	handler.cache.Invalidate(ctx)
+
+	cur, err := handler.recipes.Find(handler.ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return 0, false
+	}
+	defer cur.Close(handler.ctx)
+	for cur.Next(handler.ctx) {
+		var recipe models.Recipe
+		if err := cur.Decode(&recipe); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return 0, false
+		}
+		if err := handler.revisions.Record(handler.ctx, models.RevisionUpdate, author(ctx), recipe); err != nil {
+			log.Printf("Error while recording update revision of recipe %s: %v", recipe.ID.Hex(), err)
+		}
+		if recipe.DeletedAt != nil {
+			continue
+		}
+		if err := handler.similar.Update(handler.ctx, recipe); err != nil {
+			log.Printf("Error while indexing the similarity of recipe %s: %v", recipe.ID.Hex(), err)
+		}
+	}
+	return len(ids), true
+}
+
+// validateParent refuses parents that are unknown or would form a cycle.
+func (handler *TagsHandler) validateParent(ctx *gin.Context, tag *models.Tag) bool {
+	for parent, depth := tag.Parent, 0; parent != ""; depth++ {
+		if parent == tag.Name || depth > 100 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "A tag can't be its own ancestor"})
+			return false
+		}
+		ancestor, err := handler.taxonomy.find(handler.ctx, bson.M{"name": parent})
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Unknown parent tag " + parent})
+			return false
+		}
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return false
+		}
+		parent = ancestor.Parent
+	}
+	return true
+}
+
+// find loads the tag of the taxonomy addressed by the name parameter.
+func (handler *TagsHandler) find(ctx *gin.Context) (*models.Tag, bool) {
+	tag, err := handler.taxonomy.find(handler.ctx, bson.M{"name": models.NormalizeTag(ctx.Param("name"))})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Tag not found"})
+		return nil, false
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return tag, true
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"github.com/aheadxnet/go-sandbox/models"
+	"regexp"
+	"testing"
+)
+
+// TestTagPattern checks that the pattern finding the recipes to retag matches
+// the tags normalized to the term and nothing else.
+func TestTagPattern(t *testing.T) {
+	pattern := tagPattern("weeknight dinner")
+	matcher := regexp.MustCompile("(?" + pattern.Options + ")" + pattern.Pattern)
+	for _, tag := range []string{"weeknight dinner", "Weeknight Dinner ", " weeknight  dinner", "WEEKNIGHT\tdinner"} {
+		if models.NormalizeTag(tag) != "weeknight dinner" {
+			t.Fatalf("%q is not normalized to the term", tag)
+		}
+		if !matcher.MatchString(tag) {
+			t.Errorf("%q is not matched", tag)
+		}
+	}
+	for _, tag := range []string{"weeknight dinners", "weeknightdinner", "weeknight.dinner"} {
+		if matcher.MatchString(tag) {
+			t.Errorf("%q is matched", tag)
+		}
+	}
+	if quoted := tagPattern("c++"); !regexp.MustCompile(quoted.Pattern).MatchString("c++") {
+		t.Errorf("%q does not match c++", quoted.Pattern)
+	}
+}
@@ -0,0 +1,502 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aheadxnet/go-sandbox/models"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TenantHeader is the request header naming the tenant.
+const TenantHeader = "X-Tenant"
+
+// tenantCheckInterval is how long a loaded tenant is served before its
+// registration is checked again, so tenants deprovisioned by another
+// instance stop being served.
+const tenantCheckInterval = 30 * time.Second
+
+// tenantName restricts tenant names to what is safe in database names, Redis
+// keys and host names.
+var tenantName = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,30}[a-z0-9])?$`)
+
+var (
+	ErrUnknownTenant     = errors.New("unknown tenant")
+	ErrTenantExists      = errors.New("tenant exists already")
+	ErrInvalidTenantName = errors.New("tenant names consist of up to 32 lower case letters, digits and dashes")
+)
+
+// TenantConfig holds the settings shared by all tenants.
+type TenantConfig struct {
+	TrashRetention    time.Duration
+	RevisionRetention int
+	SimilarLimit      int
+	MaxImageSize      int64
+	JWTSecret         string
+	IdempotencyTTL    time.Duration
+	ValidateRequests  bool
+}
+
+// Tenant holds the stores and handlers of one of the brands sharing a
+// deployment, working on a database and Redis keys of its own. The default
+// tenant has no name and no key prefix.
+type Tenant struct {
+	Name        string
+	Database    *mongo.Database
+	RedisPrefix string
+
+	Recipes   *mongo.Collection
+	Reviews   *mongo.Collection
+	MealPlans *mongo.Collection
+	Cache     *RecipeCache
+	Revisions *RevisionStore
+	Images    *ImageStore
+	Cookbooks *CookbookStore
+	Similar   *SimilarityIndex
+	Tags      *TagTaxonomy
+
+	Idempotency *IdempotencyStore
+
+	RecipesHandler   *RecipesHandler
+	AuthHandler      *AuthHandler
+	MealPlansHandler *MealPlansHandler
+	ImagesHandler    *ImagesHandler
+	ReviewsHandler   *ReviewsHandler
+	CookbooksHandler *CookbooksHandler
+	TagsHandler      *TagsHandler
+
+	trashRetention   time.Duration
+	validateRequests bool
+}
+
+// TenantPrefix returns the prefix of the Redis keys of a tenant.
+func TenantPrefix(name string) string {
+	if name == "" {
+		return ""
+	}
+	return "tenant:" + name + ":"
+}
+
+// NewTenant creates the stores and handlers of a tenant.
+func NewTenant(ctx context.Context, name string, database *mongo.Database, redisClient *redis.Client, config TenantConfig) *Tenant {
+	tenant := &Tenant{
+		Name:             name,
+		Database:         database,
+		RedisPrefix:      TenantPrefix(name),
+		Recipes:          database.Collection("recipes"),
+		Reviews:          database.Collection("reviews"),
+		MealPlans:        database.Collection("meal_plans"),
+		Revisions:        NewRevisionStore(database.Collection("recipe_revisions"), config.RevisionRetention),
+		Images:           NewImageStore(database, "images"),
+		Cookbooks:        NewCookbookStore(database.Collection("cookbooks"), database.Collection("favorites")),
+		Tags:             NewTagTaxonomy(database.Collection("tags")),
+		trashRetention:   config.TrashRetention,
+		validateRequests: config.ValidateRequests,
+	}
+	tenant.Cache = NewRecipeCache(redisClient, tenant.RedisPrefix)
+	tenant.Similar = NewSimilarityIndex(redisClient, tenant.RedisPrefix, config.SimilarLimit)
+	tenant.Idempotency = NewIdempotencyStore(redisClient, tenant.RedisPrefix, config.IdempotencyTTL, config.MaxImageSize+maxEnvelopeSize)
+
+	tenant.RecipesHandler = NewRecipesHandler(ctx, tenant.Recipes, tenant.Cache, tenant.Revisions, tenant.Images, tenant.Reviews, tenant.Cookbooks, tenant.MealPlans, tenant.Similar, tenant.Tags)
+	tenant.ImagesHandler = NewImagesHandler(ctx, tenant.Recipes, tenant.Images, tenant.Cache, config.MaxImageSize)
+	tenant.AuthHandler = NewAuthHandler(ctx, database.Collection("users"), config.JWTSecret, name)
+	tenant.MealPlansHandler = NewMealPlansHandler(ctx, tenant.MealPlans, tenant.Recipes, name)
+	tenant.ReviewsHandler = NewReviewsHandler(ctx, tenant.Reviews, tenant.Recipes, tenant.Cache)
+	tenant.CookbooksHandler = NewCookbooksHandler(ctx, tenant.Cookbooks, tenant.Recipes)
+	tenant.TagsHandler = NewTagsHandler(ctx, tenant.Tags, tenant.Recipes, tenant.Cache, tenant.Revisions, tenant.Similar)
+	return tenant
+}
+
+// Indexes declares the indexes of all collections of the tenant.
+func (tenant *Tenant) Indexes() []IndexSet {
+	sets := []IndexSet{
+		{Collection: tenant.Recipes, Indexes: RecipeIndexes(tenant.trashRetention)},
+		{Collection: tenant.Reviews, Indexes: reviewIndexes()},
+		tenant.Revisions.Indexes(),
+		tenant.Tags.Indexes(),
+		tenant.AuthHandler.Indexes(),
+		tenant.MealPlansHandler.Indexes(),
+	}
+	return append(sets, tenant.Cookbooks.Indexes()...)
+}
+
+// ReconcileIndexes makes the indexes of all collections of the tenant match
+// the declared ones, or only reports the differences in a dry run.
+func (tenant *Tenant) ReconcileIndexes(ctx context.Context, dryRun bool) ([]IndexReport, error) {
+	reports := make([]IndexReport, 0)
+	for _, set := range tenant.Indexes() {
+		report, err := ReconcileIndexes(ctx, set, dryRun)
+		reports = append(reports, report)
+		if err != nil {
+			return reports, fmt.Errorf("indexes of %s: %w", set.Collection.Name(), err)
+		}
+	}
+	return reports, nil
+}
+
+// EnsureIndexes reconciles the indexes of all collections of the tenant,
+// only logging changes and failures.
+func (tenant *Tenant) EnsureIndexes(ctx context.Context) {
+	reports, err := tenant.ReconcileIndexes(ctx, false)
+	for _, report := range reports {
+		for _, name := range report.Added {
+			log.Printf("Created index %s of %s", name, report.Collection)
+		}
+		for _, drift := range report.Changed {
+			log.Printf("Recreated index %s of %s, it was %s instead of %s", drift.Name, report.Collection, drift.Existing, drift.Declared)
+		}
+		for _, name := range report.Dropped {
+			log.Printf("Dropped undeclared index %s of %s", name, report.Collection)
+		}
+	}
+	if err != nil {
+		log.Println("Error while reconciling the indexes:", err)
+	}
+}
+
+// Tenants resolves the tenant of every request and passes it on to the
+// routes of that tenant. Tenants are registered in the database of the
+// default tenant, their data is kept in databases named after them.
+type Tenants struct {
+	ctx         context.Context
+	client      *mongo.Client
+	collection  *mongo.Collection
+	redisClient *redis.Client
+	database    string
+	domain      string
+	config      TenantConfig
+	auth        *AuthHandler
+	routes      func(*Tenant) http.Handler
+	Default     *Tenant
+
+	mutex    sync.Mutex
+	tenants  map[string]*Tenant
+	checked  map[string]time.Time
+	handlers map[string]http.Handler
+}
+
+// NewTenants creates the registry of the tenants of a deployment, the
+// default tenant using the given database. Subdomains of domain name
+// tenants, routes creates the routes of a tenant on first use.
+func NewTenants(ctx context.Context, client *mongo.Client, database string, redisClient *redis.Client, domain string, config TenantConfig, routes func(*Tenant) http.Handler) *Tenants {
+	return &Tenants{
+		ctx:         ctx,
+		client:      client,
+		collection:  client.Database(database).Collection("tenants"),
+		redisClient: redisClient,
+		database:    database,
+		domain:      strings.ToLower(domain),
+		config:      config,
+		auth:        NewAuthHandler(ctx, nil, config.JWTSecret, ""),
+		routes:      routes,
+		Default:     NewTenant(ctx, "", client.Database(database), redisClient, config),
+		tenants:     make(map[string]*Tenant),
+		checked:     make(map[string]time.Time),
+		handlers:    make(map[string]http.Handler),
+	}
+}
+
+// DatabaseName returns the name of the database of a tenant.
+func (tenants *Tenants) DatabaseName(name string) string {
+	if name == "" {
+		return tenants.database
+	}
+	return tenants.database + "_" + name
+}
+
+// Resolve returns the name of the tenant a request is meant for, taken from
+// the X-Tenant header, the subdomain or the token, in that order. Calendar
+// apps can send none of them, so feeds name the tenant in the tenant query
+// parameter. It is empty for the default tenant.
+func (tenants *Tenants) Resolve(request *http.Request) string {
+	if name := request.Header.Get(TenantHeader); name != "" {
+		return strings.ToLower(strings.TrimSpace(name))
+	}
+	if strings.HasPrefix(request.URL.Path, "/feeds/") {
+		if name := request.URL.Query().Get("tenant"); name != "" {
+			return strings.ToLower(strings.TrimSpace(name))
+		}
+	}
+	if tenants.domain != "" {
+		host := strings.ToLower(request.Host)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if subdomain := strings.TrimSuffix(host, "."+tenants.domain); subdomain != host && !strings.Contains(subdomain, ".") {
+			return subdomain
+		}
+	}
+	if header := request.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		if claims, err := tenants.auth.Verify(strings.TrimPrefix(header, "Bearer ")); err == nil {
+			return claims.Tenant
+		}
+	}
+	return ""
+}
+
+// ServeHTTP passes the request on to the routes of its tenant.
+func (tenants *Tenants) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	name := tenants.Resolve(request)
+	handler, err := tenants.handler(name)
+	if errors.Is(err, ErrUnknownTenant) {
+		writeJSON(writer, http.StatusNotFound, gin.H{"error": "Unknown tenant " + strconv.Quote(name)})
+		return
+	}
+	if err != nil {
+		writeJSON(writer, http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	handler.ServeHTTP(writer, request)
+}
+
+func (tenants *Tenants) handler(name string) (http.Handler, error) {
+	tenant, err := tenants.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	tenants.mutex.Lock()
+	defer tenants.mutex.Unlock()
+	handler, found := tenants.handlers[name]
+	if !found {
+		handler = tenants.routes(tenant)
+		tenants.handlers[name] = handler
+	}
+	return handler, nil
+}
+
+// Get returns a registered tenant, the default tenant for the empty name.
+// Loaded tenants are served for tenantCheckInterval before their
+// registration is looked up again.
+func (tenants *Tenants) Get(name string) (*Tenant, error) {
+	if name == "" {
+		return tenants.Default, nil
+	}
+	tenants.mutex.Lock()
+	tenant, found := tenants.tenants[name]
+	fresh := found && time.Since(tenants.checked[name]) < tenantCheckInterval
+	tenants.mutex.Unlock()
+	if fresh {
+		return tenant, nil
+	}
+	if !tenantName.MatchString(name) {
+		return nil, ErrUnknownTenant
+	}
+	var registered models.Tenant
+	err := tenants.collection.FindOne(tenants.ctx, bson.M{"_id": name}).Decode(&registered)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Deprovisioned by another instance.
+		tenants.forget(name)
+		return nil, ErrUnknownTenant
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tenants.load(registered), nil
+}
+
+// load returns the tenant of a registration, creating it on first use, and
+// records that its registration has been checked.
+func (tenants *Tenants) load(registered models.Tenant) *Tenant {
+	tenants.mutex.Lock()
+	defer tenants.mutex.Unlock()
+	tenants.checked[registered.Name] = time.Now()
+	if tenant, found := tenants.tenants[registered.Name]; found && tenant.Database.Name() == registered.Database {
+		return tenant
+	}
+	tenant := NewTenant(tenants.ctx, registered.Name, tenants.client.Database(registered.Database), tenants.redisClient, tenants.config)
+	tenants.tenants[registered.Name] = tenant
+	delete(tenants.handlers, registered.Name)
+	return tenant
+}
+
+// forget drops a tenant and its routes from the cache.
+func (tenants *Tenants) forget(name string) {
+	tenants.mutex.Lock()
+	defer tenants.mutex.Unlock()
+	delete(tenants.tenants, name)
+	delete(tenants.checked, name)
+	delete(tenants.handlers, name)
+}
+
+// All returns the default tenant followed by all registered tenants.
+func (tenants *Tenants) All() ([]*Tenant, error) {
+	registered, err := tenants.List()
+	if err != nil {
+		return nil, err
+	}
+	all := []*Tenant{tenants.Default}
+	for _, r := range registered {
+		all = append(all, tenants.load(r))
+	}
+	return all, nil
+}
+
+// List returns the registered tenants by name.
+func (tenants *Tenants) List() ([]models.Tenant, error) {
+	cur, err := tenants.collection.Find(tenants.ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	registered := make([]models.Tenant, 0)
+	if err := cur.All(tenants.ctx, &registered); err != nil {
+		return nil, err
+	}
+	return registered, nil
+}
+
+// Provision registers a new tenant, creates its indexes and, if given, its
+// first admin user.
+func (tenants *Tenants) Provision(name string, admin *models.User) (*models.Tenant, error) {
+	if !tenantName.MatchString(name) {
+		return nil, ErrInvalidTenantName
+	}
+	registered := models.Tenant{
+		Name:        name,
+		Database:    tenants.DatabaseName(name),
+		RedisPrefix: TenantPrefix(name),
+		CreatedAt:   time.Now(),
+	}
+	_, err := tenants.collection.InsertOne(tenants.ctx, registered)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrTenantExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	tenant := tenants.load(registered)
+	tenant.EnsureIndexes(tenants.ctx)
+	if admin != nil {
+		hash, err := HashPassword(admin.Password)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tenant.Database.Collection("users").InsertOne(tenants.ctx, models.User{
+			Username: admin.Username,
+			Password: hash,
+			Role:     models.RoleAdmin,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &registered, nil
+}
+
+// Deprovision unregisters a tenant and deletes its database and Redis keys.
+func (tenants *Tenants) Deprovision(name string) error {
+	var registered models.Tenant
+	err := tenants.collection.FindOneAndDelete(tenants.ctx, bson.M{"_id": name}).Decode(&registered)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrUnknownTenant
+	}
+	if err != nil {
+		return err
+	}
+	tenants.forget(name)
+	if err := tenants.client.Database(registered.Database).Drop(tenants.ctx); err != nil {
+		return err
+	}
+	return deleteKeys(tenants.ctx, tenants.redisClient, registered.RedisPrefix+"*")
+}
+
+// deleteKeys deletes all Redis keys matching a pattern.
+func deleteKeys(ctx context.Context, client *redis.Client, pattern string) error {
+	iter := client.Scan(ctx, 0, pattern, 1000).Iterator()
+	for iter.Next(ctx) {
+		if err := client.Del(ctx, iter.Val()).Err(); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+func writeJSON(writer http.ResponseWriter, status int, value interface{}) {
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	writer.WriteHeader(status)
+	json.NewEncoder(writer).Encode(value)
+}
+
+type TenantsHandler struct {
+	tenants *Tenants
+}
+
+func NewTenantsHandler(tenants *Tenants) *TenantsHandler {
+	return &TenantsHandler{
+		tenants: tenants,
+	}
+}
+
+// ListTenantsHandler returns the registered tenants, admins of the default
+// tenant only.
+func (handler *TenantsHandler) ListTenantsHandler(ctx *gin.Context) {
+	registered, err := handler.tenants.List()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, registered)
+}
+
+// TenantRequest is a tenant to provision.
+type TenantRequest struct {
+	// the name of the tenant
+	Name string `json:"name" binding:"required"`
+
+	// the first admin user of the tenant, if any
+	Admin *models.User `json:"admin"`
+}
+
+// NewTenantHandler provisions a new tenant with an empty database, admins of
+// the default tenant only.
+func (handler *TenantsHandler) NewTenantHandler(ctx *gin.Context) {
+	var request TenantRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.Admin != nil && (request.Admin.Username == "" || request.Admin.Password == "") {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "The admin needs a username and a password"})
+		return
+	}
+	tenant, err := handler.tenants.Provision(request.Name, request.Admin)
+	if errors.Is(err, ErrInvalidTenantName) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, ErrTenantExists) {
+		ctx.JSON(http.StatusConflict, gin.H{"error": "Tenant " + strconv.Quote(request.Name) + " exists already"})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated, tenant)
+}
+
+// DeleteTenantHandler deprovisions a tenant, deleting its database and cache,
+// admins of the default tenant only.
+func (handler *TenantsHandler) DeleteTenantHandler(ctx *gin.Context) {
+	err := handler.tenants.Deprovision(ctx.Param("name"))
+	if errors.Is(err, ErrUnknownTenant) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "Tenant has been deleted"})
+}
@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/aheadxnet/go-sandbox/models"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const testSecret = "test-secret"
+
+// newTestTenants creates a registry without connecting to MongoDB or Redis,
+// both connect lazily.
+func newTestTenants(t *testing.T, uri string, routes func(*Tenant) http.Handler) (*Tenants, *mongo.Client, *redis.Client) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	client, err := mongo.NewClient(options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(ctx) })
+	redisClient := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	t.Cleanup(func() { redisClient.Close() })
+	tenants := NewTenants(ctx, client, "recipes_test", redisClient, "recipes.example.com", TenantConfig{
+		TrashRetention: time.Hour,
+		SimilarLimit:   10,
+		MaxImageSize:   1 << 20,
+		JWTSecret:      testSecret,
+	}, routes)
+	return tenants, client, redisClient
+}
+
+func TestResolveTenant(t *testing.T) {
+	tenants, _, _ := newTestTenants(t, "mongodb://localhost:27017", nil)
+	token, err := NewAuthHandler(context.Background(), nil, testSecret, "brand-b").Sign(Claims{
+		Username:  "alice",
+		Tenant:    "brand-b",
+		ExpiresAt: time.Now().Add(time.Minute).Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		host    string
+		target  string
+		headers map[string]string
+		want    string
+	}{
+		{"default", "localhost:8080", "/recipes", nil, ""},
+		{"header", "localhost:8080", "/recipes", map[string]string{TenantHeader: "Brand-A"}, "brand-a"},
+		{"subdomain", "brand-a.recipes.example.com", "/recipes", nil, "brand-a"},
+		{"subdomain with port", "brand-a.recipes.example.com:8080", "/recipes", nil, "brand-a"},
+		{"domain itself", "recipes.example.com", "/recipes", nil, ""},
+		{"nested subdomain", "x.brand-a.recipes.example.com", "/recipes", nil, ""},
+		{"token", "localhost:8080", "/recipes", map[string]string{"Authorization": "Bearer " + token}, "brand-b"},
+		{"invalid token", "localhost:8080", "/recipes", map[string]string{"Authorization": "Bearer nonsense"}, ""},
+		{"header before token", "localhost:8080", "/recipes", map[string]string{TenantHeader: "brand-a", "Authorization": "Bearer " + token}, "brand-a"},
+		{"subdomain before token", "brand-a.recipes.example.com", "/recipes", map[string]string{"Authorization": "Bearer " + token}, "brand-a"},
+		{"feed", "localhost:8080", "/feeds/mealplans/abc?tenant=brand-a", nil, "brand-a"},
+		{"query of other paths", "localhost:8080", "/recipes?tenant=brand-a", nil, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, test.target, nil)
+			request.Host = test.host
+			for key, value := range test.headers {
+				request.Header.Set(key, value)
+			}
+			if got := tenants.Resolve(request); got != test.want {
+				t.Errorf("Resolve() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTenantsAreIsolated(t *testing.T) {
+	tenants, client, redisClient := newTestTenants(t, "mongodb://localhost:27017", nil)
+	ctx := context.Background()
+	a := NewTenant(ctx, "brand-a", client.Database(tenants.DatabaseName("brand-a")), redisClient, tenants.config)
+	b := NewTenant(ctx, "brand-b", client.Database(tenants.DatabaseName("brand-b")), redisClient, tenants.config)
+	all := []*Tenant{tenants.Default, a, b}
+
+	databases := make(map[string]bool)
+	keys := make(map[string]bool)
+	for _, tenant := range all {
+		for _, collection := range []*mongo.Collection{
+			tenant.Recipes, tenant.Reviews, tenant.Revisions.collection, tenant.Cookbooks.cookbooks,
+			tenant.Cookbooks.favorites, tenant.Tags.collection, tenant.AuthHandler.collection,
+			tenant.MealPlansHandler.collection, tenant.MealPlansHandler.recipes, tenant.RecipesHandler.mealPlans, tenant.ImagesHandler.recipes,
+			tenant.ReviewsHandler.recipes, tenant.CookbooksHandler.recipes, tenant.TagsHandler.recipes,
+		} {
+			if collection.Database() != tenant.Database {
+				t.Errorf("tenant %q uses collection %s of database %s", tenant.Name, collection.Name(), collection.Database().Name())
+			}
+		}
+		if databases[tenant.Database.Name()] {
+			t.Errorf("database %s is shared", tenant.Database.Name())
+		}
+		databases[tenant.Database.Name()] = true
+
+		for _, cache := range []*RecipeCache{tenant.Cache, tenant.RecipesHandler.cache, tenant.ImagesHandler.cache,
+			tenant.ReviewsHandler.cache, tenant.TagsHandler.cache} {
+			if cache != tenant.Cache {
+				t.Errorf("tenant %q uses another recipe cache", tenant.Name)
+			}
+		}
+		for _, key := range []string{tenant.Cache.key, tenant.Similar.featuresKey(), tenant.Similar.recipeKey("1"), tenant.Similar.featureKey("tag:cake")} {
+			if keys[key] {
+				t.Errorf("Redis key %s is shared", key)
+			}
+			keys[key] = true
+		}
+	}
+	if a.Cache.key != "tenant:brand-a:recipes" || tenants.Default.Cache.key != "recipes" {
+		t.Errorf("unexpected cache keys %q and %q", a.Cache.key, tenants.Default.Cache.key)
+	}
+}
+
+func TestTokenOfAnotherTenantIsRejected(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	a := NewAuthHandler(ctx, nil, testSecret, "brand-a")
+	b := NewAuthHandler(ctx, nil, testSecret, "brand-b")
+	token, err := a.Sign(Claims{Username: "alice", Tenant: "brand-a", ExpiresAt: time.Now().Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		handler *AuthHandler
+		want    int
+	}{{a, http.StatusOK}, {b, http.StatusUnauthorized}} {
+		router := gin.New()
+		router.GET("/", test.handler.AuthMiddleware(), func(ctx *gin.Context) { ctx.Status(http.StatusOK) })
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		if recorder.Code != test.want {
+			t.Errorf("tenant %q answered %d, want %d", test.handler.tenant, recorder.Code, test.want)
+		}
+	}
+}
+
+func TestServeHTTPDispatchesByTenant(t *testing.T) {
+	tenants, _, _ := newTestTenants(t, "mongodb://localhost:27017", func(tenant *Tenant) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			writer.Write([]byte("tenant " + strconv.Quote(tenant.Name)))
+		})
+	})
+	recorder := httptest.NewRecorder()
+	tenants.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/recipes", nil))
+	if recorder.Code != http.StatusOK || recorder.Body.String() != `tenant ""` {
+		t.Errorf("default tenant answered %d %s", recorder.Code, recorder.Body)
+	}
+	request := httptest.NewRequest(http.MethodGet, "/recipes", nil)
+	request.Header.Set(TenantHeader, "no such tenant!")
+	recorder = httptest.NewRecorder()
+	tenants.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("unknown tenant answered %d, want 404", recorder.Code)
+	}
+}
+
+// TestTenantsCannotSeeEachOther runs against the MongoDB given by
+// TEST_MONGO_URI and a Redis on localhost:6379.
+func TestTenantsCannotSeeEachOther(t *testing.T) {
+	uri := os.Getenv("TEST_MONGO_URI")
+	if uri == "" {
+		t.Skip("TEST_MONGO_URI not set")
+	}
+	ctx := context.Background()
+	tenants, client, redisClient := newTestTenants(t, uri, func(tenant *Tenant) http.Handler {
+		router := gin.New()
+		router.POST("/recipes", tenant.RecipesHandler.NewRecipeHandler)
+		router.GET("/recipes", tenant.RecipesHandler.ListRecipesHandler)
+		return router
+	})
+	if err := redisClient.Ping(ctx).Err(); err != nil {
+		t.Skip("Redis not available:", err)
+	}
+	for _, name := range []string{"brand-a", "brand-b"} {
+		tenants.Deprovision(name)
+		if _, err := tenants.Provision(name, nil); err != nil {
+			t.Fatal(err)
+		}
+		defer tenants.Deprovision(name)
+	}
+	defer client.Database("recipes_test").Drop(ctx)
+
+	do := func(tenant string, method string, body interface{}) []models.Recipe {
+		data, _ := json.Marshal(body)
+		request := httptest.NewRequest(method, "/recipes", bytes.NewReader(data))
+		request.Header.Set(TenantHeader, tenant)
+		request.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		tenants.ServeHTTP(recorder, request)
+		if recorder.Code >= 300 {
+			t.Fatalf("%s /recipes for %q: %d %s", method, tenant, recorder.Code, recorder.Body)
+		}
+		var recipes []models.Recipe
+		if method == http.MethodGet {
+			if err := json.Unmarshal(recorder.Body.Bytes(), &recipes); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return recipes
+	}
+
+	// Fill the cache of brand-b with its empty list first, then add a recipe
+	// for brand-a and list both again.
+	if recipes := do("brand-b", http.MethodGet, nil); len(recipes) != 0 {
+		t.Fatalf("brand-b starts with %d recipes", len(recipes))
+	}
+	do("brand-a", http.MethodPost, models.Recipe{Name: "Käsekuchen", Ingredients: []string{"500 g Quark"}})
+	if recipes := do("brand-a", http.MethodGet, nil); len(recipes) != 1 {
+		t.Errorf("brand-a sees %d recipes, want 1", len(recipes))
+	}
+	if recipes := do("brand-a", http.MethodGet, nil); len(recipes) != 1 {
+		t.Errorf("brand-a sees %d cached recipes, want 1", len(recipes))
+	}
+	if recipes := do("brand-b", http.MethodGet, nil); len(recipes) != 0 {
+		t.Errorf("brand-b sees %d recipes of brand-a", len(recipes))
+	}
+	if n, _ := redisClient.Exists(ctx, "tenant:brand-a:recipes", "tenant:brand-b:recipes").Result(); n != 2 {
+		t.Errorf("%d of the tenant caches exist, want 2", n)
+	}
+}
+
+// TestDeprovisionedTenantIsForgotten runs against the MongoDB given by
+// TEST_MONGO_URI and a Redis on localhost:6379.
+func TestDeprovisionedTenantIsForgotten(t *testing.T) {
+	uri := os.Getenv("TEST_MONGO_URI")
+	if uri == "" {
+		t.Skip("TEST_MONGO_URI not set")
+	}
+	routes := func(tenant *Tenant) http.Handler { return http.NotFoundHandler() }
+	// Two instances of the API sharing the registry.
+	first, client, _ := newTestTenants(t, uri, routes)
+	second, _, _ := newTestTenants(t, uri, routes)
+	defer client.Database("recipes_test").Drop(context.Background())
+	first.Deprovision("brand-c")
+	if _, err := first.Provision("brand-c", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.handler("brand-c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Deprovision("brand-c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := first.Get("brand-c"); err != ErrUnknownTenant {
+		t.Errorf("deprovisioning instance got %v, want ErrUnknownTenant", err)
+	}
+	second.checked["brand-c"] = time.Now().Add(-tenantCheckInterval)
+	if _, err := second.Get("brand-c"); err != ErrUnknownTenant {
+		t.Errorf("other instance got %v, want ErrUnknownTenant", err)
+	}
+	if _, found := second.handlers["brand-c"]; found {
+		t.Error("other instance still caches the routes of the deprovisioned tenant")
+	}
+}
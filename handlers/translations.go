@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/aheadxnet/go-sandbox/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SearchIndexName is the name of the full text index over all languages of
+// the recipes.
+const SearchIndexName = "texts_text"
+
+// searchIndex is the full text index on the texts of the recipes in all
+// their languages, each stemmed according to its language.
+func searchIndex() mongo.IndexModel {
+	return mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "texts.name", Value: "text"},
+			{Key: "texts.ingredients", Value: "text"},
+			{Key: "texts.instructions", Value: "text"},
+		},
+		Options: options.Index().
+			SetName(SearchIndexName).
+			SetDefaultLanguage("none").
+			SetLanguageOverride("textLanguage").
+			SetWeights(bson.M{"texts.name": 10, "texts.ingredients": 5, "texts.instructions": 1}),
+	}
+}
+
+// preferredLanguages returns the languages of the lang parameter followed by
+// the ones of the Accept-Language header, best first.
+func preferredLanguages(ctx *gin.Context) []string {
+	languages := make([]string, 0)
+	for _, language := range strings.Split(ctx.Query("lang"), ",") {
+		if language = models.NormalizeLanguage(language); language != "" {
+			languages = append(languages, language)
+		}
+	}
+	type weighted struct {
+		language string
+		quality  float64
+	}
+	accepted := make([]weighted, 0)
+	for _, part := range strings.Split(ctx.GetHeader("Accept-Language"), ",") {
+		fields := strings.Split(part, ";")
+		language := models.NormalizeLanguage(fields[0])
+		quality := 1.0
+		for _, param := range fields[1:] {
+			if q := strings.TrimSpace(param); strings.HasPrefix(q, "q=") {
+				if value, err := strconv.ParseFloat(q[2:], 64); err == nil {
+					quality = value
+				}
+			}
+		}
+		if language != "" && quality > 0 {
+			accepted = append(accepted, weighted{language, quality})
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool { return accepted[i].quality > accepted[j].quality })
+	for _, a := range accepted {
+		languages = append(languages, a.language)
+	}
+	return languages
+}
+
+// normalizeTranslations normalizes the language of a new recipe and the keys
+// of its translations, dropping translations with invalid language tags or
+// in the language of the recipe itself.
+func normalizeTranslations(recipe *models.Recipe) {
+	recipe.Language = models.NormalizeLanguage(recipe.Language)
+	translations := make(map[string]models.RecipeTranslation)
+	for language, translation := range recipe.Translations {
+		language = models.NormalizeLanguage(language)
+		if language != "" && language != recipe.Language {
+			translations[language] = translation
+		}
+	}
+	recipe.Translations = translations
+	if len(translations) == 0 {
+		recipe.Translations = nil
+	}
+}
+
+// updateTexts stores the search texts of a recipe after its content or its
+// translations have changed.
+func (handler *RecipesHandler) updateTexts(recipe *models.Recipe) {
+	recipe.Texts = recipe.SearchTexts()
+	_, err := handler.collection.UpdateOne(handler.ctx, bson.M{"_id": recipe.ID},
+		bson.M{"$set": bson.M{"texts": recipe.Texts}})
+	if err != nil {
+		log.Printf("Error while updating the search texts of recipe %s: %v", recipe.ID.Hex(), err)
+	}
+}
+
+// ListTranslationsHandler returns the translations of a recipe by language.
+func (handler *RecipesHandler) ListTranslationsHandler(ctx *gin.Context) {
+	objectId, ok := recipeID(ctx, handler.collection, "id")
+	if !ok {
+		return
+	}
+	var recipe models.Recipe
+	err := handler.collection.FindOne(handler.ctx, active(bson.M{"_id": objectId})).Decode(&recipe)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Recipe not found"})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	translations := recipe.Translations
+	if translations == nil {
+		translations = make(map[string]models.RecipeTranslation)
+	}
+	renderData(ctx, http.StatusOK, gin.H{"language": recipe.Language, "translations": translations})
+}
+
+// PutTranslationHandler adds or replaces the translation of a recipe into a
+// language.
+func (handler *RecipesHandler) PutTranslationHandler(ctx *gin.Context) {
+	var translation models.RecipeTranslation
+	if err := bindData(ctx, &translation); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	language, ok := translationLanguage(ctx)
+	if !ok {
+		return
+	}
+	objectId, ok := recipeID(ctx, handler.collection, "id")
+	if !ok {
+		return
+	}
+	handler.updateTranslation(ctx, bson.M{"_id": objectId, "language": bson.M{"$ne": language}},
+		bson.M{"$set": bson.M{"translations." + language: translation}})
+}
+
+// DeleteTranslationHandler deletes the translation of a recipe into a
+// language.
+func (handler *RecipesHandler) DeleteTranslationHandler(ctx *gin.Context) {
+	language, ok := translationLanguage(ctx)
+	if !ok {
+		return
+	}
+	objectId, ok := recipeID(ctx, handler.collection, "id")
+	if !ok {
+		return
+	}
+	handler.updateTranslation(ctx, bson.M{"_id": objectId, "translations." + language: bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"translations." + language: ""}})
+}
+
+func (handler *RecipesHandler) updateTranslation(ctx *gin.Context, filter bson.M, update bson.M) {
+	var recipe models.Recipe
+	err := handler.collection.FindOneAndUpdate(handler.ctx, active(filter), update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&recipe)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Recipe or translation not found, the recipe itself is edited with PUT /recipes/{id}"})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	handler.updateTexts(&recipe)
+	handler.recordRevision(ctx, models.RevisionUpdate, recipe)
+	handler.cache.Invalidate(ctx)
+	renderData(ctx, http.StatusOK, recipe)
+}
+
+func translationLanguage(ctx *gin.Context) (string, bool) {
+	language := models.NormalizeLanguage(ctx.Param("lang"))
+	if language == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid language code " + strconv.Quote(ctx.Param("lang"))})
+		return "", false
+	}
+	return language, true
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/aheadxnet/go-sandbox/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"net/http"
+	"time"
+)
+
+// TrashIndexName is the name of the TTL index purging trashed recipes.
+const TrashIndexName = "deletedAt_ttl"
+
+// trashIndex is the TTL index letting MongoDB purge recipes that have been
+// in the trash longer than the retention.
+func trashIndex(retention time.Duration) mongo.IndexModel {
+	return mongo.IndexModel{
+		Keys: bson.D{{Key: "deletedAt", Value: 1}},
+		Options: options.Index().
+			SetName(TrashIndexName).
+			SetExpireAfterSeconds(int32(retention.Seconds())),
+	}
+}
+
+// ListTrashHandler returns the recipes in the trash.
+func (handler *RecipesHandler) ListTrashHandler(ctx *gin.Context) {
+	cur, err := handler.collection.Find(handler.ctx, bson.M{"deletedAt": bson.M{"$ne": nil}},
+		options.Find().SetSort(bson.D{{Key: "deletedAt", Value: -1}}))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recipes := make([]models.Recipe, 0)
+	if err := cur.All(handler.ctx, &recipes); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if recipes, ok := paginate(ctx, recipes); ok {
+		renderData(ctx, http.StatusOK, recipes)
+	}
+}
+
+// RestoreRecipeHandler restores a recipe from the trash.
+func (handler *RecipesHandler) RestoreRecipeHandler(ctx *gin.Context) {
+	objectId, ok := recipeID(ctx, handler.collection, "id")
+	if !ok {
+		return
+	}
+	var recipe models.Recipe
+	err := handler.collection.FindOneAndUpdate(handler.ctx, bson.M{
+		"_id":       objectId,
+		"deletedAt": bson.M{"$ne": nil},
+	}, bson.M{"$unset": bson.M{"deletedAt": ""}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&recipe)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Recipe is not in the trash"})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	handler.recordRevision(ctx, models.RevisionRestore, recipe)
+	handler.indexSimilar(recipe)
+	handler.cache.Invalidate(ctx)
+	renderData(ctx, http.StatusOK, recipe)
+}
+
+// PurgeRecipeHandler deletes a recipe with its images and reviews for good,
+// removing it from all cookbooks, favorites and meal plans, whether it is in
+// the trash or not.
+func (handler *RecipesHandler) PurgeRecipeHandler(ctx *gin.Context) {
+	objectId, ok := recipeID(ctx, handler.collection, "id")
+	if !ok {
+		return
+	}
+	var recipe models.Recipe
+	err := handler.collection.FindOneAndDelete(handler.ctx, bson.M{"_id": objectId}).Decode(&recipe)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Recipe not found"})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	handler.recordRevision(ctx, models.RevisionPurge, recipe)
+	handler.unindexSimilar(recipe.ID)
+	if _, err := handler.images.DeleteRecipeImages(handler.ctx, recipe.ID); err != nil {
+		log.Printf("Error while deleting the images of recipe %s: %v", recipe.ID.Hex(), err)
+	}
+	if _, err := handler.reviews.DeleteMany(handler.ctx, bson.M{"recipeId": recipe.ID}); err != nil {
+		log.Printf("Error while deleting the reviews of recipe %s: %v", recipe.ID.Hex(), err)
+	}
+	if err := handler.cookbooks.RemoveRecipe(handler.ctx, recipe.ID); err != nil {
+		log.Printf("Error while removing recipe %s from cookbooks: %v", recipe.ID.Hex(), err)
+	}
+	if _, err := RemovePlannedRecipes(handler.ctx, handler.mealPlans, []interface{}{recipe.ID}); err != nil {
+		log.Printf("Error while removing recipe %s from meal plans: %v", recipe.ID.Hex(), err)
+	}
+	handler.cache.Invalidate(ctx)
+	ctx.JSON(http.StatusOK, gin.H{"message": "Recipe has been deleted for good"})
+}
@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/aheadxnet/go-sandbox/formats"
+	"github.com/aheadxnet/go-sandbox/openapi"
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MIMEProblem is the media type of problem details as of RFC 7807.
+const MIMEProblem = "application/problem+json"
+
+// Problem describes why a request failed as of RFC 7807, listing the
+// parameters not matching the OpenAPI document. Error repeats the detail for
+// clients reading the error of the other error responses.
+type Problem struct {
+	Type          string              `json:"type" binding:"required"`
+	Title         string              `json:"title" binding:"required"`
+	Status        int                 `json:"status" binding:"required"`
+	Detail        string              `json:"detail,omitempty"`
+	Instance      string              `json:"instance,omitempty"`
+	InvalidParams []openapi.Violation `json:"invalid-params,omitempty"`
+	Error         string              `json:"error" binding:"required"`
+}
+
+func abortWithProblem(ctx *gin.Context, status int, detail string, violations []openapi.Violation) {
+	ctx.Header("Content-Type", MIMEProblem)
+	ctx.AbortWithStatusJSON(status, Problem{
+		Type:          "about:blank",
+		Title:         http.StatusText(status),
+		Status:        status,
+		Detail:        detail,
+		Instance:      ctx.Request.URL.RequestURI(),
+		InvalidParams: violations,
+		Error:         detail,
+	})
+}
+
+// ValidationMiddleware answers requests not matching the operation of their
+// route in the OpenAPI document with a problem, 400 for invalid parameters or
+// bodies and 415 for bodies in media types not described. With
+// validateResponses, responses not matching the document are replaced by a
+// problem with status 500, which is meant for tests as the responses are
+// buffered. Describe has to be called before the first request.
+func (handler *OpenAPIHandler) ValidationMiddleware(validateResponses bool) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if handler.doc == nil || ctx.FullPath() == "" {
+			ctx.Next()
+			return
+		}
+		path, _ := openapi.Path(ctx.FullPath())
+		operation := handler.doc.Operation(ctx.Request.Method, path)
+		if operation == nil {
+			ctx.Next()
+			return
+		}
+		if !handler.validateRequest(ctx, operation) {
+			return
+		}
+		if !validateResponses {
+			ctx.Next()
+			return
+		}
+
+		writer := &bufferedWriter{ResponseWriter: ctx.Writer, status: http.StatusOK}
+		ctx.Writer = writer
+		ctx.Next()
+		ctx.Writer = writer.ResponseWriter
+		violations := handler.validateResponse(operation, writer)
+		if len(violations) > 0 {
+			log.Printf("Response to %s %s does not match the OpenAPI document: %v", ctx.Request.Method, ctx.Request.URL, violations)
+			abortWithProblem(ctx, http.StatusInternalServerError, "The response does not match the OpenAPI document", violations)
+			return
+		}
+		writer.ResponseWriter.WriteHeader(writer.status)
+		writer.ResponseWriter.Write(writer.body.Bytes())
+	}
+}
+
+// validateRequest checks the parameters and the body of a request, aborting
+// it with a problem if they don't match.
+func (handler *OpenAPIHandler) validateRequest(ctx *gin.Context, operation *openapi.Operation) bool {
+	violations := make([]openapi.Violation, 0)
+	query := ctx.Request.URL.Query()
+	for _, parameter := range operation.Parameters {
+		var value string
+		var present bool
+		switch parameter.In {
+		case "path":
+			value, present = ctx.Param(parameter.Name), true
+		case "query":
+			var values []string
+			values, present = query[parameter.Name]
+			if present && len(values) > 0 {
+				value = values[0]
+			}
+		case "header":
+			value = ctx.GetHeader(parameter.Name)
+			present = value != ""
+		}
+		if !present {
+			if parameter.Required {
+				violations = append(violations, openapi.Violation{Name: parameter.Name, Reason: "is required"})
+			}
+			continue
+		}
+		violations = append(violations, handler.doc.ValidateParameter(parameter, value)...)
+	}
+
+	body := operation.RequestBody
+	if body != nil && ctx.Request.ContentLength == 0 {
+		if body.Required {
+			violations = append(violations, openapi.Violation{Name: "body", Reason: "is required"})
+		}
+	} else if body != nil {
+		// Aliases like text/xml are described by the media type of their
+		// format.
+		contentType := ctx.ContentType()
+		format, supported := formats.Supported(contentType)
+		if supported {
+			contentType = format
+		}
+		mediaType, ok := body.Content[contentType]
+		if !ok {
+			abortWithProblem(ctx, http.StatusUnsupportedMediaType, fmt.Sprintf("The body has to be one of %s", strings.Join(mediaTypes(body.Content), ", ")), nil)
+			return false
+		}
+		if supported {
+			data, err := ioutil.ReadAll(ctx.Request.Body)
+			if err != nil {
+				abortWithProblem(ctx, http.StatusBadRequest, err.Error(), nil)
+				return false
+			}
+			ctx.Request.Body = ioutil.NopCloser(bytes.NewReader(data))
+			violations = append(violations, handler.validateBody(mediaType.Schema, format, data)...)
+		}
+	}
+	if len(violations) > 0 {
+		abortWithProblem(ctx, http.StatusBadRequest, "The request does not match the OpenAPI document", violations)
+		return false
+	}
+	return true
+}
+
+// validateResponse checks the status and the body of a buffered response.
+func (handler *OpenAPIHandler) validateResponse(operation *openapi.Operation, writer *bufferedWriter) []openapi.Violation {
+	response, ok := operation.Responses[strconv.Itoa(writer.status)]
+	if !ok {
+		response, ok = operation.Responses["default"]
+	}
+	if !ok {
+		return []openapi.Violation{{Name: "status", Reason: fmt.Sprintf("%d is not described", writer.status)}}
+	}
+	if len(response.Content) == 0 {
+		if writer.body.Len() > 0 {
+			return []openapi.Violation{{Name: "body", Reason: "is not described"}}
+		}
+		return nil
+	}
+	contentType, _, _ := mime.ParseMediaType(writer.Header().Get("Content-Type"))
+	mediaType, ok := response.Content[contentType]
+	if !ok {
+		return []openapi.Violation{{Name: "Content-Type", Reason: fmt.Sprintf("must be one of %s", strings.Join(mediaTypes(response.Content), ", "))}}
+	}
+	if contentType == MIMEProblem {
+		contentType = gin.MIMEJSON
+	}
+	format, ok := formats.Supported(contentType)
+	if !ok {
+		return nil
+	}
+	return handler.validateBody(mediaType.Schema, format, writer.body.Bytes())
+}
+
+// validateBody checks a body in one of the formats against the schema of its
+// JSON encoding.
+func (handler *OpenAPIHandler) validateBody(schema *openapi.Schema, format string, data []byte) []openapi.Violation {
+	var document json.RawMessage
+	if err := formats.Unmarshal(format, data, &document); err != nil {
+		return []openapi.Violation{{Name: "body", Reason: "is not valid " + format + ": " + err.Error()}}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return []openapi.Violation{{Name: "body", Reason: "is not valid JSON: " + err.Error()}}
+	}
+	return handler.doc.Validate(schema, value, "body")
+}
+
+func mediaTypes(content map[string]*openapi.MediaType) []string {
+	names := make([]string, 0, len(content))
+	for name := range content {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// bufferedWriter holds back the status and the body of a response until it
+// has been validated.
+type bufferedWriter struct {
+	gin.ResponseWriter
+	status  int
+	written bool
+	body    bytes.Buffer
+}
+
+func (writer *bufferedWriter) WriteHeader(status int) {
+	if status > 0 && !writer.written {
+		writer.status = status
+	}
+}
+
+func (writer *bufferedWriter) WriteHeaderNow() {
+	writer.written = true
+}
+
+func (writer *bufferedWriter) Write(data []byte) (int, error) {
+	writer.written = true
+	return writer.body.Write(data)
+}
+
+func (writer *bufferedWriter) WriteString(s string) (int, error) {
+	writer.written = true
+	return writer.body.WriteString(s)
+}
+
+func (writer *bufferedWriter) Status() int {
+	return writer.status
+}
+
+func (writer *bufferedWriter) Size() int {
+	if !writer.written {
+		return -1
+	}
+	return writer.body.Len()
+}
+
+func (writer *bufferedWriter) Written() bool {
+	return writer.written
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/aheadxnet/go-sandbox/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateRequests(t *testing.T) {
+	tenants, client, redisClient := newTestTenants(t, "mongodb://localhost:27017", nil)
+	tenant := NewTenant(context.Background(), "", client.Database("recipes_test"), redisClient, TenantConfig{JWTSecret: testSecret, ValidateRequests: true})
+	router := NewRouter(tenant, NewTenantsHandler(tenants))
+
+	// None of these requests reach a handler, so none needs MongoDB.
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		body        string
+		status      int
+		invalid     string
+	}{
+		{"query parameter", http.MethodGet, "/recipes?limit=abc", "", "", http.StatusBadRequest, "limit"},
+		{"path parameter", http.MethodGet, "/recipes/1/revisions/first", "", "", http.StatusBadRequest, "rev"},
+		{"body", http.MethodPost, "/recipes", gin.MIMEJSON, `{"name": "Pizza", "tags": "italian"}`, http.StatusBadRequest, "body.tags"},
+		{"malformed body", http.MethodPost, "/recipes", gin.MIMEJSON, `{"name": `, http.StatusBadRequest, "body"},
+		{"missing body", http.MethodPost, "/signin", gin.MIMEJSON, "", http.StatusBadRequest, "body"},
+		{"media type", http.MethodPost, "/recipes", gin.MIMEPlain, "Pizza", http.StatusUnsupportedMediaType, ""},
+		{"XML alias", http.MethodPost, "/recipes", "text/xml", "<recipe><name>Pizza</name><tags>italian</tags></recipe>", http.StatusBadRequest, "body.tags"},
+		{"YAML alias", http.MethodPost, "/recipes:batch", "application/x-yaml", "operations: none", http.StatusBadRequest, "body.operations"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var body io.Reader
+			if test.body != "" {
+				body = strings.NewReader(test.body)
+			}
+			request := httptest.NewRequest(test.method, test.target, body)
+			if test.contentType != "" {
+				request.Header.Set("Content-Type", test.contentType)
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+			if recorder.Code != test.status {
+				t.Fatalf("got status %d: %s", recorder.Code, recorder.Body)
+			}
+			if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, MIMEProblem) {
+				t.Errorf("got Content-Type %q", contentType)
+			}
+			var problem Problem
+			if err := json.Unmarshal(recorder.Body.Bytes(), &problem); err != nil {
+				t.Fatal(err)
+			}
+			if problem.Status != test.status || problem.Error == "" {
+				t.Errorf("got problem %+v", problem)
+			}
+			if test.invalid == "" {
+				return
+			}
+			for _, violation := range problem.InvalidParams {
+				if violation.Name == test.invalid {
+					return
+				}
+			}
+			t.Errorf("%s is not among the invalid params %v", test.invalid, problem.InvalidParams)
+		})
+	}
+}
+
+func TestValidateResponses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewOpenAPIHandler()
+	router := gin.New()
+	router.Use(handler.ValidationMiddleware(true))
+	router.GET("/recipes/:id", func(ctx *gin.Context) {
+		if ctx.Param("id") == "broken" {
+			ctx.JSON(http.StatusOK, gin.H{"id": 5, "name": "Pizza"})
+			return
+		}
+		ctx.JSON(http.StatusOK, models.Recipe{ID: primitive.NewObjectID(), Name: "Pizza"})
+	})
+	if err := handler.Describe(router.Routes()); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/recipes/valid", nil))
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "Pizza") {
+		t.Errorf("got status %d: %s", recorder.Code, recorder.Body)
+	}
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/recipes/broken", nil))
+	if recorder.Code != http.StatusInternalServerError {
+		t.Fatalf("got status %d: %s", recorder.Code, recorder.Body)
+	}
+	var problem Problem
+	if err := json.Unmarshal(recorder.Body.Bytes(), &problem); err != nil {
+		t.Fatal(err)
+	}
+	if len(problem.InvalidParams) != 1 || problem.InvalidParams[0].Name != "body.id" {
+		t.Errorf("got invalid params %v", problem.InvalidParams)
+	}
+}
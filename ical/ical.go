@@ -0,0 +1,78 @@
+// Package ical writes calendars in the iCalendar format (RFC 5545).
+package ical
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Event is a single VEVENT of a calendar.
+type Event struct {
+	UID         string
+	Summary     string
+	Description string
+	Start       time.Time
+	End         time.Time
+}
+
+// Calendar is a VCALENDAR with its events.
+type Calendar struct {
+	Name   string
+	Events []Event
+}
+
+const timeLayout = "20060102T150405"
+
+// Encode renders the calendar. Start and end times are written as floating
+// local times, so a meal at 12:00 stays at noon in every time zone.
+func (calendar *Calendar) Encode() []byte {
+	var buf bytes.Buffer
+	writeLine(&buf, "BEGIN:VCALENDAR")
+	writeLine(&buf, "VERSION:2.0")
+	writeLine(&buf, "PRODID:-//aheadxnet//go-sandbox//EN")
+	writeLine(&buf, "CALSCALE:GREGORIAN")
+	if calendar.Name != "" {
+		writeLine(&buf, "X-WR-CALNAME:"+escape(calendar.Name))
+	}
+	stamp := time.Now().UTC().Format(timeLayout) + "Z"
+	for _, event := range calendar.Events {
+		writeLine(&buf, "BEGIN:VEVENT")
+		writeLine(&buf, "UID:"+event.UID)
+		writeLine(&buf, "DTSTAMP:"+stamp)
+		writeLine(&buf, "DTSTART:"+event.Start.Format(timeLayout))
+		writeLine(&buf, "DTEND:"+event.End.Format(timeLayout))
+		writeLine(&buf, "SUMMARY:"+escape(event.Summary))
+		if event.Description != "" {
+			writeLine(&buf, "DESCRIPTION:"+escape(event.Description))
+		}
+		writeLine(&buf, "END:VEVENT")
+	}
+	writeLine(&buf, "END:VCALENDAR")
+	return buf.Bytes()
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", "")
+
+func escape(text string) string {
+	return escaper.Replace(text)
+}
+
+// writeLine writes a content line terminated by CRLF, folding it into
+// continuation lines so no line exceeds 75 octets.
+func writeLine(buf *bytes.Buffer, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		fmt.Fprintf(buf, "%s\r\n ", line[:cut])
+		line = line[cut:]
+		limit = 74
+	}
+	buf.WriteString(line)
+	buf.WriteString("\r\n")
+}
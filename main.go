@@ -20,16 +20,32 @@ import (
 	"context"
 	"fmt"
 	"github.com/aheadxnet/go-sandbox/handlers"
-	"github.com/gin-gonic/gin"
 	redis "github.com/go-redis/redis/v8"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"log"
+	"net/http"
 	"os"
+	"strconv"
+	"time"
 )
 
-var recipesHandler *handlers.RecipesHandler
+var ctx context.Context
+var collection *mongo.Collection
+var redisClient *redis.Client
+var cache *handlers.RecipeCache
+var revisions *handlers.RevisionStore
+var images *handlers.ImageStore
+var cookbooks *handlers.CookbookStore
+var similar *handlers.SimilarityIndex
+var tags *handlers.TagTaxonomy
+
+var jwtSecret string
+
+var tenants *handlers.Tenants
+var commandTenant *handlers.Tenant
+var tenantsHandler *handlers.TenantsHandler
 
 func init() {
 	/*recipes = make([]Recipe, 0)
@@ -51,15 +67,14 @@ func init() {
 		log.Fatal(err)
 	}
 	log.Println("Inserted recipes: ", len(insertManyResult.InsertedIDs))*/
-	ctx := context.Background()
+	ctx = context.Background()
 	client, err := mongo.Connect(ctx, options.Client().ApplyURI(os.Getenv("MONGO_URI")))
 	if err = client.Ping(context.TODO(), readpref.Primary()); err != nil {
 		log.Fatal(err)
 	}
 	log.Println("Connected to MongoDB")
-	collection := client.Database(os.Getenv("MONGO_DATABASE")).Collection("recipes")
 
-	redisClient := redis.NewClient(&redis.Options{
+	redisClient = redis.NewClient(&redis.Options{
 		Addr:     "localhost:6379",
 		Password: "",
 		DB:       0,
@@ -67,17 +82,80 @@ func init() {
 	status := redisClient.Ping(ctx)
 	fmt.Println(status)
 
-	recipesHandler = handlers.NewRecipesHandler(ctx, collection, redisClient)
+	trashDays, err := strconv.Atoi(os.Getenv("TRASH_RETENTION_DAYS"))
+	if err != nil {
+		trashDays = 30
+	}
+	similarLimit, err := strconv.Atoi(os.Getenv("SIMILAR_RECIPES_LIMIT"))
+	if err != nil {
+		similarLimit = 50
+	}
+	retention, _ := strconv.Atoi(os.Getenv("RECIPE_REVISION_RETENTION"))
+	maxImageSize, err := strconv.ParseInt(os.Getenv("IMAGE_MAX_SIZE"), 10, 64)
+	if err != nil {
+		maxImageSize = 10 << 20
+	}
+	jwtSecret = os.Getenv("JWT_SECRET")
+	idempotencyHours, err := strconv.Atoi(os.Getenv("IDEMPOTENCY_TTL_HOURS"))
+	if err != nil {
+		idempotencyHours = 24
+	}
+
+	tenants = handlers.NewTenants(ctx, client, os.Getenv("MONGO_DATABASE"), redisClient, os.Getenv("TENANT_DOMAIN"), handlers.TenantConfig{
+		TrashRetention:    time.Duration(trashDays) * 24 * time.Hour,
+		RevisionRetention: retention,
+		SimilarLimit:      similarLimit,
+		MaxImageSize:      maxImageSize,
+		JWTSecret:         jwtSecret,
+		IdempotencyTTL:    time.Duration(idempotencyHours) * time.Hour,
+		ValidateRequests:  os.Getenv("VALIDATE_REQUESTS") == "true",
+	}, newRouter)
+	tenantsHandler = handlers.NewTenantsHandler(tenants)
+
+	// Commands work on the tenant named by TENANT, the default tenant if unset.
+	commandTenant, err = tenants.Get(os.Getenv("TENANT"))
+	if err != nil {
+		log.Fatalf("Tenant %q: %v", os.Getenv("TENANT"), err)
+	}
+	collection = commandTenant.Recipes
+	cache = commandTenant.Cache
+	revisions = commandTenant.Revisions
+	images = commandTenant.Images
+	cookbooks = commandTenant.Cookbooks
+	similar = commandTenant.Similar
+	tags = commandTenant.Tags
 }
 
 func main() {
-	router := gin.Default()
-	router.POST("/recipes", recipesHandler.NewRecipeHandler)
-	router.GET("/recipes", recipesHandler.ListRecipesHandler)
-	router.PUT("/recipes/:id", recipesHandler.UpdateRecipeHandler)
-	router.DELETE("/recipes/:id", recipesHandler.DeleteRecipeHandler)
-	router.GET("/recipes/:id", recipesHandler.GetRecipeHandler)
-	/*
-		router.GET("/recipes/search", SearchRecipesHandler)*/
-	router.Run()
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1:])
+		return
+	}
+	if len(jwtSecret) < handlers.MinSecretLength {
+		log.Fatalf("JWT_SECRET has to be set to a secret of at least %d characters", handlers.MinSecretLength)
+	}
+	if os.Getenv("MIGRATE_ON_START") == "true" {
+		if err := migrateAllTenants(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	all, err := tenants.All()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, tenant := range all {
+		tenant.EnsureIndexes(ctx)
+	}
+	go cleanupPeriodically(time.Hour)
+
+	address := ":8080"
+	if port := os.Getenv("PORT"); port != "" {
+		address = ":" + port
+	}
+	log.Fatal(http.ListenAndServe(address, tenants))
+}
+
+// newRouter creates the router serving the API for a tenant.
+func newRouter(tenant *handlers.Tenant) http.Handler {
+	return handlers.NewRouter(tenant, tenantsHandler)
 }
@@ -0,0 +1,266 @@
+// Package migrations changes the shape of the documents stored in a tenant's
+// database step by step. Migrations are applied in the order of their
+// versions, the applied ones are recorded in the schema_migrations collection.
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// Collection is the collection keeping the applied migrations, LockCollection
+// the one holding the lock of the instance migrating.
+const (
+	Collection     = "schema_migrations"
+	LockCollection = "schema_migrations_lock"
+)
+
+// lockTTL is the time after which the lock of an instance that died while
+// migrating is taken over. The instance migrating renews its lock every
+// lockRenewal, so long migrations keep it.
+const (
+	lockTTL     = 15 * time.Minute
+	lockRenewal = lockTTL / 3
+)
+
+// ErrLocked is returned while another instance migrates the database.
+var ErrLocked = errors.New("another instance is migrating the database")
+
+// ErrIrreversible is returned when reverting a migration without Down.
+var ErrIrreversible = errors.New("migration can't be reverted")
+
+// Migration is a step in the evolution of the stored documents. Down reverts
+// Up and is nil for migrations that lose information, like trimming texts.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database) error
+	Down        func(ctx context.Context, db *mongo.Database) error
+}
+
+// Applied is a migration recorded in schema_migrations.
+type Applied struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"appliedAt"`
+}
+
+// Status is a known or applied migration, AppliedAt is nil for pending ones.
+// Unknown is set for applied migrations this version of the code doesn't know.
+type Status struct {
+	Version     int
+	Description string
+	AppliedAt   *time.Time
+	Unknown     bool
+}
+
+// Migrator applies migrations to a database.
+type Migrator struct {
+	db         *mongo.Database
+	migrations []Migration
+	applied    *mongo.Collection
+	locks      *mongo.Collection
+	owner      string
+}
+
+// New creates a migrator for the migrations, which are sorted by version. It
+// panics on duplicate versions.
+func New(db *mongo.Database, migrations []Migration) *Migrator {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].Version == sorted[i-1].Version {
+			panic(fmt.Sprintf("migrations: duplicate version %d", sorted[i].Version))
+		}
+	}
+	hostname, _ := os.Hostname()
+	return &Migrator{
+		db:         db,
+		migrations: sorted,
+		applied:    db.Collection(Collection),
+		locks:      db.Collection(LockCollection),
+		owner:      fmt.Sprintf("%s:%d:%d", hostname, os.Getpid(), time.Now().UnixNano()),
+	}
+}
+
+// Status lists the known migrations in order, followed by applied migrations
+// that are unknown.
+func (migrator *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := migrator.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status := make([]Status, 0, len(migrator.migrations))
+	for _, migration := range migrator.migrations {
+		entry := Status{Version: migration.Version, Description: migration.Description}
+		if record, ok := applied[migration.Version]; ok {
+			entry.AppliedAt = &record.AppliedAt
+			delete(applied, migration.Version)
+		}
+		status = append(status, entry)
+	}
+	unknown := make([]Status, 0, len(applied))
+	for _, record := range applied {
+		appliedAt := record.AppliedAt
+		unknown = append(unknown, Status{Version: record.Version, Description: record.Description, AppliedAt: &appliedAt, Unknown: true})
+	}
+	sort.Slice(unknown, func(i, j int) bool { return unknown[i].Version < unknown[j].Version })
+	return append(status, unknown...), nil
+}
+
+// Up applies all pending migrations in order and returns how many have been
+// applied. It stops at the first failing migration.
+func (migrator *Migrator) Up(ctx context.Context) (int, error) {
+	unlock, err := migrator.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	applied, err := migrator.appliedVersions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, migration := range migrator.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		log.Printf("Applying migration %d: %s", migration.Version, migration.Description)
+		if err := migration.Up(ctx, migrator.db); err != nil {
+			return count, fmt.Errorf("migration %d: %w", migration.Version, err)
+		}
+		if _, err := migrator.applied.InsertOne(ctx, Applied{
+			Version:     migration.Version,
+			Description: migration.Description,
+			AppliedAt:   time.Now(),
+		}); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// UpWhenUnlocked is Up waiting for another instance migrating the database,
+// checking for the lock every interval. As Up looks up the applied migrations
+// after taking the lock, the ones applied by the other instance are skipped.
+func (migrator *Migrator) UpWhenUnlocked(ctx context.Context, interval time.Duration) (int, error) {
+	for {
+		count, err := migrator.Up(ctx)
+		if !errors.Is(err, ErrLocked) {
+			return count, err
+		}
+		log.Printf("Waiting for another instance migrating the database")
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Down reverts the given number of applied migrations, the latest first, and
+// returns how many have been reverted.
+func (migrator *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	unlock, err := migrator.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	applied, err := migrator.appliedVersions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for i := len(migrator.migrations) - 1; i >= 0 && count < steps; i-- {
+		migration := migrator.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		if migration.Down == nil {
+			return count, fmt.Errorf("migration %d: %w", migration.Version, ErrIrreversible)
+		}
+		log.Printf("Reverting migration %d: %s", migration.Version, migration.Description)
+		if err := migration.Down(ctx, migrator.db); err != nil {
+			return count, fmt.Errorf("migration %d: %w", migration.Version, err)
+		}
+		if _, err := migrator.applied.DeleteOne(ctx, bson.M{"_id": migration.Version}); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// appliedVersions loads the applied migrations by version.
+func (migrator *Migrator) appliedVersions(ctx context.Context) (map[int]Applied, error) {
+	cur, err := migrator.applied.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	records := make([]Applied, 0)
+	if err := cur.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	applied := make(map[int]Applied, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// lock takes the lock of the database, or over an expired one, and returns
+// the function releasing it. The lock is a single document, so inserting it
+// fails with a duplicate key while another instance holds it.
+func (migrator *Migrator) lock(ctx context.Context) (func(), error) {
+	now := time.Now()
+	_, err := migrator.locks.UpdateOne(ctx,
+		bson.M{"_id": "migrate", "expiresAt": bson.M{"$lt": now}},
+		bson.M{"$set": bson.M{"owner": migrator.owner, "lockedAt": now, "expiresAt": now.Add(lockTTL)}},
+		options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	done := make(chan struct{})
+	go migrator.renew(done)
+	return func() {
+		close(done)
+		if _, err := migrator.locks.DeleteOne(context.Background(), bson.M{"_id": "migrate", "owner": migrator.owner}); err != nil {
+			log.Printf("Error while releasing the migration lock: %v", err)
+		}
+	}, nil
+}
+
+// renew extends the lock every lockRenewal until done is closed, so other
+// instances don't take over the lock during a long migration.
+func (migrator *Migrator) renew(done chan struct{}) {
+	ticker := time.NewTicker(lockRenewal)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			result, err := migrator.locks.UpdateOne(context.Background(),
+				bson.M{"_id": "migrate", "owner": migrator.owner},
+				bson.M{"$set": bson.M{"expiresAt": now.Add(lockTTL)}})
+			if err != nil {
+				log.Printf("Error while renewing the migration lock: %v", err)
+			} else if result.MatchedCount == 0 {
+				log.Printf("The migration lock has been taken over by another instance")
+			}
+		}
+	}
+}
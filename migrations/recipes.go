@@ -0,0 +1,201 @@
+package migrations
+
+import (
+	"context"
+	"github.com/aheadxnet/go-sandbox/handlers"
+	"github.com/aheadxnet/go-sandbox/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
+	"time"
+)
+
+// All are the migrations of the recipes API. Add new ones at the end with the
+// next version, never change or remove applied ones.
+var All = []Migration{
+	{
+		Version:     1,
+		Description: "store publishedAt of imported recipes as dates",
+		Up:          publishedAtToDates,
+		Down:        publishedAtToStrings,
+	},
+	{
+		Version:     2,
+		Description: "trim ingredients and instructions of imported recipes",
+		Up:          trimLines,
+	},
+	{
+		Version:     3,
+		Description: "add fingerprints and search texts",
+		Up:          addFingerprints,
+		Down:        unset("fingerprint", "texts"),
+	},
+	{
+		Version:     4,
+		Description: "add slugs",
+		Up:          addSlugs,
+		Down:        unset("slug", "oldSlugs"),
+	},
+	{
+		Version:     5,
+		Description: "normalize tags to their canonical names",
+		Up:          normalizeTags,
+	},
+}
+
+// updateRecipes calls update for every recipe matching the filter, the oldest
+// first, and stores the changes it returns, if any.
+func updateRecipes(ctx context.Context, db *mongo.Database, filter bson.M, update func(recipe models.Recipe) (bson.M, error)) error {
+	collection := db.Collection("recipes")
+	cur, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var recipe models.Recipe
+		if err := cur.Decode(&recipe); err != nil {
+			return err
+		}
+		changes, err := update(recipe)
+		if err != nil {
+			return err
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": recipe.ID}, bson.M{"$set": changes}); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+// publishedAtToDates parses the publishedAt strings mongoimport stores for
+// the recipes of recipes.json.
+func publishedAtToDates(ctx context.Context, db *mongo.Database) error {
+	return convertPublishedAt(ctx, db, "string", func(value bson.RawValue) (interface{}, error) {
+		return time.Parse(time.RFC3339Nano, value.StringValue())
+	})
+}
+
+func publishedAtToStrings(ctx context.Context, db *mongo.Database) error {
+	return convertPublishedAt(ctx, db, "date", func(value bson.RawValue) (interface{}, error) {
+		return value.Time().Format(time.RFC3339Nano), nil
+	})
+}
+
+// convertPublishedAt converts the publishedAt values of the given BSON type.
+// The recipes are read as raw documents, as they don't decode into
+// models.Recipe before.
+func convertPublishedAt(ctx context.Context, db *mongo.Database, from string, convert func(bson.RawValue) (interface{}, error)) error {
+	collection := db.Collection("recipes")
+	cur, err := collection.Find(ctx, bson.M{"publishedAt": bson.M{"$type": from}},
+		options.Find().SetProjection(bson.M{"publishedAt": 1}))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var id primitive.ObjectID
+		if err := cur.Current.Lookup("_id").Unmarshal(&id); err != nil {
+			return err
+		}
+		value, err := convert(cur.Current.Lookup("publishedAt"))
+		if err != nil {
+			return err
+		}
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"publishedAt": value}}); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+// trimLines removes the carriage returns and blanks recipes.json has around
+// its ingredients and instructions.
+func trimLines(ctx context.Context, db *mongo.Database) error {
+	return updateRecipes(ctx, db, bson.M{}, func(recipe models.Recipe) (bson.M, error) {
+		ingredients, trimmedIngredients := trimAll(recipe.Ingredients)
+		instructions, trimmedInstructions := trimAll(recipe.Instructions)
+		if !trimmedIngredients && !trimmedInstructions {
+			return nil, nil
+		}
+		return bson.M{"ingredients": ingredients, "instructions": instructions}, nil
+	})
+}
+
+// trimAll trims the lines and reports whether any changed.
+func trimAll(lines []string) ([]string, bool) {
+	trimmed := make([]string, len(lines))
+	changed := false
+	for i, line := range lines {
+		trimmed[i] = strings.TrimSpace(line)
+		changed = changed || trimmed[i] != line
+	}
+	return trimmed, changed
+}
+
+func addFingerprints(ctx context.Context, db *mongo.Database) error {
+	return updateRecipes(ctx, db, bson.M{}, func(recipe models.Recipe) (bson.M, error) {
+		return bson.M{
+			"fingerprint": models.NewFingerprint(recipe),
+			"texts":       recipe.SearchTexts(),
+		}, nil
+	})
+}
+
+func addSlugs(ctx context.Context, db *mongo.Database) error {
+	collection := db.Collection("recipes")
+	return updateRecipes(ctx, db, bson.M{"slug": bson.M{"$exists": false}}, func(recipe models.Recipe) (bson.M, error) {
+		slug, err := handlers.UniqueSlug(ctx, collection, recipe.ID, recipe.Name)
+		if err != nil {
+			return nil, err
+		}
+		return bson.M{"slug": slug}, nil
+	})
+}
+
+// normalizeTags normalizes the tags of recipes written before tags were
+// normalized on write, so they are found by their canonical names.
+func normalizeTags(ctx context.Context, db *mongo.Database) error {
+	taxonomy := handlers.NewTagTaxonomy(db.Collection("tags"))
+	return updateRecipes(ctx, db, bson.M{}, func(recipe models.Recipe) (bson.M, error) {
+		tags, err := taxonomy.Normalize(ctx, recipe.Tags)
+		if err != nil {
+			return nil, err
+		}
+		if equalStrings(tags, recipe.Tags) {
+			return nil, nil
+		}
+		return bson.M{"tags": tags}, nil
+	})
+}
+
+// equalStrings reports whether both slices hold the same strings in the same
+// order.
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// unset returns a migration removing the fields from all recipes.
+func unset(fields ...string) func(ctx context.Context, db *mongo.Database) error {
+	return func(ctx context.Context, db *mongo.Database) error {
+		update := bson.M{}
+		for _, field := range fields {
+			update[field] = ""
+		}
+		_, err := db.Collection("recipes").UpdateMany(ctx, bson.M{}, bson.M{"$unset": update})
+		return err
+	}
+}
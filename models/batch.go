@@ -0,0 +1,53 @@
+package models
+
+// The operations of a batch.
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+)
+
+// swagger:model BatchRequest
+// Operations on many recipes at once.
+type BatchRequest struct {
+	// execute the operations in order and stop at the first failure, true by default
+	Ordered bool `json:"ordered"`
+
+	// the operations
+	// required: true
+	Operations []BatchOperation `json:"operations" binding:"required"`
+}
+
+// swagger:model BatchOperation
+// One operation of a batch.
+type BatchOperation struct {
+	// "create", "update" or "delete"
+	// required: true
+	Op string `json:"op"`
+
+	// the ID of the recipe to update or delete
+	ID string `json:"id,omitempty"`
+
+	// the recipe to create or the new content of the recipe to update
+	Recipe *Recipe `json:"recipe,omitempty"`
+}
+
+// swagger:model BatchResult
+// The result of one operation of a batch.
+type BatchResult struct {
+	// the position of the operation in the batch
+	Index int `json:"index"`
+
+	// the operation
+	Op string `json:"op"`
+
+	// the HTTP status the operation would have had on its own, 424 for
+	// operations not executed as an earlier one failed
+	Status int `json:"status"`
+
+	// the ID of the created, updated or deleted recipe
+	ID string `json:"id,omitempty"`
+
+	// what went wrong
+	Error string `json:"error,omitempty"`
+}
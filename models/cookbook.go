@@ -0,0 +1,96 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// Visibility decides who may read a cookbook besides its owner.
+type Visibility string
+
+const (
+	// Private cookbooks are only visible to their owner.
+	Private Visibility = "private"
+	// Shared cookbooks are visible to the users they are shared with.
+	Shared Visibility = "shared"
+	// Public cookbooks are visible to everyone, even without signing in.
+	Public Visibility = "public"
+)
+
+// Valid reports whether the visibility is one of the known visibilities.
+func (visibility Visibility) Valid() bool {
+	return visibility == Private || visibility == Shared || visibility == Public
+}
+
+// swagger:model Cookbook
+// A named, ordered collection of recipes curated by a user.
+type Cookbook struct {
+	// the id for this cookbook
+	//
+	// required: true
+	ID primitive.ObjectID `json:"id" bson:"_id"`
+
+	// the user owning this cookbook
+	Owner string `json:"owner" bson:"owner"`
+
+	// the name for this cookbook
+	// required: true
+	Name string `json:"name" bson:"name" binding:"required"`
+
+	// the description of this cookbook
+	Description string `json:"description,omitempty" bson:"description,omitempty"`
+
+	// who may read this cookbook, one of private, shared or public
+	Visibility Visibility `json:"visibility" bson:"visibility"`
+
+	// the users a shared cookbook is shared with
+	SharedWith []string `json:"sharedWith,omitempty" bson:"sharedWith,omitempty"`
+
+	// the ids of the recipes in this cookbook, in order
+	RecipeIDs []primitive.ObjectID `json:"recipeIds" bson:"recipeIds"`
+
+	// the recipes in this cookbook, in order, when reading a single cookbook
+	Recipes []Recipe `json:"recipes,omitempty" bson:"-"`
+
+	// the ids of recipes in this cookbook that have been deleted meanwhile
+	MissingRecipes []primitive.ObjectID `json:"missingRecipes,omitempty" bson:"-"`
+
+	// the creation date for this cookbook
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+
+	// the date of the last change of this cookbook
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// VisibleTo reports whether the user may read the cookbook, the empty
+// username stands for anonymous users.
+func (cookbook *Cookbook) VisibleTo(username string) bool {
+	switch {
+	case cookbook.Visibility == Public:
+		return true
+	case username == "":
+		return false
+	case cookbook.Owner == username:
+		return true
+	case cookbook.Visibility == Shared:
+		for _, user := range cookbook.SharedWith {
+			if user == username {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// swagger:model Favorite
+// A recipe bookmarked by a user.
+type Favorite struct {
+	// the user who bookmarked the recipe
+	Username string `json:"username" bson:"username"`
+
+	// the id of the bookmarked recipe
+	RecipeID primitive.ObjectID `json:"recipeId" bson:"recipeId"`
+
+	// the date the recipe has been bookmarked
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+}
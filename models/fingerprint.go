@@ -0,0 +1,79 @@
+package models
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// DuplicateThreshold is the share of ingredients two recipes of the same
+// title must have in common to be considered duplicates.
+const DuplicateThreshold = 0.8
+
+// Fingerprint identifies a recipe by its normalized title and the set of its
+// ingredient names, regardless of quantities, order, case and punctuation.
+type Fingerprint struct {
+	// the normalized title
+	Title string `json:"title" bson:"title"`
+
+	// the sorted, normalized names of the ingredients
+	Ingredients []string `json:"ingredients" bson:"ingredients"`
+
+	// a hash of title and ingredients, equal for exact duplicates
+	Hash string `json:"hash" bson:"hash"`
+}
+
+// NewFingerprint computes the fingerprint of a recipe.
+func NewFingerprint(recipe Recipe) Fingerprint {
+	ingredients := make([]string, 0, len(recipe.Ingredients))
+	for _, line := range recipe.Ingredients {
+		name := normalizeWords(ParseIngredient(line).Name)
+		if name != "" && !containsTerm(ingredients, name) {
+			ingredients = append(ingredients, name)
+		}
+	}
+	sort.Strings(ingredients)
+	title := normalizeWords(recipe.Name)
+	sum := sha1.Sum([]byte(title + "\n" + strings.Join(ingredients, "\n")))
+	return Fingerprint{
+		Title:       title,
+		Ingredients: ingredients,
+		Hash:        hex.EncodeToString(sum[:]),
+	}
+}
+
+// Similarity returns the Jaccard index of the ingredient sets, the share of
+// ingredients in common.
+func (fingerprint Fingerprint) Similarity(other Fingerprint) float64 {
+	if len(fingerprint.Ingredients) == 0 && len(other.Ingredients) == 0 {
+		return 1
+	}
+	shared := 0
+	for _, ingredient := range fingerprint.Ingredients {
+		if containsTerm(other.Ingredients, ingredient) {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(fingerprint.Ingredients)+len(other.Ingredients)-shared)
+}
+
+// Duplicates reports whether two fingerprints likely belong to the same
+// recipe: same title and nearly the same ingredients.
+func (fingerprint Fingerprint) Duplicates(other Fingerprint) bool {
+	if fingerprint.Hash == "" || other.Hash == "" {
+		return false
+	}
+	if fingerprint.Hash == other.Hash {
+		return true
+	}
+	return fingerprint.Title == other.Title && fingerprint.Similarity(other) >= DuplicateThreshold
+}
+
+// normalizeWords lower cases a text and reduces it to its words.
+func normalizeWords(text string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"regexp"
+)
+
+// legacyID matches the xid recipe IDs of the first version of the API, which
+// kept the recipes in memory, like "c0283p3d0cvuglq85log".
+var legacyID = regexp.MustCompile(`^[0-9a-v]{20}$`)
+
+// ErrInvalidID is returned for IDs in neither of the known formats.
+var ErrInvalidID = errors.New("invalid recipe ID")
+
+// RecipeID is a recipe ID as found in URLs and integrations: the hex of a
+// MongoDB ObjectID or a legacy xid.
+type RecipeID struct {
+	ObjectID primitive.ObjectID
+	Legacy   string
+}
+
+// ParseRecipeID parses an ID in either format.
+func ParseRecipeID(id string) (RecipeID, error) {
+	if objectId, err := primitive.ObjectIDFromHex(id); err == nil {
+		return RecipeID{ObjectID: objectId}, nil
+	}
+	if IsLegacyID(id) {
+		return RecipeID{Legacy: id}, nil
+	}
+	return RecipeID{}, ErrInvalidID
+}
+
+// IsLegacyID reports whether id is an xid of the first version of the API.
+func IsLegacyID(id string) bool {
+	return legacyID.MatchString(id)
+}
+
+// IsLegacy reports whether the ID is a legacy xid, which has to be looked up
+// in the legacy IDs of the recipes.
+func (id RecipeID) IsLegacy() bool {
+	return id.Legacy != ""
+}
+
+func (id RecipeID) String() string {
+	if id.IsLegacy() {
+		return id.Legacy
+	}
+	return id.ObjectID.Hex()
+}
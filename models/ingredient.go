@@ -0,0 +1,140 @@
+package models
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Ingredient is the structured form of an ingredient line like "500g Sahnequark".
+type Ingredient struct {
+	// the quantity, zero if the line has none
+	Quantity float64 `json:"quantity,omitempty" bson:"quantity,omitempty"`
+
+	// the unit of the quantity, empty for countable ingredients
+	Unit string `json:"unit,omitempty" bson:"unit,omitempty"`
+
+	// the name of the ingredient
+	Name string `json:"name" bson:"name"`
+}
+
+// units maps the unit spellings found in recipes to their canonical form.
+var units = map[string]string{
+	"g": "g", "gr": "g", "gram": "g", "grams": "g", "gramm": "g",
+	"kg": "kg", "kilogram": "kg", "kilograms": "kg",
+	"mg": "mg",
+	"ml": "ml", "milliliter": "ml", "milliliters": "ml",
+	"cl": "cl", "dl": "dl",
+	"l": "l", "liter": "l", "liters": "l", "litre": "l", "litres": "l",
+	"tsp": "tsp", "teaspoon": "tsp", "teaspoons": "tsp", "tl": "tsp",
+	"tbsp": "tbsp", "tablespoon": "tbsp", "tablespoons": "tbsp", "el": "tbsp",
+	"cup": "cup", "cups": "cup", "tasse": "cup", "tassen": "cup",
+	"oz": "oz", "ounce": "oz", "ounces": "oz",
+	"lb": "lb", "lbs": "lb", "pound": "lb", "pounds": "lb",
+	"pinch": "pinch", "pinches": "pinch", "prise": "pinch", "prisen": "pinch",
+	"clove": "clove", "cloves": "clove",
+	"can": "can", "cans": "can", "dose": "can", "dosen": "can",
+	"package": "package", "packages": "package", "pck": "package", "päckchen": "package",
+}
+
+var unicodeFractions = map[rune]float64{
+	'½': 0.5, '⅓': 1.0 / 3, '⅔': 2.0 / 3, '¼': 0.25, '¾': 0.75, '⅛': 0.125,
+}
+
+// ParseIngredient splits an ingredient line into quantity, unit and name.
+// Lines without a leading quantity are returned with the whole line as name.
+func ParseIngredient(line string) Ingredient {
+	rest := strings.TrimSpace(line)
+	quantity, rest := parseQuantity(rest)
+	if quantity == 0 {
+		return Ingredient{Name: rest}
+	}
+	ingredient := Ingredient{Quantity: quantity}
+	word := rest
+	if i := strings.IndexFunc(rest, unicode.IsSpace); i >= 0 {
+		word = rest[:i]
+	}
+	if unit, ok := units[strings.ToLower(strings.TrimSuffix(word, "."))]; ok {
+		ingredient.Unit = unit
+		rest = strings.TrimSpace(rest[len(word):])
+		rest = strings.TrimPrefix(rest, "of ")
+	}
+	ingredient.Name = strings.TrimSpace(rest)
+	return ingredient
+}
+
+// parseQuantity reads a leading quantity like "2", "1.5", "1,5", "1/2", "1 1/2" or "½"
+// and returns it together with the remaining text. A unit directly attached
+// to the number ("500g") is left at the start of the remaining text.
+func parseQuantity(text string) (float64, string) {
+	total := 0.0
+	rest := text
+	for {
+		rest = strings.TrimLeft(rest, " ")
+		value, remaining, ok := parseNumber(rest)
+		if !ok {
+			break
+		}
+		total += value
+		rest = remaining
+		if !strings.HasPrefix(rest, " ") && !startsWithFraction(rest) {
+			break
+		}
+	}
+	if total == 0 {
+		return 0, text
+	}
+	return total, strings.TrimSpace(rest)
+}
+
+func startsWithFraction(text string) bool {
+	for _, r := range text {
+		_, ok := unicodeFractions[r]
+		return ok
+	}
+	return false
+}
+
+func parseNumber(text string) (float64, string, bool) {
+	for _, r := range text {
+		if value, ok := unicodeFractions[r]; ok {
+			return value, text[len(string(r)):], true
+		}
+		break
+	}
+	end := 0
+	for end < len(text) && (text[end] >= '0' && text[end] <= '9' || text[end] == '.' || text[end] == ',' || text[end] == '/') {
+		end++
+	}
+	if end == 0 {
+		return 0, text, false
+	}
+	number := strings.TrimRight(text[:end], ".,/")
+	rest := text[len(number):]
+	if parts := strings.SplitN(number, "/", 2); len(parts) == 2 {
+		numerator, err1 := strconv.ParseFloat(parts[0], 64)
+		denominator, err2 := strconv.ParseFloat(parts[1], 64)
+		if err1 != nil || err2 != nil || denominator == 0 {
+			return 0, text, false
+		}
+		return numerator / denominator, rest, true
+	}
+	value, err := strconv.ParseFloat(strings.Replace(number, ",", ".", 1), 64)
+	if err != nil {
+		return 0, text, false
+	}
+	return value, rest, true
+}
+
+// String formats the ingredient back into a single line.
+func (ingredient Ingredient) String() string {
+	if ingredient.Quantity == 0 {
+		return ingredient.Name
+	}
+	quantity := strconv.FormatFloat(math.Round(ingredient.Quantity*100)/100, 'f', -1, 64)
+	if ingredient.Unit == "" {
+		return quantity + " " + ingredient.Name
+	}
+	return quantity + " " + ingredient.Unit + " " + ingredient.Name
+}
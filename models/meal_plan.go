@@ -0,0 +1,103 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// MealSlot is the meal of a day a recipe is planned for.
+type MealSlot string
+
+const (
+	Breakfast MealSlot = "breakfast"
+	Lunch     MealSlot = "lunch"
+	Dinner    MealSlot = "dinner"
+)
+
+// Valid reports whether the slot is one of the known meal slots.
+func (slot MealSlot) Valid() bool {
+	return slot == Breakfast || slot == Lunch || slot == Dinner
+}
+
+// MealPlanDateLayout is the layout of the dates in a meal plan entry.
+const MealPlanDateLayout = "2006-01-02"
+
+// swagger:model MealPlanEntry
+// A recipe planned for a meal slot of a day.
+type MealPlanEntry struct {
+	// the day of the meal, formatted as YYYY-MM-DD
+	// required: true
+	Date string `json:"date" bson:"date"`
+
+	// the meal slot, one of breakfast, lunch or dinner
+	// required: true
+	Slot MealSlot `json:"slot" bson:"slot"`
+
+	// the id of the planned recipe
+	// required: true
+	RecipeID primitive.ObjectID `json:"recipeId" bson:"recipeId"`
+
+	// the number of servings to cook
+	// required: true
+	// min: 1
+	Servings int `json:"servings" bson:"servings"`
+}
+
+// swagger:model MealPlan
+// A meal plan of a user.
+type MealPlan struct {
+	// the id for this meal plan
+	//
+	// required: true
+	ID primitive.ObjectID `json:"id" bson:"_id"`
+
+	// the user owning this meal plan
+	Owner string `json:"owner" bson:"owner"`
+
+	// the name for this meal plan
+	// required: true
+	Name string `json:"name" bson:"name"`
+
+	// the planned meals
+	Entries []MealPlanEntry `json:"entries" bson:"entries"`
+
+	// the ids of planned recipes that have been deleted meanwhile
+	MissingRecipes []primitive.ObjectID `json:"missingRecipes,omitempty" bson:"-"`
+
+	// the SHA-256 hash of the token of the calendar feed, empty without feed
+	FeedToken string `json:"-" bson:"feedToken,omitempty"`
+	// the creation date for this meal plan
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+
+	// the date of the last change of this meal plan
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// RecipeIDs returns the distinct ids of the recipes planned in this meal plan.
+func (plan *MealPlan) RecipeIDs() []primitive.ObjectID {
+	seen := make(map[primitive.ObjectID]bool)
+	ids := make([]primitive.ObjectID, 0)
+	for _, entry := range plan.Entries {
+		if !seen[entry.RecipeID] {
+			seen[entry.RecipeID] = true
+			ids = append(ids, entry.RecipeID)
+		}
+	}
+	return ids
+}
+
+// swagger:model ShoppingListItem
+// An ingredient to buy for a meal plan.
+type ShoppingListItem struct {
+	// the summed up quantity, zero if the ingredient has no quantity
+	Quantity float64 `json:"quantity,omitempty"`
+
+	// the unit of the quantity
+	Unit string `json:"unit,omitempty"`
+
+	// the name of the ingredient
+	Name string `json:"name"`
+
+	// the names of the recipes needing this ingredient
+	Recipes []string `json:"recipes"`
+}
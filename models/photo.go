@@ -0,0 +1,37 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// swagger:model Photo
+// A photo of a recipe stored in GridFS together with its thumbnail.
+type Photo struct {
+	// the GridFS id of the uploaded image
+	ID primitive.ObjectID `json:"id" bson:"id"`
+
+	// the GridFS id of the thumbnail
+	ThumbnailID primitive.ObjectID `json:"thumbnailId" bson:"thumbnailId"`
+
+	// the URL the image is served at
+	URL string `json:"url" bson:"url"`
+
+	// the URL the thumbnail is served at
+	ThumbnailURL string `json:"thumbnailUrl" bson:"thumbnailUrl"`
+
+	// the media type, image/jpeg or image/png
+	ContentType string `json:"contentType" bson:"contentType"`
+
+	// the width of the image in pixels
+	Width int `json:"width" bson:"width"`
+
+	// the height of the image in pixels
+	Height int `json:"height" bson:"height"`
+
+	// the size of the image in bytes
+	Size int64 `json:"size" bson:"size"`
+
+	// the upload date of the image
+	UploadedAt time.Time `json:"uploadedAt" bson:"uploadedAt"`
+}
@@ -14,11 +14,20 @@ type Recipe struct {
 	// min: 1
 	ID primitive.ObjectID `json:"id" bson:"_id"`
 
+	// the xid IDs this recipe had in the first version of the API
+	LegacyIDs []string `json:"-" bson:"legacyIds,omitempty"`
+
 	// the name for this recipe
 	// required: true
 	// min length: 3
 	Name string `json:"name" bson:"name"`
 
+	// the unique, URL-safe name of this recipe, like "stefans-kaesekuchen"
+	Slug string `json:"slug,omitempty" bson:"slug,omitempty"`
+
+	// former slugs of this recipe, redirecting to the current one
+	OldSlugs []string `json:"-" bson:"oldSlugs,omitempty"`
+
 	// tags for this recipe
 	Tags []string `json:"tags" bson:"tags"`
 
@@ -28,7 +37,53 @@ type Recipe struct {
 	// instructions for preparing this recipe
 	Instructions []string `json:"instructions" bson:"instructions"`
 
+	// the number of servings the ingredients are meant for
+	Servings int `json:"servings,omitempty" bson:"servings,omitempty"`
+
+	// the yield as given by the author, like "1 cake" or "4 portions"
+	Yield string `json:"yield,omitempty" bson:"yield,omitempty"`
+
+	// the preparation time in minutes
+	PrepTime int `json:"prepTime,omitempty" bson:"prepTime,omitempty"`
+
+	// the cooking time in minutes
+	CookTime int `json:"cookTime,omitempty" bson:"cookTime,omitempty"`
+
+	// the total time in minutes
+	TotalTime int `json:"totalTime,omitempty" bson:"totalTime,omitempty"`
+
+	// the URL of an image of this recipe
+	Image string `json:"image,omitempty" bson:"image,omitempty"`
+
+	// the aggregated ratings of this recipe
+	Rating Rating `json:"rating" bson:"rating"`
+
+	// the photos uploaded for this recipe
+	Photos []Photo `json:"photos,omitempty" bson:"photos,omitempty"`
+
+	// the language of name, ingredients and instructions, like "de"
+	Language string `json:"language,omitempty" bson:"language,omitempty"`
+
+	// translations of name, ingredients and instructions by language
+	Translations map[string]RecipeTranslation `json:"translations,omitempty" bson:"translations,omitempty"`
+
+	// the language name, ingredients and instructions have been translated
+	// to in this response, empty for the recipe in its own language
+	TranslatedTo string `json:"translatedTo,omitempty" bson:"-"`
+
+	// the texts of this recipe in all its languages for the full text search
+	Texts []RecipeText `json:"-" bson:"texts,omitempty"`
+
+	// the URL this recipe was originally published at
+	Source string `json:"source,omitempty" bson:"source,omitempty"`
+
 	// the publication date for this recipe
 	// required: true
 	PublishedAt time.Time `json:"publishedAt" bson:"publishedAt"`
+
+	// the fingerprint to detect duplicates, computed on write
+	Fingerprint Fingerprint `json:"-" bson:"fingerprint"`
+
+	// the date this recipe has been moved to the trash, empty for active recipes
+	DeletedAt *time.Time `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"`
 }
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// swagger:model Review
+// A rating and review of a recipe by a user.
+type Review struct {
+	// the id for this review
+	ID primitive.ObjectID `json:"id" bson:"_id"`
+
+	// the id of the reviewed recipe
+	RecipeID primitive.ObjectID `json:"recipeId" bson:"recipeId"`
+
+	// the user who wrote the review
+	Author string `json:"author" bson:"author"`
+
+	// the rating from 1 to 5
+	// required: true
+	// min: 1
+	// max: 5
+	Rating int `json:"rating" bson:"rating" binding:"required,min=1,max=5"`
+
+	// the text of the review
+	Text string `json:"text" bson:"text"`
+
+	// hidden reviews have been moderated and are not counted
+	Hidden bool `json:"hidden,omitempty" bson:"hidden,omitempty"`
+
+	// the creation date for this review
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+
+	// the date of the last change of this review
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// swagger:model Rating
+// The aggregated ratings of a recipe.
+type Rating struct {
+	// the average rating
+	Average float64 `json:"average" bson:"average"`
+
+	// the number of ratings
+	Count int `json:"count" bson:"count"`
+
+	// the sum of all ratings, kept to update the average atomically
+	Sum int `json:"-" bson:"sum"`
+}
+
+// BayesianRating weighs the average rating of a recipe against the mean
+// rating of all recipes, as if every recipe had additional weight ratings
+// of the mean. This keeps recipes with few ratings from topping the list.
+func BayesianRating(rating Rating, mean float64, weight float64) float64 {
+	return (weight*mean + rating.Average*float64(rating.Count)) / (weight + float64(rating.Count))
+}
@@ -0,0 +1,120 @@
+package models
+
+import (
+	"encoding/json"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Actions recorded in a revision.
+const (
+	RevisionCreate  = "create"
+	RevisionUpdate  = "update"
+	RevisionDelete  = "delete"
+	RevisionRestore = "restore"
+	RevisionPurge   = "purge"
+	RevisionRevert  = "revert"
+)
+
+// swagger:model Revision
+// An immutable snapshot of a recipe, written on every change.
+type Revision struct {
+	// the id for this revision
+	ID primitive.ObjectID `json:"id" bson:"_id"`
+
+	// the id of the recipe
+	RecipeID primitive.ObjectID `json:"recipeId" bson:"recipeId"`
+
+	// the number of this revision, counting up from 1 per recipe
+	Number int `json:"number" bson:"number"`
+
+	// the change leading to this revision: create, update, delete, restore, purge or revert
+	Action string `json:"action" bson:"action"`
+
+	// the user who made the change
+	Author string `json:"author" bson:"author"`
+
+	// the date of the change
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+
+	// the recipe as it was after the change
+	Snapshot Recipe `json:"snapshot" bson:"snapshot"`
+}
+
+// swagger:model FieldChange
+// A field that differs between two revisions.
+type FieldChange struct {
+	// the JSON name of the field
+	Field string `json:"field"`
+
+	// the value in the older revision
+	From interface{} `json:"from"`
+
+	// the value in the newer revision
+	To interface{} `json:"to"`
+
+	// for list fields, the elements only found in the newer revision
+	Added []interface{} `json:"added,omitempty"`
+
+	// for list fields, the elements only found in the older revision
+	Removed []interface{} `json:"removed,omitempty"`
+}
+
+// DiffRecipes compares two recipes field by field and returns the changed
+// fields ordered by name. Fields are compared by their JSON representation.
+func DiffRecipes(from Recipe, to Recipe) []FieldChange {
+	fromFields := jsonFields(from)
+	toFields := jsonFields(to)
+	names := make([]string, 0, len(toFields))
+	for name := range fromFields {
+		names = append(names, name)
+	}
+	for name := range toFields {
+		if _, ok := fromFields[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	changes := make([]FieldChange, 0)
+	for _, name := range names {
+		if reflect.DeepEqual(fromFields[name], toFields[name]) {
+			continue
+		}
+		change := FieldChange{Field: name, From: fromFields[name], To: toFields[name]}
+		fromList, fromIsList := fromFields[name].([]interface{})
+		toList, toIsList := toFields[name].([]interface{})
+		if fromIsList || toIsList {
+			change.Added = missingFrom(toList, fromList)
+			change.Removed = missingFrom(fromList, toList)
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+func jsonFields(recipe Recipe) map[string]interface{} {
+	fields := make(map[string]interface{})
+	data, _ := json.Marshal(recipe)
+	json.Unmarshal(data, &fields)
+	return fields
+}
+
+// missingFrom returns the elements of values not contained in other.
+func missingFrom(values []interface{}, other []interface{}) []interface{} {
+	missing := make([]interface{}, 0)
+	for _, value := range values {
+		found := false
+		for _, o := range other {
+			if reflect.DeepEqual(value, o) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, value)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"strings"
+	"unicode"
+)
+
+// maxSlugLength limits the length of slugs, cutting them at a word boundary.
+const maxSlugLength = 80
+
+// transliterations spell out letters that have no ASCII equivalent, German
+// umlauts the German way.
+var transliterations = map[rune]string{
+	'ä': "ae", 'ö': "oe", 'ü': "ue", 'ß': "ss",
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'å': "a", 'æ': "ae",
+	'ç': "c", 'è': "e", 'é': "e", 'ê': "e", 'ë': "e",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ñ': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ø': "o", 'œ': "oe",
+	'ù': "u", 'ú': "u", 'û': "u", 'ý': "y", 'ÿ': "y",
+	'ł': "l", 'š': "s", 'ž': "z", 'č': "c", 'ř': "r", 'ě': "e",
+}
+
+// Slugify turns a recipe name into a URL-safe slug like "stefans-kaesekuchen"
+// for "Stefans Käsekuchen".
+func Slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			b.WriteRune(r)
+			dash = false
+		case transliterations[r] != "":
+			b.WriteString(transliterations[r])
+			dash = false
+		case r == '\'' || r == '’':
+			// "Stefan's" becomes "stefans"
+		default:
+			if !dash && b.Len() > 0 {
+				b.WriteByte('-')
+				dash = true
+			}
+		}
+	}
+	slug := strings.TrimSuffix(b.String(), "-")
+	if len(slug) > maxSlugLength {
+		slug = slug[:maxSlugLength]
+		if i := strings.LastIndexByte(slug, '-'); i > 0 {
+			slug = slug[:i]
+		}
+	}
+	if slug == "" {
+		return "recipe"
+	}
+	return slug
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+	"time"
+)
+
+// swagger:model Tag
+// A canonical tag of the taxonomy with the alternative spellings mapped to it.
+type Tag struct {
+	// the id for this tag
+	ID primitive.ObjectID `json:"id" bson:"_id"`
+
+	// the canonical name of this tag
+	// required: true
+	Name string `json:"name" bson:"name" binding:"required"`
+
+	// alternative names normalized to this tag, like synonyms or misspellings
+	Aliases []string `json:"aliases,omitempty" bson:"aliases,omitempty"`
+
+	// the name of this tag per language code, like "de": "kuchen"
+	Translations map[string]string `json:"translations,omitempty" bson:"translations,omitempty"`
+
+	// the canonical name of the broader tag this tag belongs to
+	Parent string `json:"parent,omitempty" bson:"parent,omitempty"`
+
+	// all normalized names of this tag, to look up tags by any of them
+	Terms []string `json:"-" bson:"terms"`
+
+	// the creation date for this tag
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+
+	// the date of the last change of this tag
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// Normalize normalizes the names of the tag and collects its terms.
+func (tag *Tag) Normalize() {
+	tag.Name = NormalizeTag(tag.Name)
+	tag.Parent = NormalizeTag(tag.Parent)
+	tag.Terms = []string{tag.Name}
+	aliases := make([]string, 0, len(tag.Aliases))
+	for _, alias := range tag.Aliases {
+		alias = NormalizeTag(alias)
+		if alias != "" && !containsTerm(tag.Terms, alias) {
+			aliases = append(aliases, alias)
+			tag.Terms = append(tag.Terms, alias)
+		}
+	}
+	tag.Aliases = aliases
+	for lang, name := range tag.Translations {
+		name = NormalizeTag(name)
+		tag.Translations[lang] = name
+		if name != "" && !containsTerm(tag.Terms, name) {
+			tag.Terms = append(tag.Terms, name)
+		}
+	}
+}
+
+// swagger:model TagUsage
+// A tag with the number of recipes using it.
+type TagUsage struct {
+	// the canonical name of the tag
+	Name string `json:"name"`
+
+	// the name of the tag in the requested language
+	Label string `json:"label,omitempty"`
+
+	// the canonical name of the broader tag
+	Parent string `json:"parent,omitempty"`
+
+	// the number of recipes using the tag
+	Count int `json:"count"`
+}
+
+// NormalizeTag lower cases a tag and collapses its white space, so "Weeknight
+// Dinner " and "weeknight  dinner" become the same tag.
+func NormalizeTag(tag string) string {
+	return strings.Join(strings.Fields(strings.ToLower(tag)), " ")
+}
+
+func containsTerm(terms []string, term string) bool {
+	for _, t := range terms {
+		if t == term {
+			return true
+		}
+	}
+	return false
+}
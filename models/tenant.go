@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// swagger:model Tenant
+// One of the brands sharing a deployment, with a database of its own.
+type Tenant struct {
+	// the name of the tenant as given in the X-Tenant header, as subdomain or
+	// in the tokens of its users
+	// required: true
+	Name string `json:"name" bson:"_id"`
+
+	// the database holding the recipes and users of the tenant
+	Database string `json:"database" bson:"database"`
+
+	// the prefix of the Redis keys of the tenant
+	RedisPrefix string `json:"redisPrefix" bson:"redisPrefix"`
+
+	// the date the tenant has been provisioned
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+}
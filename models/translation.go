@@ -0,0 +1,152 @@
+package models
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// swagger:model RecipeTranslation
+// The name, ingredients and instructions of a recipe in another language.
+type RecipeTranslation struct {
+	// the translated name
+	// required: true
+	Name string `json:"name" bson:"name" binding:"required"`
+
+	// the translated ingredients
+	Ingredients []string `json:"ingredients" bson:"ingredients"`
+
+	// the translated instructions
+	Instructions []string `json:"instructions" bson:"instructions"`
+}
+
+// RecipeText is the text of a recipe in one language as indexed for the full
+// text search, with the language MongoDB stems it in.
+type RecipeText struct {
+	TextLanguage string   `json:"-" bson:"textLanguage"`
+	Name         string   `json:"-" bson:"name"`
+	Ingredients  []string `json:"-" bson:"ingredients"`
+	Instructions []string `json:"-" bson:"instructions"`
+}
+
+// textLanguages maps language codes to the languages of the MongoDB text
+// search, other languages are indexed without stemming.
+var textLanguages = map[string]string{
+	"da": "danish",
+	"de": "german",
+	"en": "english",
+	"es": "spanish",
+	"fi": "finnish",
+	"fr": "french",
+	"hu": "hungarian",
+	"it": "italian",
+	"nb": "norwegian",
+	"nl": "dutch",
+	"pt": "portuguese",
+	"ro": "romanian",
+	"ru": "russian",
+	"sv": "swedish",
+	"tr": "turkish",
+}
+
+// TextLanguage returns the MongoDB text search language for a language
+// code, "none" for languages without stemming support.
+func TextLanguage(language string) string {
+	if textLanguage, found := textLanguages[PrimaryLanguage(language)]; found {
+		return textLanguage
+	}
+	return "none"
+}
+
+var languageTag = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// NormalizeLanguage lower cases a language tag like "de-AT", returning the
+// empty string for invalid tags.
+func NormalizeLanguage(language string) string {
+	language = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(language), "_", "-"))
+	if !languageTag.MatchString(language) {
+		return ""
+	}
+	return language
+}
+
+// PrimaryLanguage returns the primary subtag of a language tag, "de" for "de-AT".
+func PrimaryLanguage(language string) string {
+	return strings.SplitN(language, "-", 2)[0]
+}
+
+// SearchTexts returns the texts of the recipe in its default language and all its
+// translations for the full text search.
+func (recipe Recipe) SearchTexts() []RecipeText {
+	texts := []RecipeText{{
+		TextLanguage: TextLanguage(recipe.Language),
+		Name:         recipe.Name,
+		Ingredients:  recipe.Ingredients,
+		Instructions: recipe.Instructions,
+	}}
+	for _, language := range recipe.TranslationLanguages() {
+		translation := recipe.Translations[language]
+		texts = append(texts, RecipeText{
+			TextLanguage: TextLanguage(language),
+			Name:         translation.Name,
+			Ingredients:  translation.Ingredients,
+			Instructions: translation.Instructions,
+		})
+	}
+	return texts
+}
+
+// TranslationLanguages returns the languages the recipe is translated to, sorted.
+func (recipe Recipe) TranslationLanguages() []string {
+	languages := make([]string, 0, len(recipe.Translations))
+	for language := range recipe.Translations {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// Localize returns the recipe in the first of the preferred languages it is
+// available in, comparing primary subtags if there's no exact match. Without
+// a match the recipe is returned in its default language.
+func (recipe Recipe) Localize(preferences []string) Recipe {
+	for _, preference := range preferences {
+		if language, found := recipe.match(preference); found {
+			return recipe.In(language)
+		}
+	}
+	return recipe
+}
+
+func (recipe Recipe) match(preference string) (string, bool) {
+	if preference == recipe.Language && preference != "" {
+		return preference, true
+	}
+	if _, found := recipe.Translations[preference]; found {
+		return preference, true
+	}
+	primary := PrimaryLanguage(preference)
+	if recipe.Language != "" && PrimaryLanguage(recipe.Language) == primary {
+		return recipe.Language, true
+	}
+	for _, language := range recipe.TranslationLanguages() {
+		if PrimaryLanguage(language) == primary {
+			return language, true
+		}
+	}
+	return "", false
+}
+
+// In returns the recipe in a language, its name, ingredients and
+// instructions replaced by the translation.
+func (recipe Recipe) In(language string) Recipe {
+	translation, found := recipe.Translations[language]
+	if !found || language == recipe.Language {
+		return recipe
+	}
+	recipe.TranslatedTo = language
+	recipe.Name = translation.Name
+	recipe.Ingredients = translation.Ingredients
+	recipe.Instructions = translation.Instructions
+	return recipe
+}
@@ -0,0 +1,19 @@
+package models
+
+// swagger:model User
+// A user of this application.
+type User struct {
+	// the name used to sign in
+	// required: true
+	Username string `json:"username" bson:"username"`
+
+	// the password used to sign in, stored as bcrypt hash
+	// required: true
+	Password string `json:"password" bson:"password"`
+
+	// the role of this user, "admin" or empty for regular users
+	Role string `json:"role,omitempty" bson:"role,omitempty"`
+}
+
+// RoleAdmin is the role of users allowed to call the admin endpoints.
+const RoleAdmin = "admin"
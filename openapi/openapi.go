@@ -0,0 +1,238 @@
+// Package openapi builds OpenAPI 3 documents, with the schemas of request and
+// response bodies derived from Go types.
+package openapi
+
+import (
+	"fmt"
+	"github.com/aheadxnet/go-sandbox/formats"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Version is the OpenAPI version of the documents.
+const Version = "3.0.3"
+
+// Document is an OpenAPI document describing an API.
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+
+	types map[reflect.Type]*Schema
+	names map[string]reflect.Type
+}
+
+// Info describes the API.
+type Info struct {
+	Title       string   `json:"title"`
+	Description string   `json:"description,omitempty"`
+	Version     string   `json:"version"`
+	Contact     *Contact `json:"contact,omitempty"`
+}
+
+// Contact names the maintainer of the API.
+type Contact struct {
+	Name  string `json:"name,omitempty"`
+	URL   string `json:"url,omitempty"`
+	Email string `json:"email,omitempty"`
+}
+
+// Components holds the schemas referenced by the operations.
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme describes how requests are authenticated.
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+// PathItem holds the operations of a path by lower case method.
+type PathItem map[string]*Operation
+
+// Operation describes a route.
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []*Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+// Parameter is a path, query or header parameter of an operation.
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody describes the body of a request by media type.
+type RequestBody struct {
+	Description string                `json:"description,omitempty"`
+	Required    bool                  `json:"required,omitempty"`
+	Content     map[string]*MediaType `json:"content"`
+}
+
+// Response describes a response by media type, without content if it has no
+// body.
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+// MediaType holds the schema of a body in one media type.
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// New creates an empty document.
+func New(info Info) *Document {
+	return &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Paths:   make(map[string]*PathItem),
+		Components: Components{
+			Schemas:         make(map[string]*Schema),
+			SecuritySchemes: make(map[string]*SecurityScheme),
+		},
+	}
+}
+
+// Add adds the operation of a route, given by the method and the path as
+// registered with gin. It fails if the path parameters of the route and
+// the operation don't match.
+func (doc *Document) Add(method string, route string, operation *Operation) error {
+	path, names := Path(route)
+	declared := make(map[string]bool)
+	for _, parameter := range operation.Parameters {
+		if parameter.In == "path" {
+			declared[parameter.Name] = true
+		}
+	}
+	for _, name := range names {
+		if !declared[name] {
+			return fmt.Errorf("%s %s: path parameter %q is not described", method, route, name)
+		}
+		delete(declared, name)
+	}
+	for name := range declared {
+		return fmt.Errorf("%s %s: described path parameter %q is not in the path", method, route, name)
+	}
+	item, ok := doc.Paths[path]
+	if !ok {
+		item = &PathItem{}
+		doc.Paths[path] = item
+	}
+	(*item)[strings.ToLower(method)] = operation
+	return nil
+}
+
+// Operation returns the operation of a method and an OpenAPI path.
+func (doc *Document) Operation(method string, path string) *Operation {
+	item, ok := doc.Paths[path]
+	if !ok {
+		return nil
+	}
+	return (*item)[strings.ToLower(method)]
+}
+
+// Routes returns the method and path of all operations, sorted.
+func (doc *Document) Routes() []string {
+	routes := make([]string, 0)
+	for path, item := range doc.Paths {
+		for method := range *item {
+			routes = append(routes, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(routes)
+	return routes
+}
+
+// Path turns a gin route like /recipes/:id into the OpenAPI path
+// /recipes/{id} and returns the names of its parameters. Only colons
+// starting a segment mark a parameter, so /recipes:batch stays as is.
+func Path(route string) (string, []string) {
+	segments := strings.Split(route, "/")
+	names := make([]string, 0)
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			names = append(names, segment[1:])
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/"), names
+}
+
+// PathParam is a required path parameter holding a string.
+func PathParam(name string, description string) *Parameter {
+	return &Parameter{Name: name, In: "path", Description: description, Required: true, Schema: String()}
+}
+
+// QueryParam is an optional query parameter.
+func QueryParam(name string, schema *Schema, description string) *Parameter {
+	return &Parameter{Name: name, In: "query", Description: description, Schema: schema}
+}
+
+// HeaderParam is an optional header.
+func HeaderParam(name string, description string) *Parameter {
+	return &Parameter{Name: name, In: "header", Description: description, Schema: String()}
+}
+
+// Body is a required request body in the given media types, the value
+// giving the schema of JSON and the other formats encoding the same data.
+// Other media types are strings.
+func (doc *Document) Body(description string, value interface{}, mediaTypes ...string) *RequestBody {
+	return &RequestBody{Description: description, Required: true, Content: doc.content(value, mediaTypes)}
+}
+
+// Upload is a required multipart/form-data request body with a file in the
+// given field.
+func Upload(description string, field string) *RequestBody {
+	return &RequestBody{Description: description, Required: true, Content: map[string]*MediaType{
+		"multipart/form-data": {Schema: &Schema{
+			Type:       "object",
+			Properties: map[string]*Schema{field: {Type: "string", Format: "binary"}},
+			Required:   []string{field},
+		}},
+	}}
+}
+
+// JSON is a response holding the value as JSON, or in further media types.
+func (doc *Document) JSON(description string, value interface{}, mediaTypes ...string) *Response {
+	return &Response{Description: description, Content: doc.content(value, append([]string{formats.MIMEJSON}, mediaTypes...))}
+}
+
+// Binary is a response holding a document in one of the media types.
+func Binary(description string, mediaTypes ...string) *Response {
+	content := make(map[string]*MediaType, len(mediaTypes))
+	for _, mediaType := range mediaTypes {
+		content[mediaType] = &MediaType{Schema: &Schema{Type: "string", Format: "binary"}}
+	}
+	return &Response{Description: description, Content: content}
+}
+
+// Empty is a response without body.
+func Empty(description string) *Response {
+	return &Response{Description: description}
+}
+
+func (doc *Document) content(value interface{}, mediaTypes []string) map[string]*MediaType {
+	content := make(map[string]*MediaType, len(mediaTypes))
+	for _, mediaType := range mediaTypes {
+		if _, ok := formats.Supported(mediaType); ok {
+			content[mediaType] = &MediaType{Schema: doc.Schema(value)}
+		} else {
+			content[mediaType] = &MediaType{Schema: String()}
+		}
+	}
+	return content
+}
@@ -0,0 +1,287 @@
+package openapi
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Schema describes a JSON value.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// String is the schema of strings.
+func String() *Schema {
+	return &Schema{Type: "string"}
+}
+
+// Integer is the schema of integers.
+func Integer() *Schema {
+	return &Schema{Type: "integer"}
+}
+
+// Boolean is the schema of booleans.
+func Boolean() *Schema {
+	return &Schema{Type: "boolean"}
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	marshalerType     = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// Define sets the schema of a type whose JSON encoding differs from its Go
+// type, like IDs encoded as hex strings.
+func (doc *Document) Define(value interface{}, schema *Schema) {
+	doc.defined()[reflect.TypeOf(value)] = schema
+}
+
+// Schema returns the schema of the JSON encoding of the value. Named struct
+// types become components referenced by their name.
+func (doc *Document) Schema(value interface{}) *Schema {
+	return doc.schema(reflect.TypeOf(value))
+}
+
+// Resolve follows the reference of a schema to the component.
+func (doc *Document) Resolve(schema *Schema) *Schema {
+	for schema != nil && schema.Ref != "" {
+		schema = doc.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+	return schema
+}
+
+func (doc *Document) defined() map[reflect.Type]*Schema {
+	if doc.types == nil {
+		doc.types = make(map[reflect.Type]*Schema)
+	}
+	return doc.types
+}
+
+func (doc *Document) schema(t reflect.Type) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+	if schema, ok := doc.defined()[t]; ok {
+		copied := *schema
+		return &copied
+	}
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t.Kind() != reflect.Ptr && t.Implements(marshalerType):
+		// The encoding is unknown without a definition.
+		return &Schema{}
+	case t.Kind() != reflect.Ptr && t.Implements(textMarshalerType):
+		return String()
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return doc.schema(t.Elem())
+	case reflect.Bool:
+		return Boolean()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return Integer()
+	case reflect.Int64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer", Minimum: float(0)}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return String()
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: doc.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: doc.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return doc.object(t)
+		}
+		return doc.component(t)
+	}
+	// Interfaces hold any value.
+	return &Schema{}
+}
+
+// component adds the schema of a named struct to the components and returns
+// a reference to it.
+func (doc *Document) component(t reflect.Type) *Schema {
+	name := componentName(t)
+	if existing, ok := doc.names[name]; ok && existing != t {
+		// Types of different packages with the same name are told apart by
+		// the package, like ModelsTag and HandlersTag.
+		pkg := []rune(path.Base(t.PkgPath()))
+		pkg[0] = unicode.ToUpper(pkg[0])
+		name = string(pkg) + name
+	}
+	ref := &Schema{Ref: "#/components/schemas/" + name}
+	if _, ok := doc.Components.Schemas[name]; ok {
+		return ref
+	}
+	if doc.names == nil {
+		doc.names = make(map[string]reflect.Type)
+	}
+	doc.names[name] = t
+	// Registered before its fields are described, so recursive types end.
+	doc.Components.Schemas[name] = &Schema{Type: "object"}
+	doc.Components.Schemas[name] = doc.object(t)
+	return ref
+}
+
+func componentName(t reflect.Type) string {
+	runes := []rune(t.Name())
+	if len(runes) == 0 {
+		return "Object"
+	}
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
+
+// object describes the exported fields of a struct as they are encoded by
+// encoding/json. Fields required by their binding tag are required, which
+// is what gin enforces for request bodies.
+func (doc *Document) object(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	doc.fields(t, schema)
+	return schema
+}
+
+func (doc *Document) fields(t reflect.Type, schema *Schema) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options := tag, ""
+		if comma := strings.Index(tag, ","); comma >= 0 {
+			name, options = tag[:comma], tag[comma+1:]
+		}
+		fieldType := field.Type
+		if field.Anonymous && name == "" {
+			if fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Struct {
+				doc.fields(fieldType, schema)
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		property := doc.schema(fieldType)
+		omitEmpty := false
+		for _, option := range strings.Split(options, ",") {
+			omitEmpty = omitEmpty || option == "omitempty"
+		}
+		if !omitEmpty && nullable(fieldType) {
+			property = nullableSchema(property)
+		}
+		if applyBinding(property, field.Tag.Get("binding")) {
+			schema.Required = append(schema.Required, name)
+		}
+		schema.Properties[name] = property
+	}
+}
+
+// nullable tells whether the zero value of a type is encoded as null.
+func nullable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() != reflect.Uint8
+	}
+	return false
+}
+
+func nullableSchema(schema *Schema) *Schema {
+	if schema.Ref != "" {
+		return &Schema{AllOf: []*Schema{schema}, Nullable: true}
+	}
+	if schema.Type != "" {
+		schema.Nullable = true
+	}
+	return schema
+}
+
+// applyBinding adds the limits of a binding tag like "required,min=1,max=5"
+// to the schema and tells whether the value is required.
+func applyBinding(schema *Schema, binding string) bool {
+	required := false
+	for _, rule := range strings.Split(binding, ",") {
+		key, value := rule, ""
+		if equals := strings.Index(rule, "="); equals >= 0 {
+			key, value = rule[:equals], rule[equals+1:]
+		}
+		limit, err := strconv.ParseFloat(value, 64)
+		switch {
+		case key == "required":
+			required = true
+		case err != nil:
+		case key == "min" || key == "max":
+			setLimit(schema, key, limit)
+		}
+	}
+	return required
+}
+
+func setLimit(schema *Schema, key string, limit float64) {
+	count := int(limit)
+	switch schema.Type {
+	case "integer", "number":
+		if key == "min" {
+			schema.Minimum = float(limit)
+		} else {
+			schema.Maximum = float(limit)
+		}
+	case "string":
+		if key == "min" {
+			schema.MinLength = &count
+		} else {
+			schema.MaxLength = &count
+		}
+	case "array":
+		if key == "min" {
+			schema.MinItems = &count
+		} else {
+			schema.MaxItems = &count
+		}
+	default:
+		panic(fmt.Sprintf("openapi: binding %s on a field of type %q", key, schema.Type))
+	}
+}
+
+func float(value float64) *float64 {
+	return &value
+}
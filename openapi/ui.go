@@ -0,0 +1,12 @@
+package openapi
+
+import "embed"
+
+// SwaggerUI holds swagger-ui/index.html, a page showing the document at
+// openapi.json, relative to the page, in Swagger UI, and the scripts and
+// styles of Swagger UI it loads from docs/. Those are taken from the npm
+// package swagger-ui-dist and checked in, run go generate to update them.
+//
+//go:generate go run ../cmd/fetch-swagger-ui -version 4.15.5 -dir swagger-ui
+//go:embed swagger-ui
+var SwaggerUI embed.FS
@@ -0,0 +1,160 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Violation is a value not matching its schema, named by where it is, like
+// "limit" or "body.tags[2]".
+type Violation struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+func (violation Violation) String() string {
+	return violation.Name + ": " + violation.Reason
+}
+
+// patterns caches the compiled patterns of the schemas.
+var patterns sync.Map
+
+// Validate checks a JSON value, decoded with UseNumber, against the schema.
+func (doc *Document) Validate(schema *Schema, value interface{}, name string) []Violation {
+	violations := make([]Violation, 0)
+	doc.validate(schema, value, name, &violations)
+	return violations
+}
+
+// ValidateParameter checks the value of a path, query or header parameter.
+func (doc *Document) ValidateParameter(parameter *Parameter, value string) []Violation {
+	var decoded interface{} = value
+	switch doc.Resolve(parameter.Schema).Type {
+	case "integer", "number":
+		decoded = json.Number(value)
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return []Violation{{Name: parameter.Name, Reason: "must be a boolean"}}
+		}
+		decoded = b
+	}
+	return doc.Validate(parameter.Schema, decoded, parameter.Name)
+}
+
+func (doc *Document) validate(schema *Schema, value interface{}, name string, violations *[]Violation) {
+	if schema == nil {
+		return
+	}
+	if schema.Ref != "" {
+		doc.validate(doc.Resolve(schema), value, name, violations)
+		return
+	}
+	fail := func(format string, args ...interface{}) {
+		*violations = append(*violations, Violation{Name: name, Reason: fmt.Sprintf(format, args...)})
+	}
+	if value == nil {
+		if !schema.Nullable && (schema.Type != "" || len(schema.AllOf) > 0) {
+			fail("must not be null")
+		}
+		return
+	}
+	for _, part := range schema.AllOf {
+		doc.validate(part, value, name, violations)
+	}
+
+	switch schema.Type {
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			fail("must be a string")
+			return
+		}
+		length := utf8.RuneCountInString(s)
+		if schema.MinLength != nil && length < *schema.MinLength {
+			fail("must have at least %d characters", *schema.MinLength)
+		}
+		if schema.MaxLength != nil && length > *schema.MaxLength {
+			fail("must have at most %d characters", *schema.MaxLength)
+		}
+		if schema.Pattern != "" && !compile(schema.Pattern).MatchString(s) {
+			fail("must match %s", schema.Pattern)
+		}
+		if schema.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+				fail("must be a date-time like 2006-01-02T15:04:05Z")
+			}
+		}
+	case "integer", "number":
+		n, ok := value.(json.Number)
+		if !ok {
+			fail("must be a%s %s", map[bool]string{true: "n"}[schema.Type == "integer"], schema.Type)
+			return
+		}
+		f, err := n.Float64()
+		if schema.Type == "integer" {
+			_, err = strconv.ParseInt(n.String(), 10, 64)
+		}
+		if err != nil {
+			fail("must be a%s %s", map[bool]string{true: "n"}[schema.Type == "integer"], schema.Type)
+			return
+		}
+		if schema.Minimum != nil && f < *schema.Minimum {
+			fail("must be at least %v", *schema.Minimum)
+		}
+		if schema.Maximum != nil && f > *schema.Maximum {
+			fail("must be at most %v", *schema.Maximum)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			fail("must be a boolean")
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			fail("must be an array")
+			return
+		}
+		if schema.MinItems != nil && len(items) < *schema.MinItems {
+			fail("must have at least %d items", *schema.MinItems)
+		}
+		if schema.MaxItems != nil && len(items) > *schema.MaxItems {
+			fail("must have at most %d items", *schema.MaxItems)
+		}
+		for i, item := range items {
+			doc.validate(schema.Items, item, fmt.Sprintf("%s[%d]", name, i), violations)
+		}
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			fail("must be an object")
+			return
+		}
+		for _, required := range schema.Required {
+			if _, ok := object[required]; !ok {
+				*violations = append(*violations, Violation{Name: name + "." + required, Reason: "is required"})
+			}
+		}
+		for key, element := range object {
+			if property, ok := schema.Properties[key]; ok {
+				doc.validate(property, element, name+"."+key, violations)
+			} else {
+				doc.validate(schema.AdditionalProperties, element, name+"."+key, violations)
+			}
+		}
+	}
+}
+
+func compile(pattern string) *regexp.Regexp {
+	if compiled, ok := patterns.Load(pattern); ok {
+		return compiled.(*regexp.Regexp)
+	}
+	compiled := regexp.MustCompile(pattern)
+	patterns.Store(pattern, compiled)
+	return compiled
+}
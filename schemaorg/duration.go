@@ -0,0 +1,38 @@
+package schemaorg
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+)
+
+var durationPattern = regexp.MustCompile(`^P(?:(\d+(?:\.\d+)?)D)?(?:T(?:(\d+(?:\.\d+)?)H)?(?:(\d+(?:\.\d+)?)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// ParseDuration reads an ISO 8601 duration like "PT1H30M" and returns it in whole minutes.
+func ParseDuration(value string) (int, error) {
+	parts := durationPattern.FindStringSubmatch(value)
+	if parts == nil || value == "P" || value == "PT" {
+		return 0, fmt.Errorf("invalid ISO 8601 duration %q", value)
+	}
+	minutes := 0.0
+	for i, factor := range []float64{24 * 60, 60, 1, 1.0 / 60} {
+		if parts[i+1] == "" {
+			continue
+		}
+		number, _ := strconv.ParseFloat(parts[i+1], 64)
+		minutes += number * factor
+	}
+	return int(math.Round(minutes)), nil
+}
+
+// FormatDuration writes minutes as ISO 8601 duration like "PT1H30M".
+func FormatDuration(minutes int) string {
+	if minutes%60 == 0 {
+		return fmt.Sprintf("PT%dH", minutes/60)
+	}
+	if minutes < 60 {
+		return fmt.Sprintf("PT%dM", minutes)
+	}
+	return fmt.Sprintf("PT%dH%dM", minutes/60, minutes%60)
+}
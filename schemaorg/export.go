@@ -0,0 +1,85 @@
+package schemaorg
+
+import (
+	"encoding/json"
+	"github.com/aheadxnet/go-sandbox/models"
+	"strconv"
+	"strings"
+)
+
+const schemaContext = "https://schema.org"
+
+// Node maps a recipe onto a schema.org Recipe node without @context.
+func Node(recipe models.Recipe) map[string]interface{} {
+	node := map[string]interface{}{
+		"@type":            "Recipe",
+		"identifier":       recipe.ID.Hex(),
+		"name":             recipe.Name,
+		"recipeIngredient": nonNil(recipe.Ingredients),
+	}
+	if len(recipe.Tags) > 0 {
+		node["keywords"] = strings.Join(recipe.Tags, ", ")
+	}
+	steps := make([]map[string]interface{}, 0, len(recipe.Instructions))
+	for i, instruction := range recipe.Instructions {
+		steps = append(steps, map[string]interface{}{
+			"@type":    "HowToStep",
+			"position": i + 1,
+			"text":     instruction,
+		})
+	}
+	node["recipeInstructions"] = steps
+	switch {
+	case recipe.Yield != "" && recipe.Servings > 0:
+		node["recipeYield"] = []string{strconv.Itoa(recipe.Servings), recipe.Yield}
+	case recipe.Yield != "":
+		node["recipeYield"] = recipe.Yield
+	case recipe.Servings > 0:
+		node["recipeYield"] = strconv.Itoa(recipe.Servings)
+	}
+	if recipe.PrepTime > 0 {
+		node["prepTime"] = FormatDuration(recipe.PrepTime)
+	}
+	if recipe.CookTime > 0 {
+		node["cookTime"] = FormatDuration(recipe.CookTime)
+	}
+	if recipe.TotalTime > 0 {
+		node["totalTime"] = FormatDuration(recipe.TotalTime)
+	}
+	if recipe.Image != "" {
+		node["image"] = recipe.Image
+	}
+	if recipe.Source != "" {
+		node["url"] = recipe.Source
+	}
+	if !recipe.PublishedAt.IsZero() {
+		node["datePublished"] = recipe.PublishedAt.Format("2006-01-02")
+	}
+	return node
+}
+
+// Marshal renders a single recipe as JSON-LD document.
+func Marshal(recipe models.Recipe) ([]byte, error) {
+	node := Node(recipe)
+	node["@context"] = schemaContext
+	return json.MarshalIndent(node, "", "  ")
+}
+
+// MarshalList renders several recipes as JSON-LD document using @graph.
+func MarshalList(recipes []models.Recipe) ([]byte, error) {
+	nodes := make([]map[string]interface{}, 0, len(recipes))
+	for _, recipe := range recipes {
+		nodes = append(nodes, Node(recipe))
+	}
+	return json.MarshalIndent(map[string]interface{}{
+		"@context": schemaContext,
+		"@graph":   nodes,
+	}, "", "  ")
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return make([]string, 0)
+	}
+	return values
+}
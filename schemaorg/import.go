@@ -0,0 +1,298 @@
+// Package schemaorg converts between recipes and the schema.org Recipe
+// vocabulary (https://schema.org/Recipe) in its JSON-LD serialization.
+package schemaorg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aheadxnet/go-sandbox/models"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrNoRecipe is returned if a document does not contain a schema.org Recipe.
+var ErrNoRecipe = errors.New("no schema.org Recipe found")
+
+var (
+	scriptPattern = regexp.MustCompile(`(?is)<script[^>]+type\s*=\s*["']?application/ld\+json["']?[^>]*>(.*?)</script>`)
+	tagPattern    = regexp.MustCompile(`(?s)<[^>]*>`)
+	yieldPattern  = regexp.MustCompile(`\d+`)
+)
+
+// The warnings about fields every recipe needs. They keep an imported recipe
+// from being stored, while a preview only reports them.
+const (
+	WarningNoName        = "recipe has no name or the name is shorter than 3 characters"
+	WarningNoIngredients = "recipe has no ingredients"
+)
+
+// Blocking returns the warnings keeping a recipe from being stored.
+func Blocking(warnings []string) []string {
+	blocking := make([]string, 0)
+	for _, warning := range warnings {
+		if warning == WarningNoName || warning == WarningNoIngredients {
+			blocking = append(blocking, warning)
+		}
+	}
+	return blocking
+}
+
+// ParseHTML extracts the first schema.org Recipe from the JSON-LD script
+// blocks of an HTML page.
+func ParseHTML(page []byte) (*models.Recipe, []string, error) {
+	blocks := scriptPattern.FindAllSubmatch(page, -1)
+	if len(blocks) == 0 {
+		return nil, nil, errors.New("no JSON-LD script block found")
+	}
+	warnings := make([]string, 0)
+	for i, block := range blocks {
+		recipe, blockWarnings, err := Parse(block[1])
+		if errors.Is(err, ErrNoRecipe) {
+			continue
+		}
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("script block %d: %v", i+1, err))
+			continue
+		}
+		return recipe, append(warnings, blockWarnings...), nil
+	}
+	return nil, warnings, ErrNoRecipe
+}
+
+// Parse maps the first schema.org Recipe of a JSON-LD document onto a recipe.
+// The document may be a single node, an array of nodes or use @graph.
+// Everything that could not be mapped is reported as warning.
+func Parse(document []byte) (*models.Recipe, []string, error) {
+	var root interface{}
+	if err := json.Unmarshal(document, &root); err != nil {
+		return nil, nil, err
+	}
+	recipes := make([]map[string]interface{}, 0)
+	collectRecipes(root, &recipes)
+	if len(recipes) == 0 {
+		return nil, nil, ErrNoRecipe
+	}
+	warnings := make([]string, 0)
+	if len(recipes) > 1 {
+		warnings = append(warnings, fmt.Sprintf("found %d recipes, only the first one is imported", len(recipes)))
+	}
+	node := recipes[0]
+
+	recipe := &models.Recipe{
+		Name:         text(node["name"]),
+		Tags:         keywords(node),
+		Ingredients:  make([]string, 0),
+		Instructions: make([]string, 0),
+	}
+	for _, ingredient := range list(node["recipeIngredient"]) {
+		if line := text(ingredient); line != "" {
+			recipe.Ingredients = append(recipe.Ingredients, line)
+		}
+	}
+	if len(recipe.Ingredients) == 0 {
+		for _, ingredient := range list(node["ingredients"]) {
+			if line := text(ingredient); line != "" {
+				recipe.Ingredients = append(recipe.Ingredients, line)
+			}
+		}
+	}
+	recipe.Instructions = instructions(node["recipeInstructions"], "")
+	recipe.Yield, recipe.Servings = yield(node["recipeYield"])
+	recipe.Image = url(node["image"])
+	recipe.Source = url(node["url"])
+	if recipe.Source == "" {
+		recipe.Source = url(node["mainEntityOfPage"])
+	}
+	for _, time := range []struct {
+		field  string
+		target *int
+	}{
+		{"prepTime", &recipe.PrepTime},
+		{"cookTime", &recipe.CookTime},
+		{"totalTime", &recipe.TotalTime},
+	} {
+		field, target := time.field, time.target
+		value := text(node[field])
+		if value == "" {
+			continue
+		}
+		minutes, err := ParseDuration(value)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: %v", field, err))
+			continue
+		}
+		*target = minutes
+	}
+
+	if len(recipe.Name) < 3 {
+		warnings = append(warnings, WarningNoName)
+	}
+	if len(recipe.Ingredients) == 0 {
+		warnings = append(warnings, WarningNoIngredients)
+	}
+	if len(recipe.Instructions) == 0 {
+		warnings = append(warnings, "recipe has no instructions")
+	}
+	if node["recipeYield"] != nil && recipe.Servings == 0 {
+		warnings = append(warnings, fmt.Sprintf("could not read servings from yield %q", recipe.Yield))
+	}
+	return recipe, warnings, nil
+}
+
+// collectRecipes walks arrays and @graph containers and collects all nodes typed Recipe.
+func collectRecipes(value interface{}, recipes *[]map[string]interface{}) {
+	switch node := value.(type) {
+	case []interface{}:
+		for _, element := range node {
+			collectRecipes(element, recipes)
+		}
+	case map[string]interface{}:
+		if hasType(node, "Recipe") {
+			*recipes = append(*recipes, node)
+		}
+		if graph, ok := node["@graph"]; ok {
+			collectRecipes(graph, recipes)
+		}
+	}
+}
+
+func hasType(node map[string]interface{}, name string) bool {
+	for _, t := range list(node["@type"]) {
+		if s, ok := t.(string); ok && (s == name || strings.HasSuffix(s, "/"+name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// instructions flattens plain texts, HowToStep and HowToSection nodes into
+// a list of steps. Steps of a section are prefixed with the section name.
+func instructions(value interface{}, section string) []string {
+	steps := make([]string, 0)
+	prefix := ""
+	if section != "" {
+		prefix = section + ": "
+	}
+	switch node := value.(type) {
+	case string:
+		for _, line := range strings.Split(cleanText(node, true), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				steps = append(steps, prefix+line)
+			}
+		}
+	case []interface{}:
+		for _, element := range node {
+			steps = append(steps, instructions(element, section)...)
+		}
+	case map[string]interface{}:
+		if hasType(node, "HowToSection") {
+			return append(steps, instructions(node["itemListElement"], text(node["name"]))...)
+		}
+		step := text(node["text"])
+		if step == "" {
+			step = text(node["name"])
+		}
+		if step != "" {
+			steps = append(steps, prefix+step)
+		}
+	}
+	return steps
+}
+
+// keywords merges keywords, recipeCategory and recipeCuisine into distinct tags.
+func keywords(node map[string]interface{}) []string {
+	tags := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, field := range []string{"keywords", "recipeCategory", "recipeCuisine"} {
+		for _, value := range list(node[field]) {
+			for _, tag := range strings.Split(text(value), ",") {
+				tag = strings.TrimSpace(tag)
+				if tag != "" && !seen[strings.ToLower(tag)] {
+					seen[strings.ToLower(tag)] = true
+					tags = append(tags, tag)
+				}
+			}
+		}
+	}
+	return tags
+}
+
+// yield returns the yield as text and the number of servings found in it.
+func yield(value interface{}) (string, int) {
+	values := list(value)
+	texts := make([]string, 0, len(values))
+	servings := 0
+	for _, v := range values {
+		t := text(v)
+		if t == "" {
+			continue
+		}
+		texts = append(texts, t)
+		if servings == 0 {
+			if number := yieldPattern.FindString(t); number != "" {
+				servings, _ = strconv.Atoi(number)
+			}
+		}
+	}
+	if len(texts) == 0 {
+		return "", 0
+	}
+	// Sites often give the plain number first and a description second.
+	return texts[len(texts)-1], servings
+}
+
+// url reads a URL given as text, as ImageObject/WebPage node or as list of those.
+func url(value interface{}) string {
+	switch node := value.(type) {
+	case string:
+		return strings.TrimSpace(node)
+	case []interface{}:
+		for _, element := range node {
+			if u := url(element); u != "" {
+				return u
+			}
+		}
+	case map[string]interface{}:
+		if u := url(node["url"]); u != "" {
+			return u
+		}
+		return url(node["@id"])
+	}
+	return ""
+}
+
+// list wraps single values into a slice, so fields may be given either way.
+func list(value interface{}) []interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return v
+	default:
+		return []interface{}{v}
+	}
+}
+
+// text returns a string or number value as cleaned up text.
+func text(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return cleanText(v, false)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+// cleanText removes markup and entities found in texts scraped from web pages.
+func cleanText(value string, keepLines bool) string {
+	value = strings.NewReplacer("<br>", "\n", "<br/>", "\n", "<br />", "\n", "</p>", "\n", "</li>", "\n").Replace(value)
+	value = html.UnescapeString(tagPattern.ReplaceAllString(value, ""))
+	if keepLines {
+		return strings.TrimSpace(value)
+	}
+	return strings.Join(strings.Fields(value), " ")
+}
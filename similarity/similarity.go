@@ -0,0 +1,104 @@
+// Package similarity compares recipes by their tags, ingredients and title
+// using a weighted Jaccard index.
+package similarity
+
+import (
+	"github.com/aheadxnet/go-sandbox/models"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Weights of the kinds of features: sharing a tag says more about two
+// recipes than sharing an ingredient, which says more than a title word.
+const (
+	TagWeight        = 3.0
+	IngredientWeight = 2.0
+	TitleWeight      = 1.0
+)
+
+// stopWords are title words too common to tell anything about a recipe.
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "the": true, "of": true, "with": true, "in": true, "on": true, "for": true,
+	"my": true, "style": true, "easy": true, "best": true, "recipe": true,
+	"der": true, "die": true, "das": true, "und": true, "mit": true, "nach": true, "art": true, "vom": true, "von": true,
+}
+
+// Features maps the features of a recipe, like "tag:vegetarian", to their weight.
+type Features map[string]float64
+
+// Of extracts the features of a recipe: its tags, the normalized names of
+// its ingredients and the words of its title.
+func Of(recipe models.Recipe) Features {
+	features := make(Features)
+	for _, tag := range recipe.Tags {
+		if term := normalize(tag); term != "" {
+			features["tag:"+term] = TagWeight
+		}
+	}
+	for _, line := range recipe.Ingredients {
+		if term := normalize(models.ParseIngredient(line).Name); term != "" {
+			features["ingredient:"+term] = IngredientWeight
+		}
+	}
+	for _, word := range strings.FieldsFunc(recipe.Name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		word = strings.ToLower(word)
+		if len([]rune(word)) > 1 && !stopWords[word] {
+			features["title:"+word] = TitleWeight
+		}
+	}
+	return features
+}
+
+// normalize lower cases a term and collapses its white space.
+func normalize(term string) string {
+	return strings.Join(strings.Fields(strings.ToLower(term)), " ")
+}
+
+// Jaccard returns the weighted Jaccard index of two feature sets, the sum of
+// the smaller weights divided by the sum of the larger weights of all
+// features, from 0 for nothing in common to 1 for the same features.
+func Jaccard(a Features, b Features) float64 {
+	var min, max float64
+	for feature, weightA := range a {
+		weightB := b[feature]
+		if weightA < weightB {
+			min += weightA
+			max += weightB
+		} else {
+			min += weightB
+			max += weightA
+		}
+	}
+	for feature, weightB := range b {
+		if _, shared := a[feature]; !shared {
+			max += weightB
+		}
+	}
+	if max == 0 {
+		return 0
+	}
+	return min / max
+}
+
+// Match is a recipe similar to another one.
+type Match struct {
+	ID    string
+	Score float64
+}
+
+// Top sorts matches by descending score and returns the first n of them.
+func Top(matches []Match, n int) []Match {
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].ID < matches[j].ID
+	})
+	if len(matches) > n {
+		matches = matches[:n]
+	}
+	return matches
+}
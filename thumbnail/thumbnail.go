@@ -0,0 +1,64 @@
+// Package thumbnail scales down images in pure Go.
+package thumbnail
+
+import (
+	"image"
+	"image/color"
+)
+
+// Fit scales an image down to fit into a box of maxSize x maxSize pixels,
+// keeping its aspect ratio. Images already fitting are returned unchanged.
+func Fit(src image.Image, maxSize int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxSize && height <= maxSize {
+		return src
+	}
+	if width >= height {
+		height = max(1, height*maxSize/width)
+		width = maxSize
+	} else {
+		width = max(1, width*maxSize/height)
+		height = maxSize
+	}
+	return Resize(src, width, height)
+}
+
+// Resize scales an image to the given size using a box filter, so every
+// target pixel is the average of the source pixels it covers.
+func Resize(src image.Image, width int, height int) *image.NRGBA {
+	bounds := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	scaleX := float64(bounds.Dx()) / float64(width)
+	scaleY := float64(bounds.Dy()) / float64(height)
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + int(float64(y)*scaleY)
+		y1 := max(y0+1, bounds.Min.Y+int(float64(y+1)*scaleY))
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + int(float64(x)*scaleX)
+			x1 := max(x0+1, bounds.Min.X+int(float64(x+1)*scaleX))
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1 && sy < bounds.Max.Y; sy++ {
+				for sx := x0; sx < x1 && sx < bounds.Max.X; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			// Average the premultiplied values, then convert back to non-premultiplied.
+			pixel := color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)}
+			dst.Set(x, y, color.NRGBAModel.Convert(pixel))
+		}
+	}
+	return dst
+}
+
+func max(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}